@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/andrewslotin/doppelganger/git"
+	"golang.org/x/net/context"
+)
+
+var (
+	// accessPolicy assigns roles to users. It's set in main() and is nil if no roles are configured, which
+	// grants every user full access.
+	accessPolicy *auth.Policy
+	// githubPermissions restricts access to mirrors of private repositories to users who can read them on
+	// GitHub. It's set in main() if users log in with GitHub.
+	githubPermissions *auth.GitHubPermissions
+)
+
+// permission is a role and an API token scope required to perform an action.
+type permission struct {
+	Role  auth.Role
+	Scope auth.Scope
+}
+
+// mirrorActionPermissions lists permissions required to perform MirrorHandler actions and to manage outgoing
+// webhooks of a mirror. Actions that are not listed require auth.RoleAdmin and auth.ScopeAdmin.
+var mirrorActionPermissions = map[string]permission{
+	"create":             {auth.RoleOperator, auth.ScopeCreate},
+	"update":             {auth.RoleOperator, auth.ScopeSync},
+	"track":              {auth.RoleOperator, auth.ScopeSync},
+	"add-push-target":    {auth.RoleAdmin, auth.ScopeAdmin},
+	"remove-push-target": {auth.RoleAdmin, auth.ScopeAdmin},
+	"delete":             {auth.RoleAdmin, auth.ScopeAdmin},
+	"manage-webhooks":    {auth.RoleOperator, auth.ScopeSync},
+}
+
+// userAccess checks permissions of the user who sent a request. It's passed to templates to hide actions
+// the user is not allowed to perform, and to add CSRF token to forms.
+type userAccess struct {
+	Identity  *auth.Identity
+	CSRFToken string
+
+	ctx context.Context
+}
+
+func accessFromRequest(req *http.Request) *userAccess {
+	return &userAccess{
+		Identity:  auth.IdentityFromContext(req.Context()),
+		CSRFToken: auth.CSRFTokenFromContext(req.Context()),
+		ctx:       req.Context(),
+	}
+}
+
+// Role returns user role for repository repoName. Users who can't read a private repository on GitHub have no
+// access to its mirror unless the roles file makes them admins of all repositories.
+func (access *userAccess) Role(repoName string) auth.Role {
+	role, err := auth.RepositoryRole(access.ctx, accessPolicy, githubPermissions, access.Identity, repoName)
+	if err != nil {
+		slog.WarnContext(access.ctx, "failed to check GitHub permissions", "user", access.Identity.String(), "repo", repoName, "error", err)
+		return auth.RoleNone
+	}
+
+	return role
+}
+
+// Can returns true if user is allowed to perform a MirrorHandler action on repository repoName.
+func (access *userAccess) Can(action, repoName string) bool {
+	required, ok := mirrorActionPermissions[action]
+	if !ok {
+		required = permission{auth.RoleAdmin, auth.ScopeAdmin}
+	}
+
+	return access.Identity.Allows(required.Scope, repoName) && access.Role(repoName) >= required.Role
+}
+
+// CanView returns true if user is allowed to browse and clone repository repoName.
+func (access *userAccess) CanView(repoName string) bool {
+	return access.Identity.Allows(auth.ScopeRead, repoName) && access.Role(repoName) >= auth.RoleViewer
+}
+
+// IsAdmin returns true if user is an admin of all repositories.
+func (access *userAccess) IsAdmin() bool {
+	return access.Identity.Allows(auth.ScopeAdmin, "") && access.Role("") == auth.RoleAdmin
+}
+
+// CanRefresh returns true if user is allowed to reload the list of repositories from GitHub, which requires
+// an operator role for all repositories.
+func (access *userAccess) CanRefresh() bool {
+	return access.Identity.Allows(auth.ScopeSync, "") && access.Role("") >= auth.RoleOperator
+}
+
+// CanViewAll returns true if user is an admin allowed to see all mirrors, including those of private repositories
+// they can't read on GitHub. When users log in with GitHub, the admin role has to be granted by the roles file.
+func (access *userAccess) CanViewAll() bool {
+	if !access.Identity.Allows(auth.ScopeRead, "") || access.Role("") != auth.RoleAdmin {
+		return false
+	}
+
+	return githubPermissions == nil || accessPolicy != nil
+}
+
+// canMirrorSubmodule returns true if the user who triggered a sync is allowed to create a mirror of submodule
+// repository fullName. Background jobs and GitHub webhooks carry no identity and mirror submodules on behalf of
+// the server.
+func canMirrorSubmodule(ctx context.Context, fullName string) bool {
+	id := auth.IdentityFromContext(ctx)
+	if id == nil {
+		return true
+	}
+
+	return (&userAccess{Identity: id, ctx: ctx}).Can("create", fullName)
+}
+
+// submoduleList is a list of submodules rendered along with the permissions of user.
+type submoduleList struct {
+	Submodules []*git.Submodule
+	User       *userAccess
+}
+
+// Submodules binds submodules to user permissions to render them in a template.
+func (access *userAccess) Submodules(submodules []*git.Submodule) submoduleList {
+	return submoduleList{Submodules: submodules, User: access}
+}
+
+// VisibleAll returns true if repository lists do not need to be filtered for user, i.e. neither access policy nor
+// GitHub permissions are configured.
+func (access *userAccess) VisibleAll() bool {
+	return accessPolicy == nil && githubPermissions == nil
+}
+
+// Visible returns repositories user is allowed to view.
+func (access *userAccess) Visible(repos []*git.Repository) []*git.Repository {
+	if access.VisibleAll() {
+		return repos
+	}
+
+	visible := make([]*git.Repository, 0, len(repos))
+	for _, repo := range repos {
+		// GitHub repositories are listed along with their visibility
+		if githubPermissions != nil && !repo.Mirrored() {
+			githubPermissions.SetVisibility(repo.FullName, repo.Private)
+		}
+
+		if access.CanView(repo.FullName) {
+			visible = append(visible, repo)
+		}
+	}
+
+	return visible
+}
+
+// WriteForbidden responds with 403 Forbidden. Browsers are shown an error page, other clients receive the message
+// in plain text.
+func WriteForbidden(w http.ResponseWriter, req *http.Request, message string) {
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		http.Error(w, message, http.StatusForbidden)
+		return
+	}
+
+	WriteErrorPage(w, UserError{Message: message, BackURL: req.Referer()}, http.StatusForbidden)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/url"
+)
+
+//go:embed assets
+var embeddedAssets embed.FS
+
+// assetsHandler serves static assets embedded into the binary.
+func assetsHandler() http.Handler {
+	assets, err := fs.Sub(embeddedAssets, "assets")
+	if err != nil {
+		panic(err)
+	}
+
+	return http.FileServer(http.FS(assets))
+}
+
+// faviconHandler serves favicon.ico from embedded assets for browsers that request it from the root.
+func faviconHandler(assets http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := new(http.Request)
+		*r = *req
+		r.URL = &url.URL{Path: "/favicon/favicon.ico"}
+
+		assets.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	actionsMu sync.Mutex
+	actions   = make(map[string]struct{})
+)
+
+// Action registers the name of an action recorded to audit log, so that it's offered as a filter, and returns it.
+// Services declare their actions next to the code that records them:
+//
+//   var actionCreate = audit.Action("create")
+func Action(name string) string {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+
+	actions[name] = struct{}{}
+
+	return name
+}
+
+// Actions returns the names of registered actions in alphabetical order.
+func Actions() []string {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,425 @@
+// Package audit implements an append-only log of operations that change mirrors, recording who has done what,
+// to which repository and with which outcome. The log is kept as a file with one JSON object per line.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Actor types.
+const (
+	// ActorUser is a signed in user or anyone if authentication is disabled.
+	ActorUser = "user"
+	// ActorToken is a script or a service using an API token.
+	ActorToken = "token"
+	// ActorWebhook is a GitHub webhook delivery.
+	ActorWebhook = "webhook"
+	// ActorScheduler is a background job, i.e. replication from primary or reconciliation of declared mirrors.
+	ActorScheduler = "scheduler"
+	// ActorCLI is an administrator running a command line subcommand.
+	ActorCLI = "cli"
+)
+
+// Operation outcomes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+const (
+	// maxLineSize is the maximum size of an entry that can be read back from log.
+	maxLineSize = 1 << 20
+	// MaxQueryLimit is the maximum number of entries returned by a single Log.Query call.
+	MaxQueryLimit = 1000
+)
+
+// ErrClosed is returned by Log methods once the log has been closed.
+var ErrClosed = errors.New("audit log is closed")
+
+type actorContextKey struct{}
+
+// Actor is the one who has requested an operation.
+type Actor struct {
+	// One of Actor* constants.
+	Type string `json:"type"`
+	// User name, token owner, webhook delivery ID or the name of background job.
+	Name string `json:"name,omitempty"`
+	// Remote address for operations requested over HTTP.
+	Address string `json:"address,omitempty"`
+}
+
+func (a Actor) String() string {
+	if a.Name == "" {
+		return a.Type
+	}
+
+	return a.Type + ":" + a.Name
+}
+
+// WithActor returns a copy of ctx that carries actor. Operations recorded with this context are attributed to it.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns actor stored in ctx with WithActor. Operations without an actor are attributed
+// to an anonymous user.
+func ActorFromContext(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorContextKey{}).(Actor); ok {
+		return actor
+	}
+
+	return Actor{Type: ActorUser}
+}
+
+// Entry is a single audit log record.
+type Entry struct {
+	Time       time.Time         `json:"time"`
+	Actor      Actor             `json:"actor"`
+	Action     string            `json:"action"`
+	Repository string            `json:"repository,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Outcome    string            `json:"outcome"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Log is an append-only audit log stored in a JSON lines file. A nil *Log is valid and records nothing,
+// so that services can record operations unconditionally.
+type Log struct {
+	path string
+
+	mu      sync.Mutex
+	fd      *os.File
+	maxSize int64
+	keep    int
+}
+
+// Open opens an audit log at path creating the file and its parent directory if needed.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %s", err)
+	}
+
+	fd, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := terminateLastLine(fd); err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+
+	return &Log{path: path, fd: fd}, nil
+}
+
+func openFile(path string) (*os.File, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+
+	return fd, nil
+}
+
+// terminateLastLine appends a newline to a partially written entry left by a crash, so that it does not
+// swallow the next one.
+func terminateLastLine(fd *os.File) error {
+	fi, err := fd.Stat()
+	if err != nil || fi.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := fd.ReadAt(last, fi.Size()-1); err != nil {
+		return err
+	}
+
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = fd.Write([]byte{'\n'})
+	return err
+}
+
+// Path returns the path to log file.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// EnableRotation makes log move its file aside once it grows beyond maxSize bytes. Up to keep rotated files
+// are kept as <path>.1, <path>.2 and so on, the most recent first. Older files are removed.
+func (l *Log) EnableRotation(maxSize int64, keep int) {
+	if keep < 1 {
+		keep = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxSize, l.keep = maxSize, keep
+}
+
+// Record appends an entry for action on repository attributed to the actor stored in ctx. The outcome is
+// a failure if err is not nil. Since failing to audit an operation should not make the operation itself
+// fail, write errors are logged instead of being returned.
+func (l *Log) Record(ctx context.Context, action, repository string, params map[string]string, err error) {
+	if l == nil {
+		return
+	}
+
+	e := Entry{
+		Time:       time.Now().UTC(),
+		Actor:      ActorFromContext(ctx),
+		Action:     action,
+		Repository: repository,
+		Params:     params,
+		Outcome:    OutcomeSuccess,
+	}
+
+	if err != nil {
+		e.Outcome, e.Error = OutcomeFailure, err.Error()
+	}
+
+	if err := l.Append(e); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit log entry", "action", action, "repo", repository, "error", err)
+	}
+}
+
+// Append writes e to the end of log.
+func (l *Log) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fd == nil {
+		return ErrClosed
+	}
+
+	if err := l.reopenRotated(); err != nil {
+		return err
+	}
+
+	// A single write to a file opened with O_APPEND is not interleaved with writes of other processes
+	if _, err := l.fd.Write(line); err != nil {
+		return err
+	}
+
+	if err := l.fd.Sync(); err != nil {
+		return err
+	}
+
+	return l.rotate()
+}
+
+// reopenRotated reopens log file if it has been rotated by another process, i.e. command line tool running
+// alongside the server.
+func (l *Log) reopenRotated() error {
+	if l.maxSize <= 0 {
+		return nil
+	}
+
+	current, err := l.fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(l.path); err == nil && os.SameFile(current, fi) {
+		return nil
+	}
+
+	return l.reopen()
+}
+
+// rotate moves log file aside once it has grown beyond maxSize shifting previously rotated files.
+func (l *Log) rotate() error {
+	if l.maxSize <= 0 {
+		return nil
+	}
+
+	fi, err := l.fd.Stat()
+	if err != nil || fi.Size() < l.maxSize {
+		return err
+	}
+
+	for i := l.keep; i > 1; i-- {
+		if err := os.Rename(l.rotatedPath(i-1), l.rotatedPath(i)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit log: %s", err)
+		}
+	}
+
+	if err := os.Rename(l.path, l.rotatedPath(1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %s", err)
+	}
+
+	return l.reopen()
+}
+
+func (l *Log) reopen() error {
+	fd, err := openFile(l.path)
+	if err != nil {
+		return err
+	}
+
+	l.fd.Close()
+	l.fd = fd
+
+	return nil
+}
+
+func (l *Log) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// files returns paths to log file and files rotated from it, the most recent first.
+func (l *Log) files() []string {
+	files := []string{l.path}
+	for n := 1; ; n++ {
+		if _, err := os.Stat(l.rotatedPath(n)); err != nil {
+			return files
+		}
+		files = append(files, l.rotatedPath(n))
+	}
+}
+
+// Query returns entries matching filter, the most recent first, along with the total number of matching entries.
+// Log files are read backwards, so that only the requested page is kept in memory, and reading stops at the first
+// entry made before f.Since. At most MaxQueryLimit entries are returned, which is also the default limit.
+func (l *Log) Query(f Filter) ([]Entry, int, error) {
+	limit := f.Limit
+	if limit <= 0 || limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var (
+		matched []Entry
+		total   int
+	)
+	for _, path := range l.files() {
+		more, err := scanFileBackward(path, func(e Entry) bool {
+			// Entries are appended in chronological order
+			if !f.Since.IsZero() && e.Time.Before(f.Since) {
+				return false
+			}
+
+			if !f.Match(e) {
+				return true
+			}
+
+			if total >= f.Offset && len(matched) < limit {
+				matched = append(matched, e)
+			}
+			total++
+
+			return true
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return matched, total, nil
+}
+
+// Export writes entries matching filter to w as JSON lines in chronological order. Offset and Limit of
+// filter are ignored.
+func (l *Log) Export(w io.Writer, f Filter) error {
+	enc := json.NewEncoder(w)
+
+	files := l.files()
+
+	var writeErr error
+	for i := len(files) - 1; i >= 0; i-- {
+		err := scanFile(files[i], func(e Entry) {
+			if writeErr == nil && f.Match(e) {
+				writeErr = enc.Encode(e)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeErr
+}
+
+// Close closes the log file. Entries can't be appended to a closed log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fd == nil {
+		return ErrClosed
+	}
+
+	err := l.fd.Close()
+	l.fd = nil
+
+	return err
+}
+
+// scanFile calls fn for each entry in file at path. Lines that can't be parsed, i.e. partially written one after
+// a crash, are skipped.
+func scanFile(path string, fn func(Entry)) error {
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open audit log: %s", err)
+	}
+	defer fd.Close()
+
+	s := bufio.NewScanner(fd)
+	s.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for lineNum := 1; s.Scan(); lineNum++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			slog.Warn("skipping malformed audit log entry", "path", path, "line", lineNum, "error", err)
+			continue
+		}
+
+		fn(e)
+	}
+
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %s", err)
+	}
+
+	return nil
+}
+
+// RedactURL replaces password in URL with "xxxxx", so that it can be safely recorded.
+func RedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"strings"
+	"time"
+)
+
+// Filter selects audit log entries. Empty fields match any entry.
+type Filter struct {
+	// Actor matches either actor type, i.e. "webhook", name, or both in "type:name" form.
+	Actor      string
+	Action     string
+	Repository string
+	Outcome    string
+	// Since and Until limit entries to the time range [Since, Until).
+	Since, Until time.Time
+
+	// Offset and Limit select a page of entries returned by Log.Query. Limit is capped by MaxQueryLimit.
+	Offset, Limit int
+}
+
+// Match returns true if e satisfies filter.
+func (f Filter) Match(e Entry) bool {
+	if f.Actor != "" && f.Actor != e.Actor.Type && f.Actor != e.Actor.Name && f.Actor != e.Actor.String() {
+		return false
+	}
+
+	if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+
+	if f.Repository != "" && !matchRepository(f.Repository, e.Repository) {
+		return false
+	}
+
+	if f.Outcome != "" && f.Outcome != e.Outcome {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// matchRepository matches either the full repository name or all repositories of owner if pattern ends with "/".
+func matchRepository(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(strings.ToLower(name), strings.ToLower(pattern))
+	}
+
+	return strings.EqualFold(pattern, name)
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// readChunkSize is the number of bytes read at once while scanning log file backwards.
+const readChunkSize = 64 << 10
+
+// scanFileBackward calls fn for each entry in file at path starting from the last one until fn returns false.
+// It returns false if scanning has been stopped by fn. A missing file is treated as an empty one, since it might
+// have just been rotated.
+func scanFileBackward(path string, fn func(Entry) bool) (bool, error) {
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to open audit log: %s", err)
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to read audit log: %s", err)
+	}
+
+	// buf holds the data between offset and the beginning of the last line passed to fn
+	var buf []byte
+	chunk := make([]byte, readChunkSize)
+	for offset := fi.Size(); offset > 0; {
+		n := int64(len(chunk))
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+
+		if _, err := fd.ReadAt(chunk[:n], offset); err != nil {
+			return false, fmt.Errorf("failed to read audit log: %s", err)
+		}
+		buf = append(append(make([]byte, 0, int(n)+len(buf)), chunk[:n]...), buf...)
+
+		// Lines that follow a newline are complete, the first one might continue in the previous chunk
+		for i := bytes.LastIndexByte(buf, '\n'); i >= 0; i = bytes.LastIndexByte(buf, '\n') {
+			line := buf[i+1:]
+			buf = buf[:i]
+
+			if !parseLine(path, offset+int64(i)+1, line, fn) {
+				return false, nil
+			}
+		}
+
+		if len(buf) > maxLineSize {
+			return false, fmt.Errorf("failed to read audit log: line at offset %d is too long", offset)
+		}
+	}
+
+	return parseLine(path, 0, buf, fn), nil
+}
+
+// parseLine passes an entry stored in line to fn. Empty lines and lines that can't be parsed, i.e. partially
+// written one after a crash, are skipped.
+func parseLine(path string, offset int64, line []byte, fn func(Entry) bool) bool {
+	if len(line) == 0 {
+		return true
+	}
+
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		slog.Warn("skipping malformed audit log entry", "path", path, "offset", offset, "error", err)
+		return true
+	}
+
+	return fn(e)
+}
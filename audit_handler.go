@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/audit"
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/andrewslotin/doppelganger/logging"
+)
+
+const (
+	// auditPerPage is the number of entries shown on a single page of audit log.
+	auditPerPage = 100
+	// auditDateFormat is the format of "since" and "until" audit log query parameters.
+	auditDateFormat = "2006-01-02"
+)
+
+var (
+	auditTemplate = parsePageTemplate("admin/audit.html.template")
+)
+
+// AuditLogHandler is a type that implements http.Handler interface and is used to browse audit log at "/admin/audit".
+// The log is only available to admins.
+//
+//   // Show failed operations on acme/* mirrors since June 1st
+//   curl 'http://doppelganger/admin/audit?repo=acme/&outcome=failure&since=2019-06-01'
+//   // Export entries made by webhook deliveries as JSON lines
+//   curl 'http://doppelganger/admin/audit?actor=webhook&format=jsonl'
+type AuditLogHandler struct {
+	log *audit.Log
+}
+
+// NewAuditLogHandler creates and initializes a new handler.
+func NewAuditLogHandler(log *audit.Log) *AuditLogHandler {
+	return &AuditLogHandler{
+		log: log,
+	}
+}
+
+func (handler *AuditLogHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	access := accessFromRequest(req)
+	if !access.IsAdmin() {
+		WriteForbidden(w, req, "Audit log is only available to admins")
+		return
+	}
+
+	page := auditPageFromRequest(req)
+
+	if req.URL.Query().Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+		if err := handler.log.Export(w, page.Filter); err != nil {
+			slog.WarnContext(req.Context(), "failed to export audit log", "error", err)
+		}
+
+		return
+	}
+
+	entries, total, err := handler.log.Query(page.Filter)
+	if err != nil {
+		slog.WarnContext(req.Context(), "failed to read audit log", "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		return
+	}
+	page.Total = total
+
+	if acceptsJSON(req) {
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		json.NewEncoder(w).Encode(entries)
+
+		return
+	}
+
+	values := struct {
+		Entries []audit.Entry
+		Actions []string
+		Page    auditPage
+		User    *userAccess
+	}{
+		Entries: entries,
+		Actions: audit.Actions(),
+		Page:    page,
+		User:    access,
+	}
+
+	if err := auditTemplate.Execute(w, values); err != nil {
+		slog.WarnContext(req.Context(), "failed to render page", "template", "admin/audit", "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+	}
+}
+
+// auditPage holds the state of paginated audit log to be rendered in template.
+type auditPage struct {
+	audit.Filter
+
+	Path  string
+	Page  int
+	Total int
+	// Since and Until as they were provided in query.
+	SinceDate, UntilDate string
+}
+
+// auditPageFromRequest reads audit log filter from request parameters:
+//
+//   actor    - actor type, name or both as "type:name"
+//   action   - operation name, i.e. "create"
+//   repo     - repository name, or "owner/" for all repositories of owner
+//   outcome  - "success" or "failure"
+//   since    - only show entries made on or after this date (YYYY-MM-DD)
+//   until    - only show entries made on or before this date (YYYY-MM-DD)
+//   page     - page number starting from 1
+func auditPageFromRequest(req *http.Request) auditPage {
+	params := req.URL.Query()
+
+	p := auditPage{
+		Filter: audit.Filter{
+			Actor:      strings.TrimSpace(params.Get("actor")),
+			Action:     strings.TrimSpace(params.Get("action")),
+			Repository: strings.TrimSpace(params.Get("repo")),
+			Outcome:    params.Get("outcome"),
+			Limit:      auditPerPage,
+		},
+		Path: req.URL.Path,
+		Page: 1,
+	}
+
+	if since, err := time.ParseInLocation(auditDateFormat, params.Get("since"), time.Local); err == nil {
+		p.Since, p.SinceDate = since, params.Get("since")
+	}
+
+	if until, err := time.ParseInLocation(auditDateFormat, params.Get("until"), time.Local); err == nil {
+		p.Until, p.UntilDate = until.AddDate(0, 0, 1), params.Get("until")
+	}
+
+	if page, err := strconv.Atoi(params.Get("page")); err == nil && page > 0 {
+		p.Page = page
+	}
+	p.Offset = (p.Page - 1) * p.Limit
+
+	return p
+}
+
+// Pages returns the total number of pages.
+func (p auditPage) Pages() int {
+	return (p.Total + p.Limit - 1) / p.Limit
+}
+
+// HasPrev returns true if there is a previous page.
+func (p auditPage) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext returns true if there is a next page.
+func (p auditPage) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// PrevURL returns the URL of previous page.
+func (p auditPage) PrevURL() string {
+	return p.pageURL(p.Page-1, "")
+}
+
+// NextURL returns the URL of next page.
+func (p auditPage) NextURL() string {
+	return p.pageURL(p.Page+1, "")
+}
+
+// ExportURL returns the URL to download entries matching filter as JSON lines.
+func (p auditPage) ExportURL() string {
+	return p.pageURL(0, "jsonl")
+}
+
+func (p auditPage) pageURL(page int, format string) string {
+	params := make(url.Values)
+	for name, value := range map[string]string{
+		"actor":   p.Actor,
+		"action":  p.Action,
+		"repo":    p.Repository,
+		"outcome": p.Outcome,
+		"since":   p.SinceDate,
+		"until":   p.UntilDate,
+		"format":  format,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	if page > 0 {
+		params.Set("page", strconv.Itoa(page))
+	}
+
+	return p.Path + "?" + params.Encode()
+}
+
+// auditActorHandler stores the sender of request in its context, so that operations performed by next are
+// attributed to them in audit log. Webhook deliveries are identified by their delivery ID, authenticated requests
+// by user name or token owner.
+func auditActorHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		actor := audit.Actor{Type: audit.ActorUser, Name: "anonymous", Address: req.RemoteAddr}
+
+		if id := auth.IdentityFromContext(req.Context()); id != nil {
+			actor.Name = id.Username
+			if id.Scopes != nil {
+				actor.Type = audit.ActorToken
+			}
+		}
+
+		if req.URL.Path == "/apihook" {
+			actor.Type, actor.Name = audit.ActorWebhook, req.Header.Get(logging.GitHubDeliveryHeader)
+		}
+
+		next.ServeHTTP(w, req.WithContext(audit.WithActor(req.Context(), actor)))
+	})
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultCSRFCookie is the name of cookie that holds CSRF token.
+	DefaultCSRFCookie = "doppelganger_csrf"
+	// CSRFField is the name of form field that is expected to contain CSRF token.
+	CSRFField = "csrf_token"
+	// CSRFHeader can be used instead of CSRFField by scripts that send requests with a body other than a form.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+var (
+	// ErrCSRFToken is returned by CSRF.Verify if request does not carry a valid CSRF token.
+	ErrCSRFToken = errors.New("CSRF token is missing or invalid")
+	// ErrCrossOrigin is returned by CSRF.Verify if request has been sent from a page served by another site.
+	ErrCrossOrigin = errors.New("cross-origin request")
+)
+
+type csrfContextKey struct{}
+
+// CSRF protects handlers from cross-site request forgery. Each client is given a random token in a cookie,
+// which pages are expected to submit back along with forms (double submit cookie). Since other sites can
+// neither read nor set this cookie, a request that carries the same token in the form and in the cookie has
+// been sent from a page served by doppelganger.
+//
+// Requests that use bearer tokens are not checked, since browsers never send them on their own. Neither are
+// requests with a content type that can't be sent by an HTML form, as browsers require a CORS preflight for them.
+type CSRF struct {
+	CookieName string
+	// Secure forces Secure flag on CSRF cookie sent over plain HTTP, i.e. when TLS is terminated by a reverse proxy.
+	Secure bool
+
+	exempt []string
+}
+
+// NewCSRF returns an instance of CSRF.
+func NewCSRF() *CSRF {
+	return &CSRF{
+		CookieName: DefaultCSRFCookie,
+	}
+}
+
+// Exempt excludes paths from CSRF checks, e.g. endpoints that receive webhooks. A path ending with "/"
+// matches any path under it.
+func (c *CSRF) Exempt(paths ...string) {
+	c.exempt = append(c.exempt, paths...)
+}
+
+// Handler wraps next with CSRF protection. Requests that reach next carry CSRF token in their context, use
+// CSRFTokenFromContext to retrieve it. Requests that fail the check receive 403 Forbidden.
+func (c *CSRF) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := c.token(req)
+		if token == "" {
+			token = randomString()
+			http.SetCookie(w, &http.Cookie{
+				Name:     c.CookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   c.Secure || req.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		req = req.WithContext(context.WithValue(req.Context(), csrfContextKey{}, token))
+
+		if c.needsCheck(req) {
+			if err := c.Verify(req); err != nil {
+				slog.WarnContext(req.Context(), "rejected request", "method", req.Method, "path", req.URL.Path, "remote_addr", req.RemoteAddr, "error", err)
+				http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Verify checks that req has been sent from the same site and carries the CSRF token issued to the client.
+func (c *CSRF) Verify(req *http.Request) error {
+	if !sameOrigin(req) {
+		return ErrCrossOrigin
+	}
+
+	expected := c.token(req)
+	if expected == "" {
+		return ErrCSRFToken
+	}
+
+	actual := req.Header.Get(CSRFHeader)
+	if actual == "" {
+		actual = req.PostFormValue(CSRFField)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) != 1 {
+		return ErrCSRFToken
+	}
+
+	return nil
+}
+
+// CSRFTokenFromContext returns CSRF token stored in ctx by CSRF.Handler or an empty string if there is none.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey{}).(string)
+	return token
+}
+
+func (c *CSRF) token(req *http.Request) string {
+	cookie, err := req.Cookie(c.CookieName)
+	if err != nil || len(cookie.Value) < 32 {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func (c *CSRF) needsCheck(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+
+	for _, p := range c.exempt {
+		if req.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(req.URL.Path, p)) {
+			return false
+		}
+	}
+
+	if fields := strings.Fields(req.Header.Get("Authorization")); len(fields) == 2 && strings.EqualFold(fields[0], "Bearer") {
+		return false
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return true
+	default:
+		return false
+	}
+}
+
+// sameOrigin returns false if Origin or, in its absence, Referer header of req points to another host.
+// Requests without both headers are let through, since browsers send at least one of them with forms.
+func sameOrigin(req *http.Request) bool {
+	source := req.Header.Get("Origin")
+	if source == "" {
+		source = req.Header.Get("Referer")
+	}
+
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, req.Host)
+}
@@ -0,0 +1,157 @@
+package auth_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var echoCSRFTokenHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprint(w, auth.CSRFTokenFromContext(req.Context()))
+})
+
+// csrfToken obtains CSRF cookie from handler the same way a browser would do by requesting a page.
+func csrfToken(t *testing.T, handler http.Handler) *http.Cookie {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, auth.DefaultCSRFCookie, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, cookies[0].Value, rec.Body.String())
+
+	return cookies[0]
+}
+
+func newFormRequest(target string, values url.Values, cookie *http.Cookie) *http.Request {
+	req := httptest.NewRequest("POST", target, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	return req
+}
+
+func TestCSRF_IssuesToken(t *testing.T) {
+	handler := auth.NewCSRF().Handler(echoCSRFTokenHandler)
+	cookie := csrfToken(t, handler)
+
+	// The token is kept once issued
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.AddCookie(cookie)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Empty(t, rec.Result().Cookies())
+	assert.Equal(t, cookie.Value, rec.Body.String())
+}
+
+func TestCSRF_ValidToken(t *testing.T) {
+	handler := auth.NewCSRF().Handler(echoCSRFTokenHandler)
+	cookie := csrfToken(t, handler)
+
+	req := newFormRequest("http://example.com/mirror", url.Values{"csrf_token": {cookie.Value}}, cookie)
+	req.Header.Set("Origin", "http://example.com")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestCSRF_TokenInHeader(t *testing.T) {
+	handler := auth.NewCSRF().Handler(echoCSRFTokenHandler)
+	cookie := csrfToken(t, handler)
+
+	req := newFormRequest("http://example.com/mirror", url.Values{"action": {"update"}}, cookie)
+	req.Header.Set(auth.CSRFHeader, cookie.Value)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestCSRF_InvalidToken(t *testing.T) {
+	handler := auth.NewCSRF().Handler(echoCSRFTokenHandler)
+	cookie := csrfToken(t, handler)
+	otherCookie := csrfToken(t, handler)
+
+	for name, req := range map[string]*http.Request{
+		"missing cookie":  newFormRequest("http://example.com/mirror", url.Values{"csrf_token": {cookie.Value}}, nil),
+		"missing field":   newFormRequest("http://example.com/mirror", url.Values{"action": {"update"}}, cookie),
+		"token mismatch":  newFormRequest("http://example.com/mirror", url.Values{"csrf_token": {otherCookie.Value}}, cookie),
+		"no content type": httptest.NewRequest("POST", "http://example.com/mirror", nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		})
+	}
+}
+
+func TestCSRF_CrossOrigin(t *testing.T) {
+	handler := auth.NewCSRF().Handler(echoCSRFTokenHandler)
+	cookie := csrfToken(t, handler)
+
+	for name, headers := range map[string]map[string]string{
+		"origin":         {"Origin": "http://evil.example.org"},
+		"null origin":    {"Origin": "null"},
+		"referer":        {"Referer": "http://evil.example.org/page"},
+		"origin wins":    {"Origin": "http://evil.example.org", "Referer": "http://example.com/"},
+		"different port": {"Origin": "http://example.com:8080"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := newFormRequest("http://example.com/mirror", url.Values{"csrf_token": {cookie.Value}}, cookie)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		})
+	}
+}
+
+func TestCSRF_SkipsCheck(t *testing.T) {
+	csrf := auth.NewCSRF()
+	csrf.Exempt("/apihook", "/api/peers/")
+	handler := csrf.Handler(echoCSRFTokenHandler)
+
+	jsonRequest := httptest.NewRequest("POST", "http://example.com/a/b/info/lfs/objects/batch", strings.NewReader("{}"))
+	jsonRequest.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+
+	bearerRequest := newFormRequest("http://example.com/mirror", url.Values{"action": {"update"}}, nil)
+	bearerRequest.Header.Set("Authorization", "Bearer dgt_token")
+
+	for name, req := range map[string]*http.Request{
+		"exempt path":   newFormRequest("http://example.com/apihook", nil, nil),
+		"exempt prefix": newFormRequest("http://example.com/api/peers/notify", nil, nil),
+		"non-form body": jsonRequest,
+		"bearer token":  bearerRequest,
+		"safe method":   httptest.NewRequest("GET", "http://example.com/mirror", nil),
+		"head request":  httptest.NewRequest("HEAD", "http://example.com/", nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+}
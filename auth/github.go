@@ -0,0 +1,459 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	// DefaultGitHubURL is the URL of GitHub web interface used for OAuth login.
+	DefaultGitHubURL = "https://github.com"
+	// DefaultGitHubAPIURL is the URL of GitHub API.
+	DefaultGitHubAPIURL = "https://api.github.com"
+
+	githubReposPerPage = 100
+)
+
+// GitHub authenticates users with GitHub OAuth web flow. The access token issued by GitHub is kept in user
+// session to check which repositories they can read with GitHubPermissions.
+type GitHub struct {
+	ClientID     string
+	ClientSecret string
+	// URL of the callback endpoint registered with OAuth app. If empty, it is derived from the request.
+	RedirectURL string
+	// OAuth scopes to request. "repo" is required to check access to private repositories.
+	Scopes []string
+	// GitHub web and API URLs, can be changed to use GitHub Enterprise.
+	BaseURL, APIURL string
+
+	httpClient *http.Client
+	sessions   *Sessions
+}
+
+// NewGitHub returns an instance of GitHub login provider for OAuth app identified by clientID. The state of
+// login flow is kept in a short-lived cookie encrypted by sessions.
+func NewGitHub(httpClient *http.Client, clientID, clientSecret string, sessions *Sessions) *GitHub {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &GitHub{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"repo", "read:user"},
+		BaseURL:      DefaultGitHubURL,
+		APIURL:       DefaultGitHubAPIURL,
+		httpClient:   httpClient,
+		sessions:     sessions,
+	}
+}
+
+// Name returns provider name.
+func (p *GitHub) Name() string {
+	return "github"
+}
+
+// Login implements LoginProvider.
+func (p *GitHub) Login(w http.ResponseWriter, req *http.Request, next string) error {
+	st := newLoginState(next)
+	if err := saveLoginState(w, req, p.sessions, st); err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.redirectURL(req))
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", st.State)
+
+	http.Redirect(w, req, strings.TrimSuffix(p.BaseURL, "/")+"/login/oauth/authorize?"+q.Encode(), http.StatusFound)
+
+	return nil
+}
+
+// Callback implements LoginProvider.
+func (p *GitHub) Callback(w http.ResponseWriter, req *http.Request) (*Identity, string, error) {
+	q := req.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return nil, "", fmt.Errorf("GitHub returned %s: %s", e, q.Get("error_description"))
+	}
+
+	st, err := loadLoginState(w, req, p.sessions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := p.exchange(req.Context(), q.Get("code"), p.redirectURL(req))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var user struct {
+		Login string `json:"login"`
+		Email string `json:"email"`
+	}
+	if err := githubGet(req.Context(), p.httpClient, strings.TrimSuffix(p.APIURL, "/")+"/user", token, &user); err != nil {
+		return nil, "", fmt.Errorf("failed to get GitHub user: %s", err)
+	}
+
+	if user.Login == "" {
+		return nil, "", errors.New("GitHub did not return user login")
+	}
+
+	return &Identity{
+		Username: user.Login,
+		Email:    user.Email,
+		Provider: p.Name(),
+		Token:    token,
+	}, st.Next, nil
+}
+
+func (p *GitHub) exchange(ctx context.Context, code, redirectURL string) (string, error) {
+	form := url.Values{}
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(p.BaseURL, "/")+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ctxhttp.Do(ctx, p.httpClient, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange authorization code: GitHub returned %s", resp.Status)
+	}
+
+	// GitHub reports errors with 200 OK
+	var tokens struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %s", err)
+	}
+
+	if tokens.Error != "" {
+		return "", fmt.Errorf("failed to exchange authorization code: %s (%s)", tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.AccessToken == "" {
+		return "", errors.New("token response does not contain an access token")
+	}
+
+	return tokens.AccessToken, nil
+}
+
+func (p *GitHub) redirectURL(req *http.Request) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + req.Host + CallbackPath
+}
+
+// GitHubPermissions checks whether users are allowed to read GitHub repositories. Public repositories are
+// readable by anyone, private ones only by users that have access to them on GitHub. Repository visibility,
+// the list of repositories available to a user and collaborator permissions are cached for ttl.
+type GitHubPermissions struct {
+	APIURL string
+
+	httpClient *http.Client
+	token      string
+	ttl        time.Duration
+
+	mu            sync.Mutex
+	visibility    map[string]cachedVisibility
+	users         map[string]cachedRepos
+	collaborators map[string]cachedPermission
+	evictedAt     time.Time
+}
+
+type cachedVisibility struct {
+	Private   bool
+	CheckedAt time.Time
+}
+
+type cachedRepos struct {
+	Repos     map[string]struct{}
+	CheckedAt time.Time
+}
+
+type cachedPermission struct {
+	CanRead   bool
+	CheckedAt time.Time
+}
+
+// NewGitHubPermissions returns an instance of GitHubPermissions that uses token to look up repository visibility.
+func NewGitHubPermissions(httpClient *http.Client, token string, ttl time.Duration) *GitHubPermissions {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &GitHubPermissions{
+		APIURL:        DefaultGitHubAPIURL,
+		httpClient:    httpClient,
+		token:         token,
+		ttl:           ttl,
+		visibility:    make(map[string]cachedVisibility),
+		users:         make(map[string]cachedRepos),
+		collaborators: make(map[string]cachedPermission),
+	}
+}
+
+// CanRead returns true if repository fullName is public or user id can read it on GitHub. Personal API tokens
+// of GitHub users are allowed to read repositories their owner is a collaborator of. Other users can only read
+// public repositories.
+func (p *GitHubPermissions) CanRead(ctx context.Context, id *Identity, fullName string) (bool, error) {
+	fullName = strings.ToLower(fullName)
+
+	private, err := p.isPrivate(ctx, fullName)
+	if err != nil {
+		return false, err
+	}
+
+	if !private {
+		return true, nil
+	}
+
+	switch {
+	case id == nil || id.Service:
+		return false, nil
+	case id.Provider == "token" && id.OwnerProvider == "github":
+		// The GitHub access token of the owner is not kept along with API token, so we ask GitHub
+		// whether they are a collaborator using our own token
+		return p.isCollaborator(ctx, id.Username, fullName)
+	case id.Provider != "github" || id.Token == "":
+		return false, nil
+	}
+
+	repos, err := p.userRepos(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := repos[fullName]
+
+	return ok, nil
+}
+
+// RepositoryRole returns the role of user id for repository repo granted by policy. If permissions is not nil,
+// users who can't read a private repository on GitHub have no access to its mirror. Only those granted RoleAdmin
+// for all repositories by a loaded policy skip this check, a nil policy making everyone an admin does not count.
+// An empty repo means all repositories and is not checked against GitHub.
+func RepositoryRole(ctx context.Context, policy *Policy, permissions *GitHubPermissions, id *Identity, repo string) (Role, error) {
+	role := policy.Role(id, repo)
+	if role == RoleNone || permissions == nil || repo == "" {
+		return role, nil
+	}
+
+	if policy != nil && policy.Role(id, "") == RoleAdmin {
+		return role, nil
+	}
+
+	ok, err := permissions.CanRead(ctx, id, repo)
+	if err != nil {
+		return RoleNone, err
+	}
+
+	if !ok {
+		return RoleNone, nil
+	}
+
+	return role, nil
+}
+
+// SetVisibility records whether repository fullName is private, i.e. when it's known from repository list.
+func (p *GitHubPermissions) SetVisibility(fullName string, private bool) {
+	p.mu.Lock()
+	p.visibility[strings.ToLower(fullName)] = cachedVisibility{Private: private, CheckedAt: time.Now()}
+	p.evictExpired()
+	p.mu.Unlock()
+}
+
+// isPrivate returns true if repository is private or is not visible with the token of GitHubPermissions.
+func (p *GitHubPermissions) isPrivate(ctx context.Context, fullName string) (bool, error) {
+	p.mu.Lock()
+	v, ok := p.visibility[fullName]
+	p.mu.Unlock()
+
+	if ok && time.Since(v.CheckedAt) < p.ttl {
+		return v.Private, nil
+	}
+
+	var repo struct {
+		Private bool `json:"private"`
+	}
+
+	err := githubGet(ctx, p.httpClient, strings.TrimSuffix(p.APIURL, "/")+"/repos/"+fullName, p.token, &repo)
+	switch err {
+	case nil:
+	case errGitHubNotFound:
+		// Repository is either gone or not visible with our token, treat it as private so that only
+		// users who can see it on GitHub are able to read the mirror
+		repo.Private = true
+	default:
+		return true, err
+	}
+
+	p.mu.Lock()
+	p.visibility[fullName] = cachedVisibility{Private: repo.Private, CheckedAt: time.Now()}
+	p.evictExpired()
+	p.mu.Unlock()
+
+	return repo.Private, nil
+}
+
+// userRepos returns the set of repositories user id has access to on GitHub.
+func (p *GitHubPermissions) userRepos(ctx context.Context, id *Identity) (map[string]struct{}, error) {
+	p.mu.Lock()
+	cached, ok := p.users[id.Username]
+	p.mu.Unlock()
+
+	if ok && time.Since(cached.CheckedAt) < p.ttl {
+		return cached.Repos, nil
+	}
+
+	repos := make(map[string]struct{})
+	for page := 1; ; page++ {
+		var list []struct {
+			FullName string `json:"full_name"`
+		}
+
+		u := fmt.Sprintf("%s/user/repos?per_page=%d&page=%d", strings.TrimSuffix(p.APIURL, "/"), githubReposPerPage, page)
+		if err := githubGet(ctx, p.httpClient, u, id.Token, &list); err != nil {
+			return nil, fmt.Errorf("failed to list GitHub repositories of %s: %s", id.Username, err)
+		}
+
+		for _, repo := range list {
+			repos[strings.ToLower(repo.FullName)] = struct{}{}
+		}
+
+		if len(list) < githubReposPerPage {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	p.users[id.Username] = cachedRepos{Repos: repos, CheckedAt: time.Now()}
+	p.evictExpired()
+	p.mu.Unlock()
+
+	return repos, nil
+}
+
+// isCollaborator returns true if GitHub user username has at least read permission for repository fullName.
+// The permission is looked up with the token of GitHub permissions, which requires push access to the repository.
+func (p *GitHubPermissions) isCollaborator(ctx context.Context, username, fullName string) (bool, error) {
+	key := strings.ToLower(username) + " " + fullName
+
+	p.mu.Lock()
+	cached, ok := p.collaborators[key]
+	p.mu.Unlock()
+
+	if ok && time.Since(cached.CheckedAt) < p.ttl {
+		return cached.CanRead, nil
+	}
+
+	var permission struct {
+		Permission string `json:"permission"`
+	}
+
+	u := strings.TrimSuffix(p.APIURL, "/") + "/repos/" + fullName + "/collaborators/" + url.PathEscape(username) + "/permission"
+	err := githubGet(ctx, p.httpClient, u, p.token, &permission)
+	switch err {
+	case nil:
+	case errGitHubNotFound:
+		permission.Permission = "none"
+	default:
+		return false, fmt.Errorf("failed to check GitHub permissions of %s: %s", username, err)
+	}
+
+	canRead := permission.Permission != "" && permission.Permission != "none"
+
+	p.mu.Lock()
+	p.collaborators[key] = cachedPermission{CanRead: canRead, CheckedAt: time.Now()}
+	p.evictExpired()
+	p.mu.Unlock()
+
+	return canRead, nil
+}
+
+// evictExpired removes cache entries that are older than ttl. It's called with p.mu held each time an entry is
+// added and scans caches at most once per ttl.
+func (p *GitHubPermissions) evictExpired() {
+	if time.Since(p.evictedAt) < p.ttl {
+		return
+	}
+	p.evictedAt = time.Now()
+
+	for k, v := range p.visibility {
+		if time.Since(v.CheckedAt) >= p.ttl {
+			delete(p.visibility, k)
+		}
+	}
+
+	for k, v := range p.users {
+		if time.Since(v.CheckedAt) >= p.ttl {
+			delete(p.users, k)
+		}
+	}
+
+	for k, v := range p.collaborators {
+		if time.Since(v.CheckedAt) >= p.ttl {
+			delete(p.collaborators, k)
+		}
+	}
+}
+
+var errGitHubNotFound = errors.New("not found")
+
+func githubGet(ctx context.Context, httpClient *http.Client, u, token string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	resp, err := ctxhttp.Do(ctx, httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return errGitHubNotFound
+	default:
+		return fmt.Errorf("GET %s returned %s", req.URL.Path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,260 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// fakeGitHub serves both GitHub OAuth endpoints and the subset of API used by doppelganger. There are two
+// repositories: public acme/web and private acme/api, which is only accessible to jdoe. Collaborator permissions
+// of private repository can only be checked with the server token.
+type fakeGitHub struct {
+	*httptest.Server
+
+	UserRepos []string
+	Requests  int32
+}
+
+func newFakeGitHub() *fakeGitHub {
+	gh := &fakeGitHub{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/authorize", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		http.Redirect(w, req, q.Get("redirect_uri")+"?code=jdoe-code&state="+q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("client_id") != "doppelganger" || req.FormValue("client_secret") != "s3cr3t" || req.FormValue("code") != "jdoe-code" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "jdoe-token", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "token jdoe-token" {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprint(w, `{"login":"jdoe","email":"jdoe@example.com"}`)
+	})
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&gh.Requests, 1)
+
+		if req.Header.Get("Authorization") != "token jdoe-token" {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+
+		perPage, _ := strconv.Atoi(req.FormValue("per_page"))
+		page, _ := strconv.Atoi(req.FormValue("page"))
+
+		repos := []map[string]string{}
+		for i := (page - 1) * perPage; i < len(gh.UserRepos) && i < page*perPage; i++ {
+			repos = append(repos, map[string]string{"full_name": gh.UserRepos[i]})
+		}
+
+		json.NewEncoder(w).Encode(repos)
+	})
+	mux.HandleFunc("/repos/acme/web", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&gh.Requests, 1)
+		fmt.Fprint(w, `{"full_name":"acme/web","private":false}`)
+	})
+	mux.HandleFunc("/repos/acme/api/collaborators/", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&gh.Requests, 1)
+		if req.Header.Get("Authorization") != "token server-token" {
+			http.NotFound(w, req)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/repos/acme/api/collaborators/jdoe/permission":
+			fmt.Fprint(w, `{"permission":"read"}`)
+		case "/repos/acme/api/collaborators/alice/permission":
+			fmt.Fprint(w, `{"permission":"none"}`)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	mux.HandleFunc("/repos/acme/api", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&gh.Requests, 1)
+		if req.Header.Get("Authorization") != "token server-token" {
+			http.NotFound(w, req)
+			return
+		}
+
+		fmt.Fprint(w, `{"full_name":"acme/api","private":true}`)
+	})
+	gh.Server = httptest.NewServer(mux)
+
+	return gh
+}
+
+func TestGitHub_LoginFlow(t *testing.T) {
+	gh := newFakeGitHub()
+	defer gh.Close()
+
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+
+	provider := auth.NewGitHub(nil, "doppelganger", "s3cr3t", sessions)
+	provider.BaseURL, provider.APIURL = gh.URL, gh.URL
+
+	m := auth.NewMiddleware(sessions)
+	m.EnableLogin(provider)
+
+	app := httptest.NewServer(m.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := auth.IdentityFromContext(req.Context())
+		fmt.Fprintf(w, "%s %s %s", id, id.Token, req.URL.RequestURI())
+	})))
+	defer app.Close()
+
+	jar, _ := cookiejar.New(nil)
+	resp, body := browserGet(t, &http.Client{Jar: jar}, app.URL+"/acme/api")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, "jdoe (github) jdoe-token /acme/api", body)
+
+	for _, cookie := range jar.Cookies(resp.Request.URL) {
+		assert.NotContains(t, cookie.Value, "jdoe-token", "access token should not be readable by client")
+	}
+}
+
+func TestGitHubPermissions_CanRead(t *testing.T) {
+	gh := newFakeGitHub()
+	defer gh.Close()
+
+	// Make sure pagination is followed
+	for i := 0; i < 150; i++ {
+		gh.UserRepos = append(gh.UserRepos, fmt.Sprintf("jdoe/repo%d", i))
+	}
+	gh.UserRepos = append(gh.UserRepos, "Acme/API")
+
+	permissions := auth.NewGitHubPermissions(nil, "server-token", time.Minute)
+	permissions.APIURL = gh.URL
+
+	jdoe := &auth.Identity{Username: "jdoe", Provider: "github", Token: "jdoe-token"}
+	alice := &auth.Identity{Username: "alice", Provider: "htpasswd"}
+	jdoeToken := &auth.Identity{Username: "jdoe", Provider: "token", OwnerProvider: "github", Scopes: []auth.Scope{auth.ScopeRead}}
+	aliceToken := &auth.Identity{Username: "alice", Provider: "token", OwnerProvider: "github", Scopes: []auth.Scope{auth.ScopeRead}}
+	htpasswdToken := &auth.Identity{Username: "jdoe", Provider: "token", OwnerProvider: "htpasswd", Scopes: []auth.Scope{auth.ScopeRead}}
+	serviceToken := &auth.Identity{Username: "service:ci", Provider: "token", Service: true, Scopes: []auth.Scope{auth.ScopeRead}}
+
+	for _, tc := range []struct {
+		ID       *auth.Identity
+		Repo     string
+		Expected bool
+	}{
+		{nil, "acme/web", true},
+		{alice, "acme/web", true},
+		{jdoe, "acme/web", true},
+		{serviceToken, "acme/web", true},
+		{nil, "acme/api", false},
+		{alice, "acme/api", false},
+		{jdoe, "acme/api", true},
+		{jdoe, "acme/missing", false},
+		{jdoeToken, "acme/api", true},
+		{aliceToken, "acme/api", false},
+		{htpasswdToken, "acme/api", false},
+		{serviceToken, "acme/api", false},
+		{jdoeToken, "acme/missing", false},
+	} {
+		ok, err := permissions.CanRead(context.Background(), tc.ID, tc.Repo)
+		require.NoError(t, err)
+		assert.Equal(t, tc.Expected, ok, "%v %s", tc.ID, tc.Repo)
+	}
+
+	requests := atomic.LoadInt32(&gh.Requests)
+
+	ok, err := permissions.CanRead(context.Background(), jdoe, "acme/api")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = permissions.CanRead(context.Background(), jdoeToken, "acme/api")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, requests, atomic.LoadInt32(&gh.Requests), "permissions should be cached")
+}
+
+func TestGitHubPermissions_CacheExpires(t *testing.T) {
+	gh := newFakeGitHub()
+	defer gh.Close()
+
+	permissions := auth.NewGitHubPermissions(nil, "server-token", 50*time.Millisecond)
+	permissions.APIURL = gh.URL
+
+	jdoeToken := &auth.Identity{Username: "jdoe", Provider: "token", OwnerProvider: "github"}
+
+	ok, err := permissions.CanRead(context.Background(), jdoeToken, "acme/api")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	requests := atomic.LoadInt32(&gh.Requests)
+
+	time.Sleep(60 * time.Millisecond)
+
+	ok, err = permissions.CanRead(context.Background(), jdoeToken, "acme/api")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, requests+2, atomic.LoadInt32(&gh.Requests), "both visibility and permission should be checked again")
+}
+
+func TestRepositoryRole(t *testing.T) {
+	gh := newFakeGitHub()
+	defer gh.Close()
+
+	gh.UserRepos = []string{"acme/api"}
+
+	permissions := auth.NewGitHubPermissions(nil, "server-token", time.Minute)
+	permissions.APIURL = gh.URL
+
+	jdoe := &auth.Identity{Username: "jdoe", Provider: "github", Token: "jdoe-token"}
+	alice := &auth.Identity{Username: "alice", Provider: "htpasswd"}
+	bob := &auth.Identity{Username: "bob", Provider: "htpasswd"}
+	service := &auth.Identity{Username: "service:ci", Provider: "token", Service: true, Scopes: []auth.Scope{auth.ScopeRead}}
+
+	policy := auth.NewPolicy(
+		auth.Rule{Role: auth.RoleAdmin, Subject: "user:htpasswd:bob"},
+		auth.Rule{Role: auth.RoleAdmin, Subject: "user:htpasswd:alice", Repos: []string{"acme/*"}},
+		auth.Rule{Role: auth.RoleViewer, Subject: "*"},
+	)
+
+	for _, tc := range []struct {
+		Policy      *auth.Policy
+		Permissions *auth.GitHubPermissions
+		ID          *auth.Identity
+		Repo        string
+		Expected    auth.Role
+	}{
+		// No GitHub permissions, only roles apply
+		{nil, nil, alice, "acme/api", auth.RoleAdmin},
+		{policy, nil, jdoe, "acme/api", auth.RoleViewer},
+		// Nil policy makes everyone an admin, but only of the repositories they can read on GitHub
+		{nil, permissions, jdoe, "acme/api", auth.RoleAdmin},
+		{nil, permissions, alice, "acme/api", auth.RoleNone},
+		{nil, permissions, alice, "acme/web", auth.RoleAdmin},
+		{nil, permissions, alice, "", auth.RoleAdmin},
+		{nil, permissions, service, "acme/api", auth.RoleNone},
+		// Admins of all repositories granted by the roles file are not checked against GitHub
+		{policy, permissions, bob, "acme/api", auth.RoleAdmin},
+		{policy, permissions, service, "acme/api", auth.RoleAdmin},
+		// Admins of some repositories are
+		{policy, permissions, alice, "acme/api", auth.RoleNone},
+		{policy, permissions, alice, "acme/web", auth.RoleAdmin},
+		{policy, permissions, jdoe, "acme/api", auth.RoleViewer},
+	} {
+		role, err := auth.RepositoryRole(context.Background(), tc.Policy, tc.Permissions, tc.ID, tc.Repo)
+		require.NoError(t, err)
+		assert.Equal(t, tc.Expected, role, "%v %s (policy: %t, permissions: %t)", tc.ID, tc.Repo, tc.Policy != nil, tc.Permissions != nil)
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd is a Provider that authenticates users with HTTP basic auth checking their passwords against
+// an htpasswd file. Only bcrypt (htpasswd -B) and SHA1 (htpasswd -s) hashes are supported.
+type Htpasswd struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// NewHtpasswd loads users from htpasswd file located at path.
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Reload re-reads htpasswd file.
+func (h *Htpasswd) Reload() error {
+	fd, err := os.Open(h.path)
+	if err != nil {
+		return fmt.Errorf("failed to open htpasswd file: %s", err)
+	}
+	defer fd.Close()
+
+	users, err := ParseHtpasswd(fd)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", h.path, err)
+	}
+
+	h.mu.Lock()
+	h.users = users
+	h.mu.Unlock()
+
+	return nil
+}
+
+// ParseHtpasswd reads htpasswd entries from r and returns password hashes by user name.
+func ParseHtpasswd(r io.Reader) (map[string]string, error) {
+	users := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("malformed entry on line %d", n)
+		}
+
+		hash := fields[1]
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("unsupported password hash for %s on line %d, use bcrypt (htpasswd -B)", fields[0], n)
+		}
+
+		users[fields[0]] = hash
+	}
+
+	return users, s.Err()
+}
+
+// Name implements Provider.
+func (h *Htpasswd) Name() string {
+	return "htpasswd"
+}
+
+// Authenticate implements Provider.
+func (h *Htpasswd) Authenticate(req *http.Request) (*Identity, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	h.mu.RLock()
+	hash, ok := h.users[username]
+	h.mu.RUnlock()
+
+	if !ok || !checkPassword(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{Username: username, Provider: h.Name()}, nil
+}
+
+// HasUser implements UserDirectory.
+func (h *Htpasswd) HasUser(username string) bool {
+	h.mu.RLock()
+	_, ok := h.users[username]
+	h.mu.RUnlock()
+
+	return ok
+}
+
+// Challenge implements Challenger.
+func (h *Htpasswd) Challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Doppelganger", charset="UTF-8"`)
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
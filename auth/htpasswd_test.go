@@ -0,0 +1,69 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseHtpasswd(t *testing.T) {
+	users, err := auth.ParseHtpasswd(strings.NewReader("# comment\n\njdoe:$2y$05$abc\nroot:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"jdoe": "$2y$05$abc",
+		"root": "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+	}, users)
+
+	_, err = auth.ParseHtpasswd(strings.NewReader("jdoe:$apr1$abc$def\n"))
+	assert.Error(t, err, "MD5 hashes should be rejected")
+
+	_, err = auth.ParseHtpasswd(strings.NewReader("jdoe\n"))
+	assert.Error(t, err)
+}
+
+func TestHtpasswd_Authenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	fd, err := ioutil.TempFile("", "htpasswd")
+	require.NoError(t, err)
+	defer os.Remove(fd.Name())
+
+	// "password" hashed with htpasswd -s
+	_, err = fd.WriteString("jdoe:" + string(hash) + "\nroot:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n")
+	require.NoError(t, err)
+	fd.Close()
+
+	htpasswd, err := auth.NewHtpasswd(fd.Name())
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		Username, Password string
+		Err                error
+	}{
+		{"jdoe", "s3cr3t", nil},
+		{"root", "password", nil},
+		{"jdoe", "password", auth.ErrInvalidCredentials},
+		{"nobody", "s3cr3t", auth.ErrInvalidCredentials},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(tc.Username, tc.Password)
+
+		id, err := htpasswd.Authenticate(req)
+		if !assert.Equal(t, tc.Err, err, tc.Username+":"+tc.Password) || err != nil {
+			continue
+		}
+
+		assert.Equal(t, &auth.Identity{Username: tc.Username, Provider: "htpasswd"}, id)
+	}
+
+	_, err = htpasswd.Authenticate(httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, auth.ErrNoCredentials, err)
+}
@@ -0,0 +1,92 @@
+// Package auth implements authentication of Doppelganger users. Users are identified either by one of stateless
+// providers, such as HTTP basic auth checked against an htpasswd file or headers set by a trusted reverse proxy,
+// or by logging in with an OpenID Connect provider. Once authenticated, the identity is kept in a signed session cookie.
+package auth
+
+import (
+	"errors"
+	"path"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrNoCredentials is returned by Provider if request does not contain credentials it can check.
+	ErrNoCredentials = errors.New("no credentials provided")
+	// ErrInvalidCredentials is returned by Provider if request credentials are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type identityContextKey struct{}
+
+// Identity describes an authenticated user.
+type Identity struct {
+	// Unique user name within provider.
+	Username string `json:"sub"`
+	// Optional user email.
+	Email string `json:"email,omitempty"`
+	// Groups user belongs to as reported by provider.
+	Groups []string `json:"groups,omitempty"`
+	// The name of provider that authenticated user.
+	Provider string `json:"provider"`
+	// Access token issued by provider, used to check user permissions there.
+	Token string `json:"token,omitempty"`
+
+	// Scopes and repository name pattern requests authenticated with an API token are limited to.
+	// Scopes are nil for interactive users.
+	Scopes []Scope `json:"scopes,omitempty"`
+	Repos  string  `json:"repos,omitempty"`
+	// Service is true for service API tokens that are not bound to a user.
+	Service bool `json:"service,omitempty"`
+	// The name of provider that authenticated the owner of a personal API token.
+	OwnerProvider string `json:"owner_provider,omitempty"`
+}
+
+// String returns user name along with the provider, i.e. "jdoe (oidc)".
+func (id *Identity) String() string {
+	return id.Username + " (" + id.Provider + ")"
+}
+
+// UserProvider returns the name of provider that authenticated the user, which for personal API tokens is the
+// provider their owner has logged in with.
+func (id *Identity) UserProvider() string {
+	if id.OwnerProvider != "" {
+		return id.OwnerProvider
+	}
+
+	return id.Provider
+}
+
+// Allows returns true if identity is allowed to use scope for repository repo. Interactive users are not limited
+// by scopes, their permissions are defined by their role only. API tokens with ScopeAdmin are allowed
+// everything, and any scope implies ScopeRead.
+func (id *Identity) Allows(scope Scope, repo string) bool {
+	if id == nil || id.Scopes == nil {
+		return true
+	}
+
+	if id.Repos != "" && repo != "" {
+		if ok, _ := path.Match(id.Repos, repo); !ok {
+			return false
+		}
+	}
+
+	for _, s := range id.Scopes {
+		if s == scope || s == ScopeAdmin || scope == ScopeRead {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithIdentity returns a copy of ctx that carries id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// IdentityFromContext returns identity of the user stored in ctx or nil if request is not authenticated.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityContextKey{}).(*Identity)
+	return id
+}
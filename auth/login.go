@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	loginStateCookie = "doppelganger_login"
+	loginStateTTL    = 10 * time.Minute
+)
+
+// LoginProvider authenticates users interactively by redirecting them to an external identity provider.
+type LoginProvider interface {
+	Name() string
+	// Login redirects user to identity provider. Once authenticated, the user should be sent back to next.
+	Login(w http.ResponseWriter, req *http.Request, next string) error
+	// Callback handles the redirect from identity provider and returns the identity of user along with
+	// the URL they should be sent to.
+	Callback(w http.ResponseWriter, req *http.Request) (*Identity, string, error)
+}
+
+// loginState is kept in a short-lived cookie between redirect to identity provider and callback.
+type loginState struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce,omitempty"`
+	Verifier  string `json:"verifier,omitempty"`
+	Next      string `json:"next"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func newLoginState(next string) loginState {
+	return loginState{
+		State:     randomString(),
+		Nonce:     randomString(),
+		Verifier:  randomString(),
+		Next:      next,
+		ExpiresAt: time.Now().Add(loginStateTTL).Unix(),
+	}
+}
+
+func saveLoginState(w http.ResponseWriter, req *http.Request, sessions *Sessions, st loginState) error {
+	value, err := sessions.encode(loginStatePurpose, st)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(loginStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   sessions.secure(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// loadLoginState reads login state cookie, removes it and verifies that the state passed back by identity
+// provider matches.
+func loadLoginState(w http.ResponseWriter, req *http.Request, sessions *Sessions) (loginState, error) {
+	var st loginState
+
+	cookie, err := req.Cookie(loginStateCookie)
+	if err != nil {
+		return st, errors.New("missing login state, please try again")
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+
+	if err := sessions.decode(loginStatePurpose, cookie.Value, &st); err != nil || time.Now().Unix() > st.ExpiresAt {
+		return st, errors.New("login state expired, please try again")
+	}
+
+	if state := req.URL.Query().Get("state"); state == "" || state != st.State {
+		return st, errors.New("login state mismatch")
+	}
+
+	return st, nil
+}
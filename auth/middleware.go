@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// LoginPath starts interactive login flow.
+	LoginPath = "/auth/login"
+	// CallbackPath is the endpoint identity provider redirects user to after login.
+	CallbackPath = "/auth/callback"
+	// LogoutPath removes user session. It only accepts POST requests, so that other sites can't log user out.
+	LogoutPath = "/auth/logout"
+)
+
+// Provider authenticates requests using credentials they carry, such as Authorization header. Providers
+// return ErrNoCredentials if there are no credentials they understand in the request and ErrInvalidCredentials
+// if credentials were provided but are not valid.
+type Provider interface {
+	Name() string
+	Authenticate(req *http.Request) (*Identity, error)
+}
+
+// Challenger is implemented by providers that need to tell the client how to authenticate in a 401 response,
+// e.g. by setting WWW-Authenticate header.
+type Challenger interface {
+	Challenge(w http.ResponseWriter)
+}
+
+// Middleware makes sure that requests to wrapped handler are authenticated. It first looks up user session
+// and then asks providers one by one to authenticate the request. Unauthenticated browser requests are sent
+// to login page if an interactive login provider is configured, all other receive 401 Unauthorized.
+type Middleware struct {
+	sessions      *Sessions
+	providers     []Provider
+	loginProvider LoginProvider
+	users         *UserStore
+	public        []string
+}
+
+// NewMiddleware returns an instance of Middleware that keeps authenticated users in sessions.
+func NewMiddleware(sessions *Sessions, providers ...Provider) *Middleware {
+	return &Middleware{
+		sessions:  sessions,
+		providers: providers,
+	}
+}
+
+// EnableLogin enables interactive login with an external identity provider, such as OpenID Connect or GitHub.
+func (m *Middleware) EnableLogin(p LoginProvider) {
+	m.loginProvider = p
+}
+
+// EnableUsers makes Middleware record users in users each time they log in or authenticate with a provider.
+func (m *Middleware) EnableUsers(users *UserStore) {
+	m.users = users
+}
+
+// Public excludes paths from authentication. A path ending with "/" matches any path under it.
+func (m *Middleware) Public(paths ...string) {
+	m.public = append(m.public, paths...)
+}
+
+// Enabled returns true if there is at least one way to authenticate.
+func (m *Middleware) Enabled() bool {
+	return len(m.providers) > 0 || m.loginProvider != nil
+}
+
+// Handler wraps next with authentication. Requests that reach next carry user identity in their context.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case LoginPath:
+			m.login(w, req)
+			return
+		case CallbackPath:
+			m.callback(w, req)
+			return
+		case LogoutPath:
+			m.logout(w, req)
+			return
+		}
+
+		if m.isPublic(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		id, err := m.Authenticate(req)
+		switch err {
+		case nil:
+			next.ServeHTTP(w, req.WithContext(WithIdentity(req.Context(), id)))
+		case ErrNoCredentials:
+			m.unauthorized(w, req)
+		default:
+			slog.WarnContext(req.Context(), "failed to authenticate request", "method", req.Method, "path", req.URL.Path, "remote_addr", req.RemoteAddr, "error", err)
+			m.challenge(w)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	})
+}
+
+// Authenticate returns the identity of request sender. Identities without user name or provider are not
+// accepted.
+func (m *Middleware) Authenticate(req *http.Request) (*Identity, error) {
+	if id, err := m.sessions.Get(req); err == nil {
+		return id, nil
+	}
+
+	for _, p := range m.providers {
+		id, err := p.Authenticate(req)
+		switch {
+		case err == ErrNoCredentials:
+			continue
+		case err != nil:
+			return nil, err
+		case id == nil || id.Username == "" || id.Provider == "":
+			return nil, ErrInvalidCredentials
+		}
+
+		m.recordUser(req, id)
+
+		return id, nil
+	}
+
+	return nil, ErrNoCredentials
+}
+
+func (m *Middleware) login(w http.ResponseWriter, req *http.Request) {
+	if m.loginProvider == nil {
+		m.unauthorized(w, req)
+		return
+	}
+
+	if err := m.loginProvider.Login(w, req, safeRedirect(req.FormValue("next"))); err != nil {
+		slog.WarnContext(req.Context(), "failed to start login", "error", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+	}
+}
+
+func (m *Middleware) callback(w http.ResponseWriter, req *http.Request) {
+	if m.loginProvider == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	id, next, err := m.loginProvider.Callback(w, req)
+	if err != nil {
+		slog.WarnContext(req.Context(), "login failed", "error", err)
+		http.Error(w, "Login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := m.sessions.Set(w, req, id); err != nil {
+		slog.WarnContext(req.Context(), "failed to create session", "user", id.String(), "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	m.recordUser(req, id)
+
+	slog.InfoContext(req.Context(), "logged in", "user", id.String())
+	http.Redirect(w, req, safeRedirect(next), http.StatusFound)
+}
+
+// logout removes user session. The request is expected to be checked by CSRF, which wraps the middleware.
+func (m *Middleware) logout(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.sessions.Clear(w)
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
+
+// recordUser stores the groups of user id, so that they are used by API tokens of this user.
+func (m *Middleware) recordUser(req *http.Request, id *Identity) {
+	if m.users == nil {
+		return
+	}
+
+	if err := m.users.Record(id); err != nil {
+		slog.WarnContext(req.Context(), "failed to record user", "user", id.String(), "error", err)
+	}
+}
+
+func (m *Middleware) unauthorized(w http.ResponseWriter, req *http.Request) {
+	if m.loginProvider != nil && req.Method == http.MethodGet && strings.Contains(req.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, req, LoginPath+"?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusFound)
+		return
+	}
+
+	m.challenge(w)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func (m *Middleware) challenge(w http.ResponseWriter) {
+	for _, p := range m.providers {
+		if c, ok := p.(Challenger); ok {
+			c.Challenge(w)
+		}
+	}
+}
+
+func (m *Middleware) isPublic(path string) bool {
+	for _, p := range m.public {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// safeRedirect makes sure that user is only redirected within the same site after login.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
@@ -0,0 +1,212 @@
+package auth_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type providerMock struct {
+	ID  *auth.Identity
+	Err error
+}
+
+func (p providerMock) Name() string { return "mock" }
+
+func (p providerMock) Authenticate(req *http.Request) (*auth.Identity, error) { return p.ID, p.Err }
+
+func (p providerMock) Challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+}
+
+var echoIdentityHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	if id := auth.IdentityFromContext(req.Context()); id != nil {
+		fmt.Fprint(w, id.Username)
+	}
+})
+
+func TestMiddleware_Authenticated(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+	m := auth.NewMiddleware(sessions, providerMock{Err: auth.ErrNoCredentials}, providerMock{ID: &auth.Identity{Username: "jdoe", Provider: "mock"}})
+
+	rec := httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "jdoe", rec.Body.String())
+}
+
+func TestMiddleware_RecordsUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doppelganger-users")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	users, err := auth.NewUserStore(filepath.Join(dir, "users.json"))
+	require.NoError(t, err)
+
+	m := auth.NewMiddleware(auth.NewSessions([]byte("secret"), time.Hour), providerMock{ID: &auth.Identity{Username: "jdoe", Provider: "mock", Groups: []string{"ops"}}})
+	m.EnableUsers(users)
+
+	rec := httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	id, err := users.Lookup("mock", "jdoe")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops"}, id.Groups)
+}
+
+func TestMiddleware_Session(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+	m := auth.NewMiddleware(sessions, providerMock{Err: auth.ErrInvalidCredentials})
+
+	value, err := sessions.Encode(&auth.Identity{Username: "jdoe", Provider: "mock"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessions.CookieName, Value: value})
+
+	rec := httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "jdoe", rec.Body.String())
+}
+
+func TestMiddleware_Unauthorized(t *testing.T) {
+	for name, provider := range map[string]providerMock{
+		"no credentials":      {Err: auth.ErrNoCredentials},
+		"invalid credentials": {Err: auth.ErrInvalidCredentials},
+	} {
+		m := auth.NewMiddleware(auth.NewSessions([]byte("secret"), time.Hour), provider)
+
+		req := httptest.NewRequest("GET", "/src/", nil)
+		req.Header.Set("Accept", "text/html")
+
+		rec := httptest.NewRecorder()
+		m.Handler(echoIdentityHandler).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+		assert.Equal(t, `Basic realm="test"`, rec.Header().Get("WWW-Authenticate"), name)
+	}
+}
+
+func TestMiddleware_Public(t *testing.T) {
+	m := auth.NewMiddleware(auth.NewSessions([]byte("secret"), time.Hour), providerMock{Err: auth.ErrNoCredentials})
+	m.Public("/apihook", "/assets/")
+
+	for path, code := range map[string]int{
+		"/apihook":         http.StatusOK,
+		"/assets/main.css": http.StatusOK,
+		"/apihook/x":       http.StatusUnauthorized,
+		"/assets":          http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		m.Handler(echoIdentityHandler).ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+
+		assert.Equal(t, code, rec.Code, path)
+	}
+}
+
+func TestMiddleware_LoginRedirect(t *testing.T) {
+	idp := newFakeIdP(t, "doppelganger")
+	defer idp.Close()
+
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+	m := auth.NewMiddleware(sessions)
+	m.EnableLogin(auth.NewOIDC(nil, idp.URL, idp.ClientID, "s3cr3t", sessions))
+
+	req := httptest.NewRequest("GET", "/src/?q=a", nil)
+	req.Header.Set("Accept", "text/html")
+
+	rec := httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, auth.LoginPath+"?next=%2Fsrc%2F%3Fq%3Da", rec.Header().Get("Location"))
+
+	// API clients should not be redirected
+	rec = httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/api/mirrors", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestMiddleware_IncompleteIdentity(t *testing.T) {
+	for name, id := range map[string]*auth.Identity{
+		"no user name": {Provider: "mock"},
+		"no provider":  {Username: "jdoe"},
+	} {
+		m := auth.NewMiddleware(auth.NewSessions([]byte("secret"), time.Hour), providerMock{ID: id})
+
+		rec := httptest.NewRecorder()
+		m.Handler(echoIdentityHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+	}
+}
+
+func TestMiddleware_LoginStateReplayedAsSession(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+
+	m := auth.NewMiddleware(sessions)
+	m.EnableLogin(auth.NewGitHub(nil, "doppelganger", "s3cr3t", sessions))
+
+	rec := httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, httptest.NewRequest("GET", auth.LoginPath, nil))
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	var loginState *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "doppelganger_login" {
+			loginState = cookie
+		}
+	}
+	require.NotNil(t, loginState, "login state cookie should be set")
+
+	req := httptest.NewRequest("GET", "/api/mirrors", nil)
+	req.AddCookie(&http.Cookie{Name: sessions.CookieName, Value: loginState.Value})
+
+	rec = httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "login state should not be accepted as a session")
+}
+
+func TestMiddleware_Logout(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+	m := auth.NewMiddleware(sessions, providerMock{Err: auth.ErrNoCredentials})
+
+	value, err := sessions.Encode(&auth.Identity{Username: "jdoe", Provider: "mock"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", auth.LogoutPath, nil)
+	req.AddCookie(&http.Cookie{Name: sessions.CookieName, Value: value})
+
+	rec := httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Empty(t, rec.Result().Cookies(), "session should not be removed by GET request")
+
+	req = httptest.NewRequest("POST", auth.LogoutPath, nil)
+	req.AddCookie(&http.Cookie{Name: sessions.CookieName, Value: value})
+
+	rec = httptest.NewRecorder()
+	m.Handler(echoIdentityHandler).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	if cookies := rec.Result().Cookies(); assert.Len(t, cookies, 1) {
+		assert.Equal(t, sessions.CookieName, cookies[0].Name)
+		assert.True(t, cookies[0].MaxAge < 0)
+	}
+}
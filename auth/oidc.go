@@ -0,0 +1,436 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	// Allowed clock skew between Doppelganger and identity provider.
+	oidcClockSkew = time.Minute
+)
+
+// ErrInvalidIDToken is returned by OIDC if identity provider returned an ID token that could not be verified.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// OIDC authenticates users by redirecting them to an OpenID Connect identity provider. It implements the
+// authorization code flow with PKCE and verifies RS256-signed ID tokens against the keys published by provider.
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// URL of the callback endpoint registered with provider. If empty, it is derived from the request.
+	RedirectURL string
+	// Additional scopes to request besides "openid". Some providers require a "groups" scope to include
+	// user groups into ID token.
+	Scopes []string
+
+	httpClient *http.Client
+	sessions   *Sessions
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          interface{} `json:"aud"`
+	ExpiresAt         int64       `json:"exp"`
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	PreferredUsername string      `json:"preferred_username"`
+	Groups            []string    `json:"groups"`
+}
+
+// NewOIDC returns an instance of OIDC for provider identified by issuer URL. The state of login flow is kept
+// in a short-lived cookie signed by sessions.
+func NewOIDC(httpClient *http.Client, issuer, clientID, clientSecret string, sessions *Sessions) *OIDC {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &OIDC{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"email", "profile"},
+		httpClient:   httpClient,
+		sessions:     sessions,
+	}
+}
+
+// Name returns provider name.
+func (p *OIDC) Name() string {
+	return "oidc"
+}
+
+// Login redirects user to the authorization endpoint of identity provider. Once authenticated, the user
+// is sent back to next.
+func (p *OIDC) Login(w http.ResponseWriter, req *http.Request, next string) error {
+	d, err := p.discover(req.Context())
+	if err != nil {
+		return err
+	}
+
+	st := newLoginState(next)
+	if err := saveLoginState(w, req, p.sessions, st); err != nil {
+		return err
+	}
+
+	challenge := sha256.Sum256([]byte(st.Verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.redirectURL(req))
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.Scopes...), " "))
+	q.Set("state", st.State)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	http.Redirect(w, req, d.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+
+	return nil
+}
+
+// Callback handles the redirect from identity provider, exchanges authorization code for an ID token and
+// returns the identity of user along with the URL they should be sent to.
+func (p *OIDC) Callback(w http.ResponseWriter, req *http.Request) (*Identity, string, error) {
+	q := req.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return nil, "", fmt.Errorf("identity provider returned %s: %s", e, q.Get("error_description"))
+	}
+
+	st, err := loadLoginState(w, req, p.sessions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rawIDToken, err := p.exchange(req.Context(), q.Get("code"), st.Verifier, p.redirectURL(req))
+	if err != nil {
+		return nil, "", err
+	}
+
+	claims, err := p.verify(req.Context(), rawIDToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if claims.Nonce != st.Nonce {
+		return nil, "", fmt.Errorf("%s: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	id := &Identity{
+		Username: claims.Subject,
+		Email:    claims.Email,
+		Groups:   claims.Groups,
+		Provider: p.Name(),
+	}
+
+	switch {
+	case claims.PreferredUsername != "":
+		id.Username = claims.PreferredUsername
+	case claims.Email != "":
+		id.Username = claims.Email
+	}
+
+	return id, st.Next, nil
+}
+
+// verify checks the signature, issuer, audience and expiration time of an ID token and returns its claims.
+func (p *OIDC) verify(ctx context.Context, rawIDToken string) (*oidcClaims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%s: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidIDToken, err)
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%s: unsupported signing algorithm %q", ErrInvalidIDToken, header.Alg)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidIDToken, err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%s: signature mismatch", ErrInvalidIDToken)
+	}
+
+	var claims oidcClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%s: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+
+	if !audienceContains(claims.Audience, p.ClientID) {
+		return nil, fmt.Errorf("%s: token was issued for another client", ErrInvalidIDToken)
+	}
+
+	if time.Now().Add(-oidcClockSkew).Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("%s: token expired", ErrInvalidIDToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%s: missing subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+func (p *OIDC) exchange(ctx context.Context, code, verifier, redirectURL string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := ctxhttp.Do(ctx, p.httpClient, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange authorization code: token endpoint returned %s", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %s", err)
+	}
+
+	if tokens.IDToken == "" {
+		return "", errors.New("token response does not contain an ID token")
+	}
+
+	return tokens.IDToken, nil
+}
+
+// discover fetches and caches provider metadata.
+func (p *OIDC) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID Connect provider %s: %s", p.Issuer, err)
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("OpenID Connect provider reported issuer %q instead of %q", d.Issuer, p.Issuer)
+	}
+	p.Issuer = d.Issuer
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("OpenID Connect provider %s metadata is incomplete", p.Issuer)
+	}
+
+	p.discovery = &d
+
+	return p.discovery, nil
+}
+
+// key returns the public key with given ID re-fetching provider keys if it is not known yet, i.e. after key rotation.
+func (p *OIDC) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch OpenID Connect provider keys: %s", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%s: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+func (p *OIDC) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+
+	// Tokens without key ID are accepted only if provider publishes a single key
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+func (p *OIDC) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ctxhttp.Do(ctx, p.httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDC) redirectURL(req *http.Request) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + req.Host + CallbackPath
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate random string: %s", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
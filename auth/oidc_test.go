@@ -0,0 +1,258 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIdP is a minimal OpenID Connect provider that logs in every user as jdoe.
+type fakeIdP struct {
+	*httptest.Server
+
+	ClientID string
+	Claims   map[string]interface{}
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newFakeIdP(t *testing.T, clientID string) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &fakeIdP{
+		ClientID: clientID,
+		key:      key,
+		codes:    make(map[string]url.Values),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+
+	return idp
+}
+
+func (idp *fakeIdP) discovery(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+func (idp *fakeIdP) jwks(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *fakeIdP) authorize(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("client_id") != idp.ClientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+
+	idp.mu.Lock()
+	idp.codes[code] = q
+	idp.mu.Unlock()
+
+	http.Redirect(w, req, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, req *http.Request) {
+	if clientID, secret, _ := req.BasicAuth(); clientID != idp.ClientID || secret != "s3cr3t" {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	idp.mu.Lock()
+	q, ok := idp.codes[req.FormValue("code")]
+	delete(idp.codes, req.FormValue("code"))
+	idp.mu.Unlock()
+
+	if !ok || q.Get("redirect_uri") != req.FormValue("redirect_uri") {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(req.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != q.Get("code_challenge") {
+		http.Error(w, "invalid code verifier", http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":                idp.URL,
+		"sub":                "1234",
+		"aud":                idp.ClientID,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              q.Get("nonce"),
+		"email":              "jdoe@example.com",
+		"preferred_username": "jdoe",
+	}
+	for k, v := range idp.Claims {
+		claims[k] = v
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "token",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(claims),
+	})
+}
+
+func (idp *fakeIdP) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newOIDCApp(idp *fakeIdP) *httptest.Server {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+
+	m := auth.NewMiddleware(sessions)
+	m.EnableLogin(auth.NewOIDC(nil, idp.URL, idp.ClientID, "s3cr3t", sessions))
+
+	return httptest.NewServer(m.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s %s", auth.IdentityFromContext(req.Context()), req.URL.RequestURI())
+	})))
+}
+
+func browserGet(t *testing.T, client *http.Client, u string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", u, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestOIDC_LoginFlow(t *testing.T) {
+	idp := newFakeIdP(t, "doppelganger")
+	defer idp.Close()
+
+	app := newOIDCApp(idp)
+	defer app.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	resp, body := browserGet(t, client, app.URL+"/src/?q=doppelganger")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, "jdoe (oidc) /src/?q=doppelganger", body)
+
+	// Subsequent requests are authenticated with session cookie
+	resp, body = browserGet(t, &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, app.URL+"/mirror")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "jdoe (oidc) /mirror", body)
+}
+
+func TestOIDC_InvalidToken(t *testing.T) {
+	for name, claims := range map[string]map[string]interface{}{
+		"another client": {"aud": "another"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"another issuer": {"iss": "https://idp.example.com"},
+		"nonce mismatch": {"nonce": "replayed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			idp := newFakeIdP(t, "doppelganger")
+			defer idp.Close()
+			idp.Claims = claims
+
+			app := newOIDCApp(idp)
+			defer app.Close()
+
+			jar, _ := cookiejar.New(nil)
+			resp, _ := browserGet(t, &http.Client{Jar: jar}, app.URL+"/")
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		})
+	}
+}
+
+func TestOIDC_StateMismatch(t *testing.T) {
+	idp := newFakeIdP(t, "doppelganger")
+	defer idp.Close()
+
+	app := newOIDCApp(idp)
+	defer app.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, _ := browserGet(t, client, app.URL+auth.LoginPath)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	resp, _ = browserGet(t, client, app.URL+auth.CallbackPath+"?code=code&state=forged")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestOIDC_LoginFlow_ExternalRedirect(t *testing.T) {
+	idp := newFakeIdP(t, "doppelganger")
+	defer idp.Close()
+
+	app := newOIDCApp(idp)
+	defer app.Close()
+
+	jar, _ := cookiejar.New(nil)
+	resp, body := browserGet(t, &http.Client{Jar: jar}, app.URL+auth.LoginPath+"?next=//evil.example.com/")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, "jdoe (oidc) /", body)
+}
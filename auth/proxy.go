@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultProxyUserHeader is the request header used by reverse proxies to pass authenticated user name.
+const DefaultProxyUserHeader = "X-Forwarded-User"
+
+// ProxyHeaders is a Provider that trusts user identity passed in request headers by an authenticating
+// reverse proxy, such as oauth2-proxy. Headers are only accepted from requests originating from trusted
+// networks, otherwise anyone could impersonate any user by setting them.
+type ProxyHeaders struct {
+	UserHeader  string
+	EmailHeader string
+	// Optional header with comma-separated list of user groups.
+	GroupsHeader string
+
+	trusted []*net.IPNet
+}
+
+// NewProxyHeaders returns an instance of ProxyHeaders that reads user name from userHeader and email from
+// emailHeader (optional) for requests coming from trustedCIDRs, i.e. "10.0.0.0/8" or "127.0.0.1".
+func NewProxyHeaders(userHeader, emailHeader string, trustedCIDRs []string) (*ProxyHeaders, error) {
+	p := &ProxyHeaders{
+		UserHeader:  userHeader,
+		EmailHeader: emailHeader,
+	}
+
+	for _, cidr := range trustedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q: %s", cidr, err)
+		}
+
+		p.trusted = append(p.trusted, ipNet)
+	}
+
+	if len(p.trusted) == 0 {
+		return nil, fmt.Errorf("no trusted proxy addresses provided")
+	}
+
+	return p, nil
+}
+
+// Name implements Provider.
+func (p *ProxyHeaders) Name() string {
+	return "proxy"
+}
+
+// Authenticate implements Provider.
+func (p *ProxyHeaders) Authenticate(req *http.Request) (*Identity, error) {
+	username := strings.TrimSpace(req.Header.Get(p.UserHeader))
+	if username == "" {
+		return nil, ErrNoCredentials
+	}
+
+	if !p.isTrusted(req.RemoteAddr) {
+		return nil, ErrNoCredentials
+	}
+
+	id := &Identity{Username: username, Provider: p.Name()}
+	if p.EmailHeader != "" {
+		id.Email = strings.TrimSpace(req.Header.Get(p.EmailHeader))
+	}
+
+	if p.GroupsHeader != "" {
+		for _, group := range strings.Split(req.Header.Get(p.GroupsHeader), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				id.Groups = append(id.Groups, group)
+			}
+		}
+	}
+
+	return id, nil
+}
+
+func (p *ProxyHeaders) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range p.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package auth_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHeaders_Authenticate(t *testing.T) {
+	proxy, err := auth.NewProxyHeaders("X-Forwarded-User", "X-Forwarded-Email", []string{"10.0.0.0/8", "::1"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:54321"
+	req.Header.Set("X-Forwarded-User", "jdoe")
+	req.Header.Set("X-Forwarded-Email", "jdoe@example.com")
+
+	id, err := proxy.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, &auth.Identity{Username: "jdoe", Email: "jdoe@example.com", Provider: "proxy"}, id)
+
+	proxy.GroupsHeader = "X-Forwarded-Groups"
+	req.Header.Set("X-Forwarded-Groups", "backend, ops,")
+
+	id, err = proxy.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backend", "ops"}, id.Groups)
+
+	req.RemoteAddr = "[::1]:54321"
+	_, err = proxy.Authenticate(req)
+	assert.NoError(t, err)
+
+	req.RemoteAddr = "192.168.1.1:54321"
+	_, err = proxy.Authenticate(req)
+	assert.Equal(t, auth.ErrNoCredentials, err, "headers from untrusted addresses should be ignored")
+}
+
+func TestNewProxyHeaders_InvalidCIDR(t *testing.T) {
+	_, err := auth.NewProxyHeaders("X-Forwarded-User", "", []string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	_, err = auth.NewProxyHeaders("X-Forwarded-User", "", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Role defines what user is allowed to do. Each role includes permissions of all roles below it.
+type Role int
+
+// Supported roles.
+const (
+	// RoleNone grants no access.
+	RoleNone Role = iota
+	// RoleViewer allows to browse and clone mirrors.
+	RoleViewer
+	// RoleOperator allows to create and synchronize mirrors.
+	RoleOperator
+	// RoleAdmin allows to manage mirror settings and delete mirrors.
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+// ParseRole returns role by its name.
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if strings.EqualFold(s, name) {
+			return role, nil
+		}
+	}
+
+	return RoleNone, fmt.Errorf("unknown role %q", s)
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Rule grants role to a subject for repositories matching any of patterns. Subject is either
+// "user:<provider>:<name>", "group:<name>" or "*" for any authenticated user. User names are qualified with
+// the provider that authenticated the user, i.e. "user:github:jdoe", since different providers might know
+// different people under the same name. Patterns use path.Match syntax, i.e. "acme/*". A rule
+// without patterns applies to all repositories.
+type Rule struct {
+	Role    Role
+	Subject string
+	Repos   []string
+}
+
+// Matches returns true if rule applies to user id and repository repo. An empty repo matches only rules
+// that apply to all repositories.
+func (rule Rule) Matches(id *Identity, repo string) bool {
+	if !rule.matchesSubject(id) {
+		return false
+	}
+
+	if len(rule.Repos) == 0 {
+		return true
+	}
+
+	for _, pattern := range rule.Repos {
+		if ok, _ := path.Match(pattern, repo); ok && repo != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule Rule) matchesSubject(id *Identity) bool {
+	if id == nil {
+		return false
+	}
+
+	switch {
+	case rule.Subject == "*":
+		return true
+	case strings.HasPrefix(rule.Subject, "user:"):
+		return rule.Subject == "user:"+id.UserProvider()+":"+id.Username
+	case strings.HasPrefix(rule.Subject, "group:"):
+		group := strings.TrimPrefix(rule.Subject, "group:")
+		for _, g := range id.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Policy assigns roles to users. A user is granted the highest role among all matching rules.
+// A nil *Policy grants RoleAdmin to everyone, which is used when no roles are configured.
+type Policy struct {
+	rules []Rule
+}
+
+// NewPolicy returns an instance of Policy with provided rules.
+func NewPolicy(rules ...Rule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// LoadPolicy reads role assignments from file located at path. See ParsePolicy for the file format.
+func LoadPolicy(path string) (*Policy, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open roles file: %s", err)
+	}
+	defer fd.Close()
+
+	p, err := ParsePolicy(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	return p, nil
+}
+
+// ParsePolicy reads role assignments from r. Each line consists of a role, subject and optional list of
+// repository name patterns separated by whitespace:
+//
+//   # role     subject            repositories
+//   admin      user:github:jdoe
+//   operator   group:backend      acme/api acme/lib-*
+//   viewer     *
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	var rules []Rule
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("malformed rule on line %d", n)
+		}
+
+		role, err := ParseRole(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s on line %d", err, n)
+		}
+
+		subject := fields[1]
+		if !validSubject(subject) {
+			return nil, fmt.Errorf("invalid subject %q on line %d, expected user:<provider>:<name>, group:<name> or *", subject, n)
+		}
+
+		for _, pattern := range fields[2:] {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid repository pattern %q on line %d", pattern, n)
+			}
+		}
+
+		rules = append(rules, Rule{Role: role, Subject: subject, Repos: fields[2:]})
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewPolicy(rules...), nil
+}
+
+// validSubject returns true if subject is either "*", "group:<name>" or "user:<provider>:<name>".
+func validSubject(subject string) bool {
+	switch {
+	case subject == "*":
+		return true
+	case strings.HasPrefix(subject, "group:"):
+		return subject != "group:"
+	case strings.HasPrefix(subject, "user:"):
+		fields := strings.SplitN(strings.TrimPrefix(subject, "user:"), ":", 2)
+		return len(fields) == 2 && fields[0] != "" && fields[1] != ""
+	default:
+		return false
+	}
+}
+
+// Role returns the role of user id for repository repo. If repo is empty, only rules that apply to all
+// repositories are considered. Service tokens are granted RoleAdmin, since their permissions are limited
+// by scopes.
+func (p *Policy) Role(id *Identity, repo string) Role {
+	if p == nil || (id != nil && id.Service) {
+		return RoleAdmin
+	}
+
+	role := RoleNone
+	for _, rule := range p.rules {
+		if rule.Role > role && rule.Matches(id, repo) {
+			role = rule.Role
+		}
+	}
+
+	return role
+}
+
+// Allowed returns true if user id has at least role required for repository repo.
+func (p *Policy) Allowed(id *Identity, repo string, required Role) bool {
+	return p.Role(id, repo) >= required
+}
@@ -0,0 +1,77 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := auth.ParsePolicy(strings.NewReader(`
+# role     subject             repositories
+admin      user:github:alice
+operator   group:backend       acme/api acme/lib-*
+viewer     *
+`))
+	require.NoError(t, err)
+
+	alice := &auth.Identity{Username: "alice", Provider: "github"}
+	bob := &auth.Identity{Username: "bob", Provider: "oidc", Groups: []string{"frontend", "backend"}}
+	carol := &auth.Identity{Username: "carol", Provider: "oidc"}
+	// Another alice who signed in with a different provider is not the same user
+	otherAlice := &auth.Identity{Username: "alice", Provider: "htpasswd"}
+	// Personal tokens act as their owner
+	aliceToken := &auth.Identity{Username: "alice", Provider: "token", OwnerProvider: "github", Scopes: []auth.Scope{auth.ScopeRead}}
+
+	for _, tc := range []struct {
+		ID       *auth.Identity
+		Repo     string
+		Expected auth.Role
+	}{
+		{alice, "acme/api", auth.RoleAdmin},
+		{alice, "", auth.RoleAdmin},
+		{otherAlice, "acme/api", auth.RoleViewer},
+		{aliceToken, "acme/api", auth.RoleAdmin},
+		{bob, "acme/api", auth.RoleOperator},
+		{bob, "acme/lib-json", auth.RoleOperator},
+		{bob, "acme/web", auth.RoleViewer},
+		{bob, "", auth.RoleViewer},
+		{carol, "acme/api", auth.RoleViewer},
+		{nil, "acme/api", auth.RoleNone},
+	} {
+		assert.Equal(t, tc.Expected, policy.Role(tc.ID, tc.Repo), "%v %s", tc.ID, tc.Repo)
+	}
+
+	assert.True(t, policy.Allowed(bob, "acme/api", auth.RoleViewer))
+	assert.False(t, policy.Allowed(bob, "acme/api", auth.RoleAdmin))
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	for name, policy := range map[string]string{
+		"unknown role":       "owner user:github:alice",
+		"missing subject":    "admin",
+		"invalid subject":    "admin alice",
+		"unqualified user":   "admin user:alice",
+		"user without name":  "admin user:github:",
+		"group without name": "admin group:",
+		"invalid pattern":    "admin user:github:alice acme/[",
+	} {
+		_, err := auth.ParsePolicy(strings.NewReader(policy))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestPolicy_Nil(t *testing.T) {
+	var policy *auth.Policy
+	assert.Equal(t, auth.RoleAdmin, policy.Role(nil, "acme/api"), "nil policy should grant full access")
+}
+
+func TestParseRole(t *testing.T) {
+	role, err := auth.ParseRole("Operator")
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleOperator, role)
+	assert.Equal(t, "operator", role.String())
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DefaultSessionCookie is the name of session cookie.
+const DefaultSessionCookie = "doppelganger_session"
+
+// ErrInvalidSession is returned by Sessions.Decode if session cookie is malformed, expired or has been
+// tampered with.
+var ErrInvalidSession = errors.New("invalid session")
+
+// Purposes of sealed values. A purpose is authenticated along with the value, so that a value issued for one
+// purpose, i.e. login state cookie, can't be passed off as another one, such as session cookie.
+const (
+	sessionPurpose    = "session"
+	loginStatePurpose = "login-state"
+)
+
+type session struct {
+	Identity
+	ExpiresAt int64 `json:"exp"`
+}
+
+// Sessions issues and verifies session cookies. A cookie holds the identity of user along with its expiration
+// time and is encrypted and authenticated with AES-GCM, so no server-side storage is needed and secrets, such
+// as provider access tokens, are not exposed to the client. Changing the key invalidates all sessions.
+type Sessions struct {
+	CookieName string
+	TTL        time.Duration
+	// Secure forces Secure flag on cookies sent over plain HTTP, i.e. when TLS is terminated by a reverse proxy.
+	Secure bool
+
+	key []byte
+}
+
+// NewSessions returns an instance of Sessions that encrypts cookies with key. Sessions expire after ttl.
+func NewSessions(key []byte, ttl time.Duration) *Sessions {
+	return &Sessions{
+		CookieName: DefaultSessionCookie,
+		TTL:        ttl,
+		key:        key,
+	}
+}
+
+// RandomKey generates a random key suitable for session cookies.
+func RandomKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Encode returns encrypted session value for id.
+func (s *Sessions) Encode(id *Identity) (string, error) {
+	return s.encode(sessionPurpose, session{
+		Identity:  *id,
+		ExpiresAt: time.Now().Add(s.TTL).Unix(),
+	})
+}
+
+// Decode verifies session value signature and expiration time and returns the identity it holds. Sessions
+// without user name or provider are rejected.
+func (s *Sessions) Decode(value string) (*Identity, error) {
+	var sess session
+	if err := s.decode(sessionPurpose, value, &sess); err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > sess.ExpiresAt || sess.Username == "" || sess.Provider == "" {
+		return nil, ErrInvalidSession
+	}
+
+	return &sess.Identity, nil
+}
+
+// Get returns the identity stored in request session cookie.
+func (s *Sessions) Get(req *http.Request) (*Identity, error) {
+	cookie, err := req.Cookie(s.CookieName)
+	if err != nil {
+		return nil, ErrNoCredentials
+	}
+
+	return s.Decode(cookie.Value)
+}
+
+// Set issues a new session cookie for id.
+func (s *Sessions) Set(w http.ResponseWriter, req *http.Request, id *Identity) error {
+	value, err := s.Encode(id)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(s.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secure(req),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// Clear removes session cookie.
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// secure returns true if cookies sent in response to req should only be sent back over HTTPS.
+func (s *Sessions) secure(req *http.Request) bool {
+	return s.Secure || req.TLS != nil
+}
+
+// encode serializes v into JSON and seals it with AES-GCM using purpose as additional data.
+func (s *Sessions) encode(purpose string, v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, payload, []byte(purpose))), nil
+}
+
+// decode opens value sealed for purpose and unmarshals its payload into v.
+func (s *Sessions) decode(purpose, value string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ErrInvalidSession
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return ErrInvalidSession
+	}
+
+	payload, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(purpose))
+	if err != nil {
+		return ErrInvalidSession
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+func (s *Sessions) aead() (cipher.AEAD, error) {
+	key := sha256.Sum256(s.key)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
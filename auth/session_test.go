@@ -0,0 +1,93 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions_EncodeDecode(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+
+	value, err := sessions.Encode(&auth.Identity{Username: "jdoe", Email: "jdoe@example.com", Provider: "oidc"})
+	require.NoError(t, err)
+
+	id, err := sessions.Decode(value)
+	require.NoError(t, err)
+	assert.Equal(t, &auth.Identity{Username: "jdoe", Email: "jdoe@example.com", Provider: "oidc"}, id)
+}
+
+func TestSessions_Decode_Tampered(t *testing.T) {
+	value, err := auth.NewSessions([]byte("secret"), time.Hour).Encode(&auth.Identity{Username: "jdoe", Provider: "htpasswd"})
+	require.NoError(t, err)
+
+	_, err = auth.NewSessions([]byte("another secret"), time.Hour).Decode(value)
+	assert.Equal(t, auth.ErrInvalidSession, err)
+
+	_, err = auth.NewSessions([]byte("secret"), time.Hour).Decode("x" + value)
+	assert.Equal(t, auth.ErrInvalidSession, err)
+}
+
+func TestSessions_Decode_Expired(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), -time.Minute)
+
+	value, err := sessions.Encode(&auth.Identity{Username: "jdoe", Provider: "htpasswd"})
+	require.NoError(t, err)
+
+	_, err = sessions.Decode(value)
+	assert.Equal(t, auth.ErrInvalidSession, err)
+}
+
+func TestSessions_SetGet(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, sessions.Set(rec, httptest.NewRequest("GET", "/", nil), &auth.Identity{Username: "jdoe", Provider: "htpasswd"}))
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.False(t, cookies[0].Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+
+	id, err := sessions.Get(req)
+	require.NoError(t, err)
+	assert.Equal(t, "jdoe", id.Username)
+}
+
+func TestSessions_Set_Secure(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+	sessions.Secure = true
+
+	// TLS is terminated by a reverse proxy
+	rec := httptest.NewRecorder()
+	require.NoError(t, sessions.Set(rec, httptest.NewRequest("GET", "http://doppelganger/", nil), &auth.Identity{Username: "jdoe", Provider: "htpasswd"}))
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].Secure)
+}
+
+func TestSessions_Decode_Incomplete(t *testing.T) {
+	sessions := auth.NewSessions([]byte("secret"), time.Hour)
+
+	for name, id := range map[string]*auth.Identity{
+		"no user name": {Provider: "htpasswd"},
+		"no provider":  {Username: "jdoe"},
+		"empty":        {},
+	} {
+		value, err := sessions.Encode(id)
+		require.NoError(t, err, name)
+
+		_, err = sessions.Decode(value)
+		assert.Equal(t, auth.ErrInvalidSession, err, name)
+	}
+}
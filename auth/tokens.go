@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenPrefix is prepended to all API tokens to make them recognizable, i.e. by secret scanners.
+	TokenPrefix = "dgt_"
+
+	// Last-used time is persisted at most once per this interval for each token.
+	tokenLastUsedResolution = time.Minute
+)
+
+// Scope limits what an API token can be used for.
+type Scope string
+
+// Supported token scopes.
+const (
+	// ScopeRead allows to list, browse and clone mirrors.
+	ScopeRead Scope = "read"
+	// ScopeSync allows to synchronize mirrors and set up change tracking.
+	ScopeSync Scope = "sync"
+	// ScopeCreate allows to create new mirrors.
+	ScopeCreate Scope = "create"
+	// ScopeAdmin allows everything, including mirror settings.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists all supported token scopes.
+var Scopes = []Scope{ScopeRead, ScopeSync, ScopeCreate, ScopeAdmin}
+
+var (
+	// ErrTokenNotFound is returned by TokenStore if there is no token with given ID.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidToken is returned by TokenStore.Create if token parameters are not valid.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Token is an API token used for non-interactive access. Personal tokens act on behalf of their owner and
+// can't do more than the owner is allowed to. Service tokens are not bound to any user and are only limited
+// by their scopes.
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// User who created the token. For personal tokens this is the user token acts on behalf of.
+	Owner string `json:"owner"`
+	// Provider that authenticated the owner, used to look up their groups and to check their permissions on GitHub.
+	OwnerProvider string  `json:"owner_provider,omitempty"`
+	Service       bool    `json:"service,omitempty"`
+	Scopes        []Scope `json:"scopes"`
+	// Optional repository name pattern token is limited to, i.e. "acme/*".
+	Repos      string    `json:"repos,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+
+	// SHA-256 of token value, the value itself is never stored.
+	Hash string `json:"hash"`
+}
+
+// Identity returns the identity requests authenticated with the token act as. Personal token identities have no
+// groups, they are resolved from the owner by TokenStore.
+func (t *Token) Identity() *Identity {
+	id := &Identity{
+		Username: t.Owner,
+		Provider: "token",
+		Scopes:   t.Scopes,
+		Repos:    t.Repos,
+		Service:  t.Service,
+	}
+
+	if t.Service {
+		id.Username = "service:" + t.Name
+	} else {
+		id.OwnerProvider = t.OwnerProvider
+	}
+
+	return id
+}
+
+// TokenStore keeps API tokens in a JSON file. It implements Provider accepting tokens both as bearer tokens
+// and as a password of HTTP basic auth, which is convenient for git clients.
+type TokenStore struct {
+	path  string
+	users *UserStore
+
+	mu     sync.Mutex
+	tokens []*Token
+}
+
+// NewTokenStore loads tokens from file located at path. The file is created on first write.
+func NewTokenStore(path string) (*TokenStore, error) {
+	store := &TokenStore{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return store, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read tokens file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &store.tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	return store, nil
+}
+
+// EnableUsers makes TokenStore resolve the groups of personal token owners with users each time a token is used.
+// Tokens of owners who no longer exist are revoked.
+func (store *TokenStore) EnableUsers(users *UserStore) {
+	store.users = users
+}
+
+// Name implements Provider.
+func (store *TokenStore) Name() string {
+	return "token"
+}
+
+// Authenticate implements Provider.
+func (store *TokenStore) Authenticate(req *http.Request) (*Identity, error) {
+	var value string
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		value = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	} else if _, password, ok := req.BasicAuth(); ok && strings.HasPrefix(password, TokenPrefix) {
+		value = password
+	} else {
+		return nil, ErrNoCredentials
+	}
+
+	t, err := store.Lookup(value)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	id := t.Identity()
+	if t.Service || store.users == nil {
+		return id, nil
+	}
+
+	owner, err := store.users.Lookup(t.OwnerProvider, t.Owner)
+	switch err {
+	case nil:
+		id.Groups = owner.Groups
+	case ErrUnknownUser:
+		slog.WarnContext(req.Context(), "revoking API token of a user that no longer exists", "token", t.ID, "owner", t.Owner, "provider", t.OwnerProvider)
+		if err := store.Revoke(t.ID); err != nil {
+			slog.WarnContext(req.Context(), "failed to revoke token", "token", t.ID, "error", err)
+		}
+
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, err
+	}
+
+	return id, nil
+}
+
+// Create issues a new token and returns it along with its value. The value is not stored and can't be
+// retrieved later.
+func (store *TokenStore) Create(t Token) (*Token, string, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || t.Owner == "" || len(t.Scopes) == 0 {
+		return nil, "", ErrInvalidToken
+	}
+
+	for _, scope := range t.Scopes {
+		if !validScope(scope) {
+			return nil, "", ErrInvalidToken
+		}
+	}
+
+	if t.Repos != "" {
+		if _, err := path.Match(t.Repos, ""); err != nil {
+			return nil, "", ErrInvalidToken
+		}
+	}
+
+	id, value := make([]byte, 6), make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+
+	if _, err := rand.Read(value); err != nil {
+		return nil, "", err
+	}
+
+	t.ID = hex.EncodeToString(id)
+	t.CreatedAt = time.Now().UTC()
+	t.LastUsedAt = time.Time{}
+
+	secret := TokenPrefix + base64.RawURLEncoding.EncodeToString(value)
+	t.Hash = hashToken(secret)
+
+	store.mu.Lock()
+	store.tokens = append(store.tokens, &t)
+	err := store.save()
+	store.mu.Unlock()
+
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save token: %s", err)
+	}
+
+	return &t, secret, nil
+}
+
+// List returns tokens owned by user owner or all tokens if owner is empty, sorted by creation time.
+func (store *TokenStore) List(owner string) []Token {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var tokens []Token
+	for _, t := range store.tokens {
+		if owner == "" || t.Owner == owner {
+			tokens = append(tokens, *t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens
+}
+
+// Get returns token with given ID.
+func (store *TokenStore) Get(id string) (Token, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, t := range store.tokens {
+		if t.ID == id {
+			return *t, nil
+		}
+	}
+
+	return Token{}, ErrTokenNotFound
+}
+
+// Revoke deletes token with given ID.
+func (store *TokenStore) Revoke(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i, t := range store.tokens {
+		if t.ID != id {
+			continue
+		}
+
+		store.tokens = append(store.tokens[:i], store.tokens[i+1:]...)
+
+		return store.save()
+	}
+
+	return ErrTokenNotFound
+}
+
+// Lookup finds token by its value and records its usage.
+func (store *TokenStore) Lookup(value string) (Token, error) {
+	if !strings.HasPrefix(value, TokenPrefix) {
+		return Token{}, ErrTokenNotFound
+	}
+
+	hash := hashToken(value)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, t := range store.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+			continue
+		}
+
+		if now := time.Now().UTC(); now.Sub(t.LastUsedAt) >= tokenLastUsedResolution {
+			t.LastUsedAt = now
+			if err := store.save(); err != nil {
+				// Failing to record usage should not lock automation out
+				slog.Warn("failed to record token usage", "token", t.ID, "error", err)
+			}
+		}
+
+		return *t, nil
+	}
+
+	return Token{}, ErrTokenNotFound
+}
+
+// save writes tokens to file, store.mu is expected to be held by caller.
+func (store *TokenStore) save() error {
+	return writeJSONFile(store.path, store.tokens)
+}
+
+// writeJSONFile atomically replaces file located at path with JSON representation of v. The file is only
+// readable by its owner.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	return os.Rename(fd.Name(), path)
+}
+
+// ParseScope returns token scope by its name.
+func ParseScope(s string) (Scope, error) {
+	if scope := Scope(strings.ToLower(strings.TrimSpace(s))); validScope(scope) {
+		return scope, nil
+	}
+
+	return "", fmt.Errorf("unknown scope %q", s)
+}
+
+func validScope(scope Scope) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,200 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTokenStore(t *testing.T) (*auth.TokenStore, string, func()) {
+	dir, err := ioutil.TempDir("", "doppelganger-tokens")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "state", "tokens.json")
+
+	store, err := auth.NewTokenStore(path)
+	require.NoError(t, err)
+
+	return store, path, func() { os.RemoveAll(dir) }
+}
+
+func TestTokenStore_CreateLookup(t *testing.T) {
+	store, path, teardown := setupTokenStore(t)
+	defer teardown()
+
+	token, value, err := store.Create(auth.Token{
+		Name:   "ci",
+		Owner:  "jdoe",
+		Scopes: []auth.Scope{auth.ScopeSync},
+		Repos:  "acme/*",
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(value, auth.TokenPrefix))
+	assert.True(t, token.LastUsedAt.IsZero())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), value, "token value should not be stored")
+
+	// Reload from disk
+	store, err = auth.NewTokenStore(path)
+	require.NoError(t, err)
+
+	found, err := store.Lookup(value)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, found.ID)
+	assert.False(t, found.LastUsedAt.IsZero(), "last used time should be recorded")
+
+	_, err = store.Lookup(value + "x")
+	assert.Equal(t, auth.ErrTokenNotFound, err)
+}
+
+func TestTokenStore_Create_Invalid(t *testing.T) {
+	store, _, teardown := setupTokenStore(t)
+	defer teardown()
+
+	for name, token := range map[string]auth.Token{
+		"no name":         {Owner: "jdoe", Scopes: []auth.Scope{auth.ScopeRead}},
+		"no scopes":       {Name: "ci", Owner: "jdoe"},
+		"unknown scope":   {Name: "ci", Owner: "jdoe", Scopes: []auth.Scope{"write"}},
+		"invalid pattern": {Name: "ci", Owner: "jdoe", Scopes: []auth.Scope{auth.ScopeRead}, Repos: "acme/["},
+	} {
+		_, _, err := store.Create(token)
+		assert.Equal(t, auth.ErrInvalidToken, err, name)
+	}
+}
+
+func TestTokenStore_ListRevoke(t *testing.T) {
+	store, _, teardown := setupTokenStore(t)
+	defer teardown()
+
+	t1, _, err := store.Create(auth.Token{Name: "ci", Owner: "jdoe", Scopes: []auth.Scope{auth.ScopeRead}})
+	require.NoError(t, err)
+
+	t2, _, err := store.Create(auth.Token{Name: "deploy", Owner: "alice", Scopes: []auth.Scope{auth.ScopeRead}, Service: true})
+	require.NoError(t, err)
+
+	assert.Len(t, store.List(""), 2)
+	if tokens := store.List("jdoe"); assert.Len(t, tokens, 1) {
+		assert.Equal(t, t1.ID, tokens[0].ID)
+	}
+
+	require.NoError(t, store.Revoke(t2.ID))
+	assert.Len(t, store.List(""), 1)
+	assert.Equal(t, auth.ErrTokenNotFound, store.Revoke(t2.ID))
+}
+
+func TestTokenStore_Authenticate(t *testing.T) {
+	store, path, teardown := setupTokenStore(t)
+	defer teardown()
+
+	users, err := auth.NewUserStore(filepath.Join(filepath.Dir(path), "users.json"))
+	require.NoError(t, err)
+	require.NoError(t, users.Record(&auth.Identity{Username: "jdoe", Provider: "oidc", Groups: []string{"frontend"}}))
+	store.EnableUsers(users)
+
+	_, personal, err := store.Create(auth.Token{Name: "ci", Owner: "jdoe", OwnerProvider: "oidc", Scopes: []auth.Scope{auth.ScopeSync}, Repos: "acme/*"})
+	require.NoError(t, err)
+
+	// Groups are resolved when the token is used, not when it is created
+	require.NoError(t, users.Record(&auth.Identity{Username: "jdoe", Provider: "oidc", Groups: []string{"backend"}}))
+
+	_, service, err := store.Create(auth.Token{Name: "deploy", Owner: "alice", Scopes: []auth.Scope{auth.ScopeRead}, Service: true})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/api/mirrors", nil)
+	req.Header.Set("Authorization", "Bearer "+personal)
+
+	id, err := store.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "jdoe", id.Username)
+	assert.Equal(t, []string{"backend"}, id.Groups)
+	assert.True(t, id.Allows(auth.ScopeSync, "acme/api"))
+	assert.True(t, id.Allows(auth.ScopeRead, "acme/api"))
+	assert.False(t, id.Allows(auth.ScopeCreate, "acme/api"))
+	assert.False(t, id.Allows(auth.ScopeSync, "other/api"))
+
+	// git clients send tokens with basic auth
+	req = httptest.NewRequest("GET", "/acme/api.git/info/refs", nil)
+	req.SetBasicAuth("git", service)
+
+	id, err = store.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "service:deploy", id.Username)
+	assert.Equal(t, auth.RoleAdmin, auth.NewPolicy().Role(id, "acme/api"), "service tokens should only be limited by scopes")
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("jdoe", "password")
+
+	_, err = store.Authenticate(req)
+	assert.Equal(t, auth.ErrNoCredentials, err, "passwords should be left to other providers")
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.TokenPrefix+"revoked")
+
+	_, err = store.Authenticate(req)
+	assert.Equal(t, auth.ErrInvalidCredentials, err)
+}
+
+func TestTokenStore_Authenticate_UnknownOwner(t *testing.T) {
+	store, path, teardown := setupTokenStore(t)
+	defer teardown()
+
+	htpasswd := filepath.Join(filepath.Dir(filepath.Dir(path)), "htpasswd")
+	require.NoError(t, ioutil.WriteFile(htpasswd, []byte("jdoe:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600))
+
+	dir, err := auth.NewHtpasswd(htpasswd)
+	require.NoError(t, err)
+
+	users, err := auth.NewUserStore(filepath.Join(filepath.Dir(path), "users.json"))
+	require.NoError(t, err)
+	users.EnableDirectory(dir)
+	store.EnableUsers(users)
+
+	token, value, err := store.Create(auth.Token{Name: "ci", Owner: "jdoe", OwnerProvider: "htpasswd", Scopes: []auth.Scope{auth.ScopeSync}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+value)
+
+	id, err := store.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "jdoe", id.Username)
+
+	// Owner is removed from htpasswd file
+	require.NoError(t, ioutil.WriteFile(htpasswd, nil, 0600))
+	require.NoError(t, dir.Reload())
+
+	_, err = store.Authenticate(req)
+	assert.Equal(t, auth.ErrInvalidCredentials, err)
+
+	_, err = store.Get(token.ID)
+	assert.Equal(t, auth.ErrTokenNotFound, err, "tokens of removed users should be revoked")
+}
+
+func TestIdentity_Allows_Interactive(t *testing.T) {
+	id := &auth.Identity{Username: "jdoe", Provider: "oidc"}
+	assert.True(t, id.Allows(auth.ScopeAdmin, "acme/api"), "interactive users are only limited by roles")
+}
+
+func TestToken_Identity(t *testing.T) {
+	personal := auth.Token{Name: "ci", Owner: "jdoe", OwnerProvider: "github", Scopes: []auth.Scope{auth.ScopeRead}}
+
+	id := personal.Identity()
+	assert.Equal(t, "jdoe", id.Username)
+	assert.Equal(t, "token", id.Provider)
+	assert.Equal(t, "github", id.UserProvider())
+
+	service := auth.Token{Name: "ci", Owner: "jdoe", OwnerProvider: "github", Service: true, Scopes: []auth.Scope{auth.ScopeRead}}
+
+	id = service.Identity()
+	assert.Equal(t, "service:ci", id.Username)
+	assert.Equal(t, "token", id.UserProvider(), "service tokens should not act on behalf of their owner")
+}
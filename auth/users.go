@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownUser is returned by UserStore if user has never signed in or has been removed from the provider
+// that authenticated them.
+var ErrUnknownUser = errors.New("unknown user")
+
+// UserDirectory is implemented by providers that keep a list of their users, such as Htpasswd, and can tell
+// whether a user still exists.
+type UserDirectory interface {
+	Name() string
+	HasUser(username string) bool
+}
+
+type storedUser struct {
+	Username string   `json:"username"`
+	Provider string   `json:"provider"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// UserStore keeps the groups of users as they were reported by provider the last time users authenticated,
+// so that personal API tokens act with the current groups of their owner. Users are recorded by Middleware
+// and persisted in a JSON file.
+type UserStore struct {
+	path        string
+	directories map[string]UserDirectory
+
+	mu    sync.Mutex
+	users map[string]*storedUser
+}
+
+// NewUserStore loads users from file located at path. The file is created on first write.
+func NewUserStore(path string) (*UserStore, error) {
+	store := &UserStore{
+		path:        path,
+		directories: make(map[string]UserDirectory),
+		users:       make(map[string]*storedUser),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return store, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read users file: %s", err)
+	}
+
+	var users []*storedUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	for _, u := range users {
+		store.users[userKey(u.Provider, u.Username)] = u
+	}
+
+	return store, nil
+}
+
+// EnableDirectory makes UserStore consult dir whether users authenticated by it still exist.
+func (store *UserStore) EnableDirectory(dir UserDirectory) {
+	store.directories[dir.Name()] = dir
+}
+
+// Record stores the groups of user id. API token identities are skipped, since their groups are resolved
+// from the owner. The file is only written if user is new or their groups have changed.
+func (store *UserStore) Record(id *Identity) error {
+	if id == nil || id.Scopes != nil || id.Service {
+		return nil
+	}
+
+	groups := append([]string(nil), id.Groups...)
+	sort.Strings(groups)
+
+	key := userKey(id.Provider, id.Username)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if u, ok := store.users[key]; ok && strings.Join(u.Groups, "\n") == strings.Join(groups, "\n") {
+		return nil
+	}
+
+	store.users[key] = &storedUser{Username: id.Username, Provider: id.Provider, Groups: groups}
+
+	return store.save()
+}
+
+// Lookup returns the identity of user authenticated by provider with the groups they had when they last
+// signed in. ErrUnknownUser is returned if user has never signed in or no longer exists in the directory
+// of provider.
+func (store *UserStore) Lookup(provider, username string) (*Identity, error) {
+	dir, hasDir := store.directories[provider]
+	if hasDir && !dir.HasUser(username) {
+		return nil, ErrUnknownUser
+	}
+
+	store.mu.Lock()
+	u, ok := store.users[userKey(provider, username)]
+	store.mu.Unlock()
+
+	switch {
+	case ok:
+		return &Identity{Username: u.Username, Provider: u.Provider, Groups: u.Groups}, nil
+	case hasDir:
+		// Directory users who have never signed in interactively have no groups
+		return &Identity{Username: username, Provider: provider}, nil
+	default:
+		return nil, ErrUnknownUser
+	}
+}
+
+// save writes users to file, store.mu is expected to be held by caller.
+func (store *UserStore) save() error {
+	users := make([]*storedUser, 0, len(store.users))
+	for _, u := range store.users {
+		users = append(users, u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return userKey(users[i].Provider, users[i].Username) < userKey(users[j].Provider, users[j].Username)
+	})
+
+	return writeJSONFile(store.path, users)
+}
+
+func userKey(provider, username string) string {
+	return provider + ":" + username
+}
@@ -0,0 +1,69 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type userDirectoryMock map[string]bool
+
+func (d userDirectoryMock) Name() string { return "htpasswd" }
+
+func (d userDirectoryMock) HasUser(username string) bool { return d[username] }
+
+func TestUserStore_RecordLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doppelganger-users")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "users.json")
+
+	store, err := auth.NewUserStore(path)
+	require.NoError(t, err)
+
+	_, err = store.Lookup("oidc", "jdoe")
+	assert.Equal(t, auth.ErrUnknownUser, err)
+
+	require.NoError(t, store.Record(&auth.Identity{Username: "jdoe", Provider: "oidc", Groups: []string{"ops", "backend"}}))
+	// Token identities are not users
+	require.NoError(t, store.Record(&auth.Identity{Username: "alice", Provider: "token", Scopes: []auth.Scope{auth.ScopeRead}}))
+
+	// Reload from disk
+	store, err = auth.NewUserStore(path)
+	require.NoError(t, err)
+
+	id, err := store.Lookup("oidc", "jdoe")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backend", "ops"}, id.Groups)
+
+	_, err = store.Lookup("github", "jdoe")
+	assert.Equal(t, auth.ErrUnknownUser, err, "users should be distinguished by provider")
+
+	_, err = store.Lookup("token", "alice")
+	assert.Equal(t, auth.ErrUnknownUser, err)
+}
+
+func TestUserStore_Lookup_Directory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doppelganger-users")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := auth.NewUserStore(filepath.Join(dir, "users.json"))
+	require.NoError(t, err)
+
+	store.EnableDirectory(userDirectoryMock{"jdoe": true})
+	require.NoError(t, store.Record(&auth.Identity{Username: "alice", Provider: "htpasswd"}))
+
+	id, err := store.Lookup("htpasswd", "jdoe")
+	require.NoError(t, err, "directory users should be known even if they have never signed in")
+	assert.Equal(t, "jdoe", id.Username)
+
+	_, err = store.Lookup("htpasswd", "alice")
+	assert.Equal(t, auth.ErrUnknownUser, err, "users removed from directory should not be known")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/andrewslotin/doppelganger/auth"
+)
+
+// publicPaths lists endpoints that are reachable without authentication. GitHub webhooks and peer notifications
+// are verified with their own signatures.
+var publicPaths = []string{
+	"/healthz",
+	"/readyz",
+	"/favicon.ico",
+	"/assets/",
+	"/apihook",
+	"/api/peers/notify",
+}
+
+// newAuthMiddleware configures authentication providers enabled with command line flags. Session key
+// is read from DOPPELGANGER_SESSION_KEY environment variable (base64), OpenID Connect client secret
+// from DOPPELGANGER_OIDC_CLIENT_SECRET and GitHub OAuth app secret from DOPPELGANGER_GITHUB_OAUTH_SECRET.
+// Session cookies are marked secure if doppelganger is served over TLS or its public URL is an HTTPS one.
+// Authenticated users are recorded in users, which also checks whether htpasswd users still exist.
+func newAuthMiddleware(tokens *auth.TokenStore, users *auth.UserStore, publicURL string) (*auth.Middleware, error) {
+	sessions := auth.NewSessions(nil, args.sessionTTL)
+	if key := os.Getenv("DOPPELGANGER_SESSION_KEY"); key != "" {
+		k, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode DOPPELGANGER_SESSION_KEY: %s", err)
+		}
+
+		if len(k) < 32 {
+			return nil, fmt.Errorf("DOPPELGANGER_SESSION_KEY should be at least 32 bytes long")
+		}
+
+		sessions = auth.NewSessions(k, args.sessionTTL)
+	}
+	sessions.Secure = secureCookies(publicURL)
+
+	var providers []auth.Provider
+
+	if args.authProxyHeader != "" {
+		p, err := auth.NewProxyHeaders(args.authProxyHeader, args.authProxyEmailHeader, strings.Split(args.authTrustedProxies, ","))
+		if err != nil {
+			return nil, err
+		}
+
+		p.GroupsHeader = args.authProxyGroupsHeader
+
+		providers = append(providers, p)
+	}
+
+	if args.htpasswd != "" {
+		p, err := auth.NewHtpasswd(args.htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		users.EnableDirectory(p)
+
+		providers = append(providers, p)
+	}
+
+	// API tokens are checked first, since they are also accepted as basic auth password
+	if len(providers) > 0 || args.oidcIssuer != "" || args.githubOAuthClientID != "" {
+		providers = append([]auth.Provider{tokens}, providers...)
+	}
+
+	m := auth.NewMiddleware(sessions, providers...)
+	m.EnableUsers(users)
+	m.Public(publicPaths...)
+
+	if args.oidcIssuer != "" && args.githubOAuthClientID != "" {
+		return nil, fmt.Errorf("OpenID Connect and GitHub login can't be enabled at the same time")
+	}
+
+	if args.githubOAuthClientID != "" {
+		github := auth.NewGitHub(nil, args.githubOAuthClientID, os.Getenv("DOPPELGANGER_GITHUB_OAUTH_SECRET"), sessions)
+		github.RedirectURL = args.githubOAuthRedirectURL
+
+		m.EnableLogin(github)
+	}
+
+	if args.oidcIssuer != "" {
+		if args.oidcClientID == "" {
+			return nil, fmt.Errorf("missing OpenID Connect client ID (set it with -oidc-client-id)")
+		}
+
+		oidc := auth.NewOIDC(nil, args.oidcIssuer, args.oidcClientID, os.Getenv("DOPPELGANGER_OIDC_CLIENT_SECRET"), sessions)
+		oidc.RedirectURL = args.oidcRedirectURL
+
+		m.EnableLogin(oidc)
+	}
+
+	if !m.Enabled() {
+		return m, nil
+	}
+
+	if os.Getenv("DOPPELGANGER_SESSION_KEY") == "" {
+		slog.Warn("DOPPELGANGER_SESSION_KEY is not set, using a random session key, users will need to log in again after restart")
+
+		key, err := auth.RandomKey()
+		if err != nil {
+			return nil, err
+		}
+
+		*sessions = *auth.NewSessions(key, args.sessionTTL)
+		sessions.Secure = secureCookies(publicURL)
+	}
+
+	return m, nil
+}
+
+// secureCookies returns true if publicURL is an HTTPS one, which means that cookies should only be sent over
+// HTTPS even if TLS is terminated by a reverse proxy in front of doppelganger.
+func secureCookies(publicURL string) bool {
+	return strings.HasPrefix(strings.ToLower(publicURL), "https://")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+)
+
+// cloneProgressKeepAlive is the interval after which the current state is sent again to an idle event stream, so that
+// proxies do not close the connection while git is busy, i.e. compressing objects on remote side.
+const cloneProgressKeepAlive = 15 * time.Second
+
+var cloneProgressTemplate = parsePageTemplate("mirror/progress.html.template")
+
+// CloneProgressHandler is a type that implements http.Handler interface and is used to show the progress of mirrors
+// being created in background at "/:owner/:repo/progress". Requests accepting "text/event-stream" receive a stream of
+// Server-Sent Events:
+//
+//   // Current state, sent each time git reports progress and periodically to keep the connection alive
+//   event: progress
+//   data: {"phase":"Receiving objects","percent":45,"current":450,"total":1000,"done":false}
+//
+//   // The mirror has been created, sent once before the stream is closed
+//   event: done
+//   data: {"url":"/andrewslotin/doppelganger"}
+//
+//   // The clone has failed, sent once before the stream is closed
+//   event: failed
+//   data: {"error":"..."}
+//
+// Once the clone job is gone the user is redirected to the repository page.
+type CloneProgressHandler struct {
+	clones       *git.CloneJobs
+	shuttingDown <-chan struct{}
+}
+
+// NewCloneProgressHandler creates and initializes a new handler. Event streams are closed once shuttingDown is closed.
+func NewCloneProgressHandler(clones *git.CloneJobs, shuttingDown <-chan struct{}) *CloneProgressHandler {
+	return &CloneProgressHandler{
+		clones:       clones,
+		shuttingDown: shuttingDown,
+	}
+}
+
+func (handler *CloneProgressHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name, err := git.ParseRepositoryName(req.URL.Query().Get(":owner") + "/" + req.URL.Query().Get(":repo"))
+	if err != nil {
+		WriteNotFoundPage(w, "No such repository", "")
+		return
+	}
+	repoName := name.String()
+
+	access := accessFromRequest(req)
+	if !access.CanView(repoName) {
+		WriteForbidden(w, req, fmt.Sprintf("You are not allowed to view %s", repoName))
+		return
+	}
+
+	job, ok := handler.clones.Get(repoName)
+
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		handler.Stream(w, req, job, repoName)
+		return
+	}
+
+	if !ok {
+		http.Redirect(w, req, "/"+repoName, http.StatusSeeOther)
+		return
+	}
+
+	state, _ := job.Watch()
+	values := struct {
+		FullName string
+		State    git.CloneState
+		User     *userAccess
+	}{
+		FullName: job.FullName,
+		State:    state,
+		User:     access,
+	}
+
+	if err := cloneProgressTemplate.Execute(w, values); err != nil {
+		slog.WarnContext(req.Context(), "failed to render page", "template", "mirror/progress", "repo", repoName, "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+	}
+}
+
+// Stream sends the state of job as Server-Sent Events until it's done, the client disconnects or the server is
+// shutting down. A nil job is reported as done, since there is nothing left to wait for.
+func (handler *CloneProgressHandler) Stream(w http.ResponseWriter, req *http.Request, job *git.CloneJob, repoName string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteErrorPage(w, UserError{Message: "Streaming not supported", BackURL: req.Referer()}, http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if job == nil {
+		writeEvent(w, "done", map[string]string{"url": "/" + repoName})
+		flusher.Flush()
+
+		return
+	}
+
+	keepAlive := time.NewTicker(cloneProgressKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		state, changed := job.Watch()
+
+		switch {
+		case state.Done && state.Error != "":
+			writeEvent(w, "failed", map[string]string{"error": state.Error})
+		case state.Done:
+			writeEvent(w, "done", map[string]string{"url": "/" + job.FullName})
+		default:
+			writeEvent(w, "progress", state)
+		}
+		flusher.Flush()
+
+		if state.Done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C: // send the current state again to keep connection alive
+		case <-req.Context().Done():
+			return
+		case <-handler.shuttingDown:
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event with JSON-encoded data to w.
+func writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
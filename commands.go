@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/audit"
+	"github.com/andrewslotin/doppelganger/config"
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/git/gitssh"
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/andrewslotin/doppelganger/server"
+)
+
+const commandsUsage = `Commands:
+  mirror add <owner/repo> [<git-url>]  Create a mirror, the URL is looked up on GitHub unless provided
+  mirror sync [<owner/repo>...]        Update mirrors, all of them unless names are provided
+  mirror list                          List mirrors
+  mirror remove <owner/repo>           Delete a mirror
+  mirror track <owner/repo> [<url>]    Set up GitHub webhook to send push events to url (default <public_url>/apihook)
+  key show                             Print public SSH key used to clone private repositories
+  doctor                               Check whether doppelganger is able to operate
+
+Without a command doppelganger starts the web server. Commands operate on the mirror directory directly and can
+be used while the server is running.
+`
+
+// errUsage is returned by cli.Run if the command line is malformed.
+var errUsage = errors.New("invalid command")
+
+// cli implements commands to administer mirrors without the web server, i.e. from scripts or while the server
+// is down. Changes made to a mirror are serialized with the running server by a lock file.
+type cli struct {
+	Stdout io.Writer
+
+	cfg           *config.Config
+	token         string
+	webhookSecret string
+	gitCmd        interface {
+		git.Command
+		Version(ctx context.Context) (string, error)
+	}
+	mirrors *git.MirroredRepositories
+	audit   *audit.Log
+	actor   audit.Actor
+}
+
+// newCLI returns an instance of cli that operates on args.mirrorDir. Changes are recorded in auditLog on behalf
+// of the current system user.
+func newCLI(cfg *config.Config, token, webhookSecret string, auditLog *audit.Log) (*cli, error) {
+	gitCmd, err := git.SystemGit()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors := git.NewMirroredRepositories(args.mirrorDir, gitCmd)
+	if args.lfs && token != "" {
+		lfsClient := lfs.NewClient(nil)
+		lfsClient.SetCredential(lfs.GitHubHost, token)
+		mirrors.EnableLFS(lfsClient)
+	}
+	mirrors.EnableAudit(auditLog)
+	if args.pushTargetDir != "" {
+		mirrors.EnableLocalPushTargets(args.pushTargetDir)
+	}
+
+	actor := audit.Actor{Type: audit.ActorCLI, Name: os.Getenv("USER")}
+	if u, err := user.Current(); err == nil {
+		actor.Name = u.Username
+	}
+
+	return &cli{
+		Stdout:        os.Stdout,
+		cfg:           cfg,
+		token:         token,
+		webhookSecret: webhookSecret,
+		gitCmd:        gitCmd,
+		mirrors:       mirrors,
+		audit:         auditLog,
+		actor:         actor,
+	}, nil
+}
+
+// Run executes a command. errUsage is returned for unknown commands and wrong number of arguments.
+func (c *cli) Run(ctx context.Context, cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return errUsage
+	}
+	ctx = audit.WithActor(ctx, c.actor)
+
+	switch cmd, cmdArgs := cmdArgs[0], cmdArgs[1:]; {
+	case cmd == "mirror" && len(cmdArgs) > 0:
+		return c.runMirror(ctx, cmdArgs[0], cmdArgs[1:])
+	case cmd == "key" && len(cmdArgs) == 1 && cmdArgs[0] == "show":
+		return c.showKey()
+	case cmd == "doctor" && len(cmdArgs) == 0:
+		return c.doctor(ctx)
+	default:
+		return errUsage
+	}
+}
+
+func (c *cli) runMirror(ctx context.Context, cmd string, cmdArgs []string) error {
+	switch {
+	case cmd == "add" && (len(cmdArgs) == 1 || len(cmdArgs) == 2):
+		var gitURL string
+		if len(cmdArgs) == 2 {
+			gitURL = cmdArgs[1]
+		}
+
+		return c.addMirror(ctx, cmdArgs[0], gitURL)
+	case cmd == "sync":
+		return c.syncMirrors(ctx, cmdArgs)
+	case cmd == "list" && len(cmdArgs) == 0:
+		return c.listMirrors(ctx)
+	case cmd == "remove" && len(cmdArgs) == 1:
+		name, err := git.ParseRepositoryName(cmdArgs[0])
+		if err != nil {
+			return fmt.Errorf("invalid repository name %q", cmdArgs[0])
+		}
+
+		if err := c.mirrors.Remove(ctx, name); err != nil {
+			return fmt.Errorf("failed to remove %s: %s", cmdArgs[0], err)
+		}
+		fmt.Fprintf(c.Stdout, "removed %s\n", cmdArgs[0])
+
+		return nil
+	case cmd == "track" && (len(cmdArgs) == 1 || len(cmdArgs) == 2):
+		callbackURL := c.cfg.WebhookURL()
+		if len(cmdArgs) == 2 {
+			callbackURL = cmdArgs[1]
+		}
+
+		return c.trackMirror(ctx, cmdArgs[0], callbackURL)
+	default:
+		return errUsage
+	}
+}
+
+func (c *cli) addMirror(ctx context.Context, fullName, gitURL string) error {
+	name, err := git.ParseRepositoryName(fullName)
+	if err != nil {
+		return fmt.Errorf("invalid repository name %q", fullName)
+	}
+
+	switch _, err := c.mirrors.Get(ctx, name); err {
+	case nil:
+		return fmt.Errorf("%s is already mirrored", fullName)
+	case git.ErrorNotMirrored:
+	default:
+		return err
+	}
+
+	if gitURL == "" {
+		github, err := c.github()
+		if err != nil {
+			return err
+		}
+
+		repo, err := github.Get(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to look up %s on GitHub: %s", fullName, err)
+		}
+		gitURL = repo.GitURL
+	}
+
+	if err := c.mirrors.Create(ctx, name, gitURL); err != nil {
+		return fmt.Errorf("failed to mirror %s: %s", fullName, err)
+	}
+	fmt.Fprintf(c.Stdout, "mirrored %s from %s\n", fullName, gitURL)
+
+	return nil
+}
+
+func (c *cli) syncMirrors(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		repos, err := c.mirrors.All(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list mirrors: %s", err)
+		}
+
+		for _, repo := range repos {
+			names = append(names, repo.FullName)
+		}
+	}
+
+	var failed int
+	for _, name := range names {
+		repoName, err := git.ParseRepositoryName(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid repository name %q\n", name)
+			failed++
+			continue
+		}
+
+		if err := c.mirrors.Update(ctx, repoName); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to update %s: %s\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(c.Stdout, "updated %s\n", name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d mirrors have not been updated", failed, len(names))
+	}
+
+	return nil
+}
+
+func (c *cli) listMirrors(ctx context.Context) error {
+	repos, err := c.mirrors.All(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list mirrors: %s", err)
+	}
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tBRANCH\tLAST SYNC\tSOURCE")
+	for _, repo := range repos {
+		lastSyncAt := "-"
+		if !repo.LastSyncAt.IsZero() {
+			lastSyncAt = repo.LastSyncAt.Local().Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", repo.FullName, repo.Master, lastSyncAt, repo.GitURL)
+	}
+
+	return w.Flush()
+}
+
+func (c *cli) trackMirror(ctx context.Context, fullName, callbackURL string) error {
+	if callbackURL == "" {
+		return errors.New("webhook URL is required, pass it as an argument or set public_url in configuration file")
+	}
+
+	name, err := git.ParseRepositoryName(fullName)
+	if err != nil {
+		return fmt.Errorf("invalid repository name %q", fullName)
+	}
+
+	if _, err := c.mirrors.Get(ctx, name); err != nil {
+		return fmt.Errorf("failed to look up mirror %s: %s", fullName, err)
+	}
+
+	github, err := c.github()
+	if err != nil {
+		return err
+	}
+
+	if err := github.Track(ctx, fullName, callbackURL); err != nil {
+		return fmt.Errorf("failed to set up webhook for %s: %s", fullName, err)
+	}
+	fmt.Fprintf(c.Stdout, "push events of %s are sent to %s\n", fullName, callbackURL)
+
+	return nil
+}
+
+func (c *cli) showKey() error {
+	pkey, err := gitssh.ReadPrivateRSAKey(PrivateKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read SSH key, it is created when the first private repository is mirrored (%s)", err)
+	}
+
+	pubkey, err := gitssh.AuthorizedRSAKey(pkey)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Stdout.Write(pubkey)
+	return err
+}
+
+func (c *cli) doctor(ctx context.Context) error {
+	checks := server.NewReadinessHandler()
+	checks.AddCheck("git", func(ctx context.Context) error {
+		_, err := c.gitCmd.Version(ctx)
+		return err
+	})
+	checks.AddCheck("mirror_dir", checkMirrorDir(args.mirrorDir))
+	checks.AddCheck("mirrors", func(ctx context.Context) error {
+		_, err := c.mirrors.All(ctx)
+		return err
+	})
+	checks.AddCheck("templates", checkTemplates)
+	checks.AddCheck("github", func(ctx context.Context) error {
+		github, err := c.github()
+		if err != nil {
+			return err
+		}
+
+		return github.CheckToken(ctx)
+	})
+	checks.AddCheck("ssh_key", func(ctx context.Context) error {
+		_, err := gitssh.ReadPrivateRSAKey(PrivateKeyPath)
+		return err
+	})
+
+	status := checks.Check(ctx)
+
+	names := make([]string, 0, len(status.Checks))
+	for name := range status.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		result := status.Checks[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, result.Status, result.Duration, result.Error)
+	}
+	w.Flush()
+
+	if status.Status != "ok" {
+		return errors.New("some checks have failed")
+	}
+
+	return nil
+}
+
+// github returns GitHub repository service or an error if access token is not configured.
+func (c *cli) github() (*git.GithubRepositories, error) {
+	if c.token == "" {
+		return nil, errors.New("missing GitHub access token (set DOPPELGANGER_GITHUB_TOKEN environment variable or sources.github.token in configuration file)")
+	}
+
+	github, err := git.NewGithubRepositories(context.WithValue(context.Background(), git.GithubToken, c.token))
+	if err != nil {
+		return nil, err
+	}
+	github.SetWebhookSecret(c.webhookSecret)
+	github.EnableAudit(c.audit)
+
+	return github, nil
+}
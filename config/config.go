@@ -0,0 +1,396 @@
+// Package config reads declarative Doppelganger configuration that lists server settings, sources to mirror
+// from and the mirrors themselves, so that the mirror set can be kept in version control:
+//
+//   listen: 0.0.0.0:8081
+//   public_url: https://doppelganger.example.com
+//   mirror_dir: /var/lib/doppelganger
+//
+//   sources:
+//     github:
+//       token: env:DOPPELGANGER_GITHUB_TOKEN
+//       webhook_secret: file:/run/secrets/webhook
+//
+//   mirrors:
+//     - name: acme/api
+//     - name: acme/website
+//       track: false
+//       push_targets:
+//         - name: gitea
+//           url: https://gitea.example.com/acme/website.git
+//           credential: env:GITEA_TOKEN
+//
+//   notifications:
+//     stale_after: 24h
+//     notifiers:
+//       - name: ops
+//         email:
+//           smtp: smtp.example.com:587
+//           password: env:SMTP_PASSWORD
+//           from: doppelganger@example.com
+//           to: [ops@example.com]
+//     subscriptions:
+//       - notifier: ops
+//
+// Secrets are never stored in configuration file, instead it contains references to them in the same format
+// as push target credentials, see git.Credential.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/notify"
+)
+
+// Config is the content of Doppelganger configuration file.
+type Config struct {
+	// Listen is the address to listen on, i.e. "0.0.0.0:8081" or ":8081".
+	Listen string `yaml:"listen"`
+	// PublicURL is the URL this instance is reachable at. It is used to set up webhooks for declared mirrors.
+	PublicURL string `yaml:"public_url"`
+	// MirrorDir is the directory mirrors are stored in.
+	MirrorDir string `yaml:"mirror_dir"`
+	// DataDir is the directory to keep API tokens and other state in.
+	DataDir string `yaml:"data_dir"`
+	// PushTargetDir is the directory local push targets are allowed in. Push targets can only be remote unless set.
+	PushTargetDir string `yaml:"push_target_dir"`
+
+	Sources       Sources       `yaml:"sources"`
+	Mirrors       []Mirror      `yaml:"mirrors"`
+	Notifications Notifications `yaml:"notifications"`
+}
+
+// Sources configures access to hosting services mirrors are created from.
+type Sources struct {
+	GitHub GitHubSource `yaml:"github"`
+}
+
+// GitHubSource configures access to GitHub API.
+type GitHubSource struct {
+	// Token references GitHub API token, i.e. "env:DOPPELGANGER_GITHUB_TOKEN".
+	Token git.Credential `yaml:"token"`
+	// WebhookSecret references the secret used to sign webhooks.
+	WebhookSecret git.Credential `yaml:"webhook_secret"`
+}
+
+// Mirror declares a repository that should be mirrored.
+type Mirror struct {
+	// Name is the full name of repository, i.e. "owner/repo".
+	Name string `yaml:"name"`
+	// URL is the remote URL to clone mirror from. By default it's looked up on GitHub.
+	URL string `yaml:"url"`
+	// Track enables setting up a webhook to update the mirror on push. Enabled unless set to false or
+	// the mirror is cloned from a URL that does not point to GitHub.
+	Track *bool `yaml:"track"`
+	// PushTargets lists remotes the mirror is replicated to. Push targets that are not listed are removed.
+	PushTargets []PushTarget `yaml:"push_targets"`
+}
+
+// Tracked returns true if changes in mirrored repository should be tracked with a webhook. GitHub can't
+// send webhooks for repositories hosted elsewhere, so mirrors with such URL are never tracked.
+func (m Mirror) Tracked() bool {
+	return m.fromGitHub() && (m.Track == nil || *m.Track)
+}
+
+// fromGitHub returns true if mirror is cloned from GitHub, i.e. if its URL is not set or points to GitHub.
+func (m Mirror) fromGitHub() bool {
+	if m.URL == "" {
+		return true
+	}
+
+	_, ok := git.RepositoryNameFromURL(m.URL, m.Name)
+	return ok
+}
+
+// PushTarget declares a remote that a mirror is replicated to.
+type PushTarget struct {
+	Name       string         `yaml:"name"`
+	URL        string         `yaml:"url"`
+	Credential git.Credential `yaml:"credential"`
+}
+
+// Notifications configures alerts about mirrors that need attention.
+type Notifications struct {
+	// StaleAfter is the time since the latest successful sync after which a mirror is considered stale.
+	// Stale mirrors are not reported if not set.
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// DigestInterval is the minimum time between two messages sent to a subscription. Events that occur
+	// in between are grouped into a digest. Defaults to DefaultDigestInterval.
+	DigestInterval time.Duration  `yaml:"digest_interval"`
+	Notifiers      []Notifier     `yaml:"notifiers"`
+	Subscriptions  []Subscription `yaml:"subscriptions"`
+}
+
+// DefaultDigestInterval is the default minimum time between two notifications sent to a subscription.
+const DefaultDigestInterval = time.Hour
+
+// Notifier declares a way to deliver notifications. Exactly one of Email, Webhook or Slack is expected to be set.
+type Notifier struct {
+	Name    string           `yaml:"name"`
+	Email   *EmailNotifier   `yaml:"email"`
+	Webhook *WebhookNotifier `yaml:"webhook"`
+	// Slack posts messages to Slack or Mattermost incoming webhook.
+	Slack *WebhookNotifier `yaml:"slack"`
+}
+
+// EmailNotifier sends notifications via SMTP.
+type EmailNotifier struct {
+	// SMTP is the address of SMTP server, i.e. "smtp.example.com:587".
+	SMTP     string         `yaml:"smtp"`
+	Username string         `yaml:"username"`
+	Password git.Credential `yaml:"password"`
+	From     string         `yaml:"from"`
+	To       []string       `yaml:"to"`
+}
+
+// WebhookNotifier posts notifications to a URL. Since incoming webhook URLs of chat services grant access
+// to post messages, the URL may also be a reference to a secret, i.e. "env:SLACK_WEBHOOK_URL".
+type WebhookNotifier struct {
+	URL string `yaml:"url"`
+}
+
+// ResolveURL returns webhook URL resolving it if it's a reference to a secret.
+func (n *WebhookNotifier) ResolveURL() (string, error) {
+	if strings.HasPrefix(n.URL, "http://") || strings.HasPrefix(n.URL, "https://") {
+		return n.URL, nil
+	}
+
+	return git.Credential(n.URL).Secret()
+}
+
+// Subscription sends events of mirrors to a notifier.
+type Subscription struct {
+	// Notifier is the name of notifier to use.
+	Notifier string `yaml:"notifier"`
+	// Repos lists repository name patterns, i.e. "acme/*", subscription is limited to. All mirrors
+	// are included if empty.
+	Repos []string `yaml:"repos"`
+	// Events lists event types to send, see notify.Events. All events are sent if empty.
+	Events []string `yaml:"events"`
+}
+
+// Load reads and validates configuration file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Parse reads configuration in YAML format and validates it. Unknown keys are considered an error.
+func Parse(r io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks configuration for errors.
+func (cfg *Config) Validate() error {
+	if cfg.Listen != "" {
+		if _, _, err := cfg.ListenAddr(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.PublicURL != "" {
+		if u, err := url.Parse(cfg.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid public_url %q", cfg.PublicURL)
+		}
+	}
+
+	if err := cfg.Sources.GitHub.Token.Validate(); err != nil {
+		return fmt.Errorf("invalid sources.github.token: %s", err)
+	}
+
+	if err := cfg.Sources.GitHub.WebhookSecret.Validate(); err != nil {
+		return fmt.Errorf("invalid sources.github.webhook_secret: %s", err)
+	}
+
+	seen := make(map[string]struct{}, len(cfg.Mirrors))
+	for i, m := range cfg.Mirrors {
+		if _, err := git.ParseRepositoryName(m.Name); err != nil {
+			return fmt.Errorf("mirrors[%d]: invalid repository name %q", i, m.Name)
+		}
+
+		if _, ok := seen[m.Name]; ok {
+			return fmt.Errorf("mirrors[%d]: %s is declared more than once", i, m.Name)
+		}
+		seen[m.Name] = struct{}{}
+
+		if m.Track != nil && *m.Track && !m.fromGitHub() {
+			return fmt.Errorf("mirrors[%d]: tracking requires a GitHub url", i)
+		}
+
+		targets := make(map[string]struct{}, len(m.PushTargets))
+		for j, target := range m.PushTargets {
+			if target.Name == "" || target.URL == "" {
+				return fmt.Errorf("mirrors[%d].push_targets[%d]: both name and url are required", i, j)
+			}
+
+			if _, ok := targets[target.Name]; ok {
+				return fmt.Errorf("mirrors[%d].push_targets[%d]: %s is declared more than once", i, j, target.Name)
+			}
+			targets[target.Name] = struct{}{}
+
+			if err := target.Credential.Validate(); err != nil {
+				return fmt.Errorf("mirrors[%d].push_targets[%d]: %s", i, j, err)
+			}
+		}
+	}
+
+	return cfg.Notifications.Validate()
+}
+
+// Validate checks notification settings for errors.
+func (n *Notifications) Validate() error {
+	if n.StaleAfter < 0 || n.DigestInterval < 0 {
+		return fmt.Errorf("notifications: stale_after and digest_interval can't be negative")
+	}
+
+	notifiers := make(map[string]struct{}, len(n.Notifiers))
+	for i, notifier := range n.Notifiers {
+		if notifier.Name == "" {
+			return fmt.Errorf("notifications.notifiers[%d]: name is required", i)
+		}
+
+		if _, ok := notifiers[notifier.Name]; ok {
+			return fmt.Errorf("notifications.notifiers[%d]: %s is declared more than once", i, notifier.Name)
+		}
+		notifiers[notifier.Name] = struct{}{}
+
+		var kinds int
+		if notifier.Email != nil {
+			kinds++
+
+			if notifier.Email.SMTP == "" || notifier.Email.From == "" || len(notifier.Email.To) == 0 {
+				return fmt.Errorf("notifications.notifiers[%d].email: smtp, from and to are required", i)
+			}
+
+			if _, _, err := net.SplitHostPort(notifier.Email.SMTP); err != nil {
+				return fmt.Errorf("notifications.notifiers[%d].email: invalid smtp address %q", i, notifier.Email.SMTP)
+			}
+
+			if err := notifier.Email.Password.Validate(); err != nil {
+				return fmt.Errorf("notifications.notifiers[%d].email.password: %s", i, err)
+			}
+		}
+
+		for kind, hook := range map[string]*WebhookNotifier{"webhook": notifier.Webhook, "slack": notifier.Slack} {
+			if hook == nil {
+				continue
+			}
+			kinds++
+
+			if err := hook.validate(); err != nil {
+				return fmt.Errorf("notifications.notifiers[%d].%s: %s", i, kind, err)
+			}
+		}
+
+		if kinds != 1 {
+			return fmt.Errorf("notifications.notifiers[%d]: exactly one of email, webhook or slack is expected", i)
+		}
+	}
+
+	for i, s := range n.Subscriptions {
+		if _, ok := notifiers[s.Notifier]; !ok {
+			return fmt.Errorf("notifications.subscriptions[%d]: unknown notifier %q", i, s.Notifier)
+		}
+
+		for _, pattern := range s.Repos {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("notifications.subscriptions[%d]: invalid repository pattern %q", i, pattern)
+			}
+		}
+
+		for _, event := range s.Events {
+			if !isEvent(event) {
+				return fmt.Errorf("notifications.subscriptions[%d]: unknown event %q, expected one of %s", i, event, strings.Join(notify.Events, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n *WebhookNotifier) validate() error {
+	if strings.HasPrefix(n.URL, "http://") || strings.HasPrefix(n.URL, "https://") {
+		if _, err := url.Parse(n.URL); err != nil {
+			return fmt.Errorf("invalid url %q", n.URL)
+		}
+
+		return nil
+	}
+
+	if n.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+
+	return git.Credential(n.URL).Validate()
+}
+
+func isEvent(s string) bool {
+	for _, event := range notify.Events {
+		if s == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListenAddr returns host and port to listen on.
+func (cfg *Config) ListenAddr() (string, int, error) {
+	host, p, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid listen address %q: %s", cfg.Listen, err)
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid listen address %q: bad port", cfg.Listen)
+	}
+
+	return host, port, nil
+}
+
+// WebhookURL returns the URL to send GitHub webhooks to or an empty string if public URL is not configured.
+func (cfg *Config) WebhookURL() string {
+	if cfg.PublicURL == "" {
+		return ""
+	}
+
+	u, _ := url.Parse(cfg.PublicURL)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/apihook"
+
+	return u.String()
+}
@@ -0,0 +1,191 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/config"
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exampleConfig = `
+listen: 127.0.0.1:8082
+public_url: https://doppelganger.example.com/
+mirror_dir: /var/lib/doppelganger
+
+sources:
+  github:
+    token: env:DOPPELGANGER_GITHUB_TOKEN
+    webhook_secret: file:/run/secrets/webhook
+
+mirrors:
+  - name: acme/api
+  - name: acme/website
+    url: https://git.example.com/acme/website.git
+    track: false
+    push_targets:
+      - name: gitea
+        url: https://gitea.example.com/acme/website.git
+        credential: env:GITEA_TOKEN
+
+notifications:
+  stale_after: 24h
+  notifiers:
+    - name: ops
+      email:
+        smtp: smtp.example.com:587
+        username: doppelganger
+        password: env:SMTP_PASSWORD
+        from: doppelganger@example.com
+        to: [ops@example.com]
+    - name: chat
+      slack:
+        url: env:SLACK_WEBHOOK_URL
+  subscriptions:
+    - notifier: ops
+    - notifier: chat
+      repos: [acme/*]
+      events: [sync_failed, recovered]
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := config.Parse(strings.NewReader(exampleConfig))
+	require.NoError(t, err)
+
+	host, port, err := cfg.ListenAddr()
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+	assert.Equal(t, 8082, port)
+
+	assert.Equal(t, "/var/lib/doppelganger", cfg.MirrorDir)
+	assert.Equal(t, "https://doppelganger.example.com/apihook", cfg.WebhookURL())
+	assert.Equal(t, git.Credential("env:DOPPELGANGER_GITHUB_TOKEN"), cfg.Sources.GitHub.Token)
+	assert.Equal(t, git.Credential("file:/run/secrets/webhook"), cfg.Sources.GitHub.WebhookSecret)
+
+	if assert.Len(t, cfg.Mirrors, 2) {
+		assert.Equal(t, "acme/api", cfg.Mirrors[0].Name)
+		assert.True(t, cfg.Mirrors[0].Tracked())
+		assert.Empty(t, cfg.Mirrors[0].PushTargets)
+
+		assert.Equal(t, "acme/website", cfg.Mirrors[1].Name)
+		assert.Equal(t, "https://git.example.com/acme/website.git", cfg.Mirrors[1].URL)
+		assert.False(t, cfg.Mirrors[1].Tracked())
+		assert.Equal(t, []config.PushTarget{
+			{Name: "gitea", URL: "https://gitea.example.com/acme/website.git", Credential: "env:GITEA_TOKEN"},
+		}, cfg.Mirrors[1].PushTargets)
+	}
+
+	assert.Equal(t, 24*time.Hour, cfg.Notifications.StaleAfter)
+	assert.Zero(t, cfg.Notifications.DigestInterval)
+	if assert.Len(t, cfg.Notifications.Notifiers, 2) {
+		assert.Equal(t, config.Notifier{
+			Name: "ops",
+			Email: &config.EmailNotifier{
+				SMTP:     "smtp.example.com:587",
+				Username: "doppelganger",
+				Password: "env:SMTP_PASSWORD",
+				From:     "doppelganger@example.com",
+				To:       []string{"ops@example.com"},
+			},
+		}, cfg.Notifications.Notifiers[0])
+		assert.Equal(t, config.Notifier{Name: "chat", Slack: &config.WebhookNotifier{URL: "env:SLACK_WEBHOOK_URL"}}, cfg.Notifications.Notifiers[1])
+	}
+	assert.Equal(t, []config.Subscription{
+		{Notifier: "ops"},
+		{Notifier: "chat", Repos: []string{"acme/*"}, Events: []string{"sync_failed", "recovered"}},
+	}, cfg.Notifications.Subscriptions)
+}
+
+func TestWebhookNotifier_ResolveURL(t *testing.T) {
+	os.Setenv("DOPPELGANGER_TEST_SLACK_URL", "https://hooks.slack.com/services/T0/B0/secret")
+	defer os.Unsetenv("DOPPELGANGER_TEST_SLACK_URL")
+
+	u, err := (&config.WebhookNotifier{URL: "env:DOPPELGANGER_TEST_SLACK_URL"}).ResolveURL()
+	require.NoError(t, err)
+	assert.Equal(t, "https://hooks.slack.com/services/T0/B0/secret", u)
+
+	u, err = (&config.WebhookNotifier{URL: "https://hooks.example.com/doppelganger"}).ResolveURL()
+	require.NoError(t, err)
+	assert.Equal(t, "https://hooks.example.com/doppelganger", u)
+}
+
+func TestParse_Empty(t *testing.T) {
+	cfg, err := config.Parse(strings.NewReader(""))
+	require.NoError(t, err)
+
+	assert.Empty(t, cfg.Mirrors)
+	assert.Empty(t, cfg.WebhookURL())
+}
+
+func TestMirror_Tracked(t *testing.T) {
+	tracking, noTracking := true, false
+
+	for _, tc := range []struct {
+		Mirror   config.Mirror
+		Expected bool
+	}{
+		{config.Mirror{Name: "acme/api"}, true},
+		{config.Mirror{Name: "acme/api", Track: &noTracking}, false},
+		{config.Mirror{Name: "acme/api", URL: "git@github.com:acme/api.git"}, true},
+		{config.Mirror{Name: "acme/api", URL: "https://github.com/acme/api.git", Track: &tracking}, true},
+		{config.Mirror{Name: "acme/api", URL: "https://git.example.com/api.git"}, false},
+	} {
+		assert.Equal(t, tc.Expected, tc.Mirror.Tracked(), tc.Mirror.URL)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown key":               "listen: :8081\nmirrors_dir: /tmp\n",
+		"malformed yaml":            "mirrors: [",
+		"listen without port":       "listen: localhost\n",
+		"listen with bad port":      "listen: localhost:http\n",
+		"public url without host":   "public_url: /doppelganger\n",
+		"invalid token reference":   "sources:\n  github:\n    token: ghp_secret\n",
+		"invalid mirror name":       "mirrors:\n  - name: ../etc\n",
+		"missing mirror name":       "mirrors:\n  - url: https://github.com/acme/api.git\n",
+		"duplicate mirror":          "mirrors:\n  - name: acme/api\n  - name: acme/api\n",
+		"push target without url":   "mirrors:\n  - name: acme/api\n    push_targets:\n      - name: gitea\n",
+		"invalid push credential":   "mirrors:\n  - name: acme/api\n    push_targets:\n      - name: gitea\n        url: /srv/git\n        credential: secret\n",
+		"duplicate push target":     "mirrors:\n  - name: acme/api\n    push_targets:\n      - {name: a, url: /srv/a}\n      - {name: a, url: /srv/b}\n",
+		"track is not a boolean":    "mirrors:\n  - name: acme/api\n    track: sometimes\n",
+		"mirrors is not a sequence": "mirrors: acme/api\n",
+		"tracking non-github url":   "mirrors:\n  - name: acme/api\n    url: https://git.example.com/api.git\n    track: true\n",
+		"notifier without name":     "notifications:\n  notifiers:\n    - slack: {url: https://hooks.slack.com/x}\n",
+		"notifier without kind":     "notifications:\n  notifiers:\n    - name: ops\n",
+		"notifier with two kinds":   "notifications:\n  notifiers:\n    - name: ops\n      slack: {url: https://a/x}\n      webhook: {url: https://b/y}\n",
+		"email without recipients":  "notifications:\n  notifiers:\n    - name: ops\n      email: {smtp: localhost:25, from: a@b.c}\n",
+		"email with bad smtp":       "notifications:\n  notifiers:\n    - name: ops\n      email: {smtp: localhost, from: a@b.c, to: [d@e.f]}\n",
+		"webhook with bad url":      "notifications:\n  notifiers:\n    - name: ops\n      webhook: {url: hooks.example.com}\n",
+		"unknown notifier":          "notifications:\n  subscriptions:\n    - notifier: ops\n",
+		"unknown event":             "notifications:\n  notifiers:\n    - name: ops\n      slack: {url: https://a/x}\n  subscriptions:\n    - {notifier: ops, events: [pushed]}\n",
+		"bad stale_after":           "notifications:\n  stale_after: a day\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := config.Parse(strings.NewReader(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "doppelganger-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "doppelganger.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(exampleConfig), 0644))
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Mirrors, 2)
+
+	_, err = config.Load(filepath.Join(dir, "missing.yml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/logging"
+)
+
+// Report is the outcome of reconciliation.
+type Report struct {
+	// Created lists mirrors that have been created.
+	Created []string
+	// Existing lists declared mirrors that already existed.
+	Existing []string
+	// Extra lists mirrors that exist but are not declared in configuration.
+	Extra []string
+	// Failed maps names of mirrors that could not be reconciled to errors.
+	Failed map[string]error
+}
+
+// String returns a summary of reconciliation.
+func (r *Report) String() string {
+	return fmt.Sprintf("%d created, %d existing, %d failed, %d not declared", len(r.Created), len(r.Existing), len(r.Failed), len(r.Extra))
+}
+
+// Reconciler brings the set of mirrors in line with configuration: it creates missing mirrors, sets up change
+// tracking and push targets, removing push targets that are not declared. Mirrors that are not declared in
+// configuration are reported, but left intact.
+type Reconciler struct {
+	mirrors  git.MirrorService
+	source   git.RepositoryService
+	tracking git.TrackingService
+	push     git.PushService
+	hookURL  string
+
+	mu sync.Mutex
+}
+
+// NewReconciler returns an instance of Reconciler that creates mirrors using mirrors service looking up the URL
+// of repository in source unless it is set in configuration.
+func NewReconciler(mirrors git.MirrorService, source git.RepositoryService) *Reconciler {
+	return &Reconciler{
+		mirrors: mirrors,
+		source:  source,
+	}
+}
+
+// EnableTracking makes Reconciler set up webhooks to be sent to hookURL for mirrors that have tracking enabled.
+func (r *Reconciler) EnableTracking(tracking git.TrackingService, hookURL string) {
+	r.tracking, r.hookURL = tracking, hookURL
+}
+
+// EnablePushTargets makes Reconciler configure push targets of mirrors.
+func (r *Reconciler) EnablePushTargets(push git.PushService) {
+	r.push = push
+}
+
+// Reconcile makes sure that declared mirrors exist and are configured as declared. A failure to reconcile
+// one mirror does not prevent reconciling others. Concurrent calls are executed one after another.
+func (r *Reconciler) Reconcile(ctx context.Context, declared []Mirror) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx = logging.With(ctx, "job_id", logging.NewID())
+	startTime := time.Now()
+	report := &Report{
+		Failed: make(map[string]error),
+	}
+
+	names := make(map[string]struct{}, len(declared))
+	for _, m := range declared {
+		names[m.Name] = struct{}{}
+
+		created, err := r.reconcileMirror(ctx, m)
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "failed to reconcile mirror", "repo", m.Name, "error", err)
+			report.Failed[m.Name] = err
+		case created:
+			report.Created = append(report.Created, m.Name)
+		default:
+			report.Existing = append(report.Existing, m.Name)
+		}
+	}
+
+	existing, err := r.mirrors.All(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to list mirrors to find undeclared ones", "error", err)
+	}
+
+	for _, repo := range existing {
+		if _, ok := names[repo.FullName]; !ok {
+			report.Extra = append(report.Extra, repo.FullName)
+		}
+	}
+	sort.Strings(report.Extra)
+
+	for _, name := range report.Extra {
+		slog.WarnContext(ctx, "mirror is not declared in configuration", "repo", name)
+	}
+
+	slog.InfoContext(ctx, "reconciled mirrors", "created", len(report.Created), "existing", len(report.Existing), "failed", len(report.Failed), "extra", len(report.Extra), "duration", time.Since(startTime))
+
+	return report
+}
+
+func (r *Reconciler) reconcileMirror(ctx context.Context, m Mirror) (created bool, err error) {
+	name, err := git.ParseRepositoryName(m.Name)
+	if err != nil {
+		return false, err
+	}
+
+	switch _, err := r.mirrors.Get(ctx, name); err {
+	case nil:
+	case git.ErrorNotMirrored:
+		gitURL := m.URL
+		if gitURL == "" {
+			repo, err := r.source.Get(ctx, name)
+			if err != nil {
+				return false, fmt.Errorf("failed to look up source repository: %s", err)
+			}
+
+			gitURL = repo.GitURL
+		}
+
+		if err := r.mirrors.Create(ctx, name, gitURL); err != nil {
+			return false, fmt.Errorf("failed to create mirror: %s", err)
+		}
+
+		slog.InfoContext(ctx, "created mirror", "repo", m.Name, "url", gitURL)
+		created = true
+	default:
+		return false, err
+	}
+
+	if m.Tracked() && r.tracking != nil {
+		if err := r.tracking.Track(ctx, m.Name, r.hookURL); err != nil {
+			return created, fmt.Errorf("failed to set up change tracking: %s", err)
+		}
+	}
+
+	if r.push != nil {
+		if err := r.removeUndeclaredPushTargets(ctx, name, m.PushTargets); err != nil {
+			return created, err
+		}
+
+		for _, target := range m.PushTargets {
+			err := r.push.AddPushTarget(ctx, name, git.PushTarget{
+				Name:       target.Name,
+				URL:        target.URL,
+				Credential: target.Credential,
+			})
+			if err != nil {
+				return created, fmt.Errorf("failed to configure push target %s: %s", target.Name, err)
+			}
+		}
+	}
+
+	return created, nil
+}
+
+// removeUndeclaredPushTargets removes push targets of mirror name that are not listed in declared.
+func (r *Reconciler) removeUndeclaredPushTargets(ctx context.Context, name git.RepositoryName, declared []PushTarget) error {
+	targets, err := r.push.PushTargets(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to list push targets: %s", err)
+	}
+
+	names := make(map[string]struct{}, len(declared))
+	for _, target := range declared {
+		names[target.Name] = struct{}{}
+	}
+
+	for _, target := range targets {
+		if _, ok := names[target.Name]; ok {
+			continue
+		}
+
+		if err := r.push.RemovePushTarget(ctx, name, target.Name); err != nil {
+			return fmt.Errorf("failed to remove push target %s: %s", target.Name, err)
+		}
+
+		slog.InfoContext(ctx, "removed push target that is not declared", "repo", name.String(), "target", target.Name)
+	}
+
+	return nil
+}
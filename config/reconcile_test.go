@@ -0,0 +1,160 @@
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/config"
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
+)
+
+type mirrorServiceMock struct {
+	mock.Mock
+}
+
+func (m *mirrorServiceMock) All(ctx context.Context) ([]*git.Repository, error) {
+	args := m.Called()
+	repos, _ := args.Get(0).([]*git.Repository)
+	return repos, args.Error(1)
+}
+
+func (m *mirrorServiceMock) Get(ctx context.Context, name git.RepositoryName) (*git.Repository, error) {
+	args := m.Called(name.String())
+	repo, _ := args.Get(0).(*git.Repository)
+	return repo, args.Error(1)
+}
+
+func (m *mirrorServiceMock) Create(ctx context.Context, name git.RepositoryName, url string) error {
+	return m.Called(name.String(), url).Error(0)
+}
+
+func (m *mirrorServiceMock) Update(ctx context.Context, name git.RepositoryName) error {
+	return m.Called(name.String()).Error(0)
+}
+
+type trackingServiceMock struct {
+	mock.Mock
+}
+
+func (m *trackingServiceMock) Track(ctx context.Context, name, callbackURL string) error {
+	return m.Called(name, callbackURL).Error(0)
+}
+
+type pushServiceMock struct {
+	mock.Mock
+}
+
+func (m *pushServiceMock) PushTargets(ctx context.Context, name git.RepositoryName) ([]*git.PushTarget, error) {
+	args := m.Called(name.String())
+	targets, _ := args.Get(0).([]*git.PushTarget)
+	return targets, args.Error(1)
+}
+
+func (m *pushServiceMock) AddPushTarget(ctx context.Context, name git.RepositoryName, target git.PushTarget) error {
+	return m.Called(name.String(), target).Error(0)
+}
+
+func (m *pushServiceMock) RemovePushTarget(ctx context.Context, name git.RepositoryName, targetName string) error {
+	return m.Called(name.String(), targetName).Error(0)
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	noTracking := false
+
+	mirrors := &mirrorServiceMock{}
+	mirrors.On("Get", "acme/api").Return(&git.Repository{FullName: "acme/api"}, nil)
+	mirrors.On("Get", "acme/website").Return(nil, git.ErrorNotMirrored)
+	mirrors.On("Get", "acme/docs").Return(nil, git.ErrorNotMirrored)
+	mirrors.On("Create", "acme/website", "git@github.com:acme/website.git").Return(nil)
+	mirrors.On("Create", "acme/docs", "https://git.example.com/docs.git").Return(nil)
+	mirrors.On("Get", "acme/tools").Return(&git.Repository{FullName: "acme/tools"}, nil)
+	mirrors.On("All").Return([]*git.Repository{
+		{FullName: "acme/api"},
+		{FullName: "acme/website"},
+		{FullName: "acme/docs"},
+		{FullName: "acme/tools"},
+		{FullName: "acme/legacy"},
+	}, nil)
+
+	source := &mirrorServiceMock{}
+	source.On("Get", "acme/website").Return(&git.Repository{FullName: "acme/website", GitURL: "git@github.com:acme/website.git"}, nil)
+
+	tracking := &trackingServiceMock{}
+	tracking.On("Track", "acme/api", "https://doppelganger.example.com/apihook").Return(nil)
+	tracking.On("Track", "acme/website", "https://doppelganger.example.com/apihook").Return(nil)
+
+	// Push targets that are no longer declared are removed
+	push := &pushServiceMock{}
+	push.On("PushTargets", "acme/api").Return([]*git.PushTarget{{Name: "gitea"}, {Name: "backup"}}, nil)
+	push.On("RemovePushTarget", "acme/api", "backup").Return(nil)
+	push.On("AddPushTarget", "acme/api", git.PushTarget{Name: "gitea", URL: "/srv/git/api.git", Credential: "env:GITEA_TOKEN"}).Return(nil)
+	push.On("PushTargets", "acme/website").Return(nil, nil)
+	push.On("PushTargets", "acme/docs").Return(nil, nil)
+	push.On("PushTargets", "acme/tools").Return(nil, nil)
+
+	r := config.NewReconciler(mirrors, source)
+	r.EnableTracking(tracking, "https://doppelganger.example.com/apihook")
+	r.EnablePushTargets(push)
+
+	report := r.Reconcile(context.Background(), []config.Mirror{
+		{Name: "acme/api", PushTargets: []config.PushTarget{{Name: "gitea", URL: "/srv/git/api.git", Credential: "env:GITEA_TOKEN"}}},
+		{Name: "acme/website"},
+		{Name: "acme/docs", URL: "https://git.example.com/docs.git", Track: &noTracking},
+		// Mirrors cloned from outside of GitHub are not tracked
+		{Name: "acme/tools", URL: "https://git.example.com/tools.git"},
+	})
+
+	mirrors.AssertExpectations(t)
+	source.AssertExpectations(t)
+	tracking.AssertExpectations(t)
+	push.AssertExpectations(t)
+
+	assert.Equal(t, []string{"acme/website", "acme/docs"}, report.Created)
+	assert.Equal(t, []string{"acme/api", "acme/tools"}, report.Existing)
+	assert.Equal(t, []string{"acme/legacy"}, report.Extra)
+	assert.Empty(t, report.Failed)
+	assert.Equal(t, "2 created, 2 existing, 0 failed, 1 not declared", report.String())
+}
+
+func TestReconciler_Reconcile_Failures(t *testing.T) {
+	mirrors := &mirrorServiceMock{}
+	mirrors.On("Get", "acme/missing").Return(nil, git.ErrorNotMirrored)
+	mirrors.On("Get", "acme/broken").Return(nil, git.ErrorNotMirrored)
+	mirrors.On("Get", "acme/api").Return(nil, git.ErrorNotMirrored)
+	mirrors.On("Create", "acme/broken", "git@github.com:acme/broken.git").Return(errors.New("clone failed"))
+	mirrors.On("Create", "acme/api", "git@github.com:acme/api.git").Return(nil)
+	mirrors.On("All").Return(nil, errors.New("no mirror dir"))
+
+	source := &mirrorServiceMock{}
+	source.On("Get", "acme/missing").Return(nil, git.ErrorNotFound)
+	source.On("Get", "acme/broken").Return(&git.Repository{FullName: "acme/broken", GitURL: "git@github.com:acme/broken.git"}, nil)
+	source.On("Get", "acme/api").Return(&git.Repository{FullName: "acme/api", GitURL: "git@github.com:acme/api.git"}, nil)
+
+	tracking := &trackingServiceMock{}
+	tracking.On("Track", "acme/api", "http://localhost/apihook").Return(errors.New("forbidden"))
+
+	r := config.NewReconciler(mirrors, source)
+	r.EnableTracking(tracking, "http://localhost/apihook")
+
+	report := r.Reconcile(context.Background(), []config.Mirror{
+		{Name: "acme/missing"},
+		{Name: "acme/broken"},
+		{Name: "acme/api"},
+	})
+
+	mirrors.AssertExpectations(t)
+	source.AssertExpectations(t)
+	tracking.AssertExpectations(t)
+
+	assert.Empty(t, report.Created)
+	assert.Empty(t, report.Existing)
+	assert.Empty(t, report.Extra)
+	if assert.Len(t, report.Failed, 3) {
+		assert.Contains(t, report.Failed, "acme/missing")
+		assert.Contains(t, report.Failed, "acme/broken")
+		assert.Contains(t, report.Failed, "acme/api")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/andrewslotin/doppelganger/config"
+	"github.com/andrewslotin/doppelganger/git"
+	"golang.org/x/net/context"
+)
+
+// loadConfig reads configuration file and applies its settings to command line arguments that have not been
+// set explicitly, so that flags take precedence over configuration file.
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if cfg.Listen != "" && !set["addr"] && !set["port"] {
+		args.addr, args.port, _ = cfg.ListenAddr()
+	}
+
+	if cfg.MirrorDir != "" && !set["mirror"] {
+		args.mirrorDir = cfg.MirrorDir
+	}
+
+	if cfg.DataDir != "" && !set["data-dir"] {
+		args.dataDir = cfg.DataDir
+	}
+
+	if cfg.PushTargetDir != "" && !set["push-target-dir"] {
+		args.pushTargetDir = cfg.PushTargetDir
+	}
+
+	return cfg, nil
+}
+
+// configuredSecret returns the value of environment variable envName or, if it is not set, resolves the reference
+// to a secret from configuration file.
+func configuredSecret(envName string, ref git.Credential) (string, error) {
+	if value := os.Getenv(envName); value != "" || ref == "" {
+		return value, nil
+	}
+
+	return ref.Secret()
+}
+
+// reconcileMirrors brings mirrors in line with configuration unless it does not declare any.
+func reconcileMirrors(ctx context.Context, reconciler *config.Reconciler, cfg *config.Config) {
+	if cfg == nil || cfg.Mirrors == nil {
+		return
+	}
+
+	reconciler.Reconcile(ctx, cfg.Mirrors)
+}
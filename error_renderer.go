@@ -1,13 +1,18 @@
 package main
 
 import (
-	"html/template"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
 )
 
 var (
-	internalErrorTemplate = template.Must(template.ParseFiles("templates/layout.html.template", "templates/errors/internal_error.html.template"))
-	notFoundErrorTemplate = template.Must(template.ParseFiles("templates/layout.html.template", "templates/errors/not_found.html.template"))
+	internalErrorTemplate = parsePageTemplate("errors/internal_error.html.template")
+	notFoundErrorTemplate = parsePageTemplate("errors/not_found.html.template")
 )
 
 // UserError wraps an internal server error and replaces its message with a
@@ -36,3 +41,18 @@ func WriteNotFoundPage(w http.ResponseWriter, message, backURL string) {
 	w.WriteHeader(http.StatusNotFound)
 	notFoundErrorTemplate.Execute(w, struct{ Message, BackURL string }{message, backURL})
 }
+
+// WriteServiceErrorPage renders an error returned by repository service. If GitHub API rate limit has been exhausted
+// user is asked to try again after it resets, otherwise an internal server error page is rendered.
+func WriteServiceErrorPage(w http.ResponseWriter, err error, backURL string) {
+	var rateLimitErr *git.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		msg := fmt.Sprintf("GitHub API rate limit is exhausted, please try again after %s", rateLimitErr.Reset.Format("15:04 MST"))
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(rateLimitErr.Reset).Seconds())+1))
+		WriteErrorPage(w, UserError{Message: msg, BackURL: backURL, OriginalError: err}, http.StatusServiceUnavailable)
+
+		return
+	}
+
+	WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: backURL, OriginalError: err}, http.StatusInternalServerError)
+}
@@ -0,0 +1,244 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// CachedRepositories is a type that implements RepositoryService and keeps the list of repositories returned
+// by source in memory and, optionally, on disk. The list is refreshed in background once it gets older than ttl,
+// so that callers get the cached version without waiting. If the refresh fails, the stale list is kept until
+// the next successful attempt. Get requests are passed to source as is.
+type CachedRepositories struct {
+	RepositoryService
+
+	ttl       time.Duration
+	cachePath string
+
+	refreshMu sync.Mutex
+
+	mu         sync.Mutex
+	repos      []*Repository
+	loaded     bool
+	updatedAt  time.Time
+	lastErr    error
+	refreshing bool
+}
+
+type repositoriesCache struct {
+	UpdatedAt    time.Time
+	Repositories []*Repository
+}
+
+// NewCachedRepositories returns an instance of CachedRepositories that caches the list of repositories
+// returned by source for ttl. If cachePath is not empty, the list is persisted to this file and loaded
+// from it on start.
+func NewCachedRepositories(source RepositoryService, ttl time.Duration, cachePath string) *CachedRepositories {
+	service := &CachedRepositories{
+		RepositoryService: source,
+		ttl:               ttl,
+		cachePath:         cachePath,
+	}
+
+	if cachePath != "" {
+		if err := service.load(); err != nil && !os.IsNotExist(err) {
+			slog.Warn("failed to load repositories cache", "path", cachePath, "error", err)
+		}
+	}
+
+	return service
+}
+
+// All returns cached list of repositories. The list is fetched from source if it has not been loaded yet,
+// otherwise a background refresh is started if cached version is older than ttl.
+func (service *CachedRepositories) All(ctx context.Context) ([]*Repository, error) {
+	service.mu.Lock()
+	loaded, repos, expired := service.loaded, service.repos, time.Since(service.updatedAt) > service.ttl
+	service.mu.Unlock()
+
+	if !loaded {
+		if err := service.Refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		service.mu.Lock()
+		defer service.mu.Unlock()
+
+		return service.repos, nil
+	}
+
+	if expired {
+		service.refreshInBackground()
+	}
+
+	return repos, nil
+}
+
+// Query applies q to cached list of repositories. Until the list is loaded, queries are passed to source if it
+// implements QueryService, so that the first page is not held back until the full list is fetched in background.
+func (service *CachedRepositories) Query(ctx context.Context, q RepositoryQuery) (RepositoryPage, error) {
+	service.mu.Lock()
+	loaded := service.loaded
+	service.mu.Unlock()
+
+	if source, ok := service.RepositoryService.(QueryService); ok && !loaded {
+		service.refreshInBackground()
+		return source.Query(ctx, q)
+	}
+
+	repos, err := service.All(ctx)
+	if err != nil {
+		return RepositoryPage{}, err
+	}
+
+	page, total := q.Apply(repos)
+
+	return RepositoryPage{Repositories: page, Total: total, Owners: Owners(repos)}, nil
+}
+
+// Refresh fetches the list of repositories from source and updates the cache.
+func (service *CachedRepositories) Refresh(ctx context.Context) error {
+	service.refreshMu.Lock()
+	defer service.refreshMu.Unlock()
+
+	startTime := time.Now()
+
+	repos, err := service.RepositoryService.All(ctx)
+
+	service.mu.Lock()
+	service.lastErr = err
+	if err == nil {
+		service.repos, service.loaded, service.updatedAt = repos, true, time.Now()
+	}
+	service.mu.Unlock()
+
+	if err != nil {
+		slog.WarnContext(ctx, "failed to refresh repositories list", "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "refreshed repositories list", "repos", len(repos), "duration", time.Since(startTime))
+
+	if service.cachePath != "" {
+		if err := service.save(); err != nil {
+			slog.WarnContext(ctx, "failed to save repositories cache", "path", service.cachePath, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// Invalidate marks cached list as expired, so that it is refreshed on the next call to All.
+func (service *CachedRepositories) Invalidate() {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.updatedAt = time.Time{}
+}
+
+// UpdatedAt returns the time cached list was fetched from source.
+func (service *CachedRepositories) UpdatedAt() time.Time {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	return service.updatedAt
+}
+
+// Stale returns true if the latest refresh has failed and cached list is served instead.
+func (service *CachedRepositories) Stale() bool {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	return service.loaded && service.lastErr != nil
+}
+
+// Run refreshes cached list every ttl until ctx is cancelled.
+func (service *CachedRepositories) Run(ctx context.Context) {
+	ticker := time.NewTicker(service.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			service.Refresh(ctx)
+		}
+	}
+}
+
+func (service *CachedRepositories) refreshInBackground() {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.refreshing {
+		return
+	}
+	service.refreshing = true
+
+	go func() {
+		service.Refresh(context.Background())
+
+		service.mu.Lock()
+		service.refreshing = false
+		service.mu.Unlock()
+	}()
+}
+
+func (service *CachedRepositories) load() error {
+	data, err := ioutil.ReadFile(service.cachePath)
+	if err != nil {
+		return err
+	}
+
+	var cache repositoriesCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("malformed cache file: %s", err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	service.repos, service.loaded, service.updatedAt = cache.Repositories, true, cache.UpdatedAt
+
+	return nil
+}
+
+func (service *CachedRepositories) save() error {
+	service.mu.Lock()
+	data, err := json.Marshal(repositoriesCache{
+		UpdatedAt:    service.updatedAt,
+		Repositories: service.repos,
+	})
+	service.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile(filepath.Dir(service.cachePath), "."+filepath.Base(service.cachePath))
+	if err != nil {
+		return err
+	}
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	return os.Rename(fd.Name(), service.cachePath)
+}
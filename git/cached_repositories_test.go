@@ -0,0 +1,148 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCachedRepositories_All(t *testing.T) {
+	repos := []*git.Repository{{FullName: "user1/repo1"}}
+
+	source := &repositoryServiceMock{}
+	source.On("All").Return(repos, nil).Once()
+
+	service := git.NewCachedRepositories(source, time.Hour, "")
+
+	for i := 0; i < 2; i++ {
+		result, err := service.All(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, repos, result)
+	}
+
+	source.AssertExpectations(t)
+	assert.False(t, service.Stale())
+	assert.WithinDuration(t, time.Now(), service.UpdatedAt(), time.Second)
+}
+
+func TestCachedRepositories_All_SourceFails(t *testing.T) {
+	source := &repositoryServiceMock{}
+	source.On("All").Return(nil, assert.AnError)
+
+	_, err := git.NewCachedRepositories(source, time.Hour, "").All(context.Background())
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestCachedRepositories_Refresh_Stale(t *testing.T) {
+	repos := []*git.Repository{{FullName: "user1/repo1"}}
+
+	source := &repositoryServiceMock{}
+	source.On("All").Return(repos, nil).Once()
+	source.On("All").Return(nil, assert.AnError).Once()
+
+	service := git.NewCachedRepositories(source, time.Hour, "")
+	require.NoError(t, service.Refresh(context.Background()))
+	assert.Error(t, service.Refresh(context.Background()))
+
+	result, err := service.All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, repos, result, "stale list should be served if refresh fails")
+	assert.True(t, service.Stale())
+
+	source.AssertExpectations(t)
+}
+
+type queryServiceMock struct {
+	repositoryServiceMock
+}
+
+func (service *queryServiceMock) Query(ctx context.Context, q git.RepositoryQuery) (git.RepositoryPage, error) {
+	args := service.Mock.Called(q)
+	page, _ := args.Get(0).(git.RepositoryPage)
+	return page, args.Error(1)
+}
+
+func TestCachedRepositories_Query(t *testing.T) {
+	repos := []*git.Repository{{FullName: "user1/repo1"}, {FullName: "user2/repo2"}}
+	q := git.RepositoryQuery{Page: 1, PerPage: 1}
+
+	source := &queryServiceMock{}
+	source.On("Query", q).Return(git.RepositoryPage{Repositories: repos[:1], Total: 2}, nil).Once()
+	source.On("All").Return(repos, nil).Once()
+
+	service := git.NewCachedRepositories(source, time.Hour, "")
+
+	// The first page is requested from source while the list is being loaded
+	page, err := service.Query(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, repos[:1], page.Repositories)
+	assert.Equal(t, 2, page.Total)
+
+	for i := 0; i < 100 && service.UpdatedAt().IsZero(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	q.Page = 2
+	page, err = service.Query(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, repos[1:], page.Repositories)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, []string{"user1", "user2"}, page.Owners)
+
+	source.AssertExpectations(t)
+}
+
+func TestCachedRepositories_Invalidate(t *testing.T) {
+	oldRepos, newRepos := []*git.Repository{{FullName: "user1/repo1"}}, []*git.Repository{{FullName: "user1/repo2"}}
+
+	source := &repositoryServiceMock{}
+	source.On("All").Return(oldRepos, nil).Once()
+	source.On("All").Return(newRepos, nil).Once()
+
+	service := git.NewCachedRepositories(source, time.Hour, "")
+	require.NoError(t, service.Refresh(context.Background()))
+
+	service.Invalidate()
+
+	result, err := service.All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, oldRepos, result, "cached list should be returned while refreshing")
+
+	for i := 0; i < 100 && service.UpdatedAt().IsZero(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err = service.All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, newRepos, result)
+
+	source.AssertExpectations(t)
+}
+
+func TestCachedRepositories_Persistence(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "doppelganger")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cachePath := filepath.Join(tmpDir, "github.json")
+	repos := []*git.Repository{{FullName: "user1/repo1", Description: "Test repo", Master: "main"}}
+
+	source := &repositoryServiceMock{}
+	source.On("All").Return(repos, nil).Once()
+
+	require.NoError(t, git.NewCachedRepositories(source, time.Hour, cachePath).Refresh(context.Background()))
+
+	// Cached list is loaded from disk without requesting source
+	result, err := git.NewCachedRepositories(&repositoryServiceMock{}, time.Hour, cachePath).All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, repos, result)
+
+	source.AssertExpectations(t)
+}
@@ -0,0 +1,156 @@
+package git
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/logging"
+)
+
+// CloneFunc is a function that creates a mirror reporting the progress of clone to ctx set with WithProgress.
+type CloneFunc func(ctx context.Context) error
+
+// CloneState is a snapshot of a clone job.
+type CloneState struct {
+	Progress
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// CloneJob is a mirror clone running in background.
+type CloneJob struct {
+	FullName  string
+	StartedAt time.Time
+
+	mu         sync.Mutex
+	state      CloneState
+	finishedAt time.Time
+	changed    chan struct{}
+}
+
+// Watch returns the current state of job along with a channel that is closed once the state changes.
+func (job *CloneJob) Watch() (CloneState, <-chan struct{}) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.state, job.changed
+}
+
+func (job *CloneJob) update(fn func(*CloneState)) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	prev := job.state
+	if fn(&job.state); job.state == prev {
+		return
+	}
+
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// CloneJobs runs mirror clones in background and keeps track of their progress, so that it can be shown
+// to the user who has requested a mirror instead of keeping their request waiting until the clone is done.
+// Finished jobs are kept for retention to let clients that have been disconnected learn about the outcome.
+type CloneJobs struct {
+	retention time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*CloneJob
+}
+
+// NewCloneJobs creates and initializes a new instance of CloneJobs.
+func NewCloneJobs(retention time.Duration) *CloneJobs {
+	return &CloneJobs{
+		retention: retention,
+		jobs:      make(map[string]*CloneJob),
+	}
+}
+
+// Start runs fn in background to clone repository fullName. If there is a job for this repository running
+// already, it is returned along with false instead. The job keeps values stored in ctx, i.e. the actor
+// recorded to audit log and log attributes, but is not cancelled together with ctx, so that it outlives
+// the request that has started it. MirroredRepositories.Shutdown waits for the clone to complete.
+func (jobs *CloneJobs) Start(ctx context.Context, fullName string, fn CloneFunc) (*CloneJob, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	jobs.prune()
+	if job, ok := jobs.jobs[fullName]; ok {
+		if state, _ := job.Watch(); !state.Done {
+			return job, false
+		}
+	}
+
+	job := &CloneJob{
+		FullName:  fullName,
+		StartedAt: time.Now(),
+		changed:   make(chan struct{}),
+	}
+	jobs.jobs[fullName] = job
+
+	jobCtx := logging.With(detachedContext{ctx}, "job_id", logging.NewID(), "repo", fullName)
+	jobCtx = WithProgress(jobCtx, func(p Progress) {
+		job.update(func(state *CloneState) {
+			state.Progress = p
+		})
+	})
+
+	go func() {
+		err := fn(jobCtx)
+		if err != nil {
+			slog.WarnContext(jobCtx, "background clone failed", "duration", time.Since(job.StartedAt), "error", err)
+		} else {
+			slog.InfoContext(jobCtx, "background clone finished", "duration", time.Since(job.StartedAt))
+		}
+
+		job.update(func(state *CloneState) {
+			state.Done = true
+			if err != nil {
+				state.Error = err.Error()
+			}
+		})
+
+		jobs.mu.Lock()
+		job.finishedAt = time.Now()
+		jobs.mu.Unlock()
+	}()
+
+	return job, true
+}
+
+// Get returns a running or recently finished clone job for repository fullName.
+func (jobs *CloneJobs) Get(fullName string) (*CloneJob, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	jobs.prune()
+	job, ok := jobs.jobs[fullName]
+
+	return job, ok
+}
+
+// prune removes jobs that have finished more than retention ago, jobs.mu is expected to be held by caller.
+func (jobs *CloneJobs) prune() {
+	for name, job := range jobs.jobs {
+		if !job.finishedAt.IsZero() && time.Since(job.finishedAt) > jobs.retention {
+			delete(jobs.jobs, name)
+		}
+	}
+}
+
+// detachedContext keeps values of parent context, but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
@@ -0,0 +1,100 @@
+package git_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/audit"
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// waitForClone returns the state of job once it satisfies cond.
+func waitForClone(t *testing.T, job *git.CloneJob, cond func(git.CloneState) bool) git.CloneState {
+	timeout := time.After(5 * time.Second)
+	for {
+		state, changed := job.Watch()
+		if cond(state) {
+			return state
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("unexpected clone state %+v", state)
+		}
+	}
+}
+
+func TestCloneJobs_Start(t *testing.T) {
+	jobs := git.NewCloneJobs(time.Minute)
+
+	ctx, cancel := context.WithCancel(audit.WithActor(context.Background(), audit.Actor{Type: audit.ActorUser, Name: "jdoe"}))
+
+	release := make(chan struct{})
+	job, ok := jobs.Start(ctx, "a/b", func(ctx context.Context) error {
+		assert.Equal(t, "jdoe", audit.ActorFromContext(ctx).Name)
+
+		git.ProgressFromContext(ctx)(git.Progress{Phase: "Receiving objects", Percent: 50, Current: 1, Total: 2})
+		<-release
+
+		// The clone outlives the request that has started it
+		return ctx.Err()
+	})
+	require.True(t, ok)
+	cancel()
+
+	state := waitForClone(t, job, func(s git.CloneState) bool { return s.Percent == 50 })
+	assert.Equal(t, "Receiving objects", state.Phase)
+	assert.False(t, state.Done)
+
+	running, ok := jobs.Start(context.Background(), "a/b", func(ctx context.Context) error {
+		t.Error("clone has been started twice")
+		return nil
+	})
+	assert.False(t, ok)
+	assert.Equal(t, job, running)
+
+	close(release)
+	state = waitForClone(t, job, func(s git.CloneState) bool { return s.Done })
+	assert.Empty(t, state.Error)
+
+	found, ok := jobs.Get("a/b")
+	require.True(t, ok)
+	assert.Equal(t, job, found)
+
+	_, ok = jobs.Get("a/c")
+	assert.False(t, ok)
+}
+
+func TestCloneJobs_Start_Failed(t *testing.T) {
+	jobs := git.NewCloneJobs(time.Minute)
+
+	job, ok := jobs.Start(context.Background(), "a/b", func(ctx context.Context) error {
+		return errors.New("repository not found")
+	})
+	require.True(t, ok)
+
+	state := waitForClone(t, job, func(s git.CloneState) bool { return s.Done })
+	assert.Equal(t, "repository not found", state.Error)
+
+	// Failed clone can be retried
+	retry, ok := jobs.Start(context.Background(), "a/b", func(ctx context.Context) error { return nil })
+	require.True(t, ok)
+	assert.NotEqual(t, job, retry)
+}
+
+func TestCloneJobs_Retention(t *testing.T) {
+	jobs := git.NewCloneJobs(10 * time.Millisecond)
+
+	job, _ := jobs.Start(context.Background(), "a/b", func(ctx context.Context) error { return nil })
+	waitForClone(t, job, func(s git.CloneState) bool { return s.Done })
+
+	assert.Eventually(t, func() bool {
+		_, ok := jobs.Get("a/b")
+		return !ok
+	}, time.Second, 5*time.Millisecond)
+}
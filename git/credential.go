@@ -0,0 +1,121 @@
+package git
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ErrorInvalidCredential is returned when a credential reference cannot be parsed or resolved.
+var ErrorInvalidCredential = errors.New("invalid credential reference")
+
+// Credential is a reference to a secret used to authenticate against a remote. Secrets themselves are never stored
+// by Doppelganger, instead it keeps a reference that is resolved each time the secret is needed:
+//
+//   env:GITEA_TOKEN          // secret is read from GITEA_TOKEN environment variable
+//   file:/run/secrets/gitea  // secret is read from a file
+//   ssh-key:/etc/keys/id_rsa // private SSH key to use with git+ssh remotes
+//
+// For HTTPS remotes the secret is either "<username>:<password>" or a token, that is sent
+// as a password with HTTP basic auth.
+type Credential string
+
+// Validate checks whether credential reference has a supported format. An empty credential is valid.
+func (cred Credential) Validate() error {
+	if cred == "" {
+		return nil
+	}
+
+	kind, ref := cred.split()
+	switch kind {
+	case "env", "file", "ssh-key":
+		if ref == "" {
+			return ErrorInvalidCredential
+		}
+
+		return nil
+	default:
+		return ErrorInvalidCredential
+	}
+}
+
+// HostSecret returns true if credential refers to a secret kept by Doppelganger host itself, i.e. an environment
+// variable or a file, rather than to a key used to authenticate against remote.
+func (cred Credential) HostSecret() bool {
+	kind, _ := cred.split()
+	return kind == "env" || kind == "file"
+}
+
+// Secret resolves the credential reference and returns the secret it points to.
+func (cred Credential) Secret() (string, error) {
+	switch kind, ref := cred.split(); kind {
+	case "env":
+		secret, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+
+		return secret, nil
+	case "file":
+		data, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %s", err)
+		}
+
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return "", ErrorInvalidCredential
+	}
+}
+
+// GitConfig returns git configuration options that need to be passed to git in order to authenticate
+// against remoteURL with this credential. Secrets are only sent to HTTPS remotes.
+func (cred Credential) GitConfig(remoteURL string) (map[string]string, error) {
+	if cred == "" {
+		return nil, nil
+	}
+
+	if kind, keyPath := cred.split(); kind == "ssh-key" {
+		if _, err := os.Stat(keyPath); err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %s", err)
+		}
+
+		return map[string]string{
+			"core.sshCommand": fmt.Sprintf("ssh -i '%s' -o IdentitiesOnly=yes", strings.Replace(keyPath, "'", `'\''`, -1)),
+		}, nil
+	}
+
+	scheme, err := remoteURLScheme(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if scheme != "https" {
+		return nil, fmt.Errorf("credential %s can only be used with HTTPS remotes", cred)
+	}
+
+	secret, err := cred.Secret()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(secret, ":") {
+		secret = "doppelganger:" + secret
+	}
+
+	return map[string]string{
+		"http.extraHeader": "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(secret)),
+	}, nil
+}
+
+func (cred Credential) split() (kind, ref string) {
+	fields := strings.SplitN(string(cred), ":", 2)
+	if len(fields) != 2 {
+		return "", ""
+	}
+
+	return fields[0], fields[1]
+}
@@ -1,6 +1,9 @@
 package git
 
-import "golang.org/x/net/context"
+import (
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"golang.org/x/net/context"
+)
 
 // Command is the interface that wraps calls to Git.
 type Command interface {
@@ -9,4 +12,14 @@ type Command interface {
 	LastCommit(ctx context.Context, fullPath string) (Commit, error)
 	CloneMirror(ctx context.Context, gitURL, fullPath string) error
 	UpdateRemote(ctx context.Context, fullPath string) error
+	FetchMirror(ctx context.Context, fullPath, remoteURL string) error
+	RemoteURL(ctx context.Context, fullPath string) (string, error)
+	LFSPointers(ctx context.Context, fullPath string) ([]lfs.Pointer, error)
+	Refs(ctx context.Context, fullPath string) (map[string]string, error)
+	ReadFile(ctx context.Context, fullPath, rev, name string) ([]byte, error)
+	ConfigValues(ctx context.Context, fullPath, key string) []string
+	ConfigEntries(ctx context.Context, fullPath, keyPattern string) map[string]string
+	SetConfig(ctx context.Context, fullPath, key, value string) error
+	RemoveConfigSection(ctx context.Context, fullPath, section string) error
+	PushMirror(ctx context.Context, fullPath, remoteURL string, config map[string]string) error
 }
@@ -2,7 +2,8 @@ package git
 
 import (
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
@@ -10,19 +11,31 @@ import (
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 
+	"github.com/andrewslotin/doppelganger/audit"
 	"github.com/andrewslotin/doppelganger/git/internal"
+	"github.com/andrewslotin/doppelganger/logging"
+	"github.com/andrewslotin/doppelganger/notify"
 )
 
 var (
 	// ErrorNotFound is returned by Get method if specified repository cannot be found.
 	ErrorNotFound = errors.New("not found")
+	// ErrorInvalidToken is returned by CheckToken if GitHub rejects API token.
+	ErrorInvalidToken = errors.New("invalid GitHub token")
 	// GithubToken is a context.Context key for Github auth token.
 	GithubToken internal.TokenContextKey
+
+	// actionTrack is recorded to audit log when a webhook is set up for a repository.
+	actionTrack = audit.Action("track")
 )
 
 // GithubRepositories is a type intended to list and lookup GitHub repositories as well as setting webhooks.
 type GithubRepositories struct {
-	client *api.Client
+	client        *api.Client
+	rateLimits    *RateLimitTransport
+	webhookSecret string
+	audit         *audit.Log
+	monitor       *notify.Monitor
 }
 
 // NewGithubRepositories creates and initializes a new instance of GithubRepositories.
@@ -46,9 +59,11 @@ func NewGithubRepositories(ctx context.Context) (*GithubRepositories, error) {
 		AccessToken: token,
 	})
 	oauthClient := oauth2.NewClient(ctx, tokenSource)
+	rateLimits := NewRateLimitTransport(logging.NewTransport(oauthClient.Transport))
 
 	return &GithubRepositories{
-		client: api.NewClient(oauthClient),
+		client:     api.NewClient(&http.Client{Transport: rateLimits}),
+		rateLimits: rateLimits,
 	}, nil
 }
 
@@ -61,60 +76,117 @@ func (service *GithubRepositories) All(ctx context.Context) ([]*Repository, erro
 	}
 
 	var allRepos []*Repository
-
-	paginatedRepos := make([]*Repository, 0, opts.ListOptions.PerPage)
 	for {
 		githubRepos, response, err := service.client.Repositories.List(ctx, "", opts)
 		if err != nil {
 			return nil, err
 		}
+		allRepos = append(allRepos, repositoriesFromGithub(ctx, githubRepos)...)
 
-		for _, githubRepo := range githubRepos {
-			if githubRepo.FullName == nil {
-				log.Printf("[WARN] excluding GitHub repository without full_name %v", githubRepo)
-				continue
-			}
+		if response.NextPage == 0 {
+			break
+		}
+		opts.ListOptions.Page = response.NextPage
+	}
 
-			if githubRepo.SSHURL == nil {
-				log.Printf("[WARN] excluding GitHub repository without ssh_url %v", githubRepo)
-				continue
-			}
+	return allRepos, nil
+}
 
-			repo := &Repository{
-				FullName: *githubRepo.FullName,
-				Master:   "master",
-			}
+// Query returns a page of GitHub repositories matching q. Pages of repositories sorted by name or by push time are
+// requested from GitHub as is, together with the last page to count them. Other queries need the full list to be
+// fetched and are applied to it.
+func (service *GithubRepositories) Query(ctx context.Context, q RepositoryQuery) (RepositoryPage, error) {
+	if q.Page < 1 || q.PerPage < 1 || q.Name != "" || q.Owner != "" || (q.Sort != "" && q.Sort != SortByName && q.Sort != SortByLastCommit) {
+		repos, err := service.All(ctx)
+		if err != nil {
+			return RepositoryPage{}, err
+		}
 
-			if githubRepo.Description != nil {
-				repo.Description = *githubRepo.Description
-			}
+		page, total := q.Apply(repos)
 
-			if githubRepo.DefaultBranch != nil {
-				repo.Master = *githubRepo.DefaultBranch
-			}
+		return RepositoryPage{Repositories: page, Total: total, Owners: Owners(repos)}, nil
+	}
 
-			if githubRepo.HTMLURL != nil {
-				repo.HTMLURL = *githubRepo.HTMLURL
-			}
+	opts := &api.RepositoryListOptions{
+		Sort:      "full_name",
+		Direction: "asc",
+		ListOptions: api.ListOptions{
+			Page:    q.Page,
+			PerPage: q.PerPage,
+		},
+	}
+	if q.Sort == SortByLastCommit {
+		opts.Sort, opts.Direction = "pushed", "desc"
+	}
+
+	githubRepos, response, err := service.client.Repositories.List(ctx, "", opts)
+	if err != nil {
+		return RepositoryPage{}, err
+	}
 
-			paginatedRepos = append(paginatedRepos, repo)
+	page := repositoriesFromGithub(ctx, githubRepos)
+	total := (q.Page-1)*q.PerPage + len(githubRepos)
+
+	if response.LastPage > q.Page {
+		opts.ListOptions.Page = response.LastPage
+
+		lastRepos, _, err := service.client.Repositories.List(ctx, "", opts)
+		if err != nil {
+			return RepositoryPage{}, err
 		}
+		total = (response.LastPage-1)*q.PerPage + len(lastRepos)
+	}
 
-		allRepos = append(allRepos, paginatedRepos[0:]...)
-		paginatedRepos = paginatedRepos[:0]
+	return RepositoryPage{Repositories: page, Total: total, Owners: Owners(page)}, nil
+}
 
-		if response.NextPage == 0 {
-			break
+func repositoriesFromGithub(ctx context.Context, githubRepos []*api.Repository) []*Repository {
+	repos := make([]*Repository, 0, len(githubRepos))
+	for _, githubRepo := range githubRepos {
+		if githubRepo.FullName == nil {
+			slog.WarnContext(ctx, "excluding GitHub repository without full_name", "id", githubRepo.GetID())
+			continue
 		}
-		opts.ListOptions.Page = response.NextPage
+
+		if githubRepo.SSHURL == nil {
+			slog.WarnContext(ctx, "excluding GitHub repository without ssh_url", "repo", githubRepo.GetFullName())
+			continue
+		}
+
+		repo := &Repository{
+			FullName: *githubRepo.FullName,
+			Master:   "master",
+		}
+
+		if githubRepo.Description != nil {
+			repo.Description = *githubRepo.Description
+		}
+
+		if githubRepo.Private != nil {
+			repo.Private = *githubRepo.Private
+		}
+
+		if githubRepo.DefaultBranch != nil {
+			repo.Master = *githubRepo.DefaultBranch
+		}
+
+		if githubRepo.HTMLURL != nil {
+			repo.HTMLURL = *githubRepo.HTMLURL
+		}
+
+		if githubRepo.PushedAt != nil {
+			repo.LastPushAt = githubRepo.PushedAt.Time
+		}
+
+		repos = append(repos, repo)
 	}
 
-	return allRepos, nil
+	return repos
 }
 
 // Get retireves GitHub repositories details and returns an instance of Repository containing last commit information.
-func (service *GithubRepositories) Get(ctx context.Context, fullName string) (*Repository, error) {
-	repoOwner, repoName := ParseRepositoryName(fullName)
+func (service *GithubRepositories) Get(ctx context.Context, name RepositoryName) (*Repository, error) {
+	repoOwner, repoName := name.Owner(), name.Repo()
 
 	githubRepo, response, err := service.client.Repositories.Get(ctx, repoOwner, repoName)
 	if err != nil {
@@ -141,10 +213,76 @@ func (service *GithubRepositories) Get(ctx context.Context, fullName string) (*R
 	return repo, nil
 }
 
+// SetWebhookSecret makes GithubRepositories configure push webhooks to sign their payloads with secret.
+func (service *GithubRepositories) SetWebhookSecret(secret string) {
+	service.webhookSecret = secret
+}
+
+// EnableAudit makes GithubRepositories record webhooks it sets up in log.
+func (service *GithubRepositories) EnableAudit(log *audit.Log) {
+	service.audit = log
+}
+
+// EnableNotifications makes GithubRepositories report webhooks that could not be set up to monitor.
+func (service *GithubRepositories) EnableNotifications(monitor *notify.Monitor) {
+	service.monitor = monitor
+}
+
 // Track sets up "push" event GitHub webhook to be sent to callbackURL.
-func (service *GithubRepositories) Track(ctx context.Context, fullName, callbackURL string) error {
-	owner, name := ParseRepositoryName(fullName)
-	return service.registerPushWebhook(ctx, owner, name, callbackURL)
+func (service *GithubRepositories) Track(ctx context.Context, fullName, callbackURL string) (err error) {
+	defer func() {
+		service.audit.Record(ctx, actionTrack, fullName, map[string]string{"callback_url": callbackURL}, err)
+		service.monitor.TrackFinished(ctx, fullName, err)
+	}()
+
+	name, err := ParseRepositoryName(fullName)
+	if err != nil {
+		return err
+	}
+
+	return service.registerPushWebhook(ctx, name.Owner(), name.Repo(), callbackURL)
+}
+
+// RateLimit returns GitHub API rate limit status as reported by the latest response.
+func (service *GithubRepositories) RateLimit() RateLimit {
+	if service.rateLimits == nil {
+		return RateLimit{}
+	}
+
+	return service.rateLimits.RateLimit()
+}
+
+// CheckToken verifies that GitHub API token is valid and has been given either "repo" or "public_repo" scope.
+// Tokens that do not report their scopes, such as fine-grained personal access tokens, are only checked
+// for validity.
+func (service *GithubRepositories) CheckToken(ctx context.Context) error {
+	_, response, err := service.client.Users.Get(ctx, "")
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusUnauthorized {
+			return ErrorInvalidToken
+		}
+
+		return err
+	}
+
+	header, ok := response.Header["X-Oauth-Scopes"]
+	if !ok {
+		return nil
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(strings.Join(header, ","), ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "repo" || scope == "public_repo" {
+			return nil
+		}
+
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return fmt.Errorf("GitHub token lacks repo or public_repo scope (granted: %s)", strings.Join(scopes, ", "))
 }
 
 func (service *GithubRepositories) registerPushWebhook(ctx context.Context, owner, repo, cbURL string) error {
@@ -160,6 +298,10 @@ func (service *GithubRepositories) registerPushWebhook(ctx context.Context, owne
 	*hook.Name = "web"
 	*hook.Active = true
 
+	if service.webhookSecret != "" {
+		hook.Config["secret"] = service.webhookSecret
+	}
+
 	_, _, err := service.client.Repositories.CreateHook(ctx, owner, repo, hook)
 	if err != nil {
 		errorResponse, ok := err.(*api.ErrorResponse)
@@ -172,7 +314,7 @@ func (service *GithubRepositories) registerPushWebhook(ctx context.Context, owne
 		}
 
 		if service.checkPushWebhookExists(ctx, owner, repo, cbURL) {
-			log.Printf("push webhook to %s for %s/%s has already been set up", cbURL, owner, repo)
+			slog.InfoContext(ctx, "push webhook has already been set up", "repo", owner+"/"+repo, "url", cbURL)
 			return nil
 		}
 	}
@@ -188,7 +330,7 @@ func (service *GithubRepositories) checkPushWebhookExists(ctx context.Context, o
 	for {
 		hooks, response, err := service.client.Repositories.ListHooks(ctx, owner, repo, opts)
 		if err != nil {
-			log.Printf("[WARN] failed to get %s/%s webhooks: %s", owner, repo, err)
+			slog.WarnContext(ctx, "failed to get webhooks", "repo", owner+"/"+repo, "error", err)
 			return false
 		}
 
@@ -214,12 +356,6 @@ func (service *GithubRepositories) checkPushWebhookExists(ctx context.Context, o
 	return false
 }
 
-// ParseRepositoryName returns owner and project name for given GitHub repository.
-func ParseRepositoryName(fullName string) (string, string) {
-	fields := strings.SplitN(fullName, "/", 2)
-	return fields[0], fields[1]
-}
-
 func repositoryFromGithub(githubRepo *api.Repository) *Repository {
 	repo := &Repository{
 		FullName:    *githubRepo.FullName,
@@ -232,6 +368,7 @@ func repositoryFromGithub(githubRepo *api.Repository) *Repository {
 	// Use git+ssh to clone private repos
 	if githubRepo.Private != nil && *githubRepo.Private {
 		repo.GitURL = *githubRepo.SSHURL
+		repo.Private = true
 	}
 
 	return repo
@@ -19,12 +19,6 @@ import (
 	"golang.org/x/net/context"
 )
 
-func TestParseRepositoryName(t *testing.T) {
-	owner, repo := git.ParseRepositoryName("test/me")
-	assert.Equal(t, owner, "test")
-	assert.Equal(t, repo, "me")
-}
-
 func TestNewGithubRepositories_WithToken(t *testing.T) {
 	ctx := context.WithValue(context.Background(), git.GithubToken, "secret_token")
 
@@ -200,6 +194,64 @@ func TestGithubRepositoriesAll_HandlePagination(t *testing.T) {
 	assert.Len(t, repos, 2)
 }
 
+func TestGithubRepositoriesQuery_Page(t *testing.T) {
+	ctx, mux, teardown := setup()
+	defer teardown()
+
+	var requestedPages []string
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "pushed", r.FormValue("sort"))
+		assert.Equal(t, "desc", r.FormValue("direction"))
+		assert.Equal(t, "2", r.FormValue("per_page"))
+
+		requestedPages = append(requestedPages, r.FormValue("page"))
+		switch r.FormValue("page") {
+		case "2":
+			w.Header().Set("Link", `<https://api.github.com/user/repos?page=4&per_page=2>; rel="last"`)
+			fmt.Fprint(w, `[{"full_name": "user1/repo3","ssh_url": "git@github.com:user1/repo3.git"},{"full_name": "user2/repo4","ssh_url": "git@github.com:user2/repo4.git"}]`)
+		case "4":
+			fmt.Fprint(w, `[{"full_name": "user1/repo7","ssh_url": "git@github.com:user1/repo7.git"}]`)
+		default:
+			t.Errorf("unexpected page %s requested", r.FormValue("page"))
+		}
+	})
+
+	githubRepos, err := git.NewGithubRepositories(ctx)
+	require.NoError(t, err)
+
+	page, err := githubRepos.Query(context.Background(), git.RepositoryQuery{Sort: git.SortByLastCommit, Page: 2, PerPage: 2})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"2", "4"}, requestedPages)
+	if assert.Len(t, page.Repositories, 2) {
+		assert.Equal(t, "user1/repo3", page.Repositories[0].FullName)
+		assert.Equal(t, "user2/repo4", page.Repositories[1].FullName)
+	}
+	assert.Equal(t, 7, page.Total)
+}
+
+func TestGithubRepositoriesQuery_Filter(t *testing.T) {
+	ctx, mux, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.FormValue("sort"), "filtered list should be fetched in full")
+		fmt.Fprint(w, `[{"full_name": "user1/repo1","ssh_url": "git@github.com:user1/repo1.git"},{"full_name": "user2/repo2","ssh_url": "git@github.com:user2/repo2.git"}]`)
+	})
+
+	githubRepos, err := git.NewGithubRepositories(ctx)
+	require.NoError(t, err)
+
+	page, err := githubRepos.Query(context.Background(), git.RepositoryQuery{Owner: "user2", Page: 1, PerPage: 10})
+	require.NoError(t, err)
+
+	if assert.Len(t, page.Repositories, 1) {
+		assert.Equal(t, "user2/repo2", page.Repositories[0].FullName)
+	}
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, []string{"user1", "user2"}, page.Owners)
+}
+
 func TestGithubRepositoriesGet_RepositoryExists_PublicRepo(t *testing.T) {
 	ctx, mux, teardown := setup()
 	defer teardown()
@@ -292,6 +344,7 @@ func TestGithubRepositoriesGet_RepositoryExists_PrivateRepo(t *testing.T) {
 
 	assert.Equal(t, repo.FullName, "user1/repo1")
 	assert.Equal(t, repo.GitURL, "git@github.com:user1/repo1.git")
+	assert.True(t, repo.Private)
 }
 
 func TestGithubRepositoriesGet_NotFound(t *testing.T) {
@@ -333,6 +386,26 @@ func TestGithubRepositoriesTrack(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestGithubRepositoriesTrack_WebhookSecret(t *testing.T) {
+	ctx, mux, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/repos/user1/repo1/hooks", func(w http.ResponseWriter, r *http.Request) {
+		var hook github.Hook
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&hook))
+
+		assert.Equal(t, "s3cr3t", hook.Config["secret"])
+
+		fmt.Fprint(w, `{"id":1}`)
+	})
+
+	githubRepos, err := git.NewGithubRepositories(ctx)
+	require.NoError(t, err)
+	githubRepos.SetWebhookSecret("s3cr3t")
+
+	require.NoError(t, githubRepos.Track(context.Background(), "user1/repo1", "http://example.com/cb"))
+}
+
 func setup() (ctx context.Context, mux *http.ServeMux, teardownFn func()) {
 	mux = http.NewServeMux()
 	server := httptest.NewServer(mux)
@@ -347,3 +420,36 @@ func setup() (ctx context.Context, mux *http.ServeMux, teardownFn func()) {
 
 	return ctx, mux, server.Close
 }
+
+func TestGithubRepositories_CheckToken(t *testing.T) {
+	ctx, mux, teardown := setup()
+	defer teardown()
+
+	scopes := "repo, read:org"
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", scopes)
+		fmt.Fprint(w, `{"login": "user1"}`)
+	})
+
+	githubRepos, err := git.NewGithubRepositories(ctx)
+	require.NoError(t, err)
+
+	assert.NoError(t, githubRepos.CheckToken(ctx))
+
+	scopes = "read:org"
+	assert.Error(t, githubRepos.CheckToken(ctx))
+}
+
+func TestGithubRepositories_CheckToken_Unauthorized(t *testing.T) {
+	ctx, mux, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+	})
+
+	githubRepos, err := git.NewGithubRepositories(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, git.ErrorInvalidToken, githubRepos.CheckToken(ctx))
+}
@@ -0,0 +1,41 @@
+package lfs
+
+// MediaType is the content type used by Git LFS API requests and responses.
+const MediaType = "application/vnd.git-lfs+json"
+
+// BatchRequest is a request body sent to Git LFS batch API endpoint.
+//
+// For more details on batch API see https://github.com/git-lfs/git-lfs/blob/master/docs/api/batch.md.
+type BatchRequest struct {
+	Operation string    `json:"operation"`
+	Transfers []string  `json:"transfers,omitempty"`
+	Objects   []Pointer `json:"objects"`
+}
+
+// BatchResponse is a response returned by Git LFS batch API endpoint.
+type BatchResponse struct {
+	Transfer string        `json:"transfer,omitempty"`
+	Objects  []BatchObject `json:"objects"`
+	Message  string        `json:"message,omitempty"`
+}
+
+// BatchObject describes actions that can be performed with an object.
+type BatchObject struct {
+	Pointer
+	Authenticated bool               `json:"authenticated,omitempty"`
+	Actions       map[string]*Action `json:"actions,omitempty"`
+	Error         *ObjectError       `json:"error,omitempty"`
+}
+
+// Action is a hypermedia link used to transfer an object.
+type Action struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header,omitempty"`
+	ExpiresIn int               `json:"expires_in,omitempty"`
+}
+
+// ObjectError is returned for each object that cannot be transferred.
+type ObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,198 @@
+package lfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	// batchSize is the maximum number of objects requested within a single batch API call.
+	batchSize = 100
+	// GitHubHost is the host that serves LFS API of GitHub repositories.
+	GitHubHost = "github.com"
+)
+
+// Client downloads Git LFS objects from a remote LFS server using batch API and basic transfer adapter.
+type Client struct {
+	httpClient  *http.Client
+	credentials map[string]string
+}
+
+// NewClient returns an instance of Client that uses httpClient to perform requests. LFS API is requested
+// without authentication unless a credential has been set for its host with SetCredential.
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		httpClient:  httpClient,
+		credentials: make(map[string]string),
+	}
+}
+
+// SetCredential makes Client send token as a password with HTTP basic auth to LFS API served over HTTPS
+// from host, which is how GitHub authenticates LFS requests for private repositories. Endpoints on other
+// hosts, as well as plain HTTP ones, never receive the token, since they are derived from mirror remote URLs
+// that might point anywhere.
+func (c *Client) SetCredential(host, token string) {
+	c.credentials[strings.ToLower(host)] = token
+}
+
+// Fetch downloads objects referenced by pointers from LFS server at endpoint into store. Objects that
+// are already present in the store are skipped.
+func (c *Client) Fetch(ctx context.Context, endpoint string, pointers []Pointer, store *Store) error {
+	var missing []Pointer
+	for _, p := range pointers {
+		if !store.Exists(p) {
+			missing = append(missing, p)
+		}
+	}
+
+	for len(missing) > 0 {
+		n := batchSize
+		if n > len(missing) {
+			n = len(missing)
+		}
+
+		if err := c.fetchBatch(ctx, endpoint, missing[:n], store); err != nil {
+			return err
+		}
+		missing = missing[n:]
+	}
+
+	return nil
+}
+
+func (c *Client) fetchBatch(ctx context.Context, endpoint string, pointers []Pointer, store *Store) error {
+	body, err := json.Marshal(BatchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   pointers,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", MediaType)
+	req.Header.Set("Content-Type", MediaType)
+	if token := c.credential(req.URL); token != "" {
+		req.SetBasicAuth("x-access-token", token)
+	}
+
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("LFS batch request to %s failed: %s", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("LFS batch request to %s returned %s (%s)", endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var batch BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return fmt.Errorf("failed to parse LFS batch response from %s: %s", endpoint, err)
+	}
+
+	for _, obj := range batch.Objects {
+		if obj.Error != nil {
+			return fmt.Errorf("failed to fetch LFS object %s: %s (%d)", obj.OID, obj.Error.Message, obj.Error.Code)
+		}
+
+		action, ok := obj.Actions["download"]
+		if !ok {
+			// No download action means that server already has this object
+			// and there is nothing to be done about it.
+			continue
+		}
+
+		if err := c.download(ctx, obj.Pointer, action, store); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// credential returns the token to authenticate requests to u with.
+func (c *Client) credential(u *url.URL) string {
+	if u.Scheme != "https" {
+		return ""
+	}
+
+	return c.credentials[strings.ToLower(u.Hostname())]
+}
+
+func (c *Client) download(ctx context.Context, p Pointer, action *Action, store *Store) error {
+	req, err := http.NewRequest("GET", action.Href, nil)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return fmt.Errorf("failed to download LFS object %s: %s", p.OID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download LFS object %s: %s", p.OID, resp.Status)
+	}
+
+	return store.Put(p, resp.Body)
+}
+
+// EndpointFromGitURL returns Git LFS server URL for a repository cloned from gitURL following the
+// rules used by git-lfs: SSH and git:// remotes are served over HTTPS from the same host, and the
+// endpoint is located at <repo>.git/info/lfs.
+func EndpointFromGitURL(gitURL string) (string, error) {
+	var baseURL, path string
+
+	if u, err := url.Parse(gitURL); err == nil && u.Scheme != "" && u.Host != "" {
+		switch u.Scheme {
+		case "http", "https":
+			baseURL = u.Scheme + "://" + u.Host
+		default:
+			baseURL = "https://" + u.Hostname()
+		}
+		path = u.Path
+	} else if i := strings.Index(gitURL, ":"); i > 0 && !strings.Contains(gitURL[:i], "/") {
+		// scp-like syntax, i.e. git@github.com:owner/repo.git
+		host := gitURL[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		baseURL, path = "https://"+host, "/"+gitURL[i+1:]
+	} else {
+		return "", fmt.Errorf("unsupported git URL %q", gitURL)
+	}
+
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return "", fmt.Errorf("unsupported git URL %q", gitURL)
+	}
+
+	if !strings.HasSuffix(path, ".git") {
+		path += ".git"
+	}
+
+	return baseURL + path + "/info/lfs", nil
+}
@@ -0,0 +1,135 @@
+package lfs_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestClient_Fetch(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	existing, missing := pointerFor("existing object"), pointerFor("missing object")
+	require.NoError(t, store.Put(existing, stringReader("existing object")))
+
+	mux := http.NewServeMux()
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/user1/repo1.git/info/lfs/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if assert.True(t, ok) {
+			assert.Equal(t, "x-access-token", user)
+			assert.Equal(t, "secret_token", password)
+		}
+		assert.Equal(t, lfs.MediaType, r.Header.Get("Accept"))
+
+		var req lfs.BatchRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []lfs.Pointer{missing}, req.Objects, "should only request missing objects")
+
+		w.Header().Set("Content-Type", lfs.MediaType)
+		json.NewEncoder(w).Encode(lfs.BatchResponse{
+			Objects: []lfs.BatchObject{{
+				Pointer: missing,
+				Actions: map[string]*lfs.Action{
+					"download": {
+						Href:   srv.URL + "/objects/" + missing.OID,
+						Header: map[string]string{"X-Signature": "signed"},
+					},
+				},
+			}},
+		})
+	})
+	mux.HandleFunc("/objects/"+missing.OID, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "signed", r.Header.Get("X-Signature"))
+		fmt.Fprint(w, "missing object")
+	})
+
+	client := lfs.NewClient(srv.Client())
+	client.SetCredential(srv.Listener.Addr().(*net.TCPAddr).IP.String(), "secret_token")
+	require.NoError(t, client.Fetch(context.Background(), srv.URL+"/user1/repo1.git/info/lfs", []lfs.Pointer{existing, missing}, store))
+
+	assert.True(t, store.Exists(missing))
+}
+
+func TestClient_Fetch_ObjectError(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	p := pointerFor("object")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(lfs.BatchResponse{
+			Objects: []lfs.BatchObject{{
+				Pointer: p,
+				Error:   &lfs.ObjectError{Code: 404, Message: "Object does not exist"},
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	client := lfs.NewClient(srv.Client())
+	assert.Error(t, client.Fetch(context.Background(), srv.URL, []lfs.Pointer{p}, store))
+	assert.False(t, store.Exists(p))
+}
+
+func TestClient_Fetch_CredentialHost(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	p := pointerFor("object")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		assert.False(t, ok, "the token should not be sent to %s", r.Host)
+
+		json.NewEncoder(w).Encode(lfs.BatchResponse{
+			Objects: []lfs.BatchObject{{Pointer: p}},
+		})
+	})
+
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	client := lfs.NewClient(tlsSrv.Client())
+	client.SetCredential(lfs.GitHubHost, "secret_token")
+	require.NoError(t, client.Fetch(context.Background(), tlsSrv.URL, []lfs.Pointer{p}, store))
+
+	// Credential of a host is not sent over plain HTTP
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client = lfs.NewClient(srv.Client())
+	client.SetCredential(srv.Listener.Addr().(*net.TCPAddr).IP.String(), "secret_token")
+	require.NoError(t, client.Fetch(context.Background(), srv.URL, []lfs.Pointer{p}, store))
+}
+
+func TestEndpointFromGitURL(t *testing.T) {
+	for gitURL, expected := range map[string]string{
+		"git@github.com:user1/repo1.git":       "https://github.com/user1/repo1.git/info/lfs",
+		"git://github.com/user1/repo1.git":     "https://github.com/user1/repo1.git/info/lfs",
+		"ssh://git@github.com/user1/repo1.git": "https://github.com/user1/repo1.git/info/lfs",
+		"https://github.com/user1/repo1":       "https://github.com/user1/repo1.git/info/lfs",
+		"http://localhost:8081/user1/repo1/":   "http://localhost:8081/user1/repo1.git/info/lfs",
+	} {
+		endpoint, err := lfs.EndpointFromGitURL(gitURL)
+		if assert.NoError(t, err, gitURL) {
+			assert.Equal(t, expected, endpoint, gitURL)
+		}
+	}
+}
+
+func TestEndpointFromGitURL_Unsupported(t *testing.T) {
+	_, err := lfs.EndpointFromGitURL("/var/mirrors/user1/repo1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package lfs
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// MaxPointerSize is the maximum size of an LFS pointer file. Git blobs that are larger than
+// this are never considered to be pointers.
+const MaxPointerSize = 1024
+
+const pointerVersion = "https://git-lfs.github.com/spec/v1"
+
+var (
+	// ErrNotPointer is returned by ParsePointer if provided data is not a valid LFS pointer file.
+	ErrNotPointer = errors.New("not an LFS pointer")
+
+	oidPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Pointer represents a Git LFS pointer file that references an object stored outside of Git.
+type Pointer struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// ParsePointer parses the contents of a Git LFS pointer file as described in
+// https://github.com/git-lfs/git-lfs/blob/master/docs/spec.md. Only sha256 object IDs are supported.
+func ParsePointer(data []byte) (Pointer, error) {
+	var p Pointer
+
+	if len(data) > MaxPointerSize {
+		return p, ErrNotPointer
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) < 3 || string(lines[0]) != "version "+pointerVersion {
+		return p, ErrNotPointer
+	}
+
+	var hasSize bool
+	for _, line := range lines[1:] {
+		fields := bytes.SplitN(line, []byte(" "), 2)
+		if len(fields) != 2 {
+			return p, ErrNotPointer
+		}
+
+		switch key, value := string(fields[0]), string(fields[1]); key {
+		case "oid":
+			if !bytes.HasPrefix(fields[1], []byte("sha256:")) {
+				return p, ErrNotPointer
+			}
+			p.OID = value[len("sha256:"):]
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return p, ErrNotPointer
+			}
+			p.Size, hasSize = size, true
+		}
+	}
+
+	if !ValidOID(p.OID) || !hasSize {
+		return Pointer{}, ErrNotPointer
+	}
+
+	return p, nil
+}
+
+// ValidOID checks whether oid is a lowercase hex-encoded sha256 hash.
+func ValidOID(oid string) bool {
+	return oidPattern.MatchString(oid)
+}
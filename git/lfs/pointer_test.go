@@ -0,0 +1,45 @@
+package lfs_test
+
+import (
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePointer(t *testing.T) {
+	p, err := lfs.ParsePointer([]byte(`version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", p.OID)
+	assert.Equal(t, int64(12345), p.Size)
+}
+
+func TestParsePointer_ExtensionKeys(t *testing.T) {
+	p, err := lfs.ParsePointer([]byte(`version https://git-lfs.github.com/spec/v1
+ext-0-foo sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 1
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", p.OID)
+}
+
+func TestParsePointer_NotPointer(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":        "",
+		"text file":    "Hello, world!\n",
+		"no version":   "oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 1\n",
+		"no size":      "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n",
+		"invalid size": "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize -1\n",
+		"invalid oid":  "version https://git-lfs.github.com/spec/v1\noid sha256:../../../etc/passwd\nsize 1\n",
+		"sha1 oid":     "version https://git-lfs.github.com/spec/v1\noid sha1:4d7a214614ab2935c943f9e0ff69d22eadbb8f32\nsize 1\n",
+	} {
+		_, err := lfs.ParsePointer([]byte(data))
+		assert.Equal(t, lfs.ErrNotPointer, err, name)
+	}
+}
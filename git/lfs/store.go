@@ -0,0 +1,126 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrObjectNotFound is returned by Store.Open if requested object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// Store is a content-addressed storage for Git LFS objects. Objects are kept under
+// <root>/objects/<oid[0:2]>/<oid[2:4]>/<oid>, which is the same layout git-lfs uses
+// for bare repositories, so a mirror with a store rooted at <mirror>/lfs can be used
+// by git-lfs directly.
+type Store struct {
+	root string
+}
+
+// NewStore returns an instance of Store that keeps objects under root directory.
+func NewStore(root string) *Store {
+	return &Store{root: root}
+}
+
+// Exists checks whether an object referenced by p is present in the store and has the expected size.
+func (s *Store) Exists(p Pointer) bool {
+	if !ValidOID(p.OID) {
+		return false
+	}
+
+	fi, err := os.Stat(s.objectPath(p.OID))
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode().IsRegular() && fi.Size() == p.Size
+}
+
+// Open opens an object for reading. The caller is responsible for closing returned file.
+func (s *Store) Open(oid string) (*os.File, error) {
+	if !ValidOID(oid) {
+		return nil, ErrObjectNotFound
+	}
+
+	fd, err := os.Open(s.objectPath(oid))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+
+	return fd, err
+}
+
+// Put reads an object from r and stores it under p.OID. The content is verified against
+// both object ID and size before it becomes visible to readers.
+func (s *Store) Put(p Pointer, r io.Reader) error {
+	if !ValidOID(p.OID) {
+		return fmt.Errorf("invalid object id %q", p.OID)
+	}
+
+	tmpDir := filepath.Join(s.root, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %s", tmpDir, err)
+	}
+
+	tmp, err := ioutil.TempFile(tmpDir, p.OID)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %s", p.OID, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", p.OID, err)
+	}
+
+	if n != p.Size {
+		return fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", p.OID, p.Size, n)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != p.OID {
+		return fmt.Errorf("checksum mismatch for %s: got %s", p.OID, sum)
+	}
+
+	objPath := s.objectPath(p.OID)
+	if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(objPath), err)
+	}
+
+	return os.Rename(tmp.Name(), objPath)
+}
+
+// Usage returns the total size of objects kept in the store in bytes.
+func (s *Store) Usage() (int64, error) {
+	var total int64
+
+	err := filepath.Walk(filepath.Join(s.root, "objects"), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+
+		return nil
+	})
+
+	return total, err
+}
+
+func (s *Store) objectPath(oid string) string {
+	return filepath.Join(s.root, "objects", oid[0:2], oid[2:4], oid)
+}
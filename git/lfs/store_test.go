@@ -0,0 +1,90 @@
+package lfs_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_PutAndOpen(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	p := pointerFor("hello, lfs")
+	require.NoError(t, store.Put(p, strings.NewReader("hello, lfs")))
+	assert.True(t, store.Exists(p))
+
+	fd, err := store.Open(p.OID)
+	require.NoError(t, err)
+	defer fd.Close()
+
+	content, err := ioutil.ReadAll(fd)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, lfs", string(content))
+
+	usage, err := store.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, p.Size, usage)
+}
+
+func TestStore_Put_ChecksumMismatch(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	p := pointerFor("hello, lfs")
+	assert.Error(t, store.Put(p, strings.NewReader("hello, git")))
+	assert.False(t, store.Exists(p))
+}
+
+func TestStore_Put_SizeMismatch(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	p := pointerFor("hello, lfs")
+	p.Size++
+	assert.Error(t, store.Put(p, strings.NewReader("hello, lfs")))
+	assert.False(t, store.Exists(p))
+}
+
+func TestStore_Open_NotFound(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	_, err := store.Open(pointerFor("missing").OID)
+	assert.Equal(t, lfs.ErrObjectNotFound, err)
+
+	_, err = store.Open("../../../../etc/passwd")
+	assert.Equal(t, lfs.ErrObjectNotFound, err)
+}
+
+func TestStore_Usage_Empty(t *testing.T) {
+	store, teardown := setupStore(t)
+	defer teardown()
+
+	usage, err := store.Usage()
+	require.NoError(t, err)
+	assert.Zero(t, usage)
+}
+
+func setupStore(t *testing.T) (*lfs.Store, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "doppelganger-lfs")
+	require.NoError(t, err)
+
+	return lfs.NewStore(dir), func() { os.RemoveAll(dir) }
+}
+
+func pointerFor(content string) lfs.Pointer {
+	h := sha256.Sum256([]byte(content))
+	return lfs.Pointer{OID: hex.EncodeToString(h[:]), Size: int64(len(content))}
+}
+
+func stringReader(s string) *strings.Reader {
+	return strings.NewReader(s)
+}
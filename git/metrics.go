@@ -0,0 +1,167 @@
+package git
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+var (
+	gitCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "doppelganger_git_command_duration_seconds",
+		Help:    "Duration of git subprocesses by git command and result.",
+		Buckets: durationBuckets,
+	}, []string{"command", "result"})
+	mirrorSyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "doppelganger_mirror_sync_duration_seconds",
+		Help:    "Duration of mirror synchronizations by repository and operation.",
+		Buckets: durationBuckets,
+	}, []string{"repo", "operation"})
+	mirrorSyncsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "doppelganger_mirror_syncs_total",
+		Help: "Number of mirror synchronizations by repository, operation and result.",
+	}, []string{"repo", "operation", "result"})
+	githubRateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "doppelganger_github_rate_limit",
+		Help: "The maximum number of GitHub API requests per hour as reported by the latest response.",
+	})
+	githubRateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "doppelganger_github_rate_limit_remaining",
+		Help: "The number of GitHub API requests remaining in the current rate limit window as reported by the latest response.",
+	})
+
+	mirrorsDesc = prometheus.NewDesc(
+		"doppelganger_mirrors",
+		"Number of mirrored repositories.",
+		nil, nil,
+	)
+	mirrorLastSyncAgeDesc = prometheus.NewDesc(
+		"doppelganger_mirror_last_sync_age_seconds",
+		"Time since the latest successful synchronization of a mirror.",
+		[]string{"repo"}, nil,
+	)
+)
+
+// durationBuckets are histogram buckets for git commands and mirror synchronizations that may take minutes.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+func init() {
+	prometheus.MustRegister(gitCommandDuration, mirrorSyncDuration, mirrorSyncsTotal, githubRateLimit, githubRateLimitRemaining)
+}
+
+// MirrorMetrics is a prometheus.Collector reporting the number of mirrors maintained by a service and the number
+// of seconds passed since the latest successful synchronization of each mirror.
+type MirrorMetrics struct {
+	service *MirroredRepositories
+}
+
+// NewMirrorMetrics returns a collector of mirror metrics of service.
+func NewMirrorMetrics(service *MirroredRepositories) *MirrorMetrics {
+	return &MirrorMetrics{service: service}
+}
+
+// RegisterMirrorMetrics registers mirror metrics of service with the default Prometheus registry.
+func RegisterMirrorMetrics(service *MirroredRepositories) {
+	prometheus.MustRegister(NewMirrorMetrics(service))
+}
+
+// Describe implements prometheus.Collector.
+func (m *MirrorMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mirrorsDesc
+	ch <- mirrorLastSyncAgeDesc
+}
+
+// Collect implements prometheus.Collector. The mirror directory is walked once per scrape.
+func (m *MirrorMetrics) Collect(ch chan<- prometheus.Metric) {
+	times, err := m.service.LastSyncTimes(context.Background())
+	if err != nil {
+		slog.Warn("failed to list mirrors", "error", err)
+		ch <- prometheus.NewInvalidMetric(mirrorsDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(mirrorsDesc, prometheus.GaugeValue, float64(len(times)))
+	for name, t := range times {
+		if t.IsZero() {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(mirrorLastSyncAgeDesc, prometheus.GaugeValue, time.Since(t).Seconds(), name)
+	}
+}
+
+// recordSync updates mirror synchronization metrics, reports the outcome to monitor and stores the time of successful synchronization
+// or the start of failures in mirror configuration.
+func (service *MirroredRepositories) recordSync(ctx context.Context, name RepositoryName, operation string, startTime time.Time, err error) {
+	fullName := name.String()
+	mirrorSyncDuration.WithLabelValues(fullName, operation).Observe(time.Since(startTime).Seconds())
+	service.monitor.SyncFinished(ctx, fullName, err)
+
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	mirrorSyncsTotal.WithLabelValues(fullName, operation, outcome).Inc()
+
+	fullPath, pathErr := name.ResolvePath(service.mirrorPath)
+	if pathErr != nil {
+		return
+	}
+
+	// The start of failures is kept until the mirror recovers, so that it survives restarts
+	if err != nil {
+		// Interrupted syncs are not failures, and a failed clone leaves nothing to store the outcome in
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, statErr := os.Stat(fullPath); statErr != nil {
+			return
+		}
+
+		if !service.isFailing(ctx, fullPath) {
+			if err := service.cmd.SetConfig(ctx, fullPath, FailingSinceConfigKey, startTime.UTC().Format(time.RFC3339)); err != nil {
+				slog.WarnContext(ctx, "failed to store the time of failed sync", "repo", fullName, "error", err)
+			}
+		}
+
+		return
+	}
+
+	if service.isFailing(ctx, fullPath) {
+		if err := service.cmd.SetConfig(ctx, fullPath, FailingSinceConfigKey, ""); err != nil {
+			slog.WarnContext(ctx, "failed to reset the time of failed sync", "repo", fullName, "error", err)
+		}
+	}
+
+	if err := service.cmd.SetConfig(ctx, fullPath, LastSyncAtConfigKey, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		slog.WarnContext(ctx, "failed to store the time of last sync", "repo", fullName, "error", err)
+	}
+}
+
+// isFailing returns whether the latest synchronization of a mirror at fullPath has failed.
+func (service *MirroredRepositories) isFailing(ctx context.Context, fullPath string) bool {
+	values := service.cmd.ConfigValues(ctx, fullPath, FailingSinceConfigKey)
+
+	return len(values) > 0 && values[len(values)-1] != ""
+}
+
+// gitCommandName returns the name of git subcommand skipping global options, i.e. "push" for
+// `git -c core.sshCommand=... push --mirror`.
+func gitCommandName(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c" || args[i] == "-C":
+			i++
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			return args[i]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,64 @@
+package git_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorMetrics(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	lastSyncAt := map[string]string{
+		"acme/api": time.Now().UTC().Add(-time.Hour).Format(time.RFC3339),
+		"acme/web": "",
+	}
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", mirrorsDir).Return(false)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "acme")).Return(false)
+
+	for repoName, value := range lastSyncAt {
+		path := filepath.Join(mirrorsDir, repoName)
+		os.MkdirAll(path, 0755)
+
+		cmd.On("IsRepository", path).Return(true)
+		cmd.On("CurrentBranch", path).Return("master")
+		cmd.On("LastCommit", path).Return(git.Commit{SHA: "abc123"}, nil)
+		cmd.On("ConfigEntries", path, `^(remote\.origin\.url|doppelganger\.lastsyncat|doppelganger\.failingsince)$`).Return(map[string]string{
+			"remote.origin.url":       "git@github.com:" + repoName + ".git",
+			"doppelganger.lastsyncat": value,
+		})
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(git.NewMirrorMetrics(git.NewMirroredRepositories(mirrorsDir, cmd))))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	samples := make(map[string][]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, label := range m.GetLabel() {
+				name += " " + label.GetValue()
+			}
+			samples[name] = append(samples[name], m.GetGauge().GetValue())
+		}
+	}
+
+	assert.Equal(t, []float64{2}, samples["doppelganger_mirrors"])
+	if assert.Len(t, samples["doppelganger_mirror_last_sync_age_seconds acme/api"], 1) {
+		assert.InDelta(t, time.Hour.Seconds(), samples["doppelganger_mirror_last_sync_age_seconds acme/api"][0], 60)
+	}
+	assert.NotContains(t, samples, "doppelganger_mirror_last_sync_age_seconds acme/web", "mirrors that were never synced should not be reported")
+}
@@ -0,0 +1,61 @@
+package git
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// LockDir is the directory inside of mirrorPath that holds lock files of mirrors.
+const LockDir = ".doppelganger/locks"
+
+// lockRetryInterval is the time between attempts to acquire a lock held by someone else.
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockMirror acquires an exclusive lock on a mirror, so that the server and command line tool operating on the same
+// mirror directory do not modify the same repository at once. The lock is a file under <mirrorPath>/.doppelganger/locks
+// locked with flock(2), which is released by the OS if the process holding it dies. lockMirror waits until the lock is
+// released or ctx is cancelled. The returned function releases the lock.
+func (service *MirroredRepositories) lockMirror(ctx context.Context, fullName string) (unlock func(), err error) {
+	lockPath := filepath.Join(service.mirrorPath, filepath.FromSlash(LockDir), filepath.FromSlash(fullName)+".lock")
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %s", err)
+	}
+
+	fd, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %s", err)
+	}
+
+	for waiting := false; ; waiting = true {
+		locked, err := tryLockFile(fd)
+		if err != nil {
+			fd.Close()
+			return nil, fmt.Errorf("failed to lock %s: %s", lockPath, err)
+		}
+
+		if locked {
+			break
+		}
+
+		if !waiting {
+			slog.InfoContext(ctx, "mirror is locked by another process, waiting", "repo", fullName)
+		}
+
+		select {
+		case <-ctx.Done():
+			fd.Close()
+			return nil, fmt.Errorf("failed to lock %s: %s", fullName, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	return func() {
+		unlockFile(fd)
+		fd.Close()
+	}, nil
+}
@@ -0,0 +1,61 @@
+//go:build !windows
+// +build !windows
+
+package git_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestMirroredRepositories_LockedByAnotherProcess(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := filepath.Join(mirrorsDir, "a", "b")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", mirroredRepoPath).Return(nil).Once()
+	cmd.On("IsRepository", mirroredRepoPath).Return(true)
+	cmd.On("ConfigEntries", mirroredRepoPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+
+	// Create the lock file
+	require.NoError(t, mirroredRepos.Update(context.Background(), "a/b"))
+
+	// Lock it the way another process would do
+	fd, err := os.OpenFile(filepath.Join(mirrorsDir, filepath.FromSlash(git.LockDir), "a", "b.lock"), os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer fd.Close()
+
+	lockFd := int(fd.Fd())
+	require.NoError(t, syscall.Flock(lockFd, syscall.LOCK_EX|syscall.LOCK_NB))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	assert.Error(t, mirroredRepos.Update(ctx, "a/b"))
+	cmd.AssertNumberOfCalls(t, "UpdateRemote", 1)
+
+	// Update waits for the lock to be released
+	cmd.On("UpdateRemote", mirroredRepoPath).Return(nil).Once()
+	time.AfterFunc(200*time.Millisecond, func() {
+		syscall.Flock(lockFd, syscall.LOCK_UN)
+	})
+
+	assert.NoError(t, mirroredRepos.Update(context.Background(), "a/b"))
+	cmd.AssertNumberOfCalls(t, "UpdateRemote", 2)
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package git
+
+import (
+	"os"
+	"syscall"
+)
+
+func tryLockFile(fd *os.File) (bool, error) {
+	err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFile(fd *os.File) error {
+	return syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+}
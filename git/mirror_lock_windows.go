@@ -0,0 +1,23 @@
+package git
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows does not support flock(2), mirrors are locked with LockFileEx instead. The lock covers the first byte
+// of the lock file, which is enough since all processes lock the same range.
+
+func tryLockFile(fd *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(fd.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, new(windows.Overlapped))
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFile(fd *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(fd.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
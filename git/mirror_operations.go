@@ -0,0 +1,108 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrorShuttingDown is an error returned by methods of MirroredRepositories that modify mirrors once Shutdown
+// has been called.
+var ErrorShuttingDown = errors.New("shutting down")
+
+// mirrorOperations keeps track of running operations that modify mirrors.
+type mirrorOperations struct {
+	mu           sync.Mutex
+	wg           sync.WaitGroup
+	running      map[*mirrorOperation]struct{}
+	shuttingDown bool
+}
+
+type mirrorOperation struct {
+	fullName  string
+	name      string
+	startTime time.Time
+	cancel    context.CancelFunc
+}
+
+func (op *mirrorOperation) String() string {
+	return fmt.Sprintf("%s of %s running for %s", op.name, op.fullName, time.Since(op.startTime).Truncate(time.Second))
+}
+
+// startOperation registers an operation on mirror, so that Shutdown waits for it to finish. The returned context
+// is cancelled if the operation is still running when Shutdown stops waiting. The returned function must be called
+// once the operation is complete.
+func (service *MirroredRepositories) startOperation(ctx context.Context, fullName, name string) (context.Context, func(), error) {
+	ops := &service.ops
+
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+
+	if ops.shuttingDown {
+		return nil, nil, ErrorShuttingDown
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	op := &mirrorOperation{
+		fullName:  fullName,
+		name:      name,
+		startTime: time.Now(),
+		cancel:    cancel,
+	}
+
+	if ops.running == nil {
+		ops.running = make(map[*mirrorOperation]struct{})
+	}
+	ops.running[op] = struct{}{}
+	ops.wg.Add(1)
+
+	return ctx, func() {
+		ops.mu.Lock()
+		delete(ops.running, op)
+		ops.mu.Unlock()
+
+		cancel()
+		ops.wg.Done()
+	}, nil
+}
+
+// Shutdown makes MirroredRepositories reject new operations that modify mirrors with ErrorShuttingDown and waits
+// for running ones to finish. Operations that are still running when ctx is done are cancelled, which terminates
+// their git processes and removes partially cloned mirrors. Shutdown returns once all operations have returned and
+// reports those that have been interrupted.
+func (service *MirroredRepositories) Shutdown(ctx context.Context) (interrupted []string) {
+	ops := &service.ops
+
+	ops.mu.Lock()
+	ops.shuttingDown = true
+	ops.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ops.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	ops.mu.Lock()
+	for op := range ops.running {
+		interrupted = append(interrupted, op.String())
+		op.cancel()
+	}
+	ops.mu.Unlock()
+	sort.Strings(interrupted)
+
+	// Cancelled operations clean up after themselves before returning
+	<-done
+
+	return interrupted
+}
@@ -0,0 +1,92 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// slowCloneCommand is a git command that clones repositories until its context is cancelled.
+type slowCloneCommand struct {
+	*commandMock
+	started chan struct{}
+}
+
+func (cmd slowCloneCommand) CloneMirror(ctx context.Context, gitURL, fullPath string) error {
+	fakeClone(mock.Arguments{gitURL, fullPath})
+	close(cmd.started)
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestMirroredRepositories_Shutdown(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := filepath.Join(mirrorsDir, "a", "b")
+
+	cmd := slowCloneCommand{&commandMock{}, make(chan struct{})}
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- mirroredRepos.Create(context.Background(), "a/b", "git@doppelganger:a/b")
+	}()
+	<-cmd.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	interrupted := mirroredRepos.Shutdown(ctx)
+	if assert.Len(t, interrupted, 1) {
+		assert.Contains(t, interrupted[0], "create of a/b")
+	}
+	assert.Error(t, <-errs)
+
+	// Partial clone is removed
+	_, err = os.Stat(mirroredRepoPath)
+	assert.True(t, os.IsNotExist(err))
+
+	entries, err := ioutil.ReadDir(filepath.Join(mirrorsDir, "a"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// New operations are rejected
+	assert.Equal(t, git.ErrorShuttingDown, mirroredRepos.Update(context.Background(), "a/b"))
+	assert.Equal(t, git.ErrorShuttingDown, mirroredRepos.Create(context.Background(), "a/b", "git@doppelganger:a/b"))
+	assert.Equal(t, git.ErrorShuttingDown, mirroredRepos.Remove(context.Background(), "a/b"))
+
+	cmd.AssertExpectations(t)
+}
+
+func TestMirroredRepositories_Shutdown_Idle(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", filepath.Join(mirrorsDir, "a", "b")).Return(nil)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "a", "b")).Return(true)
+	cmd.On("ConfigEntries", filepath.Join(mirrorsDir, "a", "b"), `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", filepath.Join(mirrorsDir, "a", "b"), git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", filepath.Join(mirrorsDir, "a", "b"), git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	require.NoError(t, mirroredRepos.Update(context.Background(), "a/b"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.Empty(t, mirroredRepos.Shutdown(ctx))
+	cmd.AssertExpectations(t)
+}
@@ -4,24 +4,69 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/andrewslotin/doppelganger/audit"
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/andrewslotin/doppelganger/notify"
 	"golang.org/x/net/context"
 )
 
-// DefaultMaster is a default name for master branch.
-const DefaultMaster = "master"
+const (
+	// DefaultMaster is a default name for master branch.
+	DefaultMaster = "master"
+	// TrackedBranchConfigKey is a mirror configuration variable that lists branches besides master that are
+	// inspected for submodules. To add a branch run `git config --add doppelganger.trackedBranch <name>`
+	// inside of mirror directory.
+	TrackedBranchConfigKey = "doppelganger.trackedBranch"
+	// SourceURLConfigKey is a mirror configuration variable that holds the URL of original repository for
+	// mirrors that have been cloned from another mirror.
+	SourceURLConfigKey = "doppelganger.sourceURL"
+	// LastSyncAtConfigKey is a mirror configuration variable that holds the time of the latest successful
+	// synchronization in RFC3339 format.
+	LastSyncAtConfigKey = "doppelganger.lastSyncAt"
+	// FailingSinceConfigKey is a mirror configuration variable that holds the time of the first failed
+	// synchronization since the latest successful one in RFC3339 format.
+	FailingSinceConfigKey = "doppelganger.failingSince"
+
+	// repositoryConfigPattern matches configuration variables read by repositoryFromDir. Git reports variable
+	// names in lowercase.
+	repositoryConfigPattern = `^(remote\.origin\.url|doppelganger\.lastsyncat|doppelganger\.failingsince)$`
+)
+
+// cloneTempDirPrefix is the prefix of temporary directories mirrors are cloned into. It's created next
+// to the mirror directory to make sure that both reside on the same file system.
+const cloneTempDirPrefix = ".doppelganger-clone-"
 
 // ErrorNotMirrored is an error returned by Get if given repository does not exist.
 var ErrorNotMirrored = errors.New("mirror not found")
 
+// Actions recorded to audit log by MirroredRepositories.
+var (
+	actionCreate           = audit.Action("create")
+	actionUpdate           = audit.Action("update")
+	actionRemove           = audit.Action("remove")
+	actionSetSourceURL     = audit.Action("set-source-url")
+	actionUpdateFromSource = audit.Action("update-from-source")
+)
+
 // MirroredRepositories is a type that is intended for maintaining local Git repository mirrors
 // located under the mirrorPath directory.
 type MirroredRepositories struct {
 	cmd        Command
 	mirrorPath string
+	lfs        *lfs.Client
+	audit      *audit.Log
+	monitor    *notify.Monitor
+	webhooks   *hooks.Service
+	ops        mirrorOperations
+
+	localPushTargetsPath string
 }
 
 // NewMirroredRepositories creates and initializes an instance of MirroredRepositories reading and creating
@@ -33,6 +78,27 @@ func NewMirroredRepositories(path string, gitCommand Command) *MirroredRepositor
 	}
 }
 
+// EnableLFS makes MirroredRepositories fetch Git LFS objects referenced by mirrored refs using provided client
+// each time a mirror is created or updated. Objects are stored under <mirrorPath>/<fullName>/lfs.
+func (service *MirroredRepositories) EnableLFS(client *lfs.Client) {
+	service.lfs = client
+}
+
+// EnableAudit makes MirroredRepositories record operations that change mirrors in log.
+func (service *MirroredRepositories) EnableAudit(log *audit.Log) {
+	service.audit = log
+}
+
+// EnableNotifications makes MirroredRepositories report the outcome of each sync to monitor.
+func (service *MirroredRepositories) EnableNotifications(monitor *notify.Monitor) {
+	service.monitor = monitor
+}
+
+// EnableWebhooks makes MirroredRepositories send refs changed by each successful sync to outgoing webhooks.
+func (service *MirroredRepositories) EnableWebhooks(webhooks *hooks.Service) {
+	service.webhooks = webhooks
+}
+
 // All recursively searches and returns a list of repositories under mirrorPath. Unlike Get, All returns
 // only basic information about Git repository, such as its name and the name of master branch.
 func (service *MirroredRepositories) All(ctx context.Context) ([]*Repository, error) {
@@ -41,50 +107,500 @@ func (service *MirroredRepositories) All(ctx context.Context) ([]*Repository, er
 
 // Get searches for a git repository in <mirrorPath>/<fullName> and returns the name of its name, master branch
 // and lastest commit. If specified directory does not exist or not a git repository ErrorNotMirrored is returned.
-func (service *MirroredRepositories) Get(ctx context.Context, fullName string) (*Repository, error) {
-	if !service.cmd.IsRepository(ctx, service.resolveMirrorPath(fullName)) {
+func (service *MirroredRepositories) Get(ctx context.Context, name RepositoryName) (*Repository, error) {
+	fullName := name.String()
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
 		return nil, ErrorNotMirrored
 	}
 
-	repo := service.repositoryFromDir(ctx, fullName)
-	repo.LatestMasterCommit = service.commitFromDir(ctx, fullName)
+	repo := service.repositoryFromDir(ctx, fullName, fullPath)
+
+	usage, err := lfs.NewStore(filepath.Join(fullPath, "lfs")).Usage()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to calculate LFS storage usage", "repo", fullName, "error", err)
+	}
+	repo.LFSUsage = ByteSize(usage)
+	repo.Submodules = service.submoduleGraph(ctx, fullName, map[string]struct{}{fullName: {}})
+	repo.PushTargets, _ = service.PushTargets(ctx, name)
 
 	return repo, nil
 }
 
+// Exists returns true if repository fullName is mirrored.
+func (service *MirroredRepositories) Exists(ctx context.Context, fullName string) bool {
+	fullPath, err := service.resolveMirrorPath(fullName)
+	if err != nil {
+		return false
+	}
+
+	return service.cmd.IsRepository(ctx, fullPath)
+}
+
 // Create creates a local mirror of remote repository from gitURL by calling "git --mirror <gitURL> <fullName>".
-func (service *MirroredRepositories) Create(ctx context.Context, fullName, gitURL string) error {
-	fullPath := service.resolveMirrorPath(fullName)
+// The repository is cloned into a temporary directory first and moved to its place once done, so that
+// an interrupted clone neither leaves a broken mirror behind nor destroys an existing one.
+func (service *MirroredRepositories) Create(ctx context.Context, name RepositoryName, gitURL string) (err error) {
+	fullName := name.String()
+	defer func() {
+		service.audit.Record(ctx, actionCreate, fullName, map[string]string{"url": audit.RedactURL(gitURL)}, err)
+	}()
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
 
-	if _, err := os.Stat(fullPath); err == nil {
-		log.Printf("[WARN] %s already exists, removing", fullPath)
+	ctx, done, err := service.startOperation(ctx, fullName, "create")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	unlock, err := service.lockMirror(ctx, fullName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	defer func(startTime time.Time) {
+		service.recordSync(ctx, name, "create", startTime, err)
+	}(time.Now())
+
+	refsUpdated := service.watchRefs(ctx, fullName, fullPath)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(fullPath), err)
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(fullPath), cloneTempDirPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory to clone %s into: %s", fullName, err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	clonePath := filepath.Join(tmpDir, filepath.Base(fullPath))
+	if err := service.cmd.CloneMirror(ctx, gitURL, clonePath); err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(fullPath); err == nil {
+		slog.WarnContext(ctx, "mirror already exists, removing", "repo", fullName, "path", fullPath)
 		if err := os.RemoveAll(fullPath); err != nil {
 			return fmt.Errorf("failed to remove an existing file/directory %s: %s", fullPath, err)
 		}
 	}
 
-	return service.cmd.CloneMirror(ctx, gitURL, fullPath)
+	if err := os.Rename(clonePath, fullPath); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %s", clonePath, fullPath, err)
+	}
+
+	if err := service.fetchLFSObjects(ctx, name); err != nil {
+		return err
+	}
+	refsUpdated()
+
+	return nil
 }
 
 // Update downloads latest changes from remote repository into a local mirror discarding any changes that were pushed
-// to mirror only. Update calls "git remote update" in <mirrorPath>/<fullName>.
-func (service *MirroredRepositories) Update(ctx context.Context, fullName string) error {
-	return service.cmd.UpdateRemote(ctx, service.resolveMirrorPath(fullName))
+// to mirror only. Update calls "git remote update" in <mirrorPath>/<fullName> and then pushes the mirror to its
+// push targets.
+func (service *MirroredRepositories) Update(ctx context.Context, name RepositoryName) (err error) {
+	fullName := name.String()
+	defer func() {
+		service.audit.Record(ctx, actionUpdate, fullName, nil, err)
+	}()
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, done, err := service.startOperation(ctx, fullName, "update")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	unlock, err := service.lockMirror(ctx, fullName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	defer func(startTime time.Time) {
+		service.recordSync(ctx, name, "update", startTime, err)
+	}(time.Now())
+
+	refsUpdated := service.watchRefs(ctx, fullName, fullPath)
+
+	if err := service.cmd.UpdateRemote(ctx, fullPath); err != nil {
+		return err
+	}
+
+	if err := service.fetchLFSObjects(ctx, name); err != nil {
+		return err
+	}
+	refsUpdated()
+
+	// Push failures are recorded per target and should not be reported as a failed update
+	service.Push(ctx, name)
+
+	return nil
+}
+
+// Remove deletes a local mirror. Parent directories that become empty are removed as well. If the mirror
+// does not exist ErrorNotMirrored is returned.
+func (service *MirroredRepositories) Remove(ctx context.Context, name RepositoryName) (err error) {
+	fullName := name.String()
+	defer func() {
+		service.audit.Record(ctx, actionRemove, fullName, nil, err)
+	}()
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, done, err := service.startOperation(ctx, fullName, "remove")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	unlock, err := service.lockMirror(ctx, fullName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
+		return ErrorNotMirrored
+	}
+
+	if err := os.RemoveAll(fullPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %s", fullPath, err)
+	}
+
+	root := filepath.Clean(service.mirrorPath)
+	for dir := filepath.Dir(fullPath); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		// os.Remove fails on non-empty directories
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// SourceURL returns the URL of original repository. For mirrors cloned from another mirror this is the URL stored
+// with SetSourceURL, otherwise the URL of remote repository.
+func (service *MirroredRepositories) SourceURL(ctx context.Context, name RepositoryName) (string, error) {
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
+		return "", ErrorNotMirrored
+	}
+
+	if values := service.cmd.ConfigValues(ctx, fullPath, SourceURLConfigKey); len(values) > 0 && values[0] != "" {
+		return values[0], nil
+	}
+
+	return service.cmd.RemoteURL(ctx, fullPath)
+}
+
+// SetSourceURL stores the URL of original repository for a mirror that has been cloned from another mirror.
+func (service *MirroredRepositories) SetSourceURL(ctx context.Context, name RepositoryName, sourceURL string) (err error) {
+	fullName := name.String()
+	defer func() {
+		service.audit.Record(ctx, actionSetSourceURL, fullName, map[string]string{"url": audit.RedactURL(sourceURL)}, err)
+	}()
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	return service.cmd.SetConfig(ctx, fullPath, SourceURLConfigKey, sourceURL)
+}
+
+// UpdateFromSource synchronizes a mirror with its original repository bypassing the configured remote. This is
+// used to keep mirrors cloned from another mirror up-to-date while the latter is not available.
+func (service *MirroredRepositories) UpdateFromSource(ctx context.Context, name RepositoryName) (err error) {
+	fullName := name.String()
+	defer func() {
+		service.audit.Record(ctx, actionUpdateFromSource, fullName, nil, err)
+	}()
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, done, err := service.startOperation(ctx, fullName, "update-from-source")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	unlock, err := service.lockMirror(ctx, fullName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	defer func(startTime time.Time) {
+		service.recordSync(ctx, name, "update-from-source", startTime, err)
+	}(time.Now())
+
+	sourceURL, err := service.SourceURL(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	refsUpdated := service.watchRefs(ctx, fullName, fullPath)
+
+	if err := service.cmd.FetchMirror(ctx, fullPath, sourceURL); err != nil {
+		return err
+	}
+
+	if err := service.fetchLFSObjectsFrom(ctx, name, sourceURL); err != nil {
+		return err
+	}
+	refsUpdated()
+
+	return nil
+}
+
+// watchRefs takes a snapshot of mirror refs before sync and returns a function to be called once the sync
+// has succeeded that sends refs changed since then to webhooks. Refs of a mirror that does not exist yet are
+// reported as created.
+func (service *MirroredRepositories) watchRefs(ctx context.Context, fullName, fullPath string) func() {
+	if service.webhooks == nil {
+		return func() {}
+	}
+
+	before := make(map[string]string)
+	if service.cmd.IsRepository(ctx, fullPath) {
+		refs, err := service.cmd.Refs(ctx, fullPath)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read mirror refs, webhooks won't be called", "repo", fullName, "error", err)
+			return func() {}
+		}
+		before = refs
+	}
+
+	return func() {
+		after, err := service.cmd.Refs(ctx, fullPath)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read mirror refs, webhooks won't be called", "repo", fullName, "error", err)
+			return
+		}
+
+		service.webhooks.MirrorUpdated(ctx, fullName, hooks.Changes(before, after))
+	}
+}
+
+// LastSyncAt returns the time of the latest successful synchronization of a mirror. A zero time is returned
+// for mirrors that have not been synchronized since this information is recorded.
+func (service *MirroredRepositories) LastSyncAt(ctx context.Context, name RepositoryName) (time.Time, error) {
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
+		return time.Time{}, ErrorNotMirrored
+	}
+
+	values := service.cmd.ConfigValues(ctx, fullPath, LastSyncAtConfigKey)
+	if len(values) == 0 || values[len(values)-1] == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, values[len(values)-1])
+}
+
+// LastSyncTimes returns the time of the latest successful synchronization of each mirror.
+func (service *MirroredRepositories) LastSyncTimes(ctx context.Context) (map[string]time.Time, error) {
+	repos, err := service.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make(map[string]time.Time, len(repos))
+	for _, repo := range repos {
+		times[repo.FullName] = repo.LastSyncAt
+	}
+
+	return times, nil
+}
+
+// FailingMirrors returns the time of the first failed synchronization of mirrors which latest sync has failed.
+func (service *MirroredRepositories) FailingMirrors(ctx context.Context) (map[string]time.Time, error) {
+	repos, err := service.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	failing := make(map[string]time.Time)
+	for _, repo := range repos {
+		if !repo.FailingSince.IsZero() {
+			failing[repo.FullName] = repo.FailingSince
+		}
+	}
+
+	return failing, nil
+}
+
+// LFSStore returns Git LFS object store of a mirror.
+func (service *MirroredRepositories) LFSStore(fullName string) (*lfs.Store, error) {
+	fullPath, err := service.resolveMirrorPath(fullName)
+	if err != nil {
+		return nil, err
+	}
+
+	return lfs.NewStore(filepath.Join(fullPath, "lfs")), nil
+}
+
+// fetchLFSObjects downloads LFS objects referenced from any ref of a mirror that are missing in its store from
+// the LFS server of its remote repository.
+func (service *MirroredRepositories) fetchLFSObjects(ctx context.Context, name RepositoryName) error {
+	if service.lfs == nil {
+		return nil
+	}
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	remoteURL, err := service.cmd.RemoteURL(ctx, fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to fetch LFS objects for %s: %s", name, err)
+	}
+
+	return service.fetchLFSObjectsFrom(ctx, name, remoteURL)
+}
+
+// fetchLFSObjectsFrom downloads missing LFS objects of a mirror from the LFS server of repository at gitURL.
+func (service *MirroredRepositories) fetchLFSObjectsFrom(ctx context.Context, name RepositoryName, gitURL string) error {
+	if service.lfs == nil {
+		return nil
+	}
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	pointers, err := service.cmd.LFSPointers(ctx, fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to find LFS objects in %s: %s", name, err)
+	}
+
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	endpoint, err := lfs.EndpointFromGitURL(gitURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch LFS objects for %s: %s", name, err)
+	}
+
+	if err := service.lfs.Fetch(ctx, endpoint, pointers, lfs.NewStore(filepath.Join(fullPath, "lfs"))); err != nil {
+		return fmt.Errorf("failed to fetch LFS objects for %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// submodules returns the list of submodules declared in .gitmodules of master and tracked branches of a mirror.
+func (service *MirroredRepositories) submodules(ctx context.Context, fullName string) []*Submodule {
+	fullPath, err := service.resolveMirrorPath(fullName)
+	if err != nil {
+		return nil
+	}
+
+	branches := append([]string{service.cmd.CurrentBranch(ctx, fullPath)}, service.cmd.ConfigValues(ctx, fullPath, TrackedBranchConfigKey)...)
+
+	var (
+		submodules []*Submodule
+		seen       = make(map[string]struct{})
+	)
+	for _, branch := range branches {
+		data, err := service.cmd.ReadFile(ctx, fullPath, "refs/heads/"+branch, ".gitmodules")
+		if err != nil {
+			continue
+		}
+
+		for _, sm := range ParseGitmodules(data) {
+			if _, ok := seen[sm.URL]; ok {
+				continue
+			}
+			seen[sm.URL] = struct{}{}
+
+			if name, ok := RepositoryNameFromURL(sm.URL, fullName); ok {
+				sm.FullName = name
+				if smPath, err := service.resolveMirrorPath(name); err == nil {
+					sm.Mirrored = service.cmd.IsRepository(ctx, smPath)
+				}
+			}
+
+			submodules = append(submodules, sm)
+		}
+	}
+
+	return submodules
+}
+
+// submoduleGraph returns submodules of a mirror along with submodules of their mirrors.
+func (service *MirroredRepositories) submoduleGraph(ctx context.Context, fullName string, visited map[string]struct{}) []*Submodule {
+	submodules := service.submodules(ctx, fullName)
+	for _, sm := range submodules {
+		if !sm.Mirrored {
+			continue
+		}
+
+		if _, ok := visited[sm.FullName]; ok {
+			continue
+		}
+		visited[sm.FullName] = struct{}{}
+
+		sm.Submodules = service.submoduleGraph(ctx, sm.FullName, visited)
+	}
+
+	return submodules
 }
 
 func (service *MirroredRepositories) findGitRepos(ctx context.Context, path string) ([]*Repository, error) {
-	if service.cmd.IsRepository(ctx, service.resolveMirrorPath(path)) {
-		return []*Repository{service.repositoryFromDir(ctx, path)}, nil
+	fullPath := filepath.Join(service.mirrorPath, path)
+	if service.cmd.IsRepository(ctx, fullPath) {
+		name := filepath.ToSlash(path)
+		if _, err := ParseRepositoryName(name); err != nil {
+			slog.WarnContext(ctx, "skipping repository with invalid name", "path", fullPath, "name", name)
+			return nil, nil
+		}
+
+		return []*Repository{service.repositoryFromDir(ctx, name, fullPath)}, nil
 	}
 
-	entries, err := ioutil.ReadDir(filepath.Join(service.mirrorPath, path))
+	entries, err := ioutil.ReadDir(fullPath)
 	if err != nil {
 		return nil, err
 	}
 
 	var repos []*Repository
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), cloneTempDirPrefix) {
 			continue
 		}
 
@@ -98,16 +614,31 @@ func (service *MirroredRepositories) findGitRepos(ctx context.Context, path stri
 	return repos, nil
 }
 
-func (service *MirroredRepositories) repositoryFromDir(ctx context.Context, path string) *Repository {
-	return &Repository{
-		FullName:           path,
-		Master:             service.cmd.CurrentBranch(ctx, service.resolveMirrorPath(path)),
-		LatestMasterCommit: service.commitFromDir(ctx, path),
+// repositoryFromDir reads mirror details from fullPath. Configuration variables are read at once, since listing
+// mirrors calls it for each one of them.
+func (service *MirroredRepositories) repositoryFromDir(ctx context.Context, fullName, fullPath string) *Repository {
+	config := service.cmd.ConfigEntries(ctx, fullPath, repositoryConfigPattern)
+
+	repo := &Repository{
+		FullName:           fullName,
+		Master:             service.cmd.CurrentBranch(ctx, fullPath),
+		LatestMasterCommit: service.commitFromDir(ctx, fullPath),
+		GitURL:             config["remote.origin.url"],
+	}
+
+	if value := config[strings.ToLower(LastSyncAtConfigKey)]; value != "" {
+		repo.LastSyncAt, _ = time.Parse(time.RFC3339, value)
+	}
+
+	if value := config[strings.ToLower(FailingSinceConfigKey)]; value != "" {
+		repo.FailingSince, _ = time.Parse(time.RFC3339, value)
 	}
+
+	return repo
 }
 
-func (service *MirroredRepositories) commitFromDir(ctx context.Context, path string) *Commit {
-	commit, err := service.cmd.LastCommit(ctx, service.resolveMirrorPath(path))
+func (service *MirroredRepositories) commitFromDir(ctx context.Context, fullPath string) *Commit {
+	commit, err := service.cmd.LastCommit(ctx, fullPath)
 	if err != nil {
 		return nil
 	}
@@ -115,6 +646,13 @@ func (service *MirroredRepositories) commitFromDir(ctx context.Context, path str
 	return &commit
 }
 
-func (service *MirroredRepositories) resolveMirrorPath(path string) string {
-	return filepath.Join(service.mirrorPath, path)
+// resolveMirrorPath validates repository name and returns the path of its mirror directory. ErrorInvalidRepositoryName
+// is returned for malformed names and names that point outside of mirrorPath.
+func (service *MirroredRepositories) resolveMirrorPath(fullName string) (string, error) {
+	name, err := ParseRepositoryName(fullName)
+	if err != nil {
+		return "", err
+	}
+
+	return name.ResolvePath(service.mirrorPath)
 }
@@ -1,14 +1,25 @@
 package git_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/andrewslotin/doppelganger/audit"
 	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/andrewslotin/doppelganger/notify"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -46,6 +57,61 @@ func (cmd *commandMock) UpdateRemote(ctx context.Context, fullPath string) error
 	return args.Error(0)
 }
 
+func (cmd *commandMock) FetchMirror(ctx context.Context, fullPath, remoteURL string) error {
+	args := cmd.Mock.Called(fullPath, remoteURL)
+	return args.Error(0)
+}
+
+func (cmd *commandMock) RemoteURL(ctx context.Context, fullPath string) (string, error) {
+	args := cmd.Mock.Called(fullPath)
+	return args.String(0), args.Error(1)
+}
+
+func (cmd *commandMock) ReadFile(ctx context.Context, fullPath, rev, name string) ([]byte, error) {
+	args := cmd.Mock.Called(fullPath, rev, name)
+	data, _ := args.Get(0).([]byte)
+	return data, args.Error(1)
+}
+
+func (cmd *commandMock) ConfigValues(ctx context.Context, fullPath, key string) []string {
+	args := cmd.Mock.Called(fullPath, key)
+	values, _ := args.Get(0).([]string)
+	return values
+}
+
+func (cmd *commandMock) ConfigEntries(ctx context.Context, fullPath, keyPattern string) map[string]string {
+	args := cmd.Mock.Called(fullPath, keyPattern)
+	entries, _ := args.Get(0).(map[string]string)
+	return entries
+}
+
+func (cmd *commandMock) SetConfig(ctx context.Context, fullPath, key, value string) error {
+	args := cmd.Mock.Called(fullPath, key, value)
+	return args.Error(0)
+}
+
+func (cmd *commandMock) RemoveConfigSection(ctx context.Context, fullPath, section string) error {
+	args := cmd.Mock.Called(fullPath, section)
+	return args.Error(0)
+}
+
+func (cmd *commandMock) PushMirror(ctx context.Context, fullPath, remoteURL string, config map[string]string) error {
+	args := cmd.Mock.Called(fullPath, remoteURL, config)
+	return args.Error(0)
+}
+
+func (cmd *commandMock) LFSPointers(ctx context.Context, fullPath string) ([]lfs.Pointer, error) {
+	args := cmd.Mock.Called(fullPath)
+	pointers, _ := args.Get(0).([]lfs.Pointer)
+	return pointers, args.Error(1)
+}
+
+func (cmd *commandMock) Refs(ctx context.Context, fullPath string) (map[string]string, error) {
+	args := cmd.Mock.Called(fullPath)
+	refs, _ := args.Get(0).(map[string]string)
+	return refs, args.Error(1)
+}
+
 /* **************** Tests **************** */
 
 func TestMirroredRepositories_All(t *testing.T) {
@@ -54,17 +120,24 @@ func TestMirroredRepositories_All(t *testing.T) {
 	defer teardown()
 
 	reposWithBranches := map[string]string{
-		"a":      "staging",
+		"a/a1":   "staging",
 		"b/b1":   "master",
 		"b/b2/z": "master",
-		"c":      " production",
+		"c/.c1":  " production",
 	}
 
 	cmd := &commandMock{}
 
 	cmd.On("IsRepository", mirrorsDir).Return(false)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "a")).Return(false)
 	cmd.On("IsRepository", filepath.Join(mirrorsDir, "b")).Return(false)
 	cmd.On("IsRepository", filepath.Join(mirrorsDir, "b/b2")).Return(false)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "c")).Return(false)
+
+	// Repositories with invalid names are skipped
+	os.MkdirAll(filepath.Join(mirrorsDir, "-d", "d1"), 0755)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "-d")).Return(false)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "-d", "d1")).Return(true)
 
 	lastCommit := git.Commit{
 		SHA:       "abc123",
@@ -80,6 +153,10 @@ func TestMirroredRepositories_All(t *testing.T) {
 		cmd.On("IsRepository", path).Return(true)
 		cmd.On("CurrentBranch", path).Return(masterBranch)
 		cmd.On("LastCommit", path).Return(lastCommit, nil)
+		cmd.On("ConfigEntries", path, `^(remote\.origin\.url|doppelganger\.lastsyncat|doppelganger\.failingsince)$`).Return(map[string]string{
+			"remote.origin.url":       "git@github.com:" + repoName + ".git",
+			"doppelganger.lastsyncat": "2019-06-01T12:00:00Z",
+		})
 	}
 
 	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
@@ -90,6 +167,8 @@ func TestMirroredRepositories_All(t *testing.T) {
 	if assert.Len(t, mirrors, 4) {
 		for _, repo := range mirrors {
 			assert.Equal(t, reposWithBranches[repo.FullName], repo.Master)
+			assert.Equal(t, "git@github.com:"+repo.FullName+".git", repo.GitURL)
+			assert.Equal(t, time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), repo.LastSyncAt)
 			if assert.NotNil(t, repo.LatestMasterCommit) {
 				assert.Equal(t, lastCommit.SHA, repo.LatestMasterCommit.SHA)
 				assert.Equal(t, lastCommit.Author, repo.LatestMasterCommit.Author)
@@ -120,6 +199,12 @@ func TestMirroredRepositories_Get_MirrorExists(t *testing.T) {
 	cmd.On("IsRepository", mirroredRepoPath).Return(true)
 	cmd.On("CurrentBranch", mirroredRepoPath).Return("production")
 	cmd.On("LastCommit", mirroredRepoPath).Return(lastCommit, nil)
+	cmd.On("ConfigEntries", mirroredRepoPath, `^(remote\.origin\.url|doppelganger\.lastsyncat|doppelganger\.failingsince)$`).Return(map[string]string{
+		"remote.origin.url": "git@github.com:a/b.git",
+	})
+	cmd.On("ConfigValues", mirroredRepoPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("ReadFile", mirroredRepoPath, "refs/heads/production", ".gitmodules").Return(nil, git.ErrorNotFound)
+	cmd.On("ConfigEntries", mirroredRepoPath, `^doppelganger-push\.`).Return(nil)
 
 	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
 	repo, err := mirroredRepos.Get(context.Background(), "a/b")
@@ -154,18 +239,76 @@ func TestMirroredRepositories_Get_NotMirrored(t *testing.T) {
 	assert.Equal(t, err, git.ErrorNotMirrored)
 }
 
+func TestMirroredRepositories_Exists(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", path.Join(mirrorsDir, "a", "b")).Return(true)
+	cmd.On("IsRepository", path.Join(mirrorsDir, "c", "d")).Return(false)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	assert.True(t, mirroredRepos.Exists(context.Background(), "a/b"))
+	assert.False(t, mirroredRepos.Exists(context.Background(), "c/d"))
+	assert.False(t, mirroredRepos.Exists(context.Background(), "../a/b"))
+}
+
 func TestMirroredRepositories_Create(t *testing.T) {
 	mirrorsDir, teardown, err := setupMirrorsDir()
 	require.NoError(t, err)
 	defer teardown()
 
 	cmd := &commandMock{}
-	cmd.On("CloneMirror", "git@doppelganger:a/b", path.Join(mirrorsDir, "a", "b")).Return(nil)
+	cmd.On("CloneMirror", "git@doppelganger:a/b", mock.MatchedBy(isTempClonePath(path.Join(mirrorsDir, "a", "b")))).
+		Return(nil).
+		Run(fakeClone)
+	cmd.On("ConfigValues", path.Join(mirrorsDir, "a", "b"), git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", path.Join(mirrorsDir, "a", "b"), git.LastSyncAtConfigKey, mock.Anything).Return(nil)
 
 	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
 	require.NoError(t, mirroredRepos.Create(context.Background(), "a/b", "git@doppelganger:a/b"))
 
 	cmd.AssertExpectations(t)
+
+	// The clone is moved to its place and temporary directory is removed
+	_, err = os.Stat(path.Join(mirrorsDir, "a", "b", "HEAD"))
+	assert.NoError(t, err)
+
+	entries, err := ioutil.ReadDir(path.Join(mirrorsDir, "a"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestMirroredRepositories_Create_CloneFailed(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+	require.NoError(t, os.MkdirAll(mirroredRepoPath, 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(mirroredRepoPath, "HEAD"), []byte("ref: refs/heads/master\n"), 0644))
+
+	cmd := &commandMock{}
+	cmd.On("CloneMirror", "git@doppelganger:a/b", mock.MatchedBy(isTempClonePath(mirroredRepoPath))).
+		Return(errors.New("interrupted")).
+		Run(fakeClone)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.FailingSinceConfigKey, mock.Anything).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	assert.Error(t, mirroredRepos.Create(context.Background(), "a/b", "git@doppelganger:a/b"))
+
+	cmd.AssertExpectations(t)
+
+	// Existing mirror is kept intact, partial clone is removed
+	data, err := ioutil.ReadFile(path.Join(mirroredRepoPath, "HEAD"))
+	require.NoError(t, err)
+	assert.Equal(t, "ref: refs/heads/master\n", string(data))
+
+	entries, err := ioutil.ReadDir(path.Join(mirrorsDir, "a"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
 }
 
 func TestMirroredRepositories_Create_DirExists(t *testing.T) {
@@ -177,7 +320,11 @@ func TestMirroredRepositories_Create_DirExists(t *testing.T) {
 	require.NoError(t, os.MkdirAll(mirroredRepoPath, 0755))
 
 	cmd := &commandMock{}
-	cmd.On("CloneMirror", "git@doppelganger:a/b", mirroredRepoPath).Return(nil)
+	cmd.On("CloneMirror", "git@doppelganger:a/b", mock.MatchedBy(isTempClonePath(mirroredRepoPath))).
+		Return(nil).
+		Run(fakeClone)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
 
 	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
 	require.NoError(t, mirroredRepos.Create(context.Background(), "a/b", "git@doppelganger:a/b"))
@@ -185,6 +332,84 @@ func TestMirroredRepositories_Create_DirExists(t *testing.T) {
 	cmd.AssertExpectations(t)
 }
 
+func TestMirroredRepositories_InvalidName(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	// A directory outside of mirrorsDir that must survive
+	victimDir, err := ioutil.TempDir(os.TempDir(), "doppelganger-victim")
+	require.NoError(t, err)
+	defer os.RemoveAll(victimDir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(mirrorsDir, "a"), 0755))
+	require.NoError(t, os.Symlink(victimDir, filepath.Join(mirrorsDir, "a", "link")))
+
+	cmd := &commandMock{}
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+
+	for _, name := range []string{"", "a", "../" + filepath.Base(victimDir), "a/../../etc", victimDir, "a/link"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := mirroredRepos.LFSStore(name)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+		})
+	}
+
+	// A valid name that leads outside of mirrorsDir through a symlink
+	name, err := git.ParseRepositoryName("a/link")
+	require.NoError(t, err)
+
+	_, err = mirroredRepos.Get(context.Background(), name)
+	assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+	assert.Equal(t, git.ErrorInvalidRepositoryName, mirroredRepos.Create(context.Background(), name, "git@doppelganger:a/b"))
+	assert.Equal(t, git.ErrorInvalidRepositoryName, mirroredRepos.Update(context.Background(), name))
+	assert.Equal(t, git.ErrorInvalidRepositoryName, mirroredRepos.Remove(context.Background(), name))
+
+	// Nothing has been called on git
+	cmd.AssertExpectations(t)
+	_, err = os.Stat(victimDir)
+	assert.NoError(t, err)
+}
+
+func TestMirroredRepositories_NestedName(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := filepath.Join(mirrorsDir, "a", "b")
+	require.NoError(t, os.MkdirAll(filepath.Join(mirroredRepoPath, "refs", "heads"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mirroredRepoPath, "HEAD"), []byte("ref: refs/heads/master\n"), 0644))
+
+	cmd := &commandMock{}
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+
+	// Directories inside of an existing mirror are never mirrors themselves
+	for _, name := range []git.RepositoryName{"a/b/refs", "a/b/refs/heads"} {
+		t.Run(name.String(), func(t *testing.T) {
+			_, err := mirroredRepos.Get(context.Background(), name)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+
+			_, err = mirroredRepos.SourceURL(context.Background(), name)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+
+			_, err = mirroredRepos.LastSyncAt(context.Background(), name)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+
+			_, err = mirroredRepos.PushTargets(context.Background(), name)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+
+			assert.Equal(t, git.ErrorInvalidRepositoryName, mirroredRepos.Remove(context.Background(), name))
+			assert.Equal(t, git.ErrorInvalidRepositoryName, mirroredRepos.Create(context.Background(), name, "git@doppelganger:a/b"))
+			assert.Equal(t, git.ErrorInvalidRepositoryName, mirroredRepos.Update(context.Background(), name))
+		})
+	}
+
+	// Nothing has been called on git
+	cmd.AssertExpectations(t)
+	_, err = os.Stat(filepath.Join(mirroredRepoPath, "refs", "heads"))
+	assert.NoError(t, err)
+}
+
 func TestMirroredRepositories_Update(t *testing.T) {
 	mirrorsDir, teardown, err := setupMirrorsDir()
 	require.NoError(t, err)
@@ -192,6 +417,54 @@ func TestMirroredRepositories_Update(t *testing.T) {
 
 	cmd := &commandMock{}
 	cmd.On("UpdateRemote", path.Join(mirrorsDir, "a", "b")).Return(nil)
+	cmd.On("IsRepository", path.Join(mirrorsDir, "a", "b")).Return(true)
+	cmd.On("ConfigEntries", path.Join(mirrorsDir, "a", "b"), `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", path.Join(mirrorsDir, "a", "b"), git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", path.Join(mirrorsDir, "a", "b"), git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	require.NoError(t, mirroredRepos.Update(context.Background(), "a/b"))
+
+	cmd.AssertExpectations(t)
+}
+
+func TestMirroredRepositories_Update_Failed(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+	require.NoError(t, os.MkdirAll(mirroredRepoPath, 0755))
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", mirroredRepoPath).Return(assert.AnError)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return([]string{""})
+	cmd.On("SetConfig", mirroredRepoPath, git.FailingSinceConfigKey, mock.MatchedBy(func(value string) bool {
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	})).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	assert.Error(t, mirroredRepos.Update(context.Background(), "a/b"))
+
+	cmd.AssertExpectations(t)
+	cmd.AssertNotCalled(t, "SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything)
+}
+
+func TestMirroredRepositories_Update_Recovered(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", mirroredRepoPath).Return(nil)
+	cmd.On("IsRepository", mirroredRepoPath).Return(true)
+	cmd.On("ConfigEntries", mirroredRepoPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return([]string{"2019-06-01T12:00:00Z"})
+	cmd.On("SetConfig", mirroredRepoPath, git.FailingSinceConfigKey, "").Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
 
 	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
 	require.NoError(t, mirroredRepos.Update(context.Background(), "a/b"))
@@ -199,6 +472,330 @@ func TestMirroredRepositories_Update(t *testing.T) {
 	cmd.AssertExpectations(t)
 }
 
+func TestMirroredRepositories_Update_LFSEnabled(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	content := "large file content"
+	pointer := lfs.Pointer{
+		OID:  sha256Hex(content),
+		Size: int64(len(content)),
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/a/b.git/info/lfs/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		var req lfs.BatchRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "download", req.Operation)
+		assert.Equal(t, []lfs.Pointer{pointer}, req.Objects)
+
+		json.NewEncoder(w).Encode(lfs.BatchResponse{
+			Objects: []lfs.BatchObject{{
+				Pointer: pointer,
+				Actions: map[string]*lfs.Action{
+					"download": {Href: srv.URL + "/download/" + pointer.OID},
+				},
+			}},
+		})
+	})
+	mux.HandleFunc("/download/"+pointer.OID, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	})
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", mirroredRepoPath).Return(nil)
+	cmd.On("LFSPointers", mirroredRepoPath).Return([]lfs.Pointer{pointer}, nil)
+	cmd.On("RemoteURL", mirroredRepoPath).Return(srv.URL+"/a/b.git", nil)
+	cmd.On("IsRepository", mirroredRepoPath).Return(true)
+	cmd.On("ConfigEntries", mirroredRepoPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	mirroredRepos.EnableLFS(lfs.NewClient(srv.Client()))
+	require.NoError(t, mirroredRepos.Update(context.Background(), "a/b"))
+
+	cmd.AssertExpectations(t)
+	store, err := mirroredRepos.LFSStore("a/b")
+	require.NoError(t, err)
+	assert.True(t, store.Exists(pointer))
+}
+
+func TestMirroredRepositories_UpdateFromSource(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", mirroredRepoPath).Return(true)
+	cmd.On("ConfigValues", mirroredRepoPath, git.SourceURLConfigKey).Return([]string{"git@github.com:a/b.git"})
+	cmd.On("FetchMirror", mirroredRepoPath, "git@github.com:a/b.git").Return(nil)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	require.NoError(t, mirroredRepos.UpdateFromSource(context.Background(), "a/b"))
+
+	cmd.AssertExpectations(t)
+}
+
+func TestMirroredRepositories_Remove(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	for _, dir := range []string{"a/b", "a/c", "d/e/f"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(mirrorsDir, dir), 0755))
+	}
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "a", "b")).Return(true)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "d", "e", "f")).Return(true)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "x", "y")).Return(false)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	require.NoError(t, mirroredRepos.Remove(context.Background(), "a/b"))
+	require.NoError(t, mirroredRepos.Remove(context.Background(), "d/e/f"))
+	assert.Equal(t, git.ErrorNotMirrored, mirroredRepos.Remove(context.Background(), "x/y"))
+
+	cmd.AssertExpectations(t)
+
+	_, err = os.Stat(filepath.Join(mirrorsDir, "a", "b"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Non-empty parent directory is kept
+	_, err = os.Stat(filepath.Join(mirrorsDir, "a", "c"))
+	assert.NoError(t, err)
+
+	// Empty parent directories are removed
+	_, err = os.Stat(filepath.Join(mirrorsDir, "d"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(mirrorsDir)
+	assert.NoError(t, err)
+}
+
+func TestMirroredRepositories_EnableAudit(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(mirrorsDir, "a", "b"), 0755))
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "a", "b")).Return(true)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "x", "y")).Return(false)
+
+	log, err := audit.Open(filepath.Join(mirrorsDir, ".doppelganger", "audit.jsonl"))
+	require.NoError(t, err)
+	defer log.Close()
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	mirroredRepos.EnableAudit(log)
+
+	ctx := audit.WithActor(context.Background(), audit.Actor{Type: audit.ActorCLI, Name: "root"})
+	require.NoError(t, mirroredRepos.Remove(ctx, "a/b"))
+	assert.Error(t, mirroredRepos.Remove(ctx, "x/y"))
+
+	entries, _, err := log.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "remove", entries[0].Action)
+	assert.Equal(t, "x/y", entries[0].Repository)
+	assert.Equal(t, audit.OutcomeFailure, entries[0].Outcome)
+	assert.Equal(t, git.ErrorNotMirrored.Error(), entries[0].Error)
+
+	assert.Equal(t, "remove", entries[1].Action)
+	assert.Equal(t, "a/b", entries[1].Repository)
+	assert.Equal(t, audit.Actor{Type: audit.ActorCLI, Name: "root"}, entries[1].Actor)
+	assert.Equal(t, audit.OutcomeSuccess, entries[1].Outcome)
+}
+
+// notifierFunc is an adapter to use a function as notify.Notifier.
+type notifierFunc func(ctx context.Context, events []notify.Event) error
+
+func (fn notifierFunc) Notify(ctx context.Context, events []notify.Event) error {
+	return fn(ctx, events)
+}
+
+func TestMirroredRepositories_EnableNotifications(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", path.Join(mirrorsDir, "a", "b")).Return(errors.New("fatal: repository not found"))
+	cmd.On("IsRepository", path.Join(mirrorsDir, "a", "b")).Return(true)
+
+	delivered := make(chan []notify.Event, 1)
+	monitor := notify.NewMonitor(0)
+	monitor.Subscribe(notify.Subscription{Name: "test", Notifier: notifierFunc(func(ctx context.Context, events []notify.Event) error {
+		delivered <- events
+		return nil
+	})})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitor.Run(ctx)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	mirroredRepos.EnableNotifications(monitor)
+	assert.Error(t, mirroredRepos.Update(ctx, "a/b"))
+
+	select {
+	case events := <-delivered:
+		require.Len(t, events, 1)
+		assert.Equal(t, notify.EventSyncFailed, events[0].Type)
+		assert.Equal(t, "a/b", events[0].Repository)
+		assert.Equal(t, "fatal: repository not found", events[0].Error)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync failure has not been reported")
+	}
+}
+
+func TestMirroredRepositories_EnableWebhooks(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", mirroredRepoPath).Return(true)
+	cmd.On("Refs", mirroredRepoPath).Return(map[string]string{
+		"refs/heads/master":  "1111111111111111111111111111111111111111",
+		"refs/heads/removed": "2222222222222222222222222222222222222222",
+		"refs/tags/v1.0":     "3333333333333333333333333333333333333333",
+	}, nil).Once()
+	cmd.On("UpdateRemote", mirroredRepoPath).Return(nil)
+	cmd.On("Refs", mirroredRepoPath).Return(map[string]string{
+		"refs/heads/master":  "4444444444444444444444444444444444444444",
+		"refs/heads/feature": "5555555555555555555555555555555555555555",
+		"refs/tags/v1.0":     "3333333333333333333333333333333333333333",
+	}, nil).Once()
+	cmd.On("ConfigEntries", mirroredRepoPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", mirroredRepoPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredRepoPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+
+	delivered := make(chan hooks.Payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var p hooks.Payload
+		json.NewDecoder(req.Body).Decode(&p)
+		delivered <- p
+	}))
+	defer srv.Close()
+
+	webhooks, err := hooks.NewService(filepath.Join(mirrorsDir, "webhooks.json"), srv.Client())
+	require.NoError(t, err)
+	webhooks.SetBaseURL("https://doppelganger.example.com/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = webhooks.Add(ctx, hooks.Hook{URL: srv.URL, Secret: "secret"})
+	require.NoError(t, err)
+	go webhooks.Run(ctx, 1)
+
+	mirroredRepos := git.NewMirroredRepositories(mirrorsDir, cmd)
+	mirroredRepos.EnableWebhooks(webhooks)
+	require.NoError(t, mirroredRepos.Update(ctx, "a/b"))
+
+	cmd.AssertExpectations(t)
+
+	select {
+	case p := <-delivered:
+		assert.Equal(t, hooks.EventMirrorUpdated, p.Event)
+		assert.Equal(t, "a/b", p.Repository)
+		assert.Equal(t, "https://doppelganger.example.com/a/b", p.CloneURL)
+		assert.Equal(t, []hooks.RefChange{
+			{Ref: "refs/heads/feature", Before: hooks.ZeroSHA, After: "5555555555555555555555555555555555555555"},
+			{Ref: "refs/heads/master", Before: "1111111111111111111111111111111111111111", After: "4444444444444444444444444444444444444444"},
+			{Ref: "refs/heads/removed", Before: "2222222222222222222222222222222222222222", After: hooks.ZeroSHA},
+		}, p.Refs)
+	case <-time.After(5 * time.Second):
+		t.Fatal("mirror update has not been delivered")
+	}
+}
+
+func TestMirroredRepositories_LastSyncAt(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	mirroredRepoPath := path.Join(mirrorsDir, "a", "b")
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", mirroredRepoPath).Return(true)
+	cmd.On("ConfigValues", mirroredRepoPath, git.LastSyncAtConfigKey).Return([]string{"2019-06-01T12:00:00Z"})
+
+	lastSyncAt, err := git.NewMirroredRepositories(mirrorsDir, cmd).LastSyncAt(context.Background(), "a/b")
+	require.NoError(t, err)
+
+	cmd.AssertExpectations(t)
+	assert.Equal(t, time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), lastSyncAt)
+}
+
+func TestMirroredRepositories_FailingMirrors(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	failingSince := map[string]string{
+		"a/b": "2019-06-01T12:00:00Z",
+		"c/d": "",
+	}
+
+	cmd := &commandMock{}
+	cmd.On("IsRepository", mirrorsDir).Return(false)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "a")).Return(false)
+	cmd.On("IsRepository", filepath.Join(mirrorsDir, "c")).Return(false)
+
+	for repoName, value := range failingSince {
+		path := filepath.Join(mirrorsDir, repoName)
+		require.NoError(t, os.MkdirAll(path, 0755))
+
+		cmd.On("IsRepository", path).Return(true)
+		cmd.On("CurrentBranch", path).Return("master")
+		cmd.On("LastCommit", path).Return(git.Commit{SHA: "abc123"}, nil)
+		cmd.On("ConfigEntries", path, `^(remote\.origin\.url|doppelganger\.lastsyncat|doppelganger\.failingsince)$`).Return(map[string]string{
+			"remote.origin.url":         "git@github.com:" + repoName + ".git",
+			"doppelganger.failingsince": value,
+		})
+	}
+
+	failing, err := git.NewMirroredRepositories(mirrorsDir, cmd).FailingMirrors(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]time.Time{
+		"a/b": time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, failing)
+}
+
+// isTempClonePath returns a matcher for path of a temporary directory mirror in fullPath is cloned into.
+func isTempClonePath(fullPath string) func(string) bool {
+	return func(clonePath string) bool {
+		return filepath.Base(clonePath) == filepath.Base(fullPath) &&
+			filepath.Dir(filepath.Dir(clonePath)) == filepath.Dir(fullPath) &&
+			clonePath != fullPath
+	}
+}
+
+// fakeClone creates a bare repository stub in the path passed to CloneMirror.
+func fakeClone(args mock.Arguments) {
+	clonePath := args.String(1)
+	os.MkdirAll(clonePath, 0755)
+	ioutil.WriteFile(filepath.Join(clonePath, "HEAD"), []byte("ref: refs/heads/master\n"), 0644)
+}
+
 func setupMirrorsDir() (mirrorsPath string, teardownFn func(), err error) {
 	tmpDir, err := ioutil.TempDir(os.TempDir(), "doppelganger")
 	if err != nil {
@@ -207,3 +804,8 @@ func setupMirrorsDir() (mirrorsPath string, teardownFn func(), err error) {
 
 	return tmpDir, func() { os.RemoveAll(tmpDir) }, nil
 }
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// maxProgressStderrSize is the number of bytes of git stderr other than progress lines kept to be reported
+// in case command fails.
+const maxProgressStderrSize = 64 << 10
+
+var progressLineRe = regexp.MustCompile(`^(?:remote: )?([A-Z][A-Za-z ]+):\s+(\d+)% \((\d+)/(\d+)\)`)
+
+type progressContextKey struct{}
+
+// Progress is the state of a long running git command, such as clone, as reported by git itself.
+type Progress struct {
+	// Phase is the name of current phase, i.e. "Receiving objects" or "Resolving deltas".
+	Phase   string `json:"phase"`
+	Percent int    `json:"percent"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+}
+
+// ProgressFunc is a function that receives progress updates.
+type ProgressFunc func(Progress)
+
+// WithProgress returns a copy of ctx that makes MirroredRepositories.Create report clone progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, fn)
+}
+
+// ProgressFromContext returns the function stored in ctx with WithProgress or nil if there is none.
+func ProgressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressContextKey{}).(ProgressFunc)
+	return fn
+}
+
+// ParseProgress parses a progress line git writes to stderr when run with --progress, i.e.
+// "Receiving objects:  45% (450/1000), 1.20 MiB | 1.00 MiB/s". Lines sent by remote are prefixed
+// with "remote: ".
+func ParseProgress(line string) (Progress, bool) {
+	m := progressLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return Progress{}, false
+	}
+
+	p := Progress{Phase: m[1]}
+	p.Percent, _ = strconv.Atoi(m[2])
+	p.Current, _ = strconv.Atoi(m[3])
+	p.Total, _ = strconv.Atoi(m[4])
+
+	return p, true
+}
+
+// progressWriter is used as stderr of git command to report progress lines, which are terminated with "\r"
+// while in progress and with "\n" once a phase is done. Other output is kept, so that it can be used as
+// an error message.
+type progressWriter struct {
+	report ProgressFunc
+	line   []byte
+	stderr bytes.Buffer
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+
+	for {
+		i := bytes.IndexAny(w.line, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		w.handle(w.line[:i])
+		w.line = w.line[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Stderr returns output other than progress lines.
+func (w *progressWriter) Stderr() []byte {
+	if len(w.line) > 0 {
+		w.handle(w.line)
+		w.line = nil
+	}
+
+	return w.stderr.Bytes()
+}
+
+func (w *progressWriter) handle(line []byte) {
+	if p, ok := ParseProgress(string(line)); ok {
+		w.report(p)
+		return
+	}
+
+	if len(bytes.TrimSpace(line)) == 0 || w.stderr.Len()+len(line) > maxProgressStderrSize {
+		return
+	}
+
+	w.stderr.Write(line)
+	w.stderr.WriteByte('\n')
+}
@@ -0,0 +1,33 @@
+package git_test
+
+import (
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProgress(t *testing.T) {
+	for line, expected := range map[string]git.Progress{
+		"Receiving objects:  45% (450/1000), 1.20 MiB | 1.00 MiB/s": {Phase: "Receiving objects", Percent: 45, Current: 450, Total: 1000},
+		"Resolving deltas: 100% (20/20), done.":                     {Phase: "Resolving deltas", Percent: 100, Current: 20, Total: 20},
+		"remote: Compressing objects:   3% (1/30)":                  {Phase: "Compressing objects", Percent: 3, Current: 1, Total: 30},
+		"Updating files:  50% (5/10)":                               {Phase: "Updating files", Percent: 50, Current: 5, Total: 10},
+		"remote: Counting objects: 100% (1523/1523), done.":         {Phase: "Counting objects", Percent: 100, Current: 1523, Total: 1523},
+	} {
+		p, ok := git.ParseProgress(line)
+		if assert.True(t, ok, line) {
+			assert.Equal(t, expected, p, line)
+		}
+	}
+
+	for _, line := range []string{
+		"Cloning into bare repository 'doppelganger.git'...",
+		"remote: Enumerating objects: 1523, done.",
+		"fatal: repository 'https://github.com/acme/missing.git/' not found",
+		"",
+	} {
+		_, ok := git.ParseProgress(line)
+		assert.False(t, ok, line)
+	}
+}
@@ -0,0 +1,278 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/audit"
+)
+
+// pushTargetConfigSection is the name of mirror configuration section that holds push targets settings.
+// Each target is stored in its own subsection, i.e. [doppelganger-push "gitea"].
+const pushTargetConfigSection = "doppelganger-push"
+
+var (
+	// ErrorInvalidPushTarget is returned by AddPushTarget if target name or URL is not valid.
+	ErrorInvalidPushTarget = errors.New("invalid push target")
+
+	// Actions recorded to audit log when push targets are changed.
+	actionAddPushTarget    = audit.Action("add-push-target")
+	actionRemovePushTarget = audit.Action("remove-push-target")
+
+	pushTargetNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// scpLikeURLPattern matches SSH remotes written as "user@host:path"
+	scpLikeURLPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*@[A-Za-z0-9][A-Za-z0-9.-]*:[^:]`)
+)
+
+// PushTarget is a secondary remote that a mirror is replicated to after each update.
+type PushTarget struct {
+	// Unique name of a target within the mirror.
+	Name string
+	// Remote HTTPS or SSH URL or an absolute path to a bare repository on local filesystem, see
+	// MirroredRepositories.EnableLocalPushTargets.
+	URL string
+	// Credential used to authenticate against remote.
+	Credential Credential
+
+	// The time of the latest push attempt.
+	LastPushAt time.Time
+	// An error returned by the latest push attempt.
+	LastError string
+}
+
+// OK returns true if the latest push to target has succeeded.
+func (target *PushTarget) OK() bool {
+	return !target.LastPushAt.IsZero() && target.LastError == ""
+}
+
+// EnableLocalPushTargets allows mirrors to be pushed to bare repositories on local filesystem under path. Missing
+// repositories are initialized on first push. Local push targets are rejected unless enabled.
+func (service *MirroredRepositories) EnableLocalPushTargets(path string) {
+	service.localPushTargetsPath = path
+}
+
+// PushTargets returns the list of push targets configured for a mirror sorted by name.
+func (service *MirroredRepositories) PushTargets(ctx context.Context, name RepositoryName) ([]*PushTarget, error) {
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
+		return nil, ErrorNotMirrored
+	}
+
+	targets := make(map[string]*PushTarget)
+	for key, value := range service.cmd.ConfigEntries(ctx, fullPath, `^`+pushTargetConfigSection+`\.`) {
+		name, field := parsePushTargetConfigKey(key)
+		if name == "" {
+			continue
+		}
+
+		target, ok := targets[name]
+		if !ok {
+			target = &PushTarget{Name: name}
+			targets[name] = target
+		}
+
+		switch field {
+		case "url":
+			target.URL = value
+		case "credential":
+			target.Credential = Credential(value)
+		case "lastpushat":
+			target.LastPushAt, _ = time.Parse(time.RFC3339, value)
+		case "lasterror":
+			target.LastError = value
+		}
+	}
+
+	result := make([]*PushTarget, 0, len(targets))
+	for _, target := range targets {
+		if target.URL != "" {
+			result = append(result, target)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+// AddPushTarget adds a new push target to a mirror or replaces an existing one with the same name.
+func (service *MirroredRepositories) AddPushTarget(ctx context.Context, name RepositoryName, target PushTarget) (err error) {
+	defer func() {
+		params := map[string]string{"target": target.Name, "url": audit.RedactURL(target.URL)}
+		if target.Credential != "" {
+			params["credential"] = string(target.Credential)
+		}
+		service.audit.Record(ctx, actionAddPushTarget, name.String(), params, err)
+	}()
+
+	if !pushTargetNamePattern.MatchString(target.Name) || service.validatePushTargetURL(target.URL) != nil {
+		return ErrorInvalidPushTarget
+	}
+
+	if err := target.Credential.Validate(); err != nil {
+		return err
+	}
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
+		return ErrorNotMirrored
+	}
+
+	section := pushTargetConfigSection + "." + target.Name
+	service.cmd.RemoveConfigSection(ctx, fullPath, section)
+
+	if err := service.cmd.SetConfig(ctx, fullPath, section+".url", target.URL); err != nil {
+		return err
+	}
+
+	if target.Credential != "" {
+		if err := service.cmd.SetConfig(ctx, fullPath, section+".credential", string(target.Credential)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemovePushTarget removes a push target from mirror configuration.
+func (service *MirroredRepositories) RemovePushTarget(ctx context.Context, name RepositoryName, targetName string) (err error) {
+	defer func() {
+		service.audit.Record(ctx, actionRemovePushTarget, name.String(), map[string]string{"target": targetName}, err)
+	}()
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	if !service.cmd.IsRepository(ctx, fullPath) {
+		return ErrorNotMirrored
+	}
+
+	if !pushTargetNamePattern.MatchString(targetName) {
+		return ErrorNotFound
+	}
+
+	if err := service.cmd.RemoveConfigSection(ctx, fullPath, pushTargetConfigSection+"."+targetName); err != nil {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+// Push replicates a mirror to all its push targets with `git push --mirror` and records the result of each push
+// in mirror configuration. A failure to push to one target does not prevent pushing to others.
+func (service *MirroredRepositories) Push(ctx context.Context, name RepositoryName) error {
+	fullName := name.String()
+	targets, err := service.PushTargets(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	fullPath, err := name.ResolvePath(service.mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, target := range targets {
+		startTime := time.Now()
+
+		err := service.pushTo(ctx, fullPath, target)
+
+		section := pushTargetConfigSection + "." + target.Name
+		service.cmd.SetConfig(ctx, fullPath, section+".lastpushat", startTime.UTC().Format(time.RFC3339))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to push mirror", "repo", fullName, "target", target.Name, "error", err)
+			service.cmd.SetConfig(ctx, fullPath, section+".lasterror", err.Error())
+			failed = append(failed, target.Name)
+
+			continue
+		}
+
+		service.cmd.SetConfig(ctx, fullPath, section+".lasterror", "")
+		slog.InfoContext(ctx, "pushed mirror", "repo", fullName, "target", target.Name, "duration", time.Since(startTime))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to push %s to %s", fullName, strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func (service *MirroredRepositories) pushTo(ctx context.Context, fullPath string, target *PushTarget) error {
+	// Targets might have been added to mirror configuration bypassing AddPushTarget
+	if err := service.validatePushTargetURL(target.URL); err != nil {
+		return err
+	}
+
+	config, err := target.Credential.GitConfig(target.URL)
+	if err != nil {
+		return err
+	}
+
+	return service.cmd.PushMirror(ctx, fullPath, target.URL, config)
+}
+
+// validatePushTargetURL checks that remoteURL is either an HTTPS or SSH URL or an absolute path under the directory
+// set with EnableLocalPushTargets. Other transports, such as "ext::" or "file://", are rejected.
+func (service *MirroredRepositories) validatePushTargetURL(remoteURL string) error {
+	if filepath.IsAbs(remoteURL) {
+		if service.localPushTargetsPath == "" {
+			return fmt.Errorf("local push targets are not enabled")
+		}
+
+		if _, ok := withinDir(service.localPushTargetsPath, remoteURL); !ok {
+			return fmt.Errorf("%s is outside of %s", remoteURL, service.localPushTargetsPath)
+		}
+
+		return nil
+	}
+
+	_, err := remoteURLScheme(remoteURL)
+	return err
+}
+
+// remoteURLScheme checks that remoteURL is either an HTTPS or SSH URL and returns its scheme. SCP-like
+// URLs, such as "git@host:path", are reported as "ssh".
+func remoteURLScheme(remoteURL string) (string, error) {
+	if scpLikeURLPattern.MatchString(remoteURL) {
+		return "ssh", nil
+	}
+
+	u, err := url.Parse(remoteURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "ssh") || u.Hostname() == "" || strings.HasPrefix(u.Hostname(), "-") {
+		return "", fmt.Errorf("unsupported push target URL %s", audit.RedactURL(remoteURL))
+	}
+
+	return u.Scheme, nil
+}
+
+// parsePushTargetConfigKey splits "doppelganger-push.<name>.<field>" into target name and field.
+func parsePushTargetConfigKey(key string) (name, field string) {
+	key = strings.TrimPrefix(key, pushTargetConfigSection+".")
+
+	i := strings.LastIndex(key, ".")
+	if i < 0 {
+		return "", ""
+	}
+
+	return key[:i], strings.ToLower(key[i+1:])
+}
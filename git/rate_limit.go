@@ -0,0 +1,247 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimitReserve is the number of GitHub API requests left in the current rate limit window
+	// after which RateLimitTransport starts throttling requests.
+	DefaultRateLimitReserve = 100
+	// DefaultRateLimitMaxWait is the maximum time a throttled request is held until rate limit is reset.
+	DefaultRateLimitMaxWait = 30 * time.Second
+
+	// maxCachedResponses is the maximum number of responses kept by RateLimitTransport for conditional requests.
+	maxCachedResponses = 1000
+)
+
+// RateLimit is the GitHub API rate limit status as reported by the latest response.
+type RateLimit struct {
+	// The maximum number of requests per hour.
+	Limit int
+	// The number of requests remaining in the current rate limit window.
+	Remaining int
+	// The time the current rate limit window resets.
+	Reset time.Time
+}
+
+// Known returns true if rate limit status has been received from GitHub.
+func (rl RateLimit) Known() bool {
+	return rl.Limit > 0
+}
+
+// RateLimitError is returned when a GitHub API request could not be made because the rate limit is exhausted.
+type RateLimitError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("GitHub API rate limit is nearly exhausted, it resets at %s", e.Reset.Format(time.RFC3339))
+}
+
+type cachedResponse struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// RateLimitTransport is an http.RoundTripper that keeps track of GitHub API rate limit and makes GET requests
+// conditional using ETag of previously received responses. Responses that have not changed since the
+// previous request come back as 304 Not Modified, which are not counted against the rate limit by GitHub.
+//
+// Once there are less than Reserve requests left in the current window, RateLimitTransport serves cached
+// responses where possible and holds other requests until the window resets, if this happens within MaxWait.
+// Otherwise a *RateLimitError is returned.
+type RateLimitTransport struct {
+	Base    http.RoundTripper
+	Reserve int
+	MaxWait time.Duration
+
+	mu    sync.Mutex
+	rate  RateLimit
+	cache map[string]*cachedResponse
+}
+
+// NewRateLimitTransport returns an instance of RateLimitTransport that sends requests using base transport.
+// If base is nil, http.DefaultTransport is used.
+func NewRateLimitTransport(base http.RoundTripper) *RateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &RateLimitTransport{
+		Base:    base,
+		Reserve: DefaultRateLimitReserve,
+		MaxWait: DefaultRateLimitMaxWait,
+		cache:   make(map[string]*cachedResponse),
+	}
+}
+
+// RateLimit returns the rate limit status reported by the latest response.
+func (t *RateLimitTransport) RateLimit() RateLimit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.rate
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String() + "\x00" + req.Header.Get("Accept")
+	cacheable := req.Method == "GET" && req.Header.Get("Range") == ""
+
+	var cached *cachedResponse
+	if cacheable {
+		t.mu.Lock()
+		cached = t.cache[key]
+		t.mu.Unlock()
+	}
+
+	if wait := t.throttle(); wait > 0 {
+		if cached != nil {
+			return cached.response(req, nil), nil
+		}
+
+		if err := t.wait(req, wait); err != nil {
+			return nil, err
+		}
+	}
+
+	if cached != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.updateRateLimit(resp.Header)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		return cached.response(req, resp.Header), nil
+	case resp.StatusCode == http.StatusOK && cacheable && resp.Header.Get("ETag") != "":
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		t.store(key, &cachedResponse{
+			etag:   resp.Header.Get("ETag"),
+			header: resp.Header.Clone(),
+			body:   body,
+		})
+	}
+
+	return resp, nil
+}
+
+// throttle returns the time left until rate limit reset if there are less than t.Reserve requests remaining.
+func (t *RateLimitTransport) throttle() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.rate.Known() || t.rate.Remaining > t.Reserve {
+		return 0
+	}
+
+	return time.Until(t.rate.Reset)
+}
+
+func (t *RateLimitTransport) wait(req *http.Request, d time.Duration) error {
+	reset := time.Now().Add(d)
+	if d > t.MaxWait {
+		return &RateLimitError{Reset: reset}
+	}
+
+	slog.WarnContext(req.Context(), "GitHub API rate limit is nearly exhausted, holding request", "path", req.URL.Path, "wait", d)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func (t *RateLimitTransport) updateRateLimit(header http.Header) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.rate = RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}
+	t.mu.Unlock()
+
+	githubRateLimit.Set(float64(limit))
+	githubRateLimitRemaining.Set(float64(remaining))
+}
+
+func (t *RateLimitTransport) store(key string, resp *cachedResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.cache[key]; !ok && len(t.cache) >= maxCachedResponses {
+		// Evict an arbitrary entry, it will be re-fetched if needed
+		for k := range t.cache {
+			delete(t.cache, k)
+			break
+		}
+	}
+
+	t.cache[key] = resp
+}
+
+// response returns a copy of cached response. Header values from fresh, i.e. rate limit status, override
+// the cached ones.
+func (cached *cachedResponse) response(req *http.Request, fresh http.Header) *http.Response {
+	header := cached.header.Clone()
+	for k, v := range fresh {
+		if k == "Content-Length" || k == "Transfer-Encoding" {
+			continue
+		}
+		header[k] = v
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(cached.body)),
+		ContentLength: int64(len(cached.body)),
+		Request:       req,
+	}
+}
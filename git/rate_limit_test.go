@@ -0,0 +1,94 @@
+package git_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitTransport_ConditionalRequests(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	var requests, remaining int
+	remaining = 4999
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		remaining--
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"full_name": "user1/repo1"}`)
+	}))
+	defer srv.Close()
+
+	transport := git.NewRateLimitTransport(nil)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL + "/repos/user1/repo1")
+		require.NoError(t, err)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `{"full_name": "user1/repo1"}`, string(body))
+	}
+
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, git.RateLimit{Limit: 5000, Remaining: 4998, Reset: reset}, transport.RateLimit())
+}
+
+func TestRateLimitTransport_Exhausted(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	transport := git.NewRateLimitTransport(nil)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(srv.URL + "/user/repos")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Cached response is served without hitting API
+	resp, err = client.Get(srv.URL + "/user/repos")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Request that has not been cached fails as the rate limit resets later than MaxWait
+	_, err = client.Get(srv.URL + "/repos/user1/repo1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "rate limit")
+	}
+
+	assert.Equal(t, 1, requests)
+}
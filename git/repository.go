@@ -1,5 +1,10 @@
 package git
 
+import (
+	"fmt"
+	"time"
+)
+
 // Repository represents single git repository.
 type Repository struct {
 	// Full repository name. For GitHub repositories it's set to <user>/<repo>,
@@ -13,12 +18,57 @@ type Repository struct {
 	HTMLURL string
 	// Remote URL.
 	GitURL string
+	// Whether GitHub repository is private.
+	Private bool
 
 	// The latest commit from master.
 	LatestMasterCommit *Commit
+	// The time of the latest push to GitHub repository.
+	LastPushAt time.Time
+	// The time of the latest successful synchronization of a mirror.
+	LastSyncAt time.Time
+	// The time of the first failed synchronization of a mirror since the latest successful one.
+	FailingSince time.Time
+
+	// Disk space taken by Git LFS objects of a mirror.
+	LFSUsage ByteSize
+	// Submodules referenced by a mirror.
+	Submodules []*Submodule
+	// Remotes that a mirror is replicated to.
+	PushTargets []*PushTarget
 }
 
 // Mirrored returns true if this is a local repository mirror.
 func (repo *Repository) Mirrored() bool {
 	return repo.HTMLURL == ""
 }
+
+// LastCommitAt returns the date of the latest commit from master. For GitHub repositories, which
+// are listed without commit details, the time of the latest push is returned instead.
+func (repo *Repository) LastCommitAt() time.Time {
+	if repo.LatestMasterCommit != nil {
+		return repo.LatestMasterCommit.Date
+	}
+
+	return repo.LastPushAt
+}
+
+// ByteSize is a size of data in bytes.
+type ByteSize int64
+
+// String returns human-readable representation of size, i.e. "1.5 MiB".
+func (size ByteSize) String() string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := int64(size) / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,153 @@
+package git
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MaxRepositoryNameLength is the maximum length of repository full name.
+	MaxRepositoryNameLength = 255
+	// MaxRepositoryNamespaceDepth is the maximum number of namespaces a repository can be nested in, i.e. GitLab
+	// subgroups. GitHub repositories always have a single one, their owner.
+	MaxRepositoryNamespaceDepth = 20
+)
+
+// ErrorInvalidRepositoryName is returned if repository name is malformed or refers to a location outside of
+// the mirror directory.
+var ErrorInvalidRepositoryName = errors.New("invalid repository name")
+
+// RepositoryName is a validated full name of repository, i.e. "owner/repo". Names of repositories hosted by
+// providers that support nested namespaces, such as GitLab, may consist of more segments: "group/subgroup/repo".
+// Use ParseRepositoryName to obtain a RepositoryName from user input.
+type RepositoryName string
+
+// ParseRepositoryName validates fullName and returns it as a RepositoryName. A name consists of one or more
+// namespace segments followed by repository segment, separated by "/". Segments may only contain ASCII letters,
+// digits, ".", "_" and "-" and may not start with "-", so that they are never taken for a command-line option.
+// Namespaces also need to start with a letter or a digit, while repository segment may start with "." or "_",
+// i.e. "owner/.github", but can't be "." or "..". Leading or trailing slashes, empty segments and absolute
+// paths are rejected.
+func ParseRepositoryName(fullName string) (RepositoryName, error) {
+	if fullName == "" || len(fullName) > MaxRepositoryNameLength {
+		return "", ErrorInvalidRepositoryName
+	}
+
+	segments := strings.Split(fullName, "/")
+	if len(segments) < 2 || len(segments) > MaxRepositoryNamespaceDepth+1 {
+		return "", ErrorInvalidRepositoryName
+	}
+
+	for i, segment := range segments {
+		if !validNameSegment(segment, i < len(segments)-1) {
+			return "", ErrorInvalidRepositoryName
+		}
+	}
+
+	return RepositoryName(fullName), nil
+}
+
+// Owner returns repository namespace, i.e. "owner" for "owner/repo" or "group/subgroup" for "group/subgroup/repo".
+func (name RepositoryName) Owner() string {
+	return string(name[:strings.LastIndex(string(name), "/")])
+}
+
+// Repo returns the last segment of repository name, i.e. "repo" for "owner/repo".
+func (name RepositoryName) Repo() string {
+	return string(name[strings.LastIndex(string(name), "/")+1:])
+}
+
+// String returns repository full name.
+func (name RepositoryName) String() string {
+	return string(name)
+}
+
+// ResolvePath returns the path of repository directory under root. An error is returned if the path leads outside
+// of root following a symbolic link or is located inside of another repository, i.e. "owner/repo/refs" if "owner/repo"
+// is mirrored.
+func (name RepositoryName) ResolvePath(root string) (string, error) {
+	root = filepath.Clean(root)
+	fullPath := filepath.Join(root, filepath.FromSlash(string(name)))
+
+	if realPath, ok := withinDir(root, fullPath); !ok {
+		slog.Warn("repository path resolves outside of mirror directory", "path", fullPath, "real_path", realPath, "root", root)
+		return "", ErrorInvalidRepositoryName
+	}
+
+	for dir := filepath.Dir(fullPath); len(dir) > len(root); dir = filepath.Dir(dir) {
+		if isGitDir(dir) {
+			slog.Warn("repository path is located inside of another repository", "path", fullPath, "repository", dir)
+			return "", ErrorInvalidRepositoryName
+		}
+	}
+
+	return fullPath, nil
+}
+
+// isGitDir returns true if path looks like a bare git repository, i.e. contains HEAD file.
+func isGitDir(path string) bool {
+	fi, err := os.Stat(filepath.Join(path, "HEAD"))
+
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// withinDir returns true if fullPath, which does not need to exist, is located under root once symbolic links
+// are resolved. The resolved path is returned along with the result to be logged.
+func withinDir(root, fullPath string) (string, bool) {
+	root, fullPath = filepath.Clean(root), filepath.Clean(fullPath)
+	if rel, err := filepath.Rel(root, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fullPath, false
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// Nothing can be linked from a non-existing directory
+		return fullPath, os.IsNotExist(err)
+	}
+
+	// Only existing path components can be symlinks, so it's enough to check the deepest one
+	existing := fullPath
+	for existing != root {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return fullPath, false
+	}
+
+	if rel, err := filepath.Rel(realRoot, realPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return realPath, false
+	}
+
+	return realPath, true
+}
+
+func validNameSegment(segment string, namespace bool) bool {
+	if segment == "" || segment == "." || segment == ".." {
+		return false
+	}
+
+	for i, c := range segment {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' && i > 0:
+		case (c == '.' || c == '_') && (i > 0 || !namespace):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,142 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepositoryName(t *testing.T) {
+	for fullName, expected := range map[string]struct{ Owner, Repo string }{
+		"test/me":                 {"test", "me"},
+		"andrewslotin/.github":    {"andrewslotin", ".github"},
+		"my-org/my_repo.js":       {"my-org", "my_repo.js"},
+		"group/subgroup/repo":     {"group/subgroup", "repo"},
+		"Owner1/repo-1/_internal": {"Owner1/repo-1", "_internal"},
+	} {
+		t.Run(fullName, func(t *testing.T) {
+			name, err := git.ParseRepositoryName(fullName)
+			require.NoError(t, err)
+
+			assert.Equal(t, fullName, name.String())
+			assert.Equal(t, expected.Owner, name.Owner())
+			assert.Equal(t, expected.Repo, name.Repo())
+		})
+	}
+}
+
+func TestParseRepositoryName_Invalid(t *testing.T) {
+	for _, fullName := range []string{
+		"",
+		"test",
+		"/test/me",
+		"test/me/",
+		"test//me",
+		"../me",
+		"test/..",
+		"test/.",
+		"test/../../etc/passwd",
+		"./test/me",
+		".hidden/me",
+		"_owner/me",
+		"-owner/me",
+		"test/-me",
+		"test/--upload-pack=touch",
+		`test\me`,
+		`test/..\..\me`,
+		"test/me\x00",
+		"test/me\n",
+		"test/me me",
+		"test/r\u00e9po",
+		"test/me?x=1",
+		"~/me",
+		"C:/me",
+		strings.Repeat("a/", git.MaxRepositoryNamespaceDepth+1) + "me",
+		"test/" + strings.Repeat("a", git.MaxRepositoryNameLength),
+	} {
+		t.Run(fullName, func(t *testing.T) {
+			_, err := git.ParseRepositoryName(fullName)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+		})
+	}
+}
+
+func TestRepositoryName_ResolvePath(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	name, err := git.ParseRepositoryName("a/b")
+	require.NoError(t, err)
+
+	// Mirror does not exist yet
+	fullPath, err := name.ResolvePath(mirrorsDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(mirrorsDir, "a", "b"), fullPath)
+
+	// Symlinks within mirror directory are allowed
+	require.NoError(t, os.MkdirAll(filepath.Join(mirrorsDir, "c", "d"), 0755))
+	require.NoError(t, os.Symlink(filepath.Join(mirrorsDir, "c"), filepath.Join(mirrorsDir, "a")))
+
+	fullPath, err = name.ResolvePath(mirrorsDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(mirrorsDir, "a", "b"), fullPath)
+}
+
+func TestRepositoryName_ResolvePath_SymlinkEscape(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	outsideDir, err := ioutil.TempDir(os.TempDir(), "doppelganger-outside")
+	require.NoError(t, err)
+	defer os.RemoveAll(outsideDir)
+
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(mirrorsDir, "a")))
+	require.NoError(t, os.Mkdir(filepath.Join(mirrorsDir, "c"), 0755))
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(mirrorsDir, "c", "d")))
+
+	for _, fullName := range []string{"a/b", "a/b/c", "c/d", "c/d/e"} {
+		t.Run(fullName, func(t *testing.T) {
+			name, err := git.ParseRepositoryName(fullName)
+			require.NoError(t, err)
+
+			_, err = name.ResolvePath(mirrorsDir)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+		})
+	}
+}
+
+func TestRepositoryName_ResolvePath_InsideRepository(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(mirrorsDir, "a", "b", "objects"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(mirrorsDir, "a", "b", "HEAD"), []byte("ref: refs/heads/master\n"), 0644))
+
+	for _, fullName := range []string{"a/b/objects", "a/b/refs/heads", "a/b/c"} {
+		t.Run(fullName, func(t *testing.T) {
+			name, err := git.ParseRepositoryName(fullName)
+			require.NoError(t, err)
+
+			_, err = name.ResolvePath(mirrorsDir)
+			assert.Equal(t, git.ErrorInvalidRepositoryName, err)
+		})
+	}
+
+	// The repository itself and its siblings are resolved
+	for _, fullName := range []string{"a/b", "a/c"} {
+		name, err := git.ParseRepositoryName(fullName)
+		require.NoError(t, err)
+
+		_, err = name.ResolvePath(mirrorsDir)
+		assert.NoError(t, err, fullName)
+	}
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"sort"
+	"strings"
+)
+
+// Supported repository list orderings.
+const (
+	// SortByName sorts repositories by full name in alphabetical order.
+	SortByName = "name"
+	// SortByLastCommit puts repositories with the most recent commits first.
+	SortByLastCommit = "commit"
+	// SortByLastSync puts most recently synchronized mirrors first.
+	SortByLastSync = "sync"
+)
+
+// RepositoryQuery describes a subset of repository list to be returned to user.
+type RepositoryQuery struct {
+	// Case-insensitive substring of repository full name.
+	Name string
+	// Repository owner, matched case-insensitively.
+	Owner string
+	// One of SortByName, SortByLastCommit or SortByLastSync. Defaults to SortByName.
+	Sort string
+	// Page number starting from 1. If zero, all matching repositories are returned.
+	Page int
+	// The number of repositories per page.
+	PerPage int
+}
+
+// RepositoryPage is a page of repositories returned by QueryService.
+type RepositoryPage struct {
+	Repositories []*Repository
+	// The number of repositories matching the query.
+	Total int
+	// Owners of repositories known to service, used to populate owner filter.
+	Owners []string
+}
+
+// Apply filters and sorts repos according to the query and returns requested page along with the total number
+// of matching repositories. The original slice is not modified.
+func (q RepositoryQuery) Apply(repos []*Repository) (page []*Repository, total int) {
+	name, owner := strings.ToLower(q.Name), strings.ToLower(q.Owner)
+
+	matched := make([]*Repository, 0, len(repos))
+	for _, repo := range repos {
+		fullName := strings.ToLower(repo.FullName)
+
+		if name != "" && !strings.Contains(fullName, name) {
+			continue
+		}
+
+		if owner != "" && !strings.HasPrefix(fullName, owner+"/") {
+			continue
+		}
+
+		matched = append(matched, repo)
+	}
+
+	switch q.Sort {
+	case SortByLastCommit:
+		sort.SliceStable(matched, func(i, j int) bool {
+			return matched[i].LastCommitAt().After(matched[j].LastCommitAt())
+		})
+	case SortByLastSync:
+		sort.SliceStable(matched, func(i, j int) bool {
+			return matched[i].LastSyncAt.After(matched[j].LastSyncAt)
+		})
+	default:
+		sort.SliceStable(matched, func(i, j int) bool {
+			return strings.ToLower(matched[i].FullName) < strings.ToLower(matched[j].FullName)
+		})
+	}
+
+	total = len(matched)
+	if q.Page < 1 || q.PerPage < 1 {
+		return matched, total
+	}
+
+	start := (q.Page - 1) * q.PerPage
+	if start >= total {
+		return nil, total
+	}
+
+	end := start + q.PerPage
+	if end > total {
+		end = total
+	}
+
+	return matched[start:end], total
+}
+
+// Owners returns a sorted list of distinct repository owners.
+func Owners(repos []*Repository) []string {
+	seen := make(map[string]struct{})
+
+	var owners []string
+	for _, repo := range repos {
+		i := strings.Index(repo.FullName, "/")
+		if i < 0 {
+			continue
+		}
+
+		owner := repo.FullName[:i]
+		if _, ok := seen[owner]; ok {
+			continue
+		}
+		seen[owner] = struct{}{}
+
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	return owners
+}
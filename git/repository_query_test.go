@@ -0,0 +1,82 @@
+package git_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryQuery_Apply(t *testing.T) {
+	now := time.Now()
+
+	repos := []*git.Repository{
+		{FullName: "user2/tools", LastSyncAt: now.Add(-time.Hour), LastPushAt: now.Add(-time.Minute)},
+		{FullName: "user1/repo2", LastSyncAt: now, LatestMasterCommit: &git.Commit{Date: now.Add(-2 * time.Hour)}},
+		{FullName: "User1/Repo1", LastSyncAt: now.Add(-2 * time.Hour), LatestMasterCommit: &git.Commit{Date: now.Add(-time.Hour)}},
+	}
+
+	names := func(repos []*git.Repository) []string {
+		var result []string
+		for _, repo := range repos {
+			result = append(result, repo.FullName)
+		}
+
+		return result
+	}
+
+	examples := map[string]struct {
+		Query         git.RepositoryQuery
+		ExpectedNames []string
+		ExpectedTotal int
+	}{
+		"default": {
+			Query:         git.RepositoryQuery{},
+			ExpectedNames: []string{"User1/Repo1", "user1/repo2", "user2/tools"},
+			ExpectedTotal: 3,
+		},
+		"name search": {
+			Query:         git.RepositoryQuery{Name: "REPO"},
+			ExpectedNames: []string{"User1/Repo1", "user1/repo2"},
+			ExpectedTotal: 2,
+		},
+		"owner filter": {
+			Query:         git.RepositoryQuery{Owner: "user2"},
+			ExpectedNames: []string{"user2/tools"},
+			ExpectedTotal: 1,
+		},
+		"sort by last commit": {
+			Query:         git.RepositoryQuery{Sort: git.SortByLastCommit},
+			ExpectedNames: []string{"user2/tools", "User1/Repo1", "user1/repo2"},
+			ExpectedTotal: 3,
+		},
+		"sort by last sync": {
+			Query:         git.RepositoryQuery{Sort: git.SortByLastSync},
+			ExpectedNames: []string{"user1/repo2", "user2/tools", "User1/Repo1"},
+			ExpectedTotal: 3,
+		},
+		"second page": {
+			Query:         git.RepositoryQuery{Page: 2, PerPage: 2},
+			ExpectedNames: []string{"user2/tools"},
+			ExpectedTotal: 3,
+		},
+		"page out of range": {
+			Query:         git.RepositoryQuery{Page: 3, PerPage: 2},
+			ExpectedTotal: 3,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			page, total := example.Query.Apply(repos)
+			assert.Equal(t, example.ExpectedNames, names(page))
+			assert.Equal(t, example.ExpectedTotal, total)
+		})
+	}
+}
+
+func TestOwners(t *testing.T) {
+	repos := []*git.Repository{{FullName: "user2/tools"}, {FullName: "user1/repo2"}, {FullName: "user1/repo1"}}
+	assert.Equal(t, []string{"user1", "user2"}, git.Owners(repos))
+}
@@ -1,13 +1,18 @@
 package git
 
-import "golang.org/x/net/context"
+import (
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"golang.org/x/net/context"
+)
 
 // RepositoryService is a type that wraps All and Get methods.
 //
 // Repository service is used to list and lookup repositories. Two implementations
 type RepositoryService interface {
 	All(ctx context.Context) ([]*Repository, error)
-	Get(ctx context.Context, name string) (*Repository, error)
+	Get(ctx context.Context, name RepositoryName) (*Repository, error)
 }
 
 // MirrorService is a type that extends RepositoryService adding two more methods: Create and Update.
@@ -16,8 +21,8 @@ type RepositoryService interface {
 type MirrorService interface {
 	RepositoryService
 
-	Create(ctx context.Context, name, url string) error
-	Update(ctx context.Context, name string) error
+	Create(ctx context.Context, name RepositoryName, url string) error
+	Update(ctx context.Context, name RepositoryName) error
 }
 
 // TrackingService is a type that wraps Track method.
@@ -26,3 +31,44 @@ type MirrorService interface {
 type TrackingService interface {
 	Track(ctx context.Context, name, callbackURL string) error
 }
+
+// PushService is a type that wraps PushTargets, AddPushTarget and RemovePushTarget methods.
+//
+// Push service is used to configure secondary remotes that a mirror is replicated to.
+type PushService interface {
+	PushTargets(ctx context.Context, name RepositoryName) ([]*PushTarget, error)
+	AddPushTarget(ctx context.Context, name RepositoryName, target PushTarget) error
+	RemovePushTarget(ctx context.Context, name RepositoryName, targetName string) error
+}
+
+// LFSService is a type that extends RepositoryService adding LFSStore method.
+//
+// LFS service is used to access Git LFS objects that belong to repository mirrors.
+type LFSService interface {
+	RepositoryService
+
+	LFSStore(name string) (*lfs.Store, error)
+}
+
+// RateLimitService is a type that wraps RateLimit method.
+//
+// Rate limit service reports the status of API rate limit of a remote repository service.
+type RateLimitService interface {
+	RateLimit() RateLimit
+}
+
+// QueryService is a type that wraps Query method.
+//
+// Query service filters, sorts and paginates repositories on its side instead of returning the full list.
+type QueryService interface {
+	Query(ctx context.Context, q RepositoryQuery) (RepositoryPage, error)
+}
+
+// CacheService is a type that wraps methods of a repository service that caches its results.
+//
+// Cache service is used to report the freshness of cached data and to refresh it on demand.
+type CacheService interface {
+	Refresh(ctx context.Context) error
+	UpdatedAt() time.Time
+	Stale() bool
+}
@@ -0,0 +1,238 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// githubHost is the host name of GitHub used to recognize submodules that can be mirrored.
+const githubHost = "github.com"
+
+// Submodule represents a Git submodule referenced from .gitmodules file of a repository.
+type Submodule struct {
+	// The name of submodule section in .gitmodules.
+	Name string
+	// Path to submodule inside the parent repository.
+	Path string
+	// Remote URL as it is specified in .gitmodules.
+	URL string
+	// Full name of GitHub repository that submodule refers to. Empty if submodule is hosted elsewhere.
+	FullName string
+	// Mirrored is true if there is a local mirror of submodule repository.
+	Mirrored bool
+	// Submodules of a mirrored submodule.
+	Submodules []*Submodule
+}
+
+// ParseGitmodules parses the content of .gitmodules file and returns the list of submodules it declares.
+// Submodules without path or url are omitted.
+func ParseGitmodules(data []byte) []*Submodule {
+	var (
+		submodules []*Submodule
+		current    *Submodule
+	)
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			current = nil
+
+			section := strings.TrimSpace(strings.Trim(line, "[]"))
+			if !strings.HasPrefix(section, "submodule") {
+				continue
+			}
+
+			name := strings.TrimSpace(strings.TrimPrefix(section, "submodule"))
+			current = &Submodule{Name: strings.Trim(name, `"`)}
+			submodules = append(submodules, current)
+
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch key, value := strings.ToLower(strings.TrimSpace(fields[0])), strings.Trim(strings.TrimSpace(fields[1]), `"`); key {
+		case "path":
+			current.Path = value
+		case "url":
+			current.URL = value
+		}
+	}
+
+	valid := submodules[:0]
+	for _, sm := range submodules {
+		if sm.Path != "" && sm.URL != "" {
+			valid = append(valid, sm)
+		}
+	}
+
+	return valid
+}
+
+// RepositoryNameFromURL returns the full name of GitHub repository referenced by submodule URL. Relative
+// URLs, such as ../other.git, are resolved against parentName. The second value is false if URL does not
+// point to GitHub.
+func RepositoryNameFromURL(submoduleURL, parentName string) (string, bool) {
+	var repoPath string
+
+	switch {
+	case strings.HasPrefix(submoduleURL, "./") || strings.HasPrefix(submoduleURL, "../"):
+		repoPath = path.Join("/", parentName, submoduleURL)
+	case strings.HasPrefix(submoduleURL, "git@"+githubHost+":"):
+		repoPath = strings.TrimPrefix(submoduleURL, "git@"+githubHost+":")
+	default:
+		u, err := url.Parse(submoduleURL)
+		if err != nil || u.Hostname() != githubHost {
+			return "", false
+		}
+
+		switch u.Scheme {
+		case "https", "http", "git", "ssh":
+		default:
+			return "", false
+		}
+
+		repoPath = u.Path
+	}
+
+	fields := strings.Split(strings.Trim(path.Clean("/"+repoPath), "/"), "/")
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" || fields[0] == ".." {
+		return "", false
+	}
+
+	name, err := ParseRepositoryName(fields[0] + "/" + strings.TrimSuffix(fields[1], ".git"))
+	if err != nil {
+		return "", false
+	}
+
+	return name.String(), true
+}
+
+// SubmoduleMirrors is a type that implements MirrorService and extends MirroredRepositories with submodule
+// awareness. Each time a mirror is created or updated SubmoduleMirrors updates mirrors of repositories referenced
+// as its submodules. If autoMirror is enabled, missing submodule mirrors are created using GitHub repository
+// details obtained from source.
+type SubmoduleMirrors struct {
+	*MirroredRepositories
+
+	mirrors    MirrorService
+	source     RepositoryService
+	autoMirror bool
+	allowed    func(ctx context.Context, fullName string) bool
+}
+
+// NewSubmoduleMirrors creates and initializes an instance of SubmoduleMirrors.
+func NewSubmoduleMirrors(mirrors *MirroredRepositories, source RepositoryService, autoMirror bool) *SubmoduleMirrors {
+	return &SubmoduleMirrors{
+		MirroredRepositories: mirrors,
+		mirrors:              mirrors,
+		source:               source,
+		autoMirror:           autoMirror,
+	}
+}
+
+// SetMirrorService makes SubmoduleMirrors create and update submodule mirrors with mirrors, i.e. a wrapper of
+// MirroredRepositories that notifies peers. Since submodules are synced recursively by SubmoduleMirrors itself,
+// mirrors must not wrap SubmoduleMirrors.
+func (service *SubmoduleMirrors) SetMirrorService(mirrors MirrorService) {
+	service.mirrors = mirrors
+}
+
+// EnableAccessCheck makes SubmoduleMirrors skip the creation of missing submodule mirrors for repositories for
+// which allowed returns false, i.e. if the caller is not permitted to mirror them.
+func (service *SubmoduleMirrors) EnableAccessCheck(allowed func(ctx context.Context, fullName string) bool) {
+	service.allowed = allowed
+}
+
+// Create creates a mirror of repository and then mirrors its submodules.
+func (service *SubmoduleMirrors) Create(ctx context.Context, name RepositoryName, gitURL string) error {
+	if err := service.MirroredRepositories.Create(ctx, name, gitURL); err != nil {
+		return err
+	}
+
+	service.syncSubmodules(ctx, name.String(), map[string]struct{}{name.String(): {}})
+
+	return nil
+}
+
+// Update updates an existing mirror and mirrors of its submodules.
+func (service *SubmoduleMirrors) Update(ctx context.Context, name RepositoryName) error {
+	if err := service.MirroredRepositories.Update(ctx, name); err != nil {
+		return err
+	}
+
+	service.syncSubmodules(ctx, name.String(), map[string]struct{}{name.String(): {}})
+
+	return nil
+}
+
+// syncSubmodules updates existing submodule mirrors and creates missing ones if autoMirror is set. Failing
+// to sync a submodule does not fail the parent, so errors are only logged. Repositories listed in visited
+// are skipped to avoid endless loops in case of circular dependencies.
+func (service *SubmoduleMirrors) syncSubmodules(ctx context.Context, fullName string, visited map[string]struct{}) {
+	for _, sm := range service.MirroredRepositories.submodules(ctx, fullName) {
+		name, err := ParseRepositoryName(sm.FullName)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := visited[sm.FullName]; ok {
+			continue
+		}
+		visited[sm.FullName] = struct{}{}
+
+		switch {
+		case sm.Mirrored:
+			if err := service.mirrors.Update(ctx, name); err != nil {
+				slog.WarnContext(ctx, "failed to update submodule", "repo", fullName, "submodule", sm.FullName, "error", err)
+				continue
+			}
+			slog.InfoContext(ctx, "updated submodule", "repo", fullName, "submodule", sm.FullName)
+		case service.autoMirror:
+			if service.allowed != nil && !service.allowed(ctx, sm.FullName) {
+				slog.WarnContext(ctx, "not allowed to mirror submodule", "repo", fullName, "submodule", sm.FullName)
+				continue
+			}
+
+			repo, err := service.source.Get(ctx, name)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to find submodule", "repo", fullName, "submodule", sm.FullName, "error", err)
+				continue
+			}
+
+			repoName, err := ParseRepositoryName(repo.FullName)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to find submodule", "repo", fullName, "submodule", sm.FullName, "error", err)
+				continue
+			}
+
+			if err := service.mirrors.Create(ctx, repoName, repo.GitURL); err != nil {
+				slog.WarnContext(ctx, "failed to mirror submodule", "repo", fullName, "submodule", sm.FullName, "error", err)
+				continue
+			}
+			slog.InfoContext(ctx, "mirrored submodule", "repo", fullName, "submodule", sm.FullName)
+		default:
+			continue
+		}
+
+		service.syncSubmodules(ctx, sm.FullName, visited)
+	}
+}
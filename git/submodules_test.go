@@ -0,0 +1,268 @@
+package git_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type repositoryServiceMock struct {
+	mock.Mock
+}
+
+func (service *repositoryServiceMock) All(ctx context.Context) ([]*git.Repository, error) {
+	args := service.Mock.Called()
+	repos, _ := args.Get(0).([]*git.Repository)
+	return repos, args.Error(1)
+}
+
+func (service *repositoryServiceMock) Get(ctx context.Context, name git.RepositoryName) (*git.Repository, error) {
+	args := service.Mock.Called(name.String())
+	repo, _ := args.Get(0).(*git.Repository)
+	return repo, args.Error(1)
+}
+
+type mirrorServiceMock struct {
+	repositoryServiceMock
+}
+
+func (service *mirrorServiceMock) Create(ctx context.Context, name git.RepositoryName, url string) error {
+	return service.Mock.Called(name.String(), url).Error(0)
+}
+
+func (service *mirrorServiceMock) Update(ctx context.Context, name git.RepositoryName) error {
+	return service.Mock.Called(name.String()).Error(0)
+}
+
+func TestParseGitmodules(t *testing.T) {
+	submodules := git.ParseGitmodules([]byte(`
+[submodule "vendor/lib"]
+	path = vendor/lib
+	url = https://github.com/user1/lib.git
+# comment
+[core]
+	bare = false
+[submodule "docs"]
+	path = docs
+	url = ../docs.git
+[submodule "broken"]
+	path = broken
+`))
+
+	if assert.Len(t, submodules, 2) {
+		assert.Equal(t, "vendor/lib", submodules[0].Name)
+		assert.Equal(t, "vendor/lib", submodules[0].Path)
+		assert.Equal(t, "https://github.com/user1/lib.git", submodules[0].URL)
+
+		assert.Equal(t, "docs", submodules[1].Name)
+		assert.Equal(t, "../docs.git", submodules[1].URL)
+	}
+}
+
+func TestRepositoryNameFromURL(t *testing.T) {
+	for submoduleURL, expected := range map[string]string{
+		"https://github.com/user2/lib.git":    "user2/lib",
+		"https://github.com/user2/lib":        "user2/lib",
+		"git://github.com/user2/lib.git":      "user2/lib",
+		"git@github.com:user2/lib.git":        "user2/lib",
+		"ssh://git@github.com/user2/lib.git":  "user2/lib",
+		"../lib.git":                          "user1/lib",
+		"../../user2/lib.git":                 "user2/lib",
+		"https://github.com/user2/lib.git/./": "user2/lib",
+	} {
+		name, ok := git.RepositoryNameFromURL(submoduleURL, "user1/repo1")
+		if assert.True(t, ok, submoduleURL) {
+			assert.Equal(t, expected, name, submoduleURL)
+		}
+	}
+}
+
+func TestRepositoryNameFromURL_NotGitHub(t *testing.T) {
+	for _, submoduleURL := range []string{
+		"https://gitlab.com/user2/lib.git",
+		"https://go.googlesource.com/net",
+		"git@bitbucket.org:user2/lib.git",
+		"file:///srv/git/lib.git",
+		"./lib.git",
+		"../../../lib.git",
+	} {
+		_, ok := git.RepositoryNameFromURL(submoduleURL, "user1/repo1")
+		assert.False(t, ok, submoduleURL)
+	}
+}
+
+func TestSubmoduleMirrors_Update(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	parentPath, mirroredPath, missingPath := filepath.Join(mirrorsDir, "user1", "repo1"), filepath.Join(mirrorsDir, "user1", "lib"), filepath.Join(mirrorsDir, "user2", "tools")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", parentPath).Return(nil)
+	cmd.On("IsRepository", parentPath).Return(true)
+	cmd.On("ConfigEntries", parentPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", parentPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", parentPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+	cmd.On("CurrentBranch", parentPath).Return("master")
+	cmd.On("ConfigValues", parentPath, git.TrackedBranchConfigKey).Return([]string{"release"})
+	cmd.On("ReadFile", parentPath, "refs/heads/master", ".gitmodules").Return([]byte(`[submodule "lib"]
+	path = lib
+	url = ../lib.git
+[submodule "external"]
+	path = external
+	url = https://example.com/external.git
+`), nil)
+	cmd.On("ReadFile", parentPath, "refs/heads/release", ".gitmodules").Return([]byte(`[submodule "tools"]
+	path = tools
+	url = git@github.com:user2/tools.git
+`), nil)
+	cmd.On("IsRepository", mirroredPath).Return(true)
+	cmd.On("IsRepository", missingPath).Return(false)
+
+	// Mirrored submodule is updated along with the parent
+	cmd.On("UpdateRemote", mirroredPath).Return(nil)
+	cmd.On("ConfigEntries", mirroredPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", mirroredPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", mirroredPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+	cmd.On("CurrentBranch", mirroredPath).Return("master")
+	cmd.On("ConfigValues", mirroredPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("ReadFile", mirroredPath, "refs/heads/master", ".gitmodules").Return(nil, git.ErrorNotFound)
+
+	// Missing submodule is mirrored
+	source := &repositoryServiceMock{}
+	source.On("Get", "user2/tools").Return(&git.Repository{FullName: "user2/tools", GitURL: "git@github.com:user2/tools.git"}, nil)
+
+	cmd.On("CloneMirror", "git@github.com:user2/tools.git", mock.MatchedBy(isTempClonePath(missingPath))).
+		Return(nil).
+		Run(fakeClone)
+	cmd.On("ConfigValues", missingPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", missingPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+	cmd.On("CurrentBranch", missingPath).Return("master")
+	cmd.On("ConfigValues", missingPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("ReadFile", missingPath, "refs/heads/master", ".gitmodules").Return(nil, git.ErrorNotFound)
+
+	mirrors := git.NewSubmoduleMirrors(git.NewMirroredRepositories(mirrorsDir, cmd), source, true)
+	require.NoError(t, mirrors.Update(context.Background(), "user1/repo1"))
+
+	cmd.AssertExpectations(t)
+	source.AssertExpectations(t)
+}
+
+func TestSubmoduleMirrors_Update_NoAutoMirror(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	parentPath, missingPath := filepath.Join(mirrorsDir, "user1", "repo1"), filepath.Join(mirrorsDir, "user1", "lib")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", parentPath).Return(nil)
+	cmd.On("IsRepository", parentPath).Return(true)
+	cmd.On("ConfigEntries", parentPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", parentPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", parentPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+	cmd.On("CurrentBranch", parentPath).Return("master")
+	cmd.On("ConfigValues", parentPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("ReadFile", parentPath, "refs/heads/master", ".gitmodules").Return([]byte(`[submodule "lib"]
+	path = lib
+	url = ../lib.git
+`), nil)
+	cmd.On("IsRepository", missingPath).Return(false)
+
+	source := &repositoryServiceMock{}
+
+	mirrors := git.NewSubmoduleMirrors(git.NewMirroredRepositories(mirrorsDir, cmd), source, false)
+	require.NoError(t, mirrors.Update(context.Background(), "user1/repo1"))
+
+	cmd.AssertExpectations(t)
+	source.AssertNotCalled(t, "Get", "user1/lib")
+}
+
+func TestSubmoduleMirrors_Update_MirrorService(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	parentPath, mirroredPath, missingPath := filepath.Join(mirrorsDir, "user1", "repo1"), filepath.Join(mirrorsDir, "user1", "lib"), filepath.Join(mirrorsDir, "user2", "tools")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", parentPath).Return(nil)
+	cmd.On("IsRepository", parentPath).Return(true)
+	cmd.On("ConfigEntries", parentPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", parentPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", parentPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+	cmd.On("CurrentBranch", parentPath).Return("master")
+	cmd.On("ConfigValues", parentPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("ReadFile", parentPath, "refs/heads/master", ".gitmodules").Return([]byte(`[submodule "lib"]
+	path = lib
+	url = ../lib.git
+[submodule "tools"]
+	path = tools
+	url = git@github.com:user2/tools.git
+`), nil)
+	cmd.On("IsRepository", mirroredPath).Return(true)
+	cmd.On("IsRepository", missingPath).Return(false)
+	cmd.On("ReadFile", mirroredPath, "refs/heads/master", ".gitmodules").Return(nil, git.ErrorNotFound)
+	cmd.On("ReadFile", missingPath, "refs/heads/master", ".gitmodules").Return(nil, git.ErrorNotFound)
+	cmd.On("CurrentBranch", mirroredPath).Return("master")
+	cmd.On("ConfigValues", mirroredPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("CurrentBranch", missingPath).Return("master")
+	cmd.On("ConfigValues", missingPath, git.TrackedBranchConfigKey).Return(nil)
+
+	source := &repositoryServiceMock{}
+	source.On("Get", "user2/tools").Return(&git.Repository{FullName: "user2/tools", GitURL: "git@github.com:user2/tools.git"}, nil)
+
+	// Submodule mirrors are created and updated with the wrapper instead of MirroredRepositories
+	wrapper := &mirrorServiceMock{}
+	wrapper.On("Update", "user1/lib").Return(nil)
+	wrapper.On("Create", "user2/tools", "git@github.com:user2/tools.git").Return(nil)
+
+	mirrors := git.NewSubmoduleMirrors(git.NewMirroredRepositories(mirrorsDir, cmd), source, true)
+	mirrors.SetMirrorService(wrapper)
+	require.NoError(t, mirrors.Update(context.Background(), "user1/repo1"))
+
+	wrapper.AssertExpectations(t)
+	source.AssertExpectations(t)
+	cmd.AssertNotCalled(t, "UpdateRemote", mirroredPath)
+	cmd.AssertNotCalled(t, "CloneMirror", mock.Anything, mock.Anything)
+}
+
+func TestSubmoduleMirrors_Update_AccessCheck(t *testing.T) {
+	mirrorsDir, teardown, err := setupMirrorsDir()
+	require.NoError(t, err)
+	defer teardown()
+
+	parentPath, missingPath := filepath.Join(mirrorsDir, "user1", "repo1"), filepath.Join(mirrorsDir, "user2", "tools")
+
+	cmd := &commandMock{}
+	cmd.On("UpdateRemote", parentPath).Return(nil)
+	cmd.On("IsRepository", parentPath).Return(true)
+	cmd.On("ConfigEntries", parentPath, `^doppelganger-push\.`).Return(nil)
+	cmd.On("ConfigValues", parentPath, git.FailingSinceConfigKey).Return(nil)
+	cmd.On("SetConfig", parentPath, git.LastSyncAtConfigKey, mock.Anything).Return(nil)
+	cmd.On("CurrentBranch", parentPath).Return("master")
+	cmd.On("ConfigValues", parentPath, git.TrackedBranchConfigKey).Return(nil)
+	cmd.On("ReadFile", parentPath, "refs/heads/master", ".gitmodules").Return([]byte(`[submodule "tools"]
+	path = tools
+	url = git@github.com:user2/tools.git
+`), nil)
+	cmd.On("IsRepository", missingPath).Return(false)
+
+	source := &repositoryServiceMock{}
+
+	mirrors := git.NewSubmoduleMirrors(git.NewMirroredRepositories(mirrorsDir, cmd), source, true)
+	mirrors.EnableAccessCheck(func(ctx context.Context, fullName string) bool {
+		return fullName != "user2/tools"
+	})
+	require.NoError(t, mirrors.Update(context.Background(), "user1/repo1"))
+
+	cmd.AssertExpectations(t)
+	source.AssertNotCalled(t, "Get", "user2/tools")
+	cmd.AssertNotCalled(t, "CloneMirror", mock.Anything, mock.Anything)
+}
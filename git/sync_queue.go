@@ -0,0 +1,148 @@
+package git
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/logging"
+)
+
+// SyncFunc is a function that synchronizes a mirror.
+type SyncFunc func(ctx context.Context) error
+
+type syncJob struct {
+	name  string
+	fn    SyncFunc
+	attrs []interface{}
+}
+
+// SyncQueue runs mirror synchronization jobs in background. There is at most one job per repository
+// waiting in the queue at any moment, so that a burst of change notifications results in a single update.
+type SyncQueue struct {
+	jobs chan syncJob
+
+	mu      sync.Mutex
+	queued  map[string]struct{}
+	running map[string]time.Time
+	workers int
+}
+
+// NewSyncQueue creates and initializes a new queue that can hold up to size pending jobs.
+func NewSyncQueue(size int) *SyncQueue {
+	return &SyncQueue{
+		jobs:    make(chan syncJob, size),
+		queued:  make(map[string]struct{}),
+		running: make(map[string]time.Time),
+	}
+}
+
+// Enqueue schedules fn to be run for repository name. It returns false if there is already a job
+// for this repository waiting in the queue or the queue is full. The job is given an ID and logs with
+// attributes stored in ctx, i.e. the ID of request that has triggered it.
+func (q *SyncQueue) Enqueue(ctx context.Context, name string, fn SyncFunc) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.queued[name]; ok {
+		return false
+	}
+
+	select {
+	case q.jobs <- syncJob{name: name, fn: fn, attrs: logging.Attrs(ctx)}:
+		q.queued[name] = struct{}{}
+		return true
+	default:
+		slog.WarnContext(ctx, "sync queue is full, dropping job", "repo", name)
+		return false
+	}
+}
+
+// Len returns the number of jobs waiting in the queue.
+func (q *SyncQueue) Len() int {
+	return len(q.jobs)
+}
+
+// Run starts workers that process queued jobs and blocks until ctx is cancelled.
+func (q *SyncQueue) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Check returns an error if the queue is not being processed while there are pending jobs, or if any
+// of running jobs takes longer than maxDuration.
+func (q *SyncQueue) Check(maxDuration time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.workers == 0 && len(q.jobs) > 0 {
+		return fmt.Errorf("%d job(s) queued, but no workers running", len(q.jobs))
+	}
+
+	var stuck []string
+	for name, startTime := range q.running {
+		if d := time.Since(startTime); d > maxDuration {
+			stuck = append(stuck, fmt.Sprintf("%s (%s)", name, d.Truncate(time.Second)))
+		}
+	}
+
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		return fmt.Errorf("sync is running for too long: %s", strings.Join(stuck, ", "))
+	}
+
+	return nil
+}
+
+func (q *SyncQueue) work(ctx context.Context) {
+	q.mu.Lock()
+	q.workers++
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.workers--
+		q.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.jobs:
+			startTime := time.Now()
+
+			q.mu.Lock()
+			delete(q.queued, job.name)
+			q.running[job.name] = startTime
+			q.mu.Unlock()
+
+			jobCtx := logging.With(ctx, append(job.attrs, "job_id", logging.NewID(), "repo", job.name)...)
+			err := job.fn(jobCtx)
+
+			q.mu.Lock()
+			delete(q.running, job.name)
+			q.mu.Unlock()
+
+			if err != nil {
+				slog.WarnContext(jobCtx, "failed to sync mirror", "error", err, "duration", time.Since(startTime))
+				continue
+			}
+			slog.InfoContext(jobCtx, "synced mirror", "duration", time.Since(startTime))
+		}
+	}
+}
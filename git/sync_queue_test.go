@@ -0,0 +1,129 @@
+package git_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestSyncQueue_Deduplication(t *testing.T) {
+	q := git.NewSyncQueue(10)
+
+	noop := func(ctx context.Context) error { return nil }
+	assert.True(t, q.Enqueue(context.Background(), "a/b", noop))
+	assert.False(t, q.Enqueue(context.Background(), "a/b", noop), "should not queue the same repository twice")
+	assert.True(t, q.Enqueue(context.Background(), "a/c", noop))
+	assert.Equal(t, 2, q.Len())
+}
+
+func TestSyncQueue_Full(t *testing.T) {
+	q := git.NewSyncQueue(1)
+
+	noop := func(ctx context.Context) error { return nil }
+	assert.True(t, q.Enqueue(context.Background(), "a/b", noop))
+	assert.False(t, q.Enqueue(context.Background(), "a/c", noop))
+}
+
+func TestSyncQueue_Run(t *testing.T) {
+	q := git.NewSyncQueue(10)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	synced := make(chan string, 2)
+	for _, name := range []string{"a/b", "a/c"} {
+		name := name
+		q.Enqueue(context.Background(), name, func(ctx context.Context) error {
+			defer wg.Done()
+			synced <- name
+			return nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, 2)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("jobs were not processed")
+	}
+
+	close(synced)
+	var names []string
+	for name := range synced {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{"a/b", "a/c"}, names)
+
+	// Repository can be queued again once its job has been picked up
+	assert.True(t, q.Enqueue(context.Background(), "a/b", func(ctx context.Context) error { return nil }))
+}
+
+func TestSyncQueue_LoggingAttrs(t *testing.T) {
+	q := git.NewSyncQueue(10)
+
+	attrs := make(chan []interface{}, 1)
+	q.Enqueue(logging.With(context.Background(), "request_id", "req1"), "a/b", func(ctx context.Context) error {
+		attrs <- logging.Attrs(ctx)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, 1)
+
+	select {
+	case a := <-attrs:
+		require.Len(t, a, 6)
+		assert.Equal(t, []interface{}{"request_id", "req1"}, a[:2])
+		assert.Equal(t, "job_id", a[2])
+		assert.NotEmpty(t, a[3])
+		assert.Equal(t, []interface{}{"repo", "a/b"}, a[4:])
+	case <-time.After(time.Second):
+		t.Fatal("job was not processed")
+	}
+}
+
+func TestSyncQueue_Check_NoWorkers(t *testing.T) {
+	q := git.NewSyncQueue(10)
+	assert.NoError(t, q.Check(time.Minute))
+
+	q.Enqueue(context.Background(), "a/b", func(ctx context.Context) error { return nil })
+	assert.Error(t, q.Check(time.Minute))
+}
+
+func TestSyncQueue_Check_StuckJob(t *testing.T) {
+	q := git.NewSyncQueue(10)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	q.Enqueue(context.Background(), "a/b", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, 1)
+
+	<-started
+	defer close(release)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, q.Check(time.Minute))
+	assert.Error(t, q.Check(time.Millisecond))
+}
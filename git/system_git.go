@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/andrewslotin/doppelganger/git/lfs"
 	"golang.org/x/net/context"
 )
 
@@ -20,6 +24,10 @@ const (
 
 	gitPrettyFormat = "%H\n%an\n%cn\n%cd\n%s"
 	gitDateFormat   = "format:%FT%T%z"
+
+	// gitWaitDelay is the time to wait for output of a cancelled git command. Commands like `git remote update`
+	// spawn subprocesses that keep the output open after git itself has been killed.
+	gitWaitDelay = 5 * time.Second
 )
 
 var (
@@ -40,47 +48,51 @@ func SystemGit() (systemGit, error) {
 	return systemGit(cmd), nil
 }
 
-// IsRepository checks if there if `path` is a git repository.
+// Version returns the version of git, i.e. "git version 2.20.1".
+func (gitCmd systemGit) Version(ctx context.Context) (string, error) {
+	output, err := gitCmd.exec(ctx, "", "version")
+	if err != nil {
+		return "", fmt.Errorf("failed to run git: %s", strings.TrimSpace(err.Error()))
+	}
+
+	return string(output), nil
+}
+
+// IsRepository checks if `path` is the top-level directory of a bare git repository. Directories inside of
+// a repository, such as refs/ or objects/, are not considered to be repositories.
 func (gitCmd systemGit) IsRepository(ctx context.Context, path string) bool {
 	if fileInfo, err := os.Stat(path); err != nil {
 		if os.IsNotExist(err) {
 			return false
 		}
 
-		log.Printf("[WARN] failed to stat %s (%s)", path, err)
+		slog.WarnContext(ctx, "failed to stat repository", "path", path, "error", err)
 		return false
 	} else if !fileInfo.IsDir() {
 		return false
 	}
 
-	output, err := gitCmd.exec(ctx, path, "rev-parse", "--is-inside-git-dir")
+	output, err := gitCmd.exec(ctx, path, "rev-parse", "--git-dir")
 	if err == errUnexpectedExit {
-		log.Printf("[WARN] git rev-parse --is-inside-git-dir returned %s for %s (%s)", err, path, string(output))
+		slog.WarnContext(ctx, "git rev-parse --git-dir failed", "path", path, "error", err)
 	} else if err != nil {
 		return false
 	}
 
-	switch string(output) {
-	case "true":
-		return true
-	case "false":
-		return false
-	default:
-		log.Printf("[WARN] git rev-parse --is-inside-git-dir returned unexpected output for %s: %q", path, string(output))
-		return false
-	}
+	// git reports the path relative to the working directory, which is "." for the top-level directory
+	return string(output) == "."
 }
 
 // CurrentBranch returns the name of current branch in `path`.
 func (gitCmd systemGit) CurrentBranch(ctx context.Context, path string) string {
 	refName, err := gitCmd.exec(ctx, path, "symbolic-ref", "HEAD")
 	if err != nil {
-		log.Printf("[WARN] git symbolic-ref HEAD returned %s for %s (%s)", err, path, string(refName))
+		slog.WarnContext(ctx, "git symbolic-ref HEAD failed", "path", path, "error", gitError(err))
 		return DefaultMaster
 	}
 
 	if !bytes.HasPrefix(refName, []byte("refs/heads/")) {
-		log.Printf("[WARN] unexpected reference name for %s (%q)", path, refName)
+		slog.WarnContext(ctx, "unexpected reference name", "path", path, "ref", string(refName))
 		return DefaultMaster
 	}
 
@@ -91,20 +103,20 @@ func (gitCmd systemGit) CurrentBranch(ctx context.Context, path string) string {
 func (gitCmd systemGit) LastCommit(ctx context.Context, path string) (commit Commit, err error) {
 	output, err := gitCmd.exec(ctx, path, "log", "-n", "1", "--pretty="+gitPrettyFormat, "--date="+gitDateFormat)
 	if err != nil {
-		log.Printf("[WARN] git log returned error %s for %s (%s)", err, path, string(output))
+		slog.WarnContext(ctx, "git log failed", "path", path, "error", gitError(err))
 		return commit, nil
 	}
 
 	lines := strings.SplitN(string(output), "\n", gitPrettyFormatFieldsNum)
 	if len(lines) < gitPrettyFormatFieldsNum {
-		log.Printf("[WARN] unexpected output from git log for %s (%s)", path, string(output))
+		slog.WarnContext(ctx, "unexpected output from git log", "path", path, "output", string(output))
 		return commit, nil
 	}
 
 	commit.SHA, commit.Author, commit.Committer, commit.Message = lines[0], lines[1], lines[2], lines[4]
 	commit.Date, err = time.Parse(GitCommandDateLayout, lines[3])
 	if err != nil {
-		log.Printf("[WARN] unexpected date format from git log for %s (%s)", path, lines[3])
+		slog.WarnContext(ctx, "unexpected date format from git log", "path", path, "date", lines[3])
 		commit.Date = time.Time{}
 	}
 
@@ -116,13 +128,18 @@ func (gitCmd systemGit) CloneMirror(ctx context.Context, gitURL, path string) er
 	dir, projectName := filepath.Dir(path), filepath.Base(path)
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		log.Printf("failed to create %s (%s)", dir, err)
+		slog.WarnContext(ctx, "failed to create directory", "path", dir, "error", err)
 		return fmt.Errorf("failed to clone %s to %s", gitURL, path)
 	}
 
-	output, err := gitCmd.exec(ctx, dir, "clone", "--mirror", gitURL, projectName)
+	args := []string{"--mirror", gitURL, projectName}
+	if ProgressFromContext(ctx) != nil {
+		args = append([]string{"--progress"}, args...)
+	}
+
+	_, err := gitCmd.exec(ctx, dir, "clone", args...)
 	if err != nil {
-		log.Printf("git clone --mirror %s to %s returned %s (%s)", gitURL, path, err, string(output))
+		slog.WarnContext(ctx, "git clone --mirror failed", "url", gitURL, "path", path, "error", gitError(err))
 		return fmt.Errorf("failed to clone %s to %s", gitURL, path)
 	}
 
@@ -131,22 +148,317 @@ func (gitCmd systemGit) CloneMirror(ctx context.Context, gitURL, path string) er
 
 // UpdateRemote does `git remote update` in specified `path`.
 func (gitCmd systemGit) UpdateRemote(ctx context.Context, path string) error {
-	output, err := gitCmd.exec(ctx, path, "remote", "update")
+	_, err := gitCmd.exec(ctx, path, "remote", "update")
 	if err != nil {
-		log.Printf("[WARN] git remote update returned %s for %s (%s)", err, path, string(output))
+		slog.WarnContext(ctx, "git remote update failed", "path", path, "error", gitError(err))
 		return errors.New("update failed")
 	}
 
 	return nil
 }
 
+// FetchMirror fetches all refs from `remoteURL` into `path` overwriting local ones and pruning refs that no longer exist
+// in remote. Unlike UpdateRemote it does not change configured remotes.
+func (gitCmd systemGit) FetchMirror(ctx context.Context, path, remoteURL string) error {
+	_, err := gitCmd.exec(ctx, path, "fetch", "--prune", remoteURL, "+refs/*:refs/*")
+	if err != nil {
+		slog.WarnContext(ctx, "git fetch failed", "url", remoteURL, "path", path, "error", gitError(err))
+		return errors.New("fetch failed")
+	}
+
+	return nil
+}
+
+// RemoteURL returns the URL of `origin` remote configured in `path`.
+func (gitCmd systemGit) RemoteURL(ctx context.Context, path string) (string, error) {
+	output, err := gitCmd.exec(ctx, path, "config", "--get", "remote.origin.url")
+	if err != nil {
+		slog.WarnContext(ctx, "git config --get remote.origin.url failed", "path", path, "error", gitError(err))
+		return "", errors.New("failed to get remote url")
+	}
+
+	return string(output), nil
+}
+
+// ReadFile returns the content of file `name` at revision `rev` in `path`. If there is no such file
+// ErrorNotFound is returned.
+func (gitCmd systemGit) ReadFile(ctx context.Context, path, rev, name string) ([]byte, error) {
+	output, err := gitCmd.execInput(ctx, path, nil, "cat-file", "blob", rev+":"+name)
+	if err != nil {
+		return nil, ErrorNotFound
+	}
+
+	return output, nil
+}
+
+// ConfigValues returns all values of configuration variable `key` set in `path`.
+func (gitCmd systemGit) ConfigValues(ctx context.Context, path, key string) []string {
+	output, err := gitCmd.exec(ctx, path, "config", "--get-all", key)
+	if err != nil || len(output) == 0 {
+		return nil
+	}
+
+	return strings.Split(string(output), "\n")
+}
+
+// ConfigEntries returns configuration variables set in `path` which names match `keyPattern` regular expression.
+// Section and variable names in returned keys are lowercased by git.
+func (gitCmd systemGit) ConfigEntries(ctx context.Context, path, keyPattern string) map[string]string {
+	output, err := gitCmd.execInput(ctx, path, nil, "config", "--null", "--get-regexp", keyPattern)
+	if err != nil {
+		return nil
+	}
+
+	entries := make(map[string]string)
+	for _, entry := range bytes.Split(output, []byte{0}) {
+		if len(entry) == 0 {
+			continue
+		}
+
+		fields := bytes.SplitN(entry, []byte("\n"), 2)
+		if len(fields) == 1 {
+			entries[string(fields[0])] = ""
+		} else {
+			entries[string(fields[0])] = string(fields[1])
+		}
+	}
+
+	return entries
+}
+
+// Refs returns SHAs of all refs in `path` by their full names, i.e. "refs/heads/master".
+func (gitCmd systemGit) Refs(ctx context.Context, path string) (map[string]string, error) {
+	output, err := gitCmd.exec(ctx, path, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		slog.WarnContext(ctx, "git for-each-ref failed", "path", path, "error", gitError(err))
+		return nil, errors.New("failed to list refs")
+	}
+
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		refs[fields[1]] = fields[0]
+	}
+
+	return refs, nil
+}
+
+// SetConfig sets configuration variable `key` in `path`.
+func (gitCmd systemGit) SetConfig(ctx context.Context, path, key, value string) error {
+	_, err := gitCmd.exec(ctx, path, "config", "--replace-all", key, value)
+	if err != nil {
+		slog.WarnContext(ctx, "git config failed", "path", path, "key", key, "error", gitError(err))
+		return fmt.Errorf("failed to set %s", key)
+	}
+
+	return nil
+}
+
+// RemoveConfigSection removes configuration section with all its variables from `path`.
+func (gitCmd systemGit) RemoveConfigSection(ctx context.Context, path, section string) error {
+	if _, err := gitCmd.exec(ctx, path, "config", "--remove-section", section); err != nil {
+		return fmt.Errorf("failed to remove %s", section)
+	}
+
+	return nil
+}
+
+// PushMirror does `git push --mirror` from `path` to `remoteURL` with additional configuration options. If `remoteURL`
+// is an absolute path to a non-existing directory, a new bare repository is initialized there first. Configuration
+// options are passed via environment, so that credentials do not show up in the process list.
+func (gitCmd systemGit) PushMirror(ctx context.Context, path, remoteURL string, config map[string]string) error {
+	if filepath.IsAbs(remoteURL) {
+		if _, err := os.Stat(remoteURL); os.IsNotExist(err) {
+			if _, err := gitCmd.exec(ctx, path, "init", "--bare", "--", remoteURL); err != nil {
+				slog.WarnContext(ctx, "git init --bare failed", "path", remoteURL, "error", gitError(err))
+				return fmt.Errorf("failed to initialize %s", remoteURL)
+			}
+		}
+	}
+
+	env := []string{"GIT_TERMINAL_PROMPT=0", fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config))}
+
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, k), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, config[k]))
+	}
+
+	if _, err := gitCmd.execEnv(ctx, path, env, "push", "--mirror", "--", remoteURL); err != nil {
+		return fmt.Errorf("git push --mirror failed: %s", strings.TrimSpace(err.Error()))
+	}
+
+	return nil
+}
+
+// LFSPointers returns a list of Git LFS pointers found in blobs reachable from any ref in `path`.
+func (gitCmd systemGit) LFSPointers(ctx context.Context, path string) ([]lfs.Pointer, error) {
+	objects, err := gitCmd.exec(ctx, path, "rev-list", "--objects", "--all")
+	if err != nil {
+		slog.WarnContext(ctx, "git rev-list --objects --all failed", "path", path, "error", gitError(err))
+		return nil, errors.New("failed to list objects")
+	}
+
+	// Only keep object names, dropping paths that follow them
+	var input bytes.Buffer
+	for _, line := range bytes.Split(objects, []byte("\n")) {
+		if fields := bytes.Fields(line); len(fields) > 0 {
+			input.Write(fields[0])
+			input.WriteByte('\n')
+		}
+	}
+
+	output, err := gitCmd.execInput(ctx, path, &input, "cat-file", "--batch-check=%(objecttype) %(objectname) %(objectsize)")
+	if err != nil {
+		slog.WarnContext(ctx, "git cat-file --batch-check failed", "path", path, "error", gitError(err))
+		return nil, errors.New("failed to list objects")
+	}
+
+	// LFS pointers are small blobs, so there is no need to read bigger ones
+	input.Reset()
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) != 3 || string(fields[0]) != "blob" {
+			continue
+		}
+
+		if size, err := strconv.Atoi(string(fields[2])); err != nil || size > lfs.MaxPointerSize {
+			continue
+		}
+
+		input.Write(fields[1])
+		input.WriteByte('\n')
+	}
+
+	if input.Len() == 0 {
+		return nil, nil
+	}
+
+	output, err = gitCmd.execInput(ctx, path, &input, "cat-file", "--batch")
+	if err != nil {
+		slog.WarnContext(ctx, "git cat-file --batch failed", "path", path, "error", gitError(err))
+		return nil, errors.New("failed to read objects")
+	}
+
+	return parseLFSPointers(output), nil
+}
+
+// gitError returns the error message written by git to stderr without trailing newlines.
+func gitError(err error) string {
+	return strings.TrimSpace(err.Error())
+}
+
+// parseLFSPointers reads `git cat-file --batch` output and returns a list of unique LFS pointers found in it.
+func parseLFSPointers(output []byte) []lfs.Pointer {
+	var (
+		pointers []lfs.Pointer
+		seen     = make(map[string]struct{})
+	)
+
+	for len(output) > 0 {
+		// Each object is preceeded by "<sha> <type> <size>\n" header and followed by a newline
+		eol := bytes.IndexByte(output, '\n')
+		if eol < 0 {
+			break
+		}
+
+		header := bytes.Fields(output[:eol])
+		if len(header) != 3 {
+			break
+		}
+
+		size, err := strconv.Atoi(string(header[2]))
+		if err != nil || eol+1+size > len(output) {
+			break
+		}
+
+		content := output[eol+1 : eol+1+size]
+		output = output[eol+1+size:]
+		if len(output) > 0 && output[0] == '\n' {
+			output = output[1:]
+		}
+
+		p, err := lfs.ParsePointer(content)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := seen[p.OID]; !ok {
+			seen[p.OID] = struct{}{}
+			pointers = append(pointers, p)
+		}
+	}
+
+	return pointers
+}
+
 func (gitCmd systemGit) exec(ctx context.Context, path, command string, args ...string) (output []byte, err error) {
-	cmd := exec.CommandContext(ctx, string(gitCmd), append([]string{command}, args...)...)
+	return gitCmd.execEnv(ctx, path, nil, command, args...)
+}
+
+// execEnv runs git command with additional environment variables and returns its trimmed output.
+func (gitCmd systemGit) execEnv(ctx context.Context, path string, env []string, command string, args ...string) (output []byte, err error) {
+	output, err = gitCmd.run(ctx, path, nil, env, command, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(output), nil
+}
+
+// execInput runs git command feeding input to its stdin and returns unmodified output.
+func (gitCmd systemGit) execInput(ctx context.Context, path string, input io.Reader, command string, args ...string) (output []byte, err error) {
+	return gitCmd.run(ctx, path, input, nil, command, args...)
+}
+
+func (gitCmd systemGit) run(ctx context.Context, path string, input io.Reader, env []string, command string, args ...string) (output []byte, err error) {
+	args = append([]string{command}, args...)
+
+	defer func(startTime time.Time) {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		gitCommandDuration.WithLabelValues(gitCommandName(args), result).Observe(time.Since(startTime).Seconds())
+
+		// Arguments are not logged, since they might contain remote URLs with credentials
+		slog.DebugContext(ctx, "ran git command",
+			"command", gitCommandName(args),
+			"dir", path,
+			"duration", time.Since(startTime),
+			"result", result,
+		)
+	}(time.Now())
+
+	cmd := exec.CommandContext(ctx, string(gitCmd), args...)
+	cmd.WaitDelay = gitWaitDelay
+	killProcessGroupOnCancel(cmd)
 	cmd.Dir = path
+	cmd.Stdin = input
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	// Progress is reported as git writes it, so stderr can't be collected by cmd.Output()
+	var progress *progressWriter
+	if fn := ProgressFromContext(ctx); fn != nil {
+		progress = &progressWriter{report: fn}
+		cmd.Stderr = progress
+	}
 
 	output, err = cmd.Output()
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
+		if exitErr, ok := err.(*exec.ExitError); ok && progress != nil {
+			err = errors.New(string(progress.Stderr()))
+		} else if ok {
 			err = errors.New(string(exitErr.Stderr))
 		} else {
 			err = errUnexpectedExit
@@ -155,5 +467,5 @@ func (gitCmd systemGit) exec(ctx context.Context, path, command string, args ...
 		return nil, err
 	}
 
-	return bytes.TrimSpace(output), nil
+	return output, nil
 }
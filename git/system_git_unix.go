@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package git
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroupOnCancel makes cmd run in its own process group that is killed as a whole once the context
+// of command is cancelled, so that subprocesses spawned by git, i.e. git-remote-http, do not outlive it.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
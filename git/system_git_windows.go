@@ -0,0 +1,6 @@
+package git
+
+import "os/exec"
+
+// killProcessGroupOnCancel is a no-op on Windows, where only git process itself is killed on cancel.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/url"
+	"strings"
+
+	"github.com/andrewslotin/doppelganger/git"
+)
+
+// GitHTTPHandler is a type that implements http.Handler interface and serves mirrors over Git smart HTTP
+// protocol using `git http-backend`. Mirrors are read-only, so only fetching is supported:
+//
+//   git clone http://doppelganger/andrewslotin/doppelganger.git
+//
+// Secondary Doppelganger instances clone and update their mirrors from the primary one using this endpoint.
+type GitHTTPHandler struct {
+	gitPath    string
+	mirrorPath string
+}
+
+// NewGitHTTPHandler creates and initializes a new handler that serves repositories located in mirrorPath
+// using git binary located at gitPath.
+func NewGitHTTPHandler(gitPath, mirrorPath string) *GitHTTPHandler {
+	return &GitHTTPHandler{
+		gitPath:    gitPath,
+		mirrorPath: mirrorPath,
+	}
+}
+
+func (handler *GitHTTPHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	owner, repo := req.URL.Query().Get(":owner"), strings.TrimSuffix(req.URL.Query().Get(":repo"), ".git")
+
+	name, err := git.ParseRepositoryName(owner + "/" + repo)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	// git http-backend follows symlinks, so make sure the mirror is not linked from outside of mirror directory
+	if _, err := name.ResolvePath(handler.mirrorPath); err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if !accessFromRequest(req).CanView(name.String()) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	if req.URL.Query().Get("service") == "git-receive-pack" {
+		http.Error(w, "Mirror repositories are read-only", http.StatusForbidden)
+		return
+	}
+
+	// Strip .git suffix from repository name, since mirrors are stored without it, and drop route
+	// parameters added to the query by router
+	prefix := "/" + owner + "/" + req.URL.Query().Get(":repo")
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+
+	r := new(http.Request)
+	*r = *req
+	r.URL = &url.URL{
+		Path: "/" + owner + "/" + repo + strings.TrimPrefix(req.URL.Path, prefix),
+	}
+	if service := req.URL.Query().Get("service"); service != "" {
+		r.URL.RawQuery = url.Values{"service": {service}}.Encode()
+	}
+
+	backend := &cgi.Handler{
+		Path: handler.gitPath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + handler.mirrorPath,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_PROTOCOL=" + req.Header.Get("Git-Protocol"),
+		},
+	}
+	backend.ServeHTTP(w, r)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/server"
+)
+
+const (
+	// githubCheckInterval is the time GitHub token check result is reused to save API rate limit.
+	githubCheckInterval = 5 * time.Minute
+	// maxSyncDuration is the time after which a running sync job is considered to be stuck.
+	maxSyncDuration = 30 * time.Minute
+)
+
+// checkMirrorDir returns a readiness check that verifies whether new files can be created in dir.
+func checkMirrorDir(dir string) server.CheckFunc {
+	return func(ctx context.Context) error {
+		fd, err := ioutil.TempFile(dir, ".doppelganger-readyz-")
+		if err != nil {
+			return fmt.Errorf("mirror directory is not writable: %s", err)
+		}
+		fd.Close()
+
+		return os.Remove(fd.Name())
+	}
+}
+
+// checkTemplates is a readiness check that verifies whether page templates can be parsed.
+func checkTemplates(ctx context.Context) error {
+	for _, t := range pageTemplates {
+		if _, err := t.parse(templateFiles(args.templatesDir)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrInternalAddress is returned when a webhook receiver resolves to an address that is not publicly routable.
+var ErrInternalAddress = errors.New("webhooks can't be delivered to internal addresses")
+
+// internalNetworks are address ranges that are not covered by net.IP methods, but are not publicly routable either.
+var internalNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+// NewHTTPClient returns an HTTP client for webhook deliveries. It refuses to connect to loopback, private, link-local
+// and other internal addresses, so that webhooks can't be used to reach services behind the firewall. Addresses are
+// checked right before connecting, after the host name has been resolved, so that a receiver host name can't be
+// pointed to an internal address once the webhook has been added. Proxies set in environment are not used, since the
+// check would apply to the address of proxy and not to the receiver.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   denyInternalAddress,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// denyInternalAddress is a net.Dialer control function that returns ErrInternalAddress if address is not public.
+func denyInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return fmt.Errorf("%s: %s", ErrInternalAddress, host)
+	}
+
+	return nil
+}
+
+func internalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
@@ -0,0 +1,40 @@
+package hooks_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPClient_InternalAddresses(t *testing.T) {
+	var requests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	client := hooks.NewHTTPClient(time.Second)
+
+	for _, u := range []string{
+		srv.URL,
+		strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+		"http://0.0.0.0:1/",
+		"http://10.0.0.1:1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:1/",
+		"http://[fd00::1]:1/",
+	} {
+		_, err := client.Post(u, "application/json", nil)
+		if assert.Error(t, err, u) {
+			assert.Contains(t, err.Error(), hooks.ErrInternalAddress.Error(), u)
+		}
+	}
+
+	assert.Zero(t, requests)
+}
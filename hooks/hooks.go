@@ -0,0 +1,156 @@
+// Package hooks implements outgoing webhooks that notify downstream systems, such as CI, once a mirror has
+// been updated. Deliveries are signed with HMAC-SHA256 using the secret of webhook, retried with backoff
+// if the receiver is not available and kept in a delivery log, so that they can be inspected and redelivered.
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// EventMirrorUpdated is sent after refs of a mirror have been changed by a sync.
+const EventMirrorUpdated = "mirror_updated"
+
+// Headers sent with each delivery.
+const (
+	// EventHeader holds the event type.
+	EventHeader = "X-Doppelganger-Event"
+	// DeliveryHeader holds the unique ID of delivery. Redeliveries get an ID of their own.
+	DeliveryHeader = "X-Doppelganger-Delivery"
+	// SignatureHeader holds HMAC-SHA256 signature of request body as "sha256=<hex>".
+	SignatureHeader = "X-Doppelganger-Signature-256"
+)
+
+// ZeroSHA is used in RefChange in place of SHA of a ref that did not exist before or has been deleted.
+const ZeroSHA = "0000000000000000000000000000000000000000"
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	// ErrHookNotFound is returned by Service if there is no webhook with given ID.
+	ErrHookNotFound = errors.New("webhook not found")
+	// ErrDeliveryNotFound is returned by Service if there is no delivery with given ID in log.
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrInvalidHook is returned by Service.Add if webhook URL or secret are not valid.
+	ErrInvalidHook = errors.New("invalid webhook")
+	// ErrNotMirrored is returned by Service.Add if webhook is limited to a repository that is not mirrored.
+	ErrNotMirrored = errors.New("repository is not mirrored")
+)
+
+// Hook is an outgoing webhook.
+type Hook struct {
+	ID string `json:"id"`
+	// Repository is the full name of mirror webhook is limited to. Webhooks without repository are called
+	// for all mirrors.
+	Repository string `json:"repository,omitempty"`
+	URL        string `json:"url"`
+	// Secret is the key used to sign deliveries.
+	Secret    string    `json:"secret"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Global returns true if webhook is called for all mirrors.
+func (h *Hook) Global() bool {
+	return h.Repository == ""
+}
+
+// RefChange describes a ref updated by mirror sync.
+type RefChange struct {
+	// Ref is the full name of ref, i.e. "refs/heads/master".
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Changes returns refs that differ between two ref sets mapping names to SHAs, sorted by name. Created refs
+// have ZeroSHA as Before and deleted ones as After.
+func Changes(before, after map[string]string) []RefChange {
+	var changes []RefChange
+	for ref, sha := range after {
+		if prev, ok := before[ref]; !ok {
+			changes = append(changes, RefChange{Ref: ref, Before: ZeroSHA, After: sha})
+		} else if prev != sha {
+			changes = append(changes, RefChange{Ref: ref, Before: prev, After: sha})
+		}
+	}
+
+	for ref, sha := range before {
+		if _, ok := after[ref]; !ok {
+			changes = append(changes, RefChange{Ref: ref, Before: sha, After: ZeroSHA})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Ref < changes[j].Ref
+	})
+
+	return changes
+}
+
+// Payload is the body of a delivery.
+type Payload struct {
+	Event      string      `json:"event"`
+	Repository string      `json:"repository"`
+	Refs       []RefChange `json:"refs"`
+	// CloneURL is the URL to clone mirror over HTTP(S). It's omitted if the public URL of Doppelganger
+	// is not known.
+	CloneURL string    `json:"clone_url,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Attempt is a single attempt to deliver a payload.
+type Attempt struct {
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// Delivery is a payload sent to a webhook along with the history of attempts to deliver it.
+type Delivery struct {
+	ID         string          `json:"id"`
+	HookID     string          `json:"hook_id"`
+	Event      string          `json:"event"`
+	Repository string          `json:"repository"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"`
+	Attempts   []Attempt       `json:"attempts"`
+	// NextAttemptAt is the time of the next attempt of a pending delivery.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// RedeliveryOf is the ID of delivery this one repeats.
+	RedeliveryOf string    `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// LastAttempt returns the latest attempt to deliver payload or nil if there were none yet.
+func (d *Delivery) LastAttempt() *Attempt {
+	if len(d.Attempts) == 0 {
+		return nil
+	}
+
+	return &d.Attempts[len(d.Attempts)-1]
+}
+
+// Sign returns the signature of body sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks whether signature matches body. It can be used by receivers written in Go.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,41 @@
+package hooks_test
+
+import (
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	before := map[string]string{
+		"refs/heads/master":  "1111111111111111111111111111111111111111",
+		"refs/heads/removed": "2222222222222222222222222222222222222222",
+		"refs/tags/v1.0":     "3333333333333333333333333333333333333333",
+	}
+	after := map[string]string{
+		"refs/heads/master":  "4444444444444444444444444444444444444444",
+		"refs/heads/feature": "5555555555555555555555555555555555555555",
+		"refs/tags/v1.0":     "3333333333333333333333333333333333333333",
+	}
+
+	assert.Equal(t, []hooks.RefChange{
+		{Ref: "refs/heads/feature", Before: hooks.ZeroSHA, After: "5555555555555555555555555555555555555555"},
+		{Ref: "refs/heads/master", Before: "1111111111111111111111111111111111111111", After: "4444444444444444444444444444444444444444"},
+		{Ref: "refs/heads/removed", Before: "2222222222222222222222222222222222222222", After: hooks.ZeroSHA},
+	}, hooks.Changes(before, after))
+
+	assert.Empty(t, hooks.Changes(after, after))
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"mirror_updated"}`)
+
+	// echo -n '{"event":"mirror_updated"}' | openssl dgst -sha256 -hmac secret
+	signature := hooks.Sign("secret", body)
+	assert.Equal(t, "sha256=105f5ec528b2bd88b06a734985c26d877597a039d176599cdb1112828e3e135b", signature)
+
+	assert.True(t, hooks.Verify("secret", body, signature))
+	assert.False(t, hooks.Verify("other secret", body, signature))
+	assert.False(t, hooks.Verify("secret", []byte(`{}`), signature))
+}
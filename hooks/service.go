@@ -0,0 +1,579 @@
+package hooks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/andrewslotin/doppelganger/audit"
+)
+
+const (
+	// maxDeliveries is the number of deliveries kept in log. Older deliveries that are not pending anymore
+	// are removed once the log grows beyond this size.
+	maxDeliveries = 200
+	// dispatchInterval is how often Service checks for deliveries due to be retried.
+	dispatchInterval = time.Second
+	// maxResponseSize is the number of bytes read from receiver response before the connection is closed.
+	maxResponseSize = 64 << 10
+)
+
+// retryDelays are the delays between consecutive attempts to deliver a payload. A delivery is marked as
+// failed once all of them have been used up.
+var retryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+// Actions recorded to audit log by Service.
+var (
+	actionAddWebhook       = audit.Action("add-webhook")
+	actionRemoveWebhook    = audit.Action("remove-webhook")
+	actionRedeliverWebhook = audit.Action("redeliver-webhook")
+)
+
+// state is the content of service file.
+type state struct {
+	Hooks      []*Hook     `json:"hooks"`
+	Deliveries []*Delivery `json:"deliveries"`
+}
+
+// Service keeps outgoing webhooks along with the log of their deliveries in a JSON file and sends
+// payloads to them. A nil *Service is valid and sends nothing, so that mirror services can report updates
+// unconditionally.
+type Service struct {
+	path     string
+	client   *http.Client
+	baseURL  string
+	audit    *audit.Log
+	mirrored func(ctx context.Context, fullName string) bool
+	wakeup   chan struct{}
+
+	mu       sync.Mutex
+	state    state
+	inFlight map[string]bool
+}
+
+// NewService loads webhooks and delivery log from file located at path and returns an instance of Service
+// that uses client to send payloads. Redirects are not followed regardless of client settings, so that
+// receivers can't send deliveries elsewhere. The file is created on first write.
+func NewService(path string, client *http.Client) (*Service, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	service := &Service{
+		path:     path,
+		client:   &noRedirects,
+		wakeup:   make(chan struct{}, 1),
+		inFlight: make(map[string]bool),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return service, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read webhooks file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &service.state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	return service, nil
+}
+
+// SetBaseURL sets the public URL of Doppelganger used to build mirror clone URLs sent in payloads.
+func (service *Service) SetBaseURL(baseURL string) {
+	service.baseURL = strings.TrimSuffix(baseURL, "/")
+}
+
+// EnableAudit makes Service record changes of webhooks and redeliveries to log.
+func (service *Service) EnableAudit(log *audit.Log) {
+	service.audit = log
+}
+
+// EnableMirrorCheck makes Service.Add reject webhooks limited to repositories for which mirrored returns false.
+func (service *Service) EnableMirrorCheck(mirrored func(ctx context.Context, fullName string) bool) {
+	service.mirrored = mirrored
+}
+
+// All returns webhooks sorted by creation time.
+func (service *Service) All() []Hook {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	hooks := make([]Hook, 0, len(service.state.Hooks))
+	for _, h := range service.state.Hooks {
+		hooks = append(hooks, *h)
+	}
+
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+
+	return hooks
+}
+
+// Get returns webhook with given ID.
+func (service *Service) Get(id string) (Hook, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if h := service.hook(id); h != nil {
+		return *h, nil
+	}
+
+	return Hook{}, ErrHookNotFound
+}
+
+// Add registers a webhook. ErrInvalidHook is returned if its URL is not an absolute HTTP(S) URL or
+// the secret is empty, ErrNotMirrored if the repository it's limited to is not mirrored.
+func (service *Service) Add(ctx context.Context, h Hook) (*Hook, error) {
+	h.URL, h.Repository = strings.TrimSpace(h.URL), strings.TrimSpace(h.Repository)
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || h.Secret == "" {
+		return nil, ErrInvalidHook
+	}
+
+	if h.Repository != "" && service.mirrored != nil && !service.mirrored(ctx, h.Repository) {
+		return nil, ErrNotMirrored
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	h.ID = id
+	h.CreatedAt = time.Now().UTC()
+
+	service.mu.Lock()
+	service.state.Hooks = append(service.state.Hooks, &h)
+	err = service.save()
+	service.mu.Unlock()
+
+	service.audit.Record(ctx, actionAddWebhook, h.Repository, map[string]string{"id": h.ID, "url": audit.RedactURL(h.URL)}, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %s", err)
+	}
+
+	return &h, nil
+}
+
+// Remove deletes webhook with given ID along with its deliveries.
+func (service *Service) Remove(ctx context.Context, id string) error {
+	service.mu.Lock()
+	h := service.hook(id)
+	if h == nil {
+		service.mu.Unlock()
+		return ErrHookNotFound
+	}
+
+	hooks := service.state.Hooks[:0]
+	for _, hook := range service.state.Hooks {
+		if hook.ID != id {
+			hooks = append(hooks, hook)
+		}
+	}
+	service.state.Hooks = hooks
+
+	deliveries := service.state.Deliveries[:0]
+	for _, d := range service.state.Deliveries {
+		if d.HookID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	service.state.Deliveries = deliveries
+
+	err := service.save()
+	service.mu.Unlock()
+
+	service.audit.Record(ctx, actionRemoveWebhook, h.Repository, map[string]string{"id": h.ID, "url": audit.RedactURL(h.URL)}, err)
+
+	return err
+}
+
+// Deliveries returns logged deliveries of webhook with given ID, newest first.
+func (service *Service) Deliveries(hookID string) []Delivery {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	var deliveries []Delivery
+	for i := len(service.state.Deliveries) - 1; i >= 0; i-- {
+		if d := service.state.Deliveries[i]; d.HookID == hookID {
+			deliveries = append(deliveries, copyDelivery(d))
+		}
+	}
+
+	return deliveries
+}
+
+// Delivery returns logged delivery with given ID.
+func (service *Service) Delivery(id string) (Delivery, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if d := service.delivery(id); d != nil {
+		return copyDelivery(d), nil
+	}
+
+	return Delivery{}, ErrDeliveryNotFound
+}
+
+// Redeliver queues the payload of delivery with given ID to be sent again as a new delivery.
+func (service *Service) Redeliver(ctx context.Context, id string) (*Delivery, error) {
+	service.mu.Lock()
+	orig := service.delivery(id)
+	if orig == nil {
+		service.mu.Unlock()
+		return nil, ErrDeliveryNotFound
+	}
+
+	var redelivery Delivery
+	d, err := service.enqueue(orig.HookID, orig.Repository, orig.Event, orig.Payload)
+	if err == nil {
+		d.RedeliveryOf = orig.ID
+		redelivery, err = copyDelivery(d), service.save()
+	}
+	service.mu.Unlock()
+
+	service.audit.Record(ctx, actionRedeliverWebhook, orig.Repository, map[string]string{"id": orig.HookID, "delivery": orig.ID}, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue redelivery: %s", err)
+	}
+	service.notify()
+
+	return &redelivery, nil
+}
+
+// MirrorUpdated queues EventMirrorUpdated deliveries to global webhooks and those registered for repository.
+// Nothing is sent if refs is empty, i.e. if sync did not bring any changes.
+func (service *Service) MirrorUpdated(ctx context.Context, repository string, refs []RefChange) {
+	if service == nil || len(refs) == 0 {
+		return
+	}
+
+	p := Payload{
+		Event:      EventMirrorUpdated,
+		Repository: repository,
+		Refs:       refs,
+		Time:       time.Now().UTC(),
+	}
+	if service.baseURL != "" {
+		p.CloneURL = service.baseURL + "/" + repository
+	}
+
+	payload, err := json.Marshal(p)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode webhook payload", "repo", repository, "error", err)
+		return
+	}
+
+	service.mu.Lock()
+	var queued int
+	for _, h := range service.state.Hooks {
+		if !h.Global() && h.Repository != repository {
+			continue
+		}
+
+		if _, err := service.enqueue(h.ID, repository, EventMirrorUpdated, payload); err != nil {
+			slog.ErrorContext(ctx, "failed to queue webhook delivery", "webhook", h.ID, "repo", repository, "error", err)
+			continue
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		if err := service.save(); err != nil {
+			slog.ErrorContext(ctx, "failed to save webhook deliveries", "error", err)
+		}
+	}
+	service.mu.Unlock()
+
+	if queued > 0 {
+		slog.DebugContext(ctx, "queued webhook deliveries", "event", EventMirrorUpdated, "repo", repository, "refs", len(refs), "webhooks", queued)
+		service.notify()
+	}
+}
+
+// Run sends pending deliveries using up to workers concurrent requests until ctx is cancelled. Deliveries
+// that were pending when the service has been stopped are resumed on the next run.
+func (service *Service) Run(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sem := make(chan struct{}, workers)
+
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, id := range service.due(time.Now()) {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(id string) {
+				defer func() { <-sem }()
+				service.attempt(ctx, id)
+			}(id)
+		}
+
+		select {
+		case <-service.wakeup:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// due returns IDs of pending deliveries that are not being sent and whose next attempt is due by now
+// marking them as in flight.
+func (service *Service) due(now time.Time) []string {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	var ids []string
+	for _, d := range service.state.Deliveries {
+		if d.Status != DeliveryPending || service.inFlight[d.ID] || d.NextAttemptAt.After(now) {
+			continue
+		}
+
+		service.inFlight[d.ID] = true
+		ids = append(ids, d.ID)
+	}
+
+	return ids
+}
+
+// attempt sends delivery with given ID to its webhook and records the outcome. Attempts interrupted
+// because ctx has been cancelled are not recorded, so that they are repeated on the next run.
+func (service *Service) attempt(ctx context.Context, id string) {
+	defer func() {
+		service.mu.Lock()
+		delete(service.inFlight, id)
+		service.mu.Unlock()
+	}()
+
+	service.mu.Lock()
+	d := service.delivery(id)
+	var h *Hook
+	if d != nil {
+		h = service.hook(d.HookID)
+	}
+
+	if h == nil {
+		// Webhook has been removed along with the delivery
+		service.mu.Unlock()
+		return
+	}
+	hook, payload, event, repo, attempts := *h, d.Payload, d.Event, d.Repository, len(d.Attempts)
+	service.mu.Unlock()
+
+	start := time.Now()
+	statusCode, err := service.send(ctx, hook, id, event, payload)
+	if ctx.Err() != nil {
+		return
+	}
+
+	a := Attempt{Time: start.UTC(), Duration: time.Since(start), StatusCode: statusCode}
+	if err != nil {
+		a.Error = err.Error()
+	}
+
+	service.mu.Lock()
+	if d = service.delivery(id); d != nil {
+		d.Attempts = append(d.Attempts, a)
+
+		switch {
+		case err == nil:
+			d.Status = DeliverySucceeded
+		case attempts < len(retryDelays):
+			d.NextAttemptAt = time.Now().Add(retryDelays[attempts]).UTC()
+		default:
+			d.Status = DeliveryFailed
+		}
+
+		if err := service.save(); err != nil {
+			slog.ErrorContext(ctx, "failed to save webhook deliveries", "error", err)
+		}
+	}
+	service.mu.Unlock()
+
+	if err != nil {
+		slog.WarnContext(ctx, "failed to deliver webhook", "webhook", hook.ID, "delivery", id, "repo", repo, "attempt", attempts+1, "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "delivered webhook", "webhook", hook.ID, "delivery", id, "repo", repo, "status", statusCode, "duration_ms", a.Duration.Milliseconds())
+}
+
+// send posts payload to webhook and returns the response status. Responses with status other than 2xx are
+// returned as errors.
+func (service *Service) send(ctx context.Context, h Hook, deliveryID, event string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "doppelganger-webhook")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(SignatureHeader, Sign(h.Secret, payload))
+
+	resp, err := service.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// enqueue appends a pending delivery to log, service.mu is expected to be held by caller.
+func (service *Service) enqueue(hookID, repository, event string, payload json.RawMessage) (*Delivery, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	d := &Delivery{
+		ID:            id,
+		HookID:        hookID,
+		Event:         event,
+		Repository:    repository,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	service.state.Deliveries = append(service.state.Deliveries, d)
+	service.trim()
+
+	return d, nil
+}
+
+// trim removes the oldest deliveries that are not pending anymore until there are at most maxDeliveries
+// left, service.mu is expected to be held by caller.
+func (service *Service) trim() {
+	excess := len(service.state.Deliveries) - maxDeliveries
+	if excess <= 0 {
+		return
+	}
+
+	deliveries := service.state.Deliveries[:0]
+	for _, d := range service.state.Deliveries {
+		if excess > 0 && d.Status != DeliveryPending {
+			excess--
+			continue
+		}
+
+		deliveries = append(deliveries, d)
+	}
+	service.state.Deliveries = deliveries
+}
+
+func (service *Service) notify() {
+	select {
+	case service.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// hook returns webhook with given ID, service.mu is expected to be held by caller.
+func (service *Service) hook(id string) *Hook {
+	for _, h := range service.state.Hooks {
+		if h.ID == id {
+			return h
+		}
+	}
+
+	return nil
+}
+
+// delivery returns delivery with given ID, service.mu is expected to be held by caller.
+func (service *Service) delivery(id string) *Delivery {
+	for _, d := range service.state.Deliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// save writes webhooks and deliveries to file, service.mu is expected to be held by caller.
+func (service *Service) save() error {
+	data, err := json.MarshalIndent(service.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(service.path), 0700); err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile(filepath.Dir(service.path), "."+filepath.Base(service.path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	return os.Rename(fd.Name(), service.path)
+}
+
+func copyDelivery(d *Delivery) Delivery {
+	c := *d
+	c.Attempts = append([]Attempt(nil), d.Attempts...)
+
+	return c
+}
+
+func newID() (string, error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,286 @@
+package hooks_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type receivedDelivery struct {
+	Header http.Header
+	Body   []byte
+}
+
+// setupService returns an instance of hooks.Service and a receiver that responds with statuses in order and
+// 200 OK once they have been used up.
+func setupService(t *testing.T, statuses ...int) (*hooks.Service, *httptest.Server, <-chan receivedDelivery, string, func()) {
+	dir, err := ioutil.TempDir("", "doppelganger-webhooks")
+	require.NoError(t, err)
+
+	received := make(chan receivedDelivery, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		received <- receivedDelivery{Header: req.Header, Body: body}
+
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+
+	path := filepath.Join(dir, "state", "webhooks.json")
+
+	service, err := hooks.NewService(path, srv.Client())
+	require.NoError(t, err)
+
+	return service, srv, received, path, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func waitForDelivery(t *testing.T, received <-chan receivedDelivery) receivedDelivery {
+	select {
+	case d := <-received:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("payload has not been delivered")
+	}
+
+	return receivedDelivery{}
+}
+
+var refs = []hooks.RefChange{
+	{Ref: "refs/heads/master", Before: "1111111111111111111111111111111111111111", After: "2222222222222222222222222222222222222222"},
+}
+
+func TestService_MirrorUpdated(t *testing.T) {
+	service, srv, received, _, teardown := setupService(t)
+	defer teardown()
+
+	service.SetBaseURL("https://doppelganger.example.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	global, err := service.Add(ctx, hooks.Hook{URL: srv.URL + "/global", Secret: "global secret"})
+	require.NoError(t, err)
+
+	_, err = service.Add(ctx, hooks.Hook{URL: srv.URL + "/other", Repository: "c/d", Secret: "other secret"})
+	require.NoError(t, err)
+
+	go service.Run(ctx, 2)
+	service.MirrorUpdated(ctx, "a/b", refs)
+
+	d := waitForDelivery(t, received)
+	assert.Equal(t, hooks.EventMirrorUpdated, d.Header.Get(hooks.EventHeader))
+	assert.True(t, hooks.Verify("global secret", d.Body, d.Header.Get(hooks.SignatureHeader)))
+
+	var p hooks.Payload
+	require.NoError(t, json.Unmarshal(d.Body, &p))
+	assert.Equal(t, hooks.EventMirrorUpdated, p.Event)
+	assert.Equal(t, "a/b", p.Repository)
+	assert.Equal(t, refs, p.Refs)
+	assert.Equal(t, "https://doppelganger.example.com/a/b", p.CloneURL)
+
+	assert.Eventually(t, func() bool {
+		deliveries := service.Deliveries(global.ID)
+		return len(deliveries) == 1 && deliveries[0].Status == hooks.DeliverySucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	deliveries := service.Deliveries(global.ID)
+	assert.Equal(t, d.Header.Get(hooks.DeliveryHeader), deliveries[0].ID)
+	require.Len(t, deliveries[0].Attempts, 1)
+	assert.Equal(t, http.StatusOK, deliveries[0].Attempts[0].StatusCode)
+
+	select {
+	case d := <-received:
+		t.Errorf("unexpected delivery of %s", d.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestService_MirrorUpdated_NoChanges(t *testing.T) {
+	service, srv, _, _, teardown := setupService(t)
+	defer teardown()
+
+	h, err := service.Add(context.Background(), hooks.Hook{URL: srv.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	service.MirrorUpdated(context.Background(), "a/b", nil)
+	assert.Empty(t, service.Deliveries(h.ID))
+}
+
+func TestService_Retry(t *testing.T) {
+	service, srv, received, path, teardown := setupService(t, http.StatusServiceUnavailable)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := service.Add(ctx, hooks.Hook{URL: srv.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	go service.Run(ctx, 1)
+	service.MirrorUpdated(ctx, "a/b", refs)
+	waitForDelivery(t, received)
+
+	assert.Eventually(t, func() bool {
+		deliveries := service.Deliveries(h.ID)
+		return len(deliveries) == 1 && len(deliveries[0].Attempts) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d := service.Deliveries(h.ID)[0]
+	assert.Equal(t, hooks.DeliveryPending, d.Status)
+	assert.Equal(t, http.StatusServiceUnavailable, d.Attempts[0].StatusCode)
+	assert.NotEmpty(t, d.Attempts[0].Error)
+	assert.True(t, d.NextAttemptAt.After(time.Now().Add(5*time.Second)), "next attempt is scheduled at %s", d.NextAttemptAt)
+
+	// Pending deliveries are resumed after restart
+	restored, err := hooks.NewService(path, srv.Client())
+	require.NoError(t, err)
+
+	deliveries := restored.Deliveries(h.ID)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, d.ID, deliveries[0].ID)
+	assert.Equal(t, hooks.DeliveryPending, deliveries[0].Status)
+}
+
+func TestService_Redeliver(t *testing.T) {
+	service, srv, received, _, teardown := setupService(t, http.StatusInternalServerError)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := service.Add(ctx, hooks.Hook{URL: srv.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	go service.Run(ctx, 1)
+	service.MirrorUpdated(ctx, "a/b", refs)
+	first := waitForDelivery(t, received)
+
+	orig := service.Deliveries(h.ID)[0]
+
+	redelivery, err := service.Redeliver(ctx, orig.ID)
+	require.NoError(t, err)
+	assert.Equal(t, orig.ID, redelivery.RedeliveryOf)
+	assert.NotEqual(t, orig.ID, redelivery.ID)
+
+	second := waitForDelivery(t, received)
+	assert.Equal(t, redelivery.ID, second.Header.Get(hooks.DeliveryHeader))
+	assert.Equal(t, first.Body, second.Body)
+
+	assert.Eventually(t, func() bool {
+		d, err := service.Delivery(redelivery.ID)
+		return err == nil && d.Status == hooks.DeliverySucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = service.Redeliver(ctx, "unknown")
+	assert.Equal(t, hooks.ErrDeliveryNotFound, err)
+}
+
+func TestService_AddRemove(t *testing.T) {
+	service, srv, _, path, teardown := setupService(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	for _, h := range []hooks.Hook{
+		{URL: srv.URL},
+		{URL: "ftp://example.com", Secret: "secret"},
+		{URL: "/relative", Secret: "secret"},
+	} {
+		_, err := service.Add(ctx, h)
+		assert.Equal(t, hooks.ErrInvalidHook, err, "%+v", h)
+	}
+
+	h, err := service.Add(ctx, hooks.Hook{URL: " " + srv.URL + " ", Repository: "a/b", Secret: "secret", CreatedBy: "jdoe"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, h.ID)
+	assert.Equal(t, srv.URL, h.URL)
+
+	service.MirrorUpdated(ctx, "a/b", refs)
+	assert.Len(t, service.Deliveries(h.ID), 1)
+
+	restored, err := hooks.NewService(path, nil)
+	require.NoError(t, err)
+
+	stored, err := restored.Get(h.ID)
+	require.NoError(t, err)
+	assert.Equal(t, *h, stored)
+
+	require.NoError(t, service.Remove(ctx, h.ID))
+	assert.Empty(t, service.All())
+	assert.Empty(t, service.Deliveries(h.ID))
+
+	assert.Equal(t, hooks.ErrHookNotFound, service.Remove(ctx, h.ID))
+
+	_, err = service.Get(h.ID)
+	assert.Equal(t, hooks.ErrHookNotFound, err)
+}
+
+func TestService_Add_NotMirrored(t *testing.T) {
+	service, srv, _, _, teardown := setupService(t)
+	defer teardown()
+
+	service.EnableMirrorCheck(func(ctx context.Context, fullName string) bool {
+		return fullName == "a/b"
+	})
+
+	_, err := service.Add(context.Background(), hooks.Hook{URL: srv.URL, Repository: "c/d", Secret: "secret"})
+	assert.Equal(t, hooks.ErrNotMirrored, err)
+
+	_, err = service.Add(context.Background(), hooks.Hook{URL: srv.URL, Repository: "a/b", Secret: "secret"})
+	assert.NoError(t, err)
+
+	// Global webhooks are not limited to any mirror
+	_, err = service.Add(context.Background(), hooks.Hook{URL: srv.URL, Secret: "secret"})
+	assert.NoError(t, err)
+}
+
+func TestService_NoRedirects(t *testing.T) {
+	var redirected bool
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+
+	srv := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "doppelganger-webhooks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	service, err := hooks.NewService(filepath.Join(dir, "webhooks.json"), srv.Client())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := service.Add(ctx, hooks.Hook{URL: srv.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	go service.Run(ctx, 1)
+	service.MirrorUpdated(ctx, "a/b", refs)
+
+	assert.Eventually(t, func() bool {
+		deliveries := service.Deliveries(h.ID)
+		return len(deliveries) == 1 && len(deliveries[0].Attempts) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, service.Deliveries(h.ID)[0].Attempts[0].StatusCode)
+	assert.False(t, redirected, "redirect should not be followed")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/git/lfs"
+)
+
+// LFSHandler is a type that implements http.Handler interface and is used to serve Git LFS objects of mirrored
+// repositories. It implements the download part of Git LFS batch API at "/:owner/:repo/info/lfs/objects/batch"
+// and serves objects themselves from "/:owner/:repo/info/lfs/objects/:oid". Mirrors are read-only, so upload
+// requests are rejected.
+//
+// To make git-lfs fetch objects from the mirror set lfs.url in the cloned repository:
+//
+//   git config lfs.url http://doppelganger/andrewslotin/doppelganger.git/info/lfs
+//
+// For more details on Git LFS API see https://github.com/git-lfs/git-lfs/tree/master/docs/api.
+type LFSHandler struct {
+	mirroredRepos git.LFSService
+	publicURL     string
+}
+
+// NewLFSHandler creates and initializes a new handler. Download links point to publicURL, or to the host the request
+// has been sent to if it's empty.
+func NewLFSHandler(mirroredRepos git.LFSService, publicURL string) *LFSHandler {
+	return &LFSHandler{
+		mirroredRepos: mirroredRepos,
+		publicURL:     publicURL,
+	}
+}
+
+func (handler *LFSHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	startTime := time.Now()
+
+	name, ok := handler.fetchRepoFromRequest(req)
+	if !ok {
+		writeLFSError(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	repoName := name.String()
+
+	if !accessFromRequest(req).CanView(repoName) {
+		writeLFSError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	switch _, err := handler.mirroredRepos.Get(req.Context(), name); err {
+	case nil:
+	case git.ErrorNotMirrored, git.ErrorNotFound:
+		writeLFSError(w, "Repository not found", http.StatusNotFound)
+		return
+	default:
+		slog.WarnContext(req.Context(), "failed to fetch mirror", "repo", repoName, "error", err)
+		writeLFSError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if oid := req.URL.Query().Get(":oid"); oid != "" {
+		handler.Download(w, req, repoName, oid)
+		slog.DebugContext(req.Context(), "served LFS object", "repo", repoName, "oid", oid, "duration", time.Since(startTime))
+
+		return
+	}
+
+	handler.Batch(w, req, repoName)
+	slog.DebugContext(req.Context(), "handled LFS batch request", "repo", repoName, "duration", time.Since(startTime))
+}
+
+// Batch handles Git LFS batch API request returning download links for objects present in mirror LFS store.
+func (handler *LFSHandler) Batch(w http.ResponseWriter, req *http.Request, repoName string) {
+	var batchReq lfs.BatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batchReq); err != nil {
+		writeLFSError(w, "Malformed batch request", http.StatusBadRequest)
+		return
+	}
+
+	if batchReq.Operation != "download" {
+		writeLFSError(w, "Mirror repositories are read-only", http.StatusForbidden)
+		return
+	}
+
+	store, err := handler.mirroredRepos.LFSStore(repoName)
+	if err != nil {
+		writeLFSError(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	resp := lfs.BatchResponse{
+		Transfer: "basic",
+		Objects:  make([]lfs.BatchObject, 0, len(batchReq.Objects)),
+	}
+	for _, p := range batchReq.Objects {
+		obj := lfs.BatchObject{Pointer: p}
+
+		if store.Exists(p) {
+			obj.Actions = map[string]*lfs.Action{
+				"download": {Href: lfsObjectURL(baseURL(handler.publicURL, req), repoName, p.OID).String()},
+			}
+		} else {
+			obj.Error = &lfs.ObjectError{Code: http.StatusNotFound, Message: "Object does not exist"}
+		}
+
+		resp.Objects = append(resp.Objects, obj)
+	}
+
+	w.Header().Set("Content-Type", lfs.MediaType)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Download sends the content of an LFS object to client.
+func (handler *LFSHandler) Download(w http.ResponseWriter, req *http.Request, repoName, oid string) {
+	store, err := handler.mirroredRepos.LFSStore(repoName)
+	if err != nil {
+		writeLFSError(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	fd, err := store.Open(oid)
+	if err != nil {
+		if err == lfs.ErrObjectNotFound {
+			writeLFSError(w, "Object does not exist", http.StatusNotFound)
+		} else {
+			slog.WarnContext(req.Context(), "failed to open LFS object", "repo", repoName, "oid", oid, "error", err)
+			writeLFSError(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+		return
+	}
+	defer fd.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, fd); err != nil {
+		slog.WarnContext(req.Context(), "failed to send LFS object", "repo", repoName, "oid", oid, "error", err)
+	}
+}
+
+func (handler *LFSHandler) fetchRepoFromRequest(req *http.Request) (git.RepositoryName, bool) {
+	name, err := git.ParseRepositoryName(req.URL.Query().Get(":owner") + "/" + strings.TrimSuffix(req.URL.Query().Get(":repo"), ".git"))
+	return name, err == nil
+}
+
+func writeLFSError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", lfs.MediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{message})
+}
+
+func lfsObjectURL(base *url.URL, repoName, oid string) *url.URL {
+	base.Path += "/" + repoName + ".git/info/lfs/objects/" + oid
+
+	return base
+}
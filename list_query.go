@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/andrewslotin/doppelganger/git"
+)
+
+const (
+	// defaultPerPage is the number of repositories shown on a single page of repository list.
+	defaultPerPage = 50
+	// maxPerPage is the maximum number of repositories that can be requested with per_page parameter.
+	maxPerPage = 500
+)
+
+// repositoryQueryFromRequest reads repository list query from request parameters:
+//
+//   q         - search repositories by name
+//   owner     - only list repositories of given owner
+//   sort      - "name", "commit" or "sync"
+//   page      - page number starting from 1
+//   per_page  - the number of repositories per page
+//
+// If page is not specified, defaultPage is used. Zero defaultPage means that all repositories are listed.
+func repositoryQueryFromRequest(req *http.Request, defaultPage int) git.RepositoryQuery {
+	params := req.URL.Query()
+
+	q := git.RepositoryQuery{
+		Name:    strings.TrimSpace(params.Get("q")),
+		Owner:   strings.TrimSpace(params.Get("owner")),
+		Sort:    params.Get("sort"),
+		Page:    defaultPage,
+		PerPage: defaultPerPage,
+	}
+
+	switch q.Sort {
+	case git.SortByName, git.SortByLastCommit, git.SortByLastSync:
+	default:
+		q.Sort = git.SortByName
+	}
+
+	if page, err := strconv.Atoi(params.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+
+	if perPage, err := strconv.Atoi(params.Get("per_page")); err == nil && perPage > 0 {
+		if perPage > maxPerPage {
+			perPage = maxPerPage
+		}
+		q.PerPage = perPage
+	}
+
+	return q
+}
+
+// repositoryListPage holds the state of paginated repository list to be rendered in template.
+type repositoryListPage struct {
+	git.RepositoryQuery
+
+	Path   string
+	Total  int
+	Owners []string
+}
+
+// Pages returns the total number of pages.
+func (p repositoryListPage) Pages() int {
+	if p.PerPage < 1 {
+		return 1
+	}
+
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// HasPrev returns true if there is a previous page.
+func (p repositoryListPage) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext returns true if there is a next page.
+func (p repositoryListPage) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// PrevURL returns the URL of previous page.
+func (p repositoryListPage) PrevURL() string {
+	return p.pageURL(p.Page - 1)
+}
+
+// NextURL returns the URL of next page.
+func (p repositoryListPage) NextURL() string {
+	return p.pageURL(p.Page + 1)
+}
+
+func (p repositoryListPage) pageURL(page int) string {
+	params := make(url.Values)
+	if p.Name != "" {
+		params.Set("q", p.Name)
+	}
+
+	if p.Owner != "" {
+		params.Set("owner", p.Owner)
+	}
+
+	if p.Sort != git.SortByName {
+		params.Set("sort", p.Sort)
+	}
+
+	if p.PerPage != defaultPerPage {
+		params.Set("per_page", strconv.Itoa(p.PerPage))
+	}
+	params.Set("page", strconv.Itoa(page))
+
+	return p.Path + "?" + params.Encode()
+}
+
+// writePaginationHeaders sets X-Total-Count and Link headers of JSON API response.
+func writePaginationHeaders(w http.ResponseWriter, req *http.Request, q git.RepositoryQuery, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	if q.Page < 1 {
+		return
+	}
+
+	p := repositoryListPage{RepositoryQuery: q, Path: req.URL.Path, Total: total}
+
+	var links []string
+	if p.HasPrev() {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, p.PrevURL()))
+	}
+
+	if p.HasNext() {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, p.NextURL()))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// acceptsJSON returns true if client prefers JSON response over HTML.
+func acceptsJSON(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json") || req.URL.Query().Get("format") == "json"
+}
@@ -0,0 +1,127 @@
+// Package logging sets up structured levelled logging and carries correlation IDs, such as request and job IDs,
+// in context, so that they're attached to every record logged with this context:
+//
+//	ctx = logging.With(ctx, "job_id", logging.NewID())
+//	slog.InfoContext(ctx, "synced mirror", "repo", name)
+//
+// Records written with the standard log package are logged at info level. A "[WARN] " prefix raises the level
+// to warning.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Supported output formats.
+const (
+	// FormatText is logfmt, i.e. time=2019-06-01T12:00:00.000Z level=INFO msg="synced mirror" repo=a/b
+	FormatText = "text"
+	// FormatJSON writes each record as a JSON object on a separate line.
+	FormatJSON = "json"
+)
+
+// legacyWarnPrefix marks warnings written with the standard log package.
+const legacyWarnPrefix = "[WARN] "
+
+type attrsKey struct{}
+
+// Setup makes structured logger writing records of given level and above to w in given format the default one.
+// The output of standard log package is redirected to it as well.
+func Setup(w io.Writer, format, level string) error {
+	h, err := NewHandler(w, format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(h))
+
+	return nil
+}
+
+// NewHandler returns slog.Handler writing records of given level and above to w in given format.
+// The handler adds attributes stored in context with With to each record.
+func NewHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unsupported log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case FormatText:
+		return contextHandler{slog.NewTextHandler(w, opts)}, nil
+	case FormatJSON:
+		return contextHandler{slog.NewJSONHandler(w, opts)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", format)
+	}
+}
+
+// With returns a copy of ctx that carries provided attributes, either key-value pairs or slog.Attr values,
+// in addition to those already stored in ctx.
+func With(ctx context.Context, args ...interface{}) context.Context {
+	return context.WithValue(ctx, attrsKey{}, append(Attrs(ctx), args...))
+}
+
+// Attrs returns attributes stored in ctx with With.
+func Attrs(ctx context.Context) []interface{} {
+	attrs, _ := ctx.Value(attrsKey{}).([]interface{})
+	return attrs[:len(attrs):len(attrs)]
+}
+
+// NewID returns a random identifier to correlate log records.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// contextHandler is a slog.Handler that adds attributes stored in context to records and handles
+// warnings written with the standard log package.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Enabled implements slog.Handler. Info level is always enabled, because it might turn out to be a warning.
+func (h contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level == slog.LevelInfo || h.Handler.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelInfo && strings.HasPrefix(r.Message, legacyWarnPrefix) {
+		r.Level, r.Message = slog.LevelWarn, strings.TrimPrefix(r.Message, legacyWarnPrefix)
+	}
+
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+
+	if attrs := Attrs(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.Add(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,98 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestNewHandler_ContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+
+	h, err := logging.NewHandler(&buf, logging.FormatJSON, "info")
+	require.NoError(t, err)
+	logger := slog.New(h)
+
+	ctx := logging.With(context.Background(), "request_id", "req1")
+	jobCtx := logging.With(ctx, "job_id", "job1")
+
+	logger.InfoContext(jobCtx, "synced mirror", "repo", "a/b")
+	logger.InfoContext(ctx, "served request")
+	logger.DebugContext(jobCtx, "ran git command")
+
+	records := decodeRecords(t, &buf)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "synced mirror", records[0]["msg"])
+	assert.Equal(t, "INFO", records[0]["level"])
+	assert.Equal(t, "a/b", records[0]["repo"])
+	assert.Equal(t, "req1", records[0]["request_id"])
+	assert.Equal(t, "job1", records[0]["job_id"])
+
+	assert.Equal(t, "req1", records[1]["request_id"])
+	assert.NotContains(t, records[1], "job_id", "attributes of derived context should not leak into the parent one")
+}
+
+func TestNewHandler_LegacyLog(t *testing.T) {
+	var buf bytes.Buffer
+
+	h, err := logging.NewHandler(&buf, logging.FormatJSON, "warn")
+	require.NoError(t, err)
+	l := slog.NewLogLogger(h, slog.LevelInfo)
+
+	l.Printf("[WARN] failed to do %s", "something")
+	l.Printf("did %s", "something")
+
+	records := decodeRecords(t, &buf)
+	require.Len(t, records, 1)
+
+	assert.Equal(t, "WARN", records[0]["level"])
+	assert.Equal(t, "failed to do something", records[0]["msg"])
+}
+
+func TestNewHandler_Text(t *testing.T) {
+	var buf bytes.Buffer
+
+	h, err := logging.NewHandler(&buf, logging.FormatText, "debug")
+	require.NoError(t, err)
+
+	slog.New(h).DebugContext(logging.With(context.Background(), "job_id", "job1"), "ran git command", "command", "fetch")
+	assert.Contains(t, buf.String(), `level=DEBUG msg="ran git command" command=fetch job_id=job1`)
+}
+
+func TestNewHandler_InvalidOptions(t *testing.T) {
+	_, err := logging.NewHandler(&bytes.Buffer{}, "xml", "info")
+	assert.Error(t, err)
+
+	_, err = logging.NewHandler(&bytes.Buffer{}, logging.FormatText, "verbose")
+	assert.Error(t, err)
+}
+
+func TestNewID(t *testing.T) {
+	id1, id2 := logging.NewID(), logging.NewID()
+
+	assert.Len(t, id1, 16)
+	assert.NotEqual(t, id1, id2)
+}
+
+func decodeRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var rec map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &rec), line)
+		records = append(records, rec)
+	}
+
+	return records
+}
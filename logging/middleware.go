@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// RequestIDHeader is the name of header that carries request ID. IDs assigned by a reverse proxy are kept,
+	// and the ID is sent back to the client in a response header with the same name.
+	RequestIDHeader = "X-Request-Id"
+	// GitHubDeliveryHeader is the name of header that carries the ID of GitHub webhook delivery.
+	GitHubDeliveryHeader = "X-GitHub-Delivery"
+)
+
+var requestIDRe = regexp.MustCompile(`\A[\w.:-]{1,128}\z`)
+
+// RequestLogger is a middleware that assigns an ID to each request, adds it along with the ID of GitHub
+// webhook delivery to request context and logs the request once it has been served.
+type RequestLogger struct {
+	quiet []string
+}
+
+// NewRequestLogger returns an instance of RequestLogger.
+func NewRequestLogger() *RequestLogger {
+	return &RequestLogger{}
+}
+
+// Quiet lowers the level of records for successful requests to paths such as health checks, that are
+// polled frequently, to debug. A path ending with "/" matches any path under it.
+func (l *RequestLogger) Quiet(paths ...string) {
+	l.quiet = append(l.quiet, paths...)
+}
+
+// Handler wraps next with request logging.
+func (l *RequestLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if !requestIDRe.MatchString(id) {
+			id = NewID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := With(req.Context(), "request_id", id)
+		if delivery := req.Header.Get(GitHubDeliveryHeader); delivery != "" {
+			ctx = With(ctx, "github_delivery", delivery)
+		}
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		startTime := time.Now()
+		next.ServeHTTP(rw, req.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case rw.status >= http.StatusInternalServerError:
+			level = slog.LevelWarn
+		case rw.status < http.StatusBadRequest && l.isQuiet(req.URL.Path):
+			level = slog.LevelDebug
+		}
+
+		slog.Log(ctx, level, "served request",
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", rw.status,
+			"bytes", rw.bytes,
+			"duration", time.Since(startTime),
+			"remote_addr", req.RemoteAddr,
+		)
+	})
+}
+
+func (l *RequestLogger) isQuiet(path string) bool {
+	for _, p := range l.quiet {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// responseWriter records response status and size. It implements http.Flusher, so that streamed responses,
+// i.e. git packs, are not buffered, and exposes the original http.ResponseWriter for http.ResponseController.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
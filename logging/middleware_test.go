@@ -0,0 +1,112 @@
+package logging_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogger_Handler(t *testing.T) {
+	var buf bytes.Buffer
+	setupTestLogger(t, &buf, "info")
+
+	var (
+		ctxAttrs []interface{}
+		flushed  bool
+	)
+	l := logging.NewRequestLogger()
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctxAttrs = logging.Attrs(req.Context())
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("OK"))
+
+		f, ok := w.(http.Flusher)
+		require.True(t, ok, "response writer should support streaming")
+		f.Flush()
+		flushed = true
+	}))
+
+	req := httptest.NewRequest("POST", "/apihook", nil)
+	req.Header.Set(logging.GitHubDeliveryHeader, "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.True(t, flushed)
+	assert.True(t, rec.Flushed)
+
+	id := rec.Header().Get(logging.RequestIDHeader)
+	require.NotEmpty(t, id)
+	assert.Equal(t, []interface{}{"request_id", id, "github_delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958"}, ctxAttrs)
+
+	records := decodeRecords(t, &buf)
+	require.Len(t, records, 1)
+
+	assert.Equal(t, "served request", records[0]["msg"])
+	assert.Equal(t, "POST", records[0]["method"])
+	assert.Equal(t, "/apihook", records[0]["path"])
+	assert.Equal(t, float64(http.StatusAccepted), records[0]["status"])
+	assert.Equal(t, float64(2), records[0]["bytes"])
+	assert.Equal(t, id, records[0]["request_id"])
+	assert.Equal(t, "72d3162e-cc78-11e3-81ab-4c9367dc0958", records[0]["github_delivery"])
+}
+
+func TestRequestLogger_Handler_RequestIDHeader(t *testing.T) {
+	setupTestLogger(t, &bytes.Buffer{}, "info")
+
+	h := logging.NewRequestLogger().Handler(http.NotFoundHandler())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(logging.RequestIDHeader, "proxy-assigned.1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, "proxy-assigned.1", rec.Header().Get(logging.RequestIDHeader))
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(logging.RequestIDHeader, "not a valid\nid")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Len(t, rec.Header().Get(logging.RequestIDHeader), 16)
+}
+
+func TestRequestLogger_Quiet(t *testing.T) {
+	var buf bytes.Buffer
+	setupTestLogger(t, &buf, "info")
+
+	l := logging.NewRequestLogger()
+	l.Quiet("/healthz", "/assets/")
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("fail") != "" {
+			http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		}
+	}))
+
+	for _, path := range []string{"/healthz", "/assets/app.css", "/healthz?fail=1", "/"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	records := decodeRecords(t, &buf)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "/healthz", records[0]["path"])
+	assert.Equal(t, "WARN", records[0]["level"])
+	assert.Equal(t, "/", records[1]["path"])
+	assert.Equal(t, "INFO", records[1]["level"])
+}
+
+// setupTestLogger makes slog write JSON records to w for the duration of test.
+func setupTestLogger(t *testing.T, w *bytes.Buffer, level string) {
+	h, err := logging.NewHandler(w, logging.FormatJSON, level)
+	require.NoError(t, err)
+
+	orig := slog.Default()
+	slog.SetDefault(slog.New(h))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Transport is an http.RoundTripper that logs outgoing requests at debug level along with attributes stored
+// in request context, so that API calls can be traced back to the request or job that made them.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewTransport returns an instance of Transport that sends requests using base transport.
+// If base is nil, http.DefaultTransport is used.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	startTime := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		slog.WarnContext(ctx, "outgoing request failed",
+			"method", req.Method,
+			"url", req.URL.Redacted(),
+			"duration", time.Since(startTime),
+			"error", err,
+		)
+
+		return nil, err
+	}
+
+	args := []interface{}{
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"status", resp.StatusCode,
+		"duration", time.Since(startTime),
+	}
+	if id := resp.Header.Get("X-GitHub-Request-Id"); id != "" {
+		args = append(args, "github_request_id", id)
+	}
+	slog.DebugContext(ctx, "outgoing request", args...)
+
+	return resp, nil
+}
@@ -4,20 +4,49 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/andrewslotin/doppelganger/audit"
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/andrewslotin/doppelganger/config"
 	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/git/lfs"
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/andrewslotin/doppelganger/logging"
+	"github.com/andrewslotin/doppelganger/peer"
 	"github.com/andrewslotin/doppelganger/server"
 	"github.com/bmizerany/pat"
 )
 
+const (
+	// peerSubscriptionTTL is the time a change notifications subscription of secondary instance is kept
+	// without being renewed.
+	peerSubscriptionTTL = time.Hour
+	// peerSyncQueueSize is the maximum number of pending mirror sync jobs on secondary instance.
+	peerSyncQueueSize = 1024
+	// peerSyncWorkers is the number of mirrors synchronized simultaneously on secondary instance.
+	peerSyncWorkers = 4
+	// notificationTimeout limits the time it takes to deliver a notification to a webhook.
+	notificationTimeout = 10 * time.Second
+	// webhookTimeout limits the time it takes to deliver a mirror update to an outgoing webhook.
+	webhookTimeout = 30 * time.Second
+	// webhookWorkers is the number of outgoing webhook deliveries sent simultaneously.
+	webhookWorkers = 4
+	// cloneJobRetention is the time the outcome of a mirror created in background is kept for progress page.
+	cloneJobRetention = 10 * time.Minute
+)
+
 var (
 	// Version is used in help message and logs and set by Makefile
 	Version = "n/a"
@@ -27,22 +56,95 @@ var (
 	PrivateKeyPath = path.Join(os.Getenv("HOME"), ".ssh", "id_rsa")
 
 	args struct {
-		version   bool
-		addr      string
-		port      int
-		mirrorDir string
+		version          bool
+		config           string
+		addr             string
+		port             int
+		tlsCert          string
+		tlsKey           string
+		tlsMinVersion    string
+		tlsClientCA      string
+		tlsClientAuth    string
+		mirrorDir        string
+		dataDir          string
+		auditMaxSize     int
+		auditMaxFiles    int
+		pushTargetDir    string
+		templatesDir     string
+		logFormat        string
+		logLevel         string
+		shutdownTimeout  time.Duration
+		lfs              bool
+		mirrorSubmodules bool
+		primary          string
+		peerInterval     time.Duration
+		peerCallbackURL  string
+		githubCacheTTL   time.Duration
+		githubCacheFile  string
+
+		sessionTTL            time.Duration
+		htpasswd              string
+		authProxyHeader       string
+		authProxyEmailHeader  string
+		authProxyGroupsHeader string
+		authTrustedProxies    string
+		oidcIssuer            string
+		oidcClientID          string
+		oidcRedirectURL       string
+		roles                 string
+
+		githubOAuthClientID    string
+		githubOAuthRedirectURL string
+		githubPermissionsTTL   time.Duration
 	}
 )
 
 func init() {
 	flag.BoolVar(&args.version, "version", false, "Print version and exit")
+	flag.StringVar(&args.config, "config", "", "YAML file with server settings and the list of mirrors, flags take precedence over it")
 	flag.StringVar(&args.addr, "addr", "", "Listen address")
 	flag.IntVar(&args.port, "port", 8081, "Listen port")
+	flag.StringVar(&args.tlsCert, "tls-cert", "", "PEM-encoded TLS certificate to serve HTTPS with, reloaded once changed")
+	flag.StringVar(&args.tlsKey, "tls-key", "", "PEM-encoded private key of TLS certificate, reloaded once changed")
+	flag.StringVar(&args.tlsMinVersion, "tls-min-version", "1.2", "Minimum accepted TLS version (1.0, 1.1, 1.2 or 1.3)")
+	flag.StringVar(&args.tlsClientCA, "tls-client-ca", "", "PEM file with CA certificates to verify client certificates against")
+	flag.StringVar(&args.tlsClientAuth, "tls-client-auth", "require", "Whether client certificate is required (require) or only verified if presented (verify-if-given)")
 	flag.StringVar(&args.mirrorDir, "mirror", filepath.Join(os.Getenv("GOPATH"), "src", "github.com"), "Mirrored repositories directory")
+	flag.StringVar(&args.dataDir, "data-dir", "", "Directory to keep API tokens and other state in (default <mirror>/.doppelganger)")
+	flag.IntVar(&args.auditMaxSize, "audit-max-size", 100, "Size in megabytes audit log is rotated at, 0 disables rotation")
+	flag.IntVar(&args.auditMaxFiles, "audit-max-files", 5, "Number of rotated audit log files to keep")
+	flag.StringVar(&args.pushTargetDir, "push-target-dir", "", "Directory mirrors can be pushed to as bare repositories on local filesystem, only remote push targets are allowed unless set")
+	flag.StringVar(&args.templatesDir, "templates-dir", "", "Directory to load page templates from instead of built-in ones, templates are re-read on each request")
+	flag.StringVar(&args.logFormat, "log-format", logging.FormatText, "Log format, either text (logfmt) or json")
+	flag.StringVar(&args.logLevel, "log-level", "info", "Minimum level of log records (debug, info, warn or error)")
+	flag.DurationVar(&args.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to wait for running requests and mirror syncs to finish on shutdown before interrupting them")
+	flag.BoolVar(&args.lfs, "lfs", true, "Fetch Git LFS objects of mirrored repositories")
+	flag.BoolVar(&args.mirrorSubmodules, "mirror-submodules", false, "Automatically mirror GitHub repositories referenced as submodules")
+	flag.DurationVar(&args.githubCacheTTL, "github-cache-ttl", 10*time.Minute, "Time GitHub repositories list is cached before being refreshed in background")
+	flag.StringVar(&args.githubCacheFile, "github-cache-file", "", "File to persist cached GitHub repositories list across restarts")
+	flag.StringVar(&args.primary, "primary", "", "URL of primary Doppelganger instance to replicate mirrors from")
+	flag.DurationVar(&args.peerInterval, "peer-interval", 10*time.Minute, "Interval between full synchronizations with primary instance")
+	flag.StringVar(&args.peerCallbackURL, "peer-callback-url", "", "URL of this instance used by primary to send change notifications (default http(s)://<hostname>:<port>)")
+
+	flag.DurationVar(&args.sessionTTL, "session-ttl", 24*time.Hour, "Time a user stays logged in")
+	flag.StringVar(&args.htpasswd, "htpasswd", "", "Authenticate users with HTTP basic auth against an htpasswd file (bcrypt or SHA1 hashes)")
+	flag.StringVar(&args.authProxyHeader, "auth-proxy-header", "", "Trust authenticated user name passed by reverse proxy in this header, i.e. "+auth.DefaultProxyUserHeader)
+	flag.StringVar(&args.authProxyEmailHeader, "auth-proxy-email-header", "", "Header used by reverse proxy to pass authenticated user email")
+	flag.StringVar(&args.authProxyGroupsHeader, "auth-proxy-groups-header", "", "Header used by reverse proxy to pass comma-separated list of user groups")
+	flag.StringVar(&args.authTrustedProxies, "auth-trusted-proxies", "127.0.0.1,::1", "Comma-separated list of reverse proxy addresses or CIDRs allowed to set authentication headers")
+	flag.StringVar(&args.oidcIssuer, "oidc-issuer", "", "OpenID Connect provider URL to log users in with")
+	flag.StringVar(&args.oidcClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&args.oidcRedirectURL, "oidc-redirect-url", "", "OpenID Connect callback URL (default <request host>"+auth.CallbackPath+")")
+
+	flag.StringVar(&args.githubOAuthClientID, "github-oauth-client-id", "", "GitHub OAuth app client ID to log users in with GitHub and restrict mirrors of private repositories to their GitHub collaborators")
+	flag.StringVar(&args.githubOAuthRedirectURL, "github-oauth-redirect-url", "", "GitHub OAuth callback URL (default <request host>"+auth.CallbackPath+")")
+	flag.DurationVar(&args.githubPermissionsTTL, "github-permissions-ttl", 5*time.Minute, "Time GitHub repository permissions of a user are cached")
+	flag.StringVar(&args.roles, "roles", "", "File with role assignments, by default every authenticated user is an admin")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n\nOptions:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] [COMMAND]\n\nOptions:\n", os.Args[0])
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n%s", commandsUsage)
 		os.Exit(2)
 	}
 }
@@ -54,12 +156,74 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.SetOutput(os.Stderr)
-	log.SetFlags(log.LstdFlags)
+	if err := logging.Setup(os.Stderr, args.logFormat, args.logLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	if args.config != "" {
+		c, err := loadConfig(args.config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg = c
+	}
+
+	token, err := configuredSecret("DOPPELGANGER_GITHUB_TOKEN", cfg.Sources.GitHub.Token)
+	if err != nil {
+		log.Fatalf("failed to read GitHub access token: %s", err)
+	}
+
+	webhookSecret, err := configuredSecret("DOPPELGANGER_WEBHOOK_SECRET", cfg.Sources.GitHub.WebhookSecret)
+	if err != nil {
+		log.Fatalf("failed to read webhook secret: %s", err)
+	}
+
+	if args.dataDir == "" {
+		args.dataDir = filepath.Join(args.mirrorDir, ".doppelganger")
+	}
+
+	auditLog, err := audit.Open(filepath.Join(args.dataDir, "audit.jsonl"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	if args.auditMaxSize > 0 {
+		auditLog.EnableRotation(int64(args.auditMaxSize)<<20, args.auditMaxFiles)
+	}
+
+	if flag.NArg() > 0 {
+		c, err := newCLI(cfg, token, webhookSecret, auditLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Interrupted commands clean up after themselves, i.e. remove partially cloned mirrors
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			cancel()
+		}()
+
+		err = c.Run(ctx, flag.Args())
+		cancel()
+
+		switch err {
+		case nil:
+			os.Exit(0)
+		case errUsage:
+			flag.Usage()
+		default:
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
-	token := os.Getenv("DOPPELGANGER_GITHUB_TOKEN")
 	if token == "" {
-		fmt.Fprintln(os.Stderr, "Missing GitHub access token (set DOPPELGANGER_GITHUB_TOKEN environment variable)")
+		fmt.Fprintln(os.Stderr, "Missing GitHub access token (set DOPPELGANGER_GITHUB_TOKEN environment variable or sources.github.token in configuration file)")
 		os.Exit(-1)
 	}
 
@@ -68,41 +232,290 @@ func main() {
 		log.Fatal(err)
 	}
 
+	githubRateLimit = repositoryService
+
+	repositoryService.SetWebhookSecret(webhookSecret)
+	repositoryService.EnableAudit(auditLog)
+
+	tokens, err := auth.NewTokenStore(filepath.Join(args.dataDir, "tokens.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	users, err := auth.NewUserStore(filepath.Join(args.dataDir, "users.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokens.EnableUsers(users)
+
+	authMiddleware, err := newAuthMiddleware(tokens, users, cfg.PublicURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Outgoing API requests are logged at debug level along with the ID of request or job that made them
+	apiClient := &http.Client{Transport: logging.NewTransport(nil)}
+
+	if args.githubOAuthClientID != "" {
+		githubPermissions = auth.NewGitHubPermissions(apiClient, token, args.githubPermissionsTTL)
+	}
+
+	if args.roles != "" {
+		if !authMiddleware.Enabled() {
+			log.Fatal("roles require authentication to be enabled")
+		}
+
+		if accessPolicy, err = auth.LoadPolicy(args.roles); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !authMiddleware.Enabled() {
+		slog.Warn("authentication is disabled, anyone who can reach doppelganger is able to read and mirror repositories")
+	} else if webhookSecret == "" {
+		// /apihook is public, so without a secret anyone would be able to trigger mirror updates
+		log.Fatal("DOPPELGANGER_WEBHOOK_SECRET is required when authentication is enabled")
+	}
+
 	gitCmd, err := git.SystemGit()
 	if err != nil {
 		log.Fatal(err)
 	}
 	mirroredRepositoryService := git.NewMirroredRepositories(args.mirrorDir, gitCmd)
+	if args.lfs {
+		lfsClient := lfs.NewClient(apiClient)
+		lfsClient.SetCredential(lfs.GitHubHost, token)
+		mirroredRepositoryService.EnableLFS(lfsClient)
+	}
+	mirroredRepositoryService.EnableAudit(auditLog)
+	if args.pushTargetDir != "" {
+		mirroredRepositoryService.EnableLocalPushTargets(args.pushTargetDir)
+	}
+	git.RegisterMirrorMetrics(mirroredRepositoryService)
+	submoduleMirrors := git.NewSubmoduleMirrors(mirroredRepositoryService, repositoryService, args.mirrorSubmodules)
+	submoduleMirrors.EnableAccessCheck(canMirrorSubmodule)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Changes made by background jobs are attributed to them in audit log
+	replicatorCtx := audit.WithActor(ctx, audit.Actor{Type: audit.ActorScheduler, Name: "replicator"})
+	reconcilerCtx := audit.WithActor(ctx, audit.Actor{Type: audit.ActorScheduler, Name: "config"})
+
+	monitor, err := newNotificationMonitor(cfg.Notifications, &http.Client{Timeout: notificationTimeout, Transport: logging.NewTransport(nil)}, mirroredRepositoryService)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if monitor != nil {
+		mirroredRepositoryService.EnableNotifications(monitor)
+		repositoryService.EnableNotifications(monitor)
+		go monitor.Run(ctx)
+	}
+
+	webhookClient := hooks.NewHTTPClient(webhookTimeout)
+	webhookClient.Transport = logging.NewTransport(webhookClient.Transport)
+
+	webhooks, err := hooks.NewService(filepath.Join(args.dataDir, "webhooks.json"), webhookClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhooks.SetBaseURL(cfg.PublicURL)
+	webhooks.EnableAudit(auditLog)
+	webhooks.EnableMirrorCheck(mirroredRepositoryService.Exists)
+	mirroredRepositoryService.EnableWebhooks(webhooks)
+	go webhooks.Run(ctx, webhookWorkers)
+
+	clones := git.NewCloneJobs(cloneJobRetention)
+
+	// Peer notifications are signed with a secret shared by all instances
+	peerSecret := os.Getenv("DOPPELGANGER_PEER_SECRET")
+
+	notifier := peer.NewNotifier(nil, peerSubscriptionTTL)
+	notifier.SetSecret(peerSecret)
+	notifyingMirrors := peer.NewNotifyingMirrors(submoduleMirrors, notifier)
+	submoduleMirrors.SetMirrorService(peer.NewNotifyingMirrors(mirroredRepositoryService, notifier))
+
+	srv := server.New(args.addr, args.port)
+	if args.tlsCert != "" || args.tlsKey != "" {
+		opts, err := tlsOptions()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := srv.EnableTLS(opts); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var (
+		replicator *peer.Replicator
+		queue      *git.SyncQueue
+	)
+	if args.primary != "" {
+		if peerSecret == "" {
+			log.Fatal("missing peer secret, set DOPPELGANGER_PEER_SECRET to the same value as on primary instance")
+		}
+
+		callbackURL := args.peerCallbackURL
+		if callbackURL == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("failed to determine callback URL, please set it with -peer-callback-url (%s)", err)
+			}
+			scheme := "http"
+			if srv.TLS() {
+				scheme = "https"
+			}
+			callbackURL = fmt.Sprintf("%s://%s:%d", scheme, hostname, args.port)
+		}
+
+		queue = git.NewSyncQueue(peerSyncQueueSize)
+		go queue.Run(replicatorCtx, peerSyncWorkers)
+
+		replicator = peer.NewReplicator(peer.NewClient(apiClient, args.primary), mirroredRepositoryService, queue, strings.TrimSuffix(callbackURL, "/")+"/api/peers/notify", args.peerInterval)
+		go replicator.Run(replicatorCtx)
+
+		slog.Info("replicating mirrors", "primary", audit.RedactURL(args.primary))
+	}
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readiness := server.NewReadinessHandler()
+	readiness.AddCheck("git", func(ctx context.Context) error {
+		_, err := gitCmd.Version(ctx)
+		return err
+	})
+	readiness.AddCheck("mirror_dir", checkMirrorDir(args.mirrorDir))
+	readiness.AddCheck("templates", checkTemplates)
+	readiness.AddCheck("github", server.CachedCheck(githubCheckInterval, repositoryService.CheckToken))
+	if queue != nil {
+		readiness.AddCheck("sync_queue", func(ctx context.Context) error {
+			return queue.Check(maxSyncDuration)
+		})
+	}
+
+	reconciler := config.NewReconciler(notifyingMirrors, repositoryService)
+	reconciler.EnablePushTargets(mirroredRepositoryService)
+	if hookURL := cfg.WebhookURL(); hookURL != "" {
+		reconciler.EnableTracking(repositoryService, hookURL)
+	} else if len(cfg.Mirrors) > 0 {
+		slog.Warn("public_url is not set in configuration file, webhooks for declared mirrors won't be set up")
+	}
+	go reconcileMirrors(reconcilerCtx, reconciler, cfg)
+
+	cachedRepositoryService := git.NewCachedRepositories(repositoryService, args.githubCacheTTL, args.githubCacheFile)
+	go cachedRepositoryService.Run(ctx)
 
 	mux := pat.New()
-	mux.Get("/favicon.ico", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./assets/favicon.ico")
-	}))
+	mux.Get("/healthz", server.HealthHandler{})
+	mux.Get("/readyz", readiness)
+	assets := assetsHandler()
+	mux.Get("/favicon.ico", faviconHandler(assets))
 
+	mux.Get("/metrics", NewMetricsHandler())
+	mux.Get("/api/mirrors", NewMirrorsAPIHandler(mirroredRepositoryService, cfg.PublicURL))
+	mux.Post("/api/peers/subscribe", NewPeerHandler(notifier, nil, peerSecret))
+	mux.Post("/api/peers/notify", NewPeerHandler(nil, replicator, peerSecret))
+	mux.Get("/api/peers/", server.MethodNotAllowed{"POST"})
+	mux.Get("/admin/audit", NewAuditLogHandler(auditLog))
+	mux.Get("/webhooks", NewWebhooksHandler(webhooks))
+	mux.Post("/webhooks", NewWebhooksHandler(webhooks))
+	mux.Get("/webhooks/:id", NewWebhooksHandler(webhooks))
+	mux.Post("/webhooks/:id", server.MethodNotAllowed{"GET"})
+	mux.Get("/:owner/:repo/info/refs", NewGitHTTPHandler(gitPath, args.mirrorDir))
+	mux.Post("/:owner/:repo/git-upload-pack", NewGitHTTPHandler(gitPath, args.mirrorDir))
+	mux.Get("/:owner/:repo/git-upload-pack", server.MethodNotAllowed{"POST"})
 	mux.Get("/:owner/:repo", NewRepoHandler(mirroredRepositoryService))
+	mux.Post("/:owner/:repo/info/lfs/objects/batch", NewLFSHandler(mirroredRepositoryService, cfg.PublicURL))
+	mux.Get("/:owner/:repo/info/lfs/objects/batch", server.MethodNotAllowed{"POST"})
+	mux.Get("/:owner/:repo/info/lfs/objects/:oid", NewLFSHandler(mirroredRepositoryService, cfg.PublicURL))
 	mux.Get("/", NewReposHandler(mirroredRepositoryService, true))
 	mux.Get("/src/:owner/:repo", NewRepoHandler(repositoryService))
-	mux.Get("/src/", NewReposHandler(repositoryService, false))
-	mux.Post("/mirror", NewMirrorHandler(repositoryService, mirroredRepositoryService, repositoryService))
-	mux.Get("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets"))))
+	mux.Get("/:owner/:repo/progress", NewCloneProgressHandler(clones, srv.ShuttingDown()))
+	mux.Get("/src/", NewReposHandler(cachedRepositoryService, false))
+	mux.Post("/src/", NewReposHandler(cachedRepositoryService, false))
+	if authMiddleware.Enabled() {
+		mux.Get("/tokens", NewTokensHandler(tokens))
+		mux.Post("/tokens", NewTokensHandler(tokens))
+	}
+	mirrorHandler := NewMirrorHandler(repositoryService, notifyingMirrors, repositoryService, submoduleMirrors, clones, cfg.PublicURL)
+	mirrorHandler.EnableAudit(auditLog)
+	mux.Post("/mirror", mirrorHandler)
+	mux.Get("/mirror", server.MethodNotAllowed{"POST"})
+	mux.Get("/assets/", http.StripPrefix("/assets/", assets))
 
 	// GitHub webhooks
-	mux.Post("/apihook", NewWebhookHandler(mirroredRepositoryService))
+	mux.Post("/apihook", NewWebhookHandler(notifyingMirrors, webhookSecret))
+	mux.Get("/apihook", server.MethodNotAllowed{"POST"})
 
-	srv := server.New(args.addr, args.port)
-	if err := srv.Run(mux); err != nil {
+	var handler http.Handler = auditActorHandler(mux)
+	if authMiddleware.Enabled() {
+		handler = authMiddleware.Handler(handler)
+	}
+
+	// Webhooks and peer notifications come from other servers and are authenticated with their own secrets
+	csrf := auth.NewCSRF()
+	csrf.Secure = secureCookies(cfg.PublicURL)
+	csrf.Exempt("/apihook", "/api/peers/")
+	handler = csrf.Handler(handler)
+
+	requestLogger := logging.NewRequestLogger()
+	requestLogger.Quiet("/healthz", "/readyz", "/metrics", "/assets/", "/favicon.ico")
+	handler = requestLogger.Handler(handler)
+
+	if err := srv.Run(handler); err != nil {
 		log.Panic(err)
 	}
-	log.Printf("doppelganger %s is listening on %s", Version, srv.Addr)
+	if srv.TLS() {
+		slog.Info("doppelganger is listening", "version", Version, "url", "https://"+srv.Addr)
+	} else {
+		slog.Info("doppelganger is listening", "version", Version, "url", "http://"+srv.Addr)
+	}
 
-	signals := make(chan os.Signal)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	select {
-	case <-signals:
-		log.Println("shutdown signal received, terminating...")
-		if err := srv.Shutdown(); err != nil {
-			log.Fatal(err)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if args.config == "" {
+				slog.Warn("SIGHUP received, but there is no configuration file to reload")
+				continue
+			}
+
+			// Only the list of mirrors is reloaded, other settings require restart
+			c, err := config.Load(args.config)
+			if err != nil {
+				slog.Warn("failed to reload configuration, keeping the current one", "path", args.config, "error", err)
+				continue
+			}
+			slog.Info("reloaded configuration", "path", args.config)
+
+			go reconcileMirrors(reconcilerCtx, reconciler, c)
+			continue
 		}
+
+		slog.Info("shutdown signal received, waiting for running requests and syncs to finish", "timeout", args.shutdownTimeout)
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), args.shutdownTimeout)
+		defer cancelShutdown()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("some requests have not been completed in time", "error", err)
+		}
+
+		for _, op := range mirroredRepositoryService.Shutdown(shutdownCtx) {
+			slog.Warn("interrupted mirror operation", "operation", op)
+		}
+
+		if queue != nil && queue.Len() > 0 {
+			slog.Warn("queued mirror syncs have been dropped", "jobs", queue.Len())
+		}
+
+		slog.Info("terminated")
+
+		return
 	}
 }
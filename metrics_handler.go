@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsHandler is a type that implements http.Handler interface and is used to expose Prometheus metrics at
+// "/metrics". Since metrics are labeled with repository names, they are only available to admins who are allowed
+// to see all mirrors, such as a service API token used by Prometheus:
+//
+//   curl -H 'Authorization: Bearer dgt_...' http://doppelganger/metrics
+type MetricsHandler struct {
+	metrics http.Handler
+}
+
+// NewMetricsHandler creates and initializes a new handler exposing metrics from the default Prometheus registry.
+func NewMetricsHandler() *MetricsHandler {
+	return &MetricsHandler{
+		metrics: promhttp.Handler(),
+	}
+}
+
+func (handler *MetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !accessFromRequest(req).CanViewAll() {
+		WriteForbidden(w, req, "Metrics are only available to admins")
+		return
+	}
+
+	handler.metrics.ServeHTTP(w, req)
+}
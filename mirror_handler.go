@@ -1,88 +1,130 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/andrewslotin/doppelganger/audit"
 	"github.com/andrewslotin/doppelganger/git"
 	"github.com/andrewslotin/doppelganger/git/gitssh"
 	"golang.org/x/net/context"
 )
 
 var (
-	privateRepoAccessTemplate = template.Must(template.ParseFiles("templates/layout.html.template", "templates/mirror/private_repo_access.html.template"))
+	privateRepoAccessTemplate = parsePageTemplate("mirror/private_repo_access.html.template")
+
+	// errHostCredential is returned by AddPushTarget if push target credential refers to a secret kept on server.
+	// Such credentials can only be used by push targets declared in configuration file, otherwise anyone allowed to
+	// add a push target could send server secrets to a remote of their choice.
+	errHostCredential = errors.New("environment and file credentials are only allowed in configuration file")
+	// errCloneInProgress is recorded to audit log for create requests that follow a clone started by someone else.
+	errCloneInProgress = errors.New("mirror is being created already")
+
+	actionCreate = audit.Action("create")
 )
 
-// MirrorHandler is a type that implements http.Handler interface and is used to handle requests to "/mirror".
+// MirrorHandler is a type that implements http.Handler interface and is used to handle POST requests to "/mirror".
 // An action that needs to be executed is defined by "action" form variable. Target repository is specified by its name passed in
-// request parameter "name".
+// form variable "repo". Each action requires a role and token scope listed in mirrorActionPermissions for the target repository.
+// Scripts authenticate with an API token in Authorization header, since requests with a bearer token are not subject to CSRF checks.
 //
 //   // Create a new mirror of andrewslotin/doppelganger
-//   curl http://doppelganger/mirror?action=create&name=andrewslotin/doppelganger
+//   curl -X POST -H 'Authorization: Bearer <token>' -d action=create -d repo=andrewslotin/doppelganger http://doppelganger/mirror
 //   // Update an existing mirror of andrewslotin/doppelganger
-//   curl http://doppelganger/mirror?action=update&name=andrewslotin/doppelganger
+//   curl -X POST -H 'Authorization: Bearer <token>' -d action=update -d repo=andrewslotin/doppelganger http://doppelganger/mirror
 //   // Set up tracking of changes in andrewslotin/doppelganger
-//   curl http://doppelganger/mirror?action=track&name=andrewslotin/doppelganger
+//   curl -X POST -H 'Authorization: Bearer <token>' -d action=track -d repo=andrewslotin/doppelganger http://doppelganger/mirror
+//   // Replicate andrewslotin/doppelganger to another remote after each update
+//   curl -X POST -H 'Authorization: Bearer <token>' -d action=add-push-target -d repo=andrewslotin/doppelganger -d target=gitea \
+//        -d url=git@gitea:doppelganger.git -d credential=ssh-key:/etc/doppelganger/gitea_rsa http://doppelganger/mirror
+//   // Stop replicating andrewslotin/doppelganger to a remote
+//   curl -X POST -H 'Authorization: Bearer <token>' -d action=remove-push-target -d repo=andrewslotin/doppelganger -d target=gitea http://doppelganger/mirror
+//
+// Browsers creating a mirror are redirected to "/:owner/:repo/progress" right away while the clone runs in background,
+// other clients wait until it's done.
 type MirrorHandler struct {
 	githubRepos      git.RepositoryService
 	mirroredRepos    git.MirrorService
 	trackRepoService git.TrackingService
+	pushService      git.PushService
+	clones           *git.CloneJobs
+	publicURL        string
+	audit            *audit.Log
 }
 
-// NewMirrorHandler creates and initializes a new handler.
-func NewMirrorHandler(githubRepos git.RepositoryService, mirroredRepos git.MirrorService, trackingService git.TrackingService) *MirrorHandler {
+// NewMirrorHandler creates and initializes a new handler. The trackingService, pushService and clones are optional,
+// without clones all mirrors are created synchronously. Webhooks are set up to be sent to publicURL, or to the host
+// the request has been sent to if it's empty.
+func NewMirrorHandler(githubRepos git.RepositoryService, mirroredRepos git.MirrorService, trackingService git.TrackingService, pushService git.PushService, clones *git.CloneJobs, publicURL string) *MirrorHandler {
 	return &MirrorHandler{
 		githubRepos:      githubRepos,
 		mirroredRepos:    mirroredRepos,
 		trackRepoService: trackingService,
+		pushService:      pushService,
+		clones:           clones,
+		publicURL:        publicURL,
 	}
 }
 
+// EnableAudit makes MirrorHandler record create requests that follow a running clone instead of starting a new one
+// to log. Other actions are recorded by the services that perform them.
+func (handler *MirrorHandler) EnableAudit(log *audit.Log) {
+	handler.audit = log
+}
+
 func (handler *MirrorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	startTime := time.Now()
 	ctx := req.Context()
 
-	repoName, ok := handler.fetchRepoFromRequest(req)
+	name, ok := handler.fetchRepoFromRequest(req)
 	if !ok {
-		WriteErrorPage(w, UserError{Message: "Missing source repository name", BackURL: req.Referer()}, http.StatusBadRequest)
+		WriteErrorPage(w, UserError{Message: "Missing or invalid source repository name", BackURL: req.Referer()}, http.StatusBadRequest)
+		return
+	}
+	repoName := name.String()
+
+	action := strings.ToLower(req.FormValue("action"))
+	if !accessFromRequest(req).Can(action, repoName) {
+		WriteForbidden(w, req, fmt.Sprintf("You are not allowed to %s %s", action, repoName))
 		return
 	}
 
-	switch action := strings.ToLower(req.FormValue("action")); action {
+	switch action {
 	case "create":
-		if err := handler.CreateMirror(ctx, w, repoName); err != nil {
-			if err == git.ErrorNotFound {
-				err = handler.ShowPrivateRepoAccessPage(w, repoName, action)
-				if err == nil {
-					return
-				}
+		if handler.clones != nil && strings.Contains(req.Header.Get("Accept"), "text/html") {
+			job, started, err := handler.StartMirror(ctx, req, name)
+			if err != nil {
+				handler.writeCreateError(w, req, repoName, action, err)
+				return
+			}
 
-				log.Printf("failed to obtain public key: %s", err)
+			if started {
+				slog.InfoContext(ctx, "started mirroring repository", "repo", job.FullName)
 			} else {
-				log.Printf("failed to create mirror %s: %s", repoName, err)
+				slog.InfoContext(ctx, "repository is being mirrored already, following the running clone", "repo", job.FullName, "started_at", job.StartedAt)
+				handler.audit.Record(ctx, actionCreate, job.FullName, nil, errCloneInProgress)
 			}
+			http.Redirect(w, req, "/"+job.FullName+"/progress", http.StatusSeeOther)
 
-			userErr := UserError{
-				Message:       "Internal server error",
-				BackURL:       req.Referer(),
-				OriginalError: err,
-			}
-			WriteErrorPage(w, userErr, http.StatusInternalServerError)
+			return
+		}
 
+		if err := handler.CreateMirror(ctx, w, name); err != nil {
+			handler.writeCreateError(w, req, repoName, action, err)
 			return
 		}
 
 		if req.FormValue("notrack") == "" && handler.trackRepoService != nil {
-			if err := handler.SetupChangeTracking(ctx, w, req, repoName); err != nil {
+			if err := handler.SetupChangeTracking(ctx, w, req, name); err != nil {
 				if err == git.ErrorNotMirrored {
 					WriteNotFoundPage(w, fmt.Sprintf("Repository %s was not mirrored yet", repoName), "/src/"+repoName)
 				} else {
-					log.Printf("failed to track changes for mirror %s: %s", repoName, err)
+					slog.WarnContext(ctx, "failed to track changes for mirror", "repo", repoName, "error", err)
 					userErr := UserError{
 						Message:       "Failed to set up push web hook, please check logs for details",
 						BackURL:       req.Referer(),
@@ -95,14 +137,14 @@ func (handler *MirrorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 			}
 		}
 
-		log.Printf("mirrored %s [%s]", repoName, time.Since(startTime))
+		slog.InfoContext(ctx, "mirrored repository", "repo", repoName, "duration", time.Since(startTime))
 		handler.redirectToRepository(w, req, repoName)
 	case "update":
-		if err := handler.UpdateMirror(ctx, w, repoName); err != nil {
+		if err := handler.UpdateMirror(ctx, w, name); err != nil {
 			if err == git.ErrorNotMirrored {
 				WriteNotFoundPage(w, fmt.Sprintf("Repository %s was not mirrored yet", repoName), "/src/"+repoName)
 			} else {
-				log.Printf("failed to update mirror %s: %s", repoName, err)
+				slog.WarnContext(ctx, "failed to update mirror", "repo", repoName, "error", err)
 				userErr := UserError{
 					Message:       "Internal server error",
 					BackURL:       req.Referer(),
@@ -114,7 +156,7 @@ func (handler *MirrorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 			return
 		}
 
-		log.Printf("updated mirror %s [%s]", repoName, time.Since(startTime))
+		slog.InfoContext(ctx, "updated mirror", "repo", repoName, "duration", time.Since(startTime))
 		handler.redirectToRepository(w, req, repoName)
 	case "track":
 		if handler.trackRepoService == nil {
@@ -122,11 +164,11 @@ func (handler *MirrorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 			return
 		}
 
-		if err := handler.SetupChangeTracking(ctx, w, req, repoName); err != nil {
+		if err := handler.SetupChangeTracking(ctx, w, req, name); err != nil {
 			if err == git.ErrorNotMirrored {
 				WriteNotFoundPage(w, fmt.Sprintf("Repository %s was not mirrored yet", repoName), "/src/"+repoName)
 			} else {
-				log.Printf("failed to track changes for mirror %s: %s", repoName, err)
+				slog.WarnContext(ctx, "failed to track changes for mirror", "repo", repoName, "error", err)
 				userErr := UserError{
 					Message:       "Failed to set up push web hook, please check logs for details",
 					BackURL:       req.Referer(),
@@ -138,7 +180,42 @@ func (handler *MirrorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 			return
 		}
 
-		log.Printf("set up push changes hook for %s [%s]", repoName, time.Since(startTime))
+		slog.InfoContext(ctx, "set up push changes hook", "repo", repoName, "duration", time.Since(startTime))
+		handler.redirectToRepository(w, req, repoName)
+	case "add-push-target", "remove-push-target":
+		if handler.pushService == nil {
+			WriteErrorPage(w, UserError{Message: "Push mirroring not supported", BackURL: req.Referer()}, http.StatusNotImplemented)
+			return
+		}
+
+		var err error
+		if action == "add-push-target" {
+			err = handler.AddPushTarget(ctx, req, name)
+		} else {
+			err = handler.pushService.RemovePushTarget(ctx, name, req.FormValue("target"))
+		}
+
+		switch err {
+		case nil:
+		case git.ErrorNotMirrored:
+			WriteNotFoundPage(w, fmt.Sprintf("Repository %s was not mirrored yet", repoName), "/src/"+repoName)
+			return
+		case git.ErrorNotFound:
+			WriteNotFoundPage(w, fmt.Sprintf("No such push target %q", req.FormValue("target")), "/"+repoName)
+			return
+		case errHostCredential:
+			WriteErrorPage(w, UserError{Message: "Credentials read from environment variables or files can only be used by push targets declared in configuration file", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+			return
+		case git.ErrorInvalidPushTarget, git.ErrorInvalidCredential:
+			WriteErrorPage(w, UserError{Message: "Push target name, URL or credential reference is not valid", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+			return
+		default:
+			slog.WarnContext(ctx, "failed to change push targets", "action", action, "repo", repoName, "target", req.FormValue("target"), "error", err)
+			WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+			return
+		}
+
+		slog.InfoContext(ctx, "changed push targets", "action", action, "repo", repoName, "target", req.FormValue("target"), "duration", time.Since(startTime))
 		handler.redirectToRepository(w, req, repoName)
 	default:
 		WriteErrorPage(w, UserError{Message: fmt.Sprintf("Unsupported action %q", action), BackURL: req.Referer()}, http.StatusBadRequest)
@@ -146,37 +223,83 @@ func (handler *MirrorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 }
 
 // CreateMirror searches for a repository in githubRepos and creates its mirror.
-func (handler *MirrorHandler) CreateMirror(ctx context.Context, w http.ResponseWriter, repoName string) error {
-	repo, err := handler.githubRepos.Get(ctx, repoName)
+func (handler *MirrorHandler) CreateMirror(ctx context.Context, w http.ResponseWriter, name git.RepositoryName) error {
+	repo, err := handler.githubRepos.Get(ctx, name)
 	if err != nil {
 		return err
 	}
 
-	return handler.mirroredRepos.Create(ctx, repo.FullName, repo.GitURL)
+	return handler.mirroredRepos.Create(ctx, name, repo.GitURL)
+}
+
+// StartMirror searches for a repository in githubRepos and starts cloning it in background. Unless disabled with "notrack"
+// form value, changes tracking is set up once the clone is complete. If the mirror is being created already, the running
+// job is returned along with false.
+func (handler *MirrorHandler) StartMirror(ctx context.Context, req *http.Request, name git.RepositoryName) (*git.CloneJob, bool, error) {
+	repo, err := handler.githubRepos.Get(ctx, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	track := req.FormValue("notrack") == "" && handler.trackRepoService != nil
+	hookURL := apiHookURL(baseURL(handler.publicURL, req)).String()
+
+	job, started := handler.clones.Start(ctx, name.String(), func(ctx context.Context) error {
+		if err := handler.mirroredRepos.Create(ctx, name, repo.GitURL); err != nil {
+			return err
+		}
+
+		if !track {
+			return nil
+		}
+
+		if err := handler.trackRepoService.Track(ctx, name.String(), hookURL); err != nil {
+			return fmt.Errorf("failed to set up push web hook: %s", err)
+		}
+
+		return nil
+	})
+
+	return job, started, nil
 }
 
 // SetupChangeTracking searches for a repository in githubRepos and sets up changes tracker using trackingService.Track().
-func (handler *MirrorHandler) SetupChangeTracking(ctx context.Context, w http.ResponseWriter, req *http.Request, repoName string) error {
-	repo, err := handler.mirroredRepos.Get(ctx, repoName)
+func (handler *MirrorHandler) SetupChangeTracking(ctx context.Context, w http.ResponseWriter, req *http.Request, name git.RepositoryName) error {
+	repo, err := handler.mirroredRepos.Get(ctx, name)
 	if err != nil {
 		return err
 	}
 
-	return handler.trackRepoService.Track(ctx, repo.FullName, apiHookURL(req.Host, req.TLS != nil).String())
+	return handler.trackRepoService.Track(ctx, repo.FullName, apiHookURL(baseURL(handler.publicURL, req)).String())
 }
 
 // UpdateMirror updates an existing mirror synchronizing its with source.
-func (handler *MirrorHandler) UpdateMirror(ctx context.Context, w http.ResponseWriter, repoName string) error {
-	repo, err := handler.mirroredRepos.Get(ctx, repoName)
-	if err != nil {
+func (handler *MirrorHandler) UpdateMirror(ctx context.Context, w http.ResponseWriter, name git.RepositoryName) error {
+	if _, err := handler.mirroredRepos.Get(ctx, name); err != nil {
 		return err
 	}
 
-	return handler.mirroredRepos.Update(ctx, repo.FullName)
+	return handler.mirroredRepos.Update(ctx, name)
+}
+
+// AddPushTarget configures a mirror to be replicated to a remote specified by "target", "url" and "credential" form values.
+// Credentials that refer to secrets kept on server are rejected with errHostCredential.
+func (handler *MirrorHandler) AddPushTarget(ctx context.Context, req *http.Request, name git.RepositoryName) error {
+	target := git.PushTarget{
+		Name:       strings.TrimSpace(req.FormValue("target")),
+		URL:        strings.TrimSpace(req.FormValue("url")),
+		Credential: git.Credential(strings.TrimSpace(req.FormValue("credential"))),
+	}
+
+	if target.Credential.HostSecret() {
+		return errHostCredential
+	}
+
+	return handler.pushService.AddPushTarget(ctx, name, target)
 }
 
 // ShowPrivateRepoAccessPage renders a page with public SSH key that can be used for GitHub authentication.
-func (handler *MirrorHandler) ShowPrivateRepoAccessPage(w http.ResponseWriter, repoName, action string) error {
+func (handler *MirrorHandler) ShowPrivateRepoAccessPage(w http.ResponseWriter, req *http.Request, repoName, action string) error {
 	pubkey, err := handler.getPublicKey()
 	if err != nil {
 		return err
@@ -186,19 +309,45 @@ func (handler *MirrorHandler) ShowPrivateRepoAccessPage(w http.ResponseWriter, r
 		PublicKey string
 		FullName  string
 		Action    string
+		User      *userAccess
 	}{
 		PublicKey: string(pubkey),
-		FullName:  "andrewslotin/lol",
-		Action:    "create",
+		FullName:  repoName,
+		Action:    action,
+		User:      accessFromRequest(req),
 	}
 
 	return privateRepoAccessTemplate.Execute(w, values)
 }
 
+// writeCreateError responds with private repository access page if the source repository could not be found and
+// with an error page otherwise.
+func (handler *MirrorHandler) writeCreateError(w http.ResponseWriter, req *http.Request, repoName, action string, err error) {
+	ctx := req.Context()
+
+	if err == git.ErrorNotFound {
+		err = handler.ShowPrivateRepoAccessPage(w, req, repoName, action)
+		if err == nil {
+			return
+		}
+
+		slog.WarnContext(ctx, "failed to obtain public key", "error", err)
+	} else {
+		slog.WarnContext(ctx, "failed to create mirror", "repo", repoName, "error", err)
+	}
+
+	userErr := UserError{
+		Message:       "Internal server error",
+		BackURL:       req.Referer(),
+		OriginalError: err,
+	}
+	WriteErrorPage(w, userErr, http.StatusInternalServerError)
+}
+
 func (handler *MirrorHandler) getPublicKey() ([]byte, error) {
 	pkey, err := gitssh.ReadPrivateRSAKey(PrivateKeyPath)
 	if err != nil {
-		log.Printf("writing a new RSA key to %s", PrivateKeyPath)
+		slog.Info("writing a new RSA key", "path", PrivateKeyPath)
 		pkey, err = gitssh.CreatePrivateRSAKey(PrivateKeyPath)
 		if err != nil {
 			return nil, err
@@ -217,20 +366,35 @@ func (handler *MirrorHandler) redirectToRepository(w http.ResponseWriter, req *h
 	http.Redirect(w, req, "/"+repoName, http.StatusSeeOther)
 }
 
-func (handler *MirrorHandler) fetchRepoFromRequest(req *http.Request) (string, bool) {
-	fullRepoName := req.FormValue("repo")
-	return fullRepoName, fullRepoName != ""
+func (handler *MirrorHandler) fetchRepoFromRequest(req *http.Request) (git.RepositoryName, bool) {
+	name, err := git.ParseRepositoryName(strings.TrimSpace(req.FormValue("repo")))
+	return name, err == nil
+}
+
+func apiHookURL(base *url.URL) *url.URL {
+	base.Path += "/apihook"
+
+	return base
 }
 
-func apiHookURL(host string, isSSL bool) *url.URL {
+// baseURL returns the URL this instance is reachable at. Unless publicURL is configured, the URL is derived from
+// the Host header and TLS state of req, which are under control of client and any proxy in between.
+func baseURL(publicURL string, req *http.Request) *url.URL {
+	if u, err := url.Parse(publicURL); err == nil && u.Host != "" {
+		return &url.URL{
+			Scheme: u.Scheme,
+			Host:   u.Host,
+			Path:   strings.TrimSuffix(u.Path, "/"),
+		}
+	}
+
 	scheme := "http"
-	if isSSL {
+	if req.TLS != nil {
 		scheme = "https"
 	}
 
 	return &url.URL{
 		Scheme: scheme,
-		Host:   host,
-		Path:   "/apihook",
+		Host:   req.Host,
 	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/andrewslotin/doppelganger/config"
+	"github.com/andrewslotin/doppelganger/notify"
+	"golang.org/x/net/context"
+)
+
+// newNotificationMonitor sets up notifiers and subscriptions declared in configuration file. Mirrors are
+// checked for staleness if stale_after is set, and those that were failing before restart are reported once they
+// recover. It returns nil if there are no subscriptions.
+func newNotificationMonitor(cfg config.Notifications, client *http.Client, mirrors notify.Mirrors) (*notify.Monitor, error) {
+	if len(cfg.Subscriptions) == 0 {
+		return nil, nil
+	}
+
+	notifiers := make(map[string]notify.Notifier, len(cfg.Notifiers))
+	for _, n := range cfg.Notifiers {
+		switch {
+		case n.Email != nil:
+			email := notify.NewEmail(n.Email.SMTP, n.Email.From, n.Email.To)
+			if n.Email.Username != "" {
+				password, err := n.Email.Password.Secret()
+				if err != nil {
+					return nil, fmt.Errorf("failed to read SMTP password of notifier %s: %s", n.Name, err)
+				}
+
+				email.Username, email.Password = n.Email.Username, password
+			}
+			notifiers[n.Name] = email
+		case n.Webhook != nil:
+			u, err := n.Webhook.ResolveURL()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read webhook URL of notifier %s: %s", n.Name, err)
+			}
+			notifiers[n.Name] = notify.NewWebhook(client, u)
+		case n.Slack != nil:
+			u, err := n.Slack.ResolveURL()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Slack webhook URL of notifier %s: %s", n.Name, err)
+			}
+			notifiers[n.Name] = notify.NewSlack(client, u)
+		}
+	}
+
+	digestInterval := cfg.DigestInterval
+	if digestInterval == 0 {
+		digestInterval = config.DefaultDigestInterval
+	}
+
+	monitor := notify.NewMonitor(digestInterval)
+	for _, s := range cfg.Subscriptions {
+		monitor.Subscribe(notify.Subscription{
+			Name:     s.Notifier,
+			Notifier: notifiers[s.Notifier],
+			Repos:    s.Repos,
+			Events:   s.Events,
+		})
+	}
+
+	if cfg.StaleAfter > 0 {
+		monitor.EnableStaleCheck(mirrors, cfg.StaleAfter)
+	}
+
+	failing, err := mirrors.FailingMirrors(context.Background())
+	if err != nil {
+		slog.Warn("failed to restore failing mirrors", "error", err)
+	}
+	monitor.RestoreFailing(failing)
+
+	slog.Info("enabled notifications", "subscriptions", len(cfg.Subscriptions), "digest_interval", digestInterval, "stale_after", cfg.StaleAfter)
+
+	return monitor, nil
+}
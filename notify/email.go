@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// emailTimeout limits the time it takes to deliver a message to SMTP server.
+const emailTimeout = 30 * time.Second
+
+// Email sends events as plain text messages via SMTP. The connection is upgraded with STARTTLS if server
+// supports it, and credentials are only sent over an encrypted connection or to localhost.
+type Email struct {
+	Addr string
+	From string
+	To   []string
+
+	// Optional credentials for PLAIN authentication.
+	Username, Password string
+}
+
+// NewEmail returns an instance of Email that sends messages to recipients using SMTP server at addr.
+func NewEmail(addr, from string, to []string) *Email {
+	return &Email{
+		Addr: addr,
+		From: from,
+		To:   to,
+	}
+}
+
+// Notify sends a single message describing events to all recipients.
+func (n *Email) Notify(ctx context.Context, events []Event) error {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP server address %q: %s", n.Addr, err)
+	}
+
+	dialer := net.Dialer{Timeout: emailTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to SMTP server: %s", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %s", err)
+		}
+	}
+
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, host)); err != nil {
+			return fmt.Errorf("failed to authenticate with SMTP server: %s", err)
+		}
+	}
+
+	if err := c.Mail(n.From); err != nil {
+		return fmt.Errorf("SMTP server rejected sender %s: %s", n.From, err)
+	}
+
+	for _, rcpt := range n.To {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP server rejected recipient %s: %s", rcpt, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %s", err)
+	}
+
+	if _, err := w.Write(n.message(events)); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to send message: %s", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %s", err)
+	}
+
+	return c.Quit()
+}
+
+func (n *Email) message(events []Event) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", n.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[doppelganger] "+Subject(events)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+
+	buf.WriteString(strings.Replace(Text(events), "\n", "\r\n", -1))
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
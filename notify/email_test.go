@@ -0,0 +1,122 @@
+package notify_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// smtpMessage is a message received by SMTP server stand-in.
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// startSMTPServer runs a minimal SMTP server that accepts a single message and sends it to the returned channel.
+func startSMTPServer(t *testing.T) (string, <-chan smtpMessage) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	messages := make(chan smtpMessage, 1)
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		var msg smtpMessage
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				msg.From = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+				reply("250 OK")
+			case "RCPT":
+				msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+
+				var data []string
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					if line == ".\r\n" {
+						break
+					}
+					data = append(data, line)
+				}
+				msg.Data = strings.Join(data, "")
+
+				reply("250 OK")
+				messages <- msg
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	return l.Addr().String(), messages
+}
+
+func TestEmail_Notify(t *testing.T) {
+	addr, messages := startSMTPServer(t)
+
+	n := notify.NewEmail(addr, "doppelganger@example.com", []string{"ops@example.com", "dev@example.com"})
+
+	since := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, n.Notify(context.Background(), []notify.Event{
+		{Type: notify.EventSyncFailed, Repository: "acme/api", Since: since, Error: "timeout", Count: 2},
+		{Type: notify.EventStale, Repository: "acme/web", Since: since, Count: 1},
+	}))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "doppelganger@example.com", msg.From)
+		assert.Equal(t, []string{"ops@example.com", "dev@example.com"}, msg.To)
+		assert.Contains(t, msg.Data, "Subject: [doppelganger] 2 events for 2 mirrors\r\n")
+		assert.Contains(t, msg.Data, "To: ops@example.com, dev@example.com\r\n")
+		assert.Contains(t, msg.Data, "\r\n\r\nacme/api failed to sync 2 times")
+		assert.Contains(t, msg.Data, "\r\nacme/web has not been synced since")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message has not been delivered")
+	}
+}
+
+func TestEmail_Notify_Unreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	n := notify.NewEmail(addr, "doppelganger@example.com", []string{"ops@example.com"})
+	assert.Error(t, n.Notify(context.Background(), []notify.Event{{Type: notify.EventStale, Repository: "a/b", Count: 1}}))
+}
@@ -0,0 +1,315 @@
+package notify
+
+import (
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// flushInterval is how often Monitor checks whether it's time to send pending digests.
+	flushInterval = time.Minute
+	// staleCheckInterval is how often Monitor looks for stale mirrors.
+	staleCheckInterval = 10 * time.Minute
+)
+
+// Mirrors is the type that wraps LastSyncTimes and FailingMirrors methods.
+//
+// Mirrors are used to find mirrors that have not been synced for too long and mirrors which latest sync
+// has failed before restart.
+type Mirrors interface {
+	LastSyncTimes(ctx context.Context) (map[string]time.Time, error)
+	FailingMirrors(ctx context.Context) (map[string]time.Time, error)
+}
+
+// Subscription routes events to a notifier.
+type Subscription struct {
+	// Name identifies subscription in logs.
+	Name     string
+	Notifier Notifier
+	// Repos lists repository name patterns, i.e. "acme/*", subscription is limited to. Empty Repos
+	// subscribe to events of all mirrors.
+	Repos []string
+	// Events lists event types to send. Empty Events subscribe to all of them.
+	Events []string
+}
+
+func (s *Subscription) matches(e Event) bool {
+	if len(s.Events) > 0 && !contains(s.Events, e.Type) {
+		return false
+	}
+
+	if len(s.Repos) == 0 {
+		return true
+	}
+
+	for _, pattern := range s.Repos {
+		if ok, _ := path.Match(pattern, e.Repository); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscriber holds events pending to be sent to a subscription.
+type subscriber struct {
+	Subscription
+
+	pending    []Event
+	lastSentAt time.Time
+}
+
+// add queues e merging it with a pending event of the same type for the same repository.
+func (s *subscriber) add(e Event) {
+	for i, p := range s.pending {
+		if p.Type == e.Type && p.Repository == e.Repository {
+			e.Since, e.Count = p.Since, p.Count+e.Count
+			s.pending[i] = e
+			return
+		}
+	}
+
+	s.pending = append(s.pending, e)
+}
+
+// Monitor watches mirror syncs and webhook setups and sends events to subscribers. The first event is sent
+// right away, while the following ones are grouped into a digest sent once digestInterval has passed since
+// the previous message. A nil *Monitor is valid and sends nothing, so that services can report events
+// unconditionally.
+type Monitor struct {
+	digestInterval time.Duration
+	mirrors        Mirrors
+	staleAfter     time.Duration
+	wakeup         chan struct{}
+
+	mu          sync.Mutex
+	subscribers []*subscriber
+	failing     map[string]time.Time
+	stale       map[string]bool
+}
+
+// NewMonitor returns an instance of Monitor that sends at most one message per digestInterval to each
+// subscription.
+func NewMonitor(digestInterval time.Duration) *Monitor {
+	return &Monitor{
+		digestInterval: digestInterval,
+		wakeup:         make(chan struct{}, 1),
+		failing:        make(map[string]time.Time),
+		stale:          make(map[string]bool),
+	}
+}
+
+// Subscribe adds a subscription.
+func (m *Monitor) Subscribe(s Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscribers = append(m.subscribers, &subscriber{Subscription: s})
+}
+
+// EnableStaleCheck makes Monitor periodically send EventStale for mirrors that have not been synced
+// successfully for longer than staleAfter.
+func (m *Monitor) EnableStaleCheck(mirrors Mirrors, staleAfter time.Duration) {
+	m.mirrors, m.staleAfter = mirrors, staleAfter
+}
+
+// RestoreFailing marks mirrors as failing since the time of their first failed sync, so that the first
+// success after restart is reported as EventRecovered.
+func (m *Monitor) RestoreFailing(failing map[string]time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for repo, since := range failing {
+		if _, ok := m.failing[repo]; !ok {
+			m.failing[repo] = since
+		}
+	}
+}
+
+// SyncFinished reports the outcome of repository sync. A failure is reported as EventSyncFailed and the
+// first success after a failure as EventRecovered. Syncs interrupted because ctx has been cancelled,
+// i.e. on shutdown, are ignored.
+func (m *Monitor) SyncFinished(ctx context.Context, repository string, err error) {
+	if m == nil || ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	failingSince, failing := m.failing[repository]
+	delete(m.stale, repository)
+	if err == nil {
+		delete(m.failing, repository)
+	} else if !failing {
+		failingSince = now
+		m.failing[repository] = now
+	}
+	m.mu.Unlock()
+
+	switch {
+	case err != nil:
+		m.dispatch(ctx, Event{Type: EventSyncFailed, Repository: repository, Time: now, Since: failingSince, Error: err.Error()})
+	case failing:
+		m.dispatch(ctx, Event{Type: EventRecovered, Repository: repository, Time: now, Since: failingSince})
+	}
+}
+
+// TrackFinished reports the outcome of webhook setup for repository. Failures are reported as EventTrackFailed.
+func (m *Monitor) TrackFinished(ctx context.Context, repository string, err error) {
+	if m == nil || err == nil || ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	m.dispatch(ctx, Event{Type: EventTrackFailed, Repository: repository, Time: now, Since: now, Error: err.Error()})
+}
+
+// Run sends pending events and checks for stale mirrors until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	tick := flushInterval
+	if m.digestInterval > 0 && m.digestInterval < tick {
+		tick = m.digestInterval
+	}
+
+	flushTicker := time.NewTicker(tick)
+	defer flushTicker.Stop()
+
+	var staleCheck <-chan time.Time
+	if m.mirrors != nil {
+		ticker := time.NewTicker(staleCheckInterval)
+		defer ticker.Stop()
+		staleCheck = ticker.C
+
+		m.checkStale(ctx)
+	}
+
+	for {
+		select {
+		case <-m.wakeup:
+		case <-flushTicker.C:
+		case <-staleCheck:
+			m.checkStale(ctx)
+		case <-ctx.Done():
+			return
+		}
+
+		m.flush(ctx)
+	}
+}
+
+// dispatch queues e for all matching subscriptions.
+func (m *Monitor) dispatch(ctx context.Context, e Event) {
+	e.Count = 1
+
+	m.mu.Lock()
+	var matched int
+	for _, s := range m.subscribers {
+		if s.matches(e) {
+			s.add(e)
+			matched++
+		}
+	}
+	m.mu.Unlock()
+
+	if matched == 0 {
+		return
+	}
+
+	slog.DebugContext(ctx, "queued notification", "event", e.Type, "repo", e.Repository, "subscriptions", matched)
+
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// flush sends pending events to subscribers that have not been notified within digestInterval. Events that
+// failed to be sent are queued again to be retried with the next digest.
+func (m *Monitor) flush(ctx context.Context) {
+	type delivery struct {
+		*subscriber
+		events []Event
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	var deliveries []delivery
+	for _, s := range m.subscribers {
+		if len(s.pending) == 0 || now.Sub(s.lastSentAt) < m.digestInterval {
+			continue
+		}
+
+		deliveries = append(deliveries, delivery{s, s.pending})
+		s.pending, s.lastSentAt = nil, now
+	}
+	m.mu.Unlock()
+
+	for _, d := range deliveries {
+		if err := d.Notifier.Notify(ctx, d.events); err != nil {
+			slog.WarnContext(ctx, "failed to send notification", "subscription", d.Name, "events", len(d.events), "error", err)
+
+			m.mu.Lock()
+			newer := d.pending
+			d.pending = nil
+			for _, e := range append(d.events, newer...) {
+				d.add(e)
+			}
+			m.mu.Unlock()
+
+			continue
+		}
+
+		slog.InfoContext(ctx, "sent notification", "subscription", d.Name, "events", len(d.events))
+	}
+}
+
+// checkStale sends EventStale once for each mirror that has not been synced for longer than staleAfter.
+// Mirrors that have never been synced successfully are not reported, since the time of sync is not known
+// for mirrors created by older versions.
+func (m *Monitor) checkStale(ctx context.Context) {
+	lastSyncs, err := m.mirrors.LastSyncTimes(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to check for stale mirrors", "error", err)
+		return
+	}
+
+	now := time.Now()
+
+	var events []Event
+	m.mu.Lock()
+	for repo := range m.stale {
+		if t, ok := lastSyncs[repo]; !ok || now.Sub(t) <= m.staleAfter {
+			delete(m.stale, repo)
+		}
+	}
+
+	for repo, t := range lastSyncs {
+		if t.IsZero() || now.Sub(t) <= m.staleAfter || m.stale[repo] {
+			continue
+		}
+
+		m.stale[repo] = true
+		events = append(events, Event{Type: EventStale, Repository: repo, Time: now, Since: t})
+	}
+	m.mu.Unlock()
+
+	for _, e := range events {
+		m.dispatch(ctx, e)
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
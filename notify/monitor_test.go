@@ -0,0 +1,277 @@
+package notify_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// notifierStub records delivered messages and sends a signal for each of them.
+type notifierStub struct {
+	mu       sync.Mutex
+	messages [][]notify.Event
+	sent     chan struct{}
+}
+
+func newNotifierStub() *notifierStub {
+	return &notifierStub{sent: make(chan struct{}, 100)}
+}
+
+func (n *notifierStub) Notify(ctx context.Context, events []notify.Event) error {
+	n.mu.Lock()
+	n.messages = append(n.messages, events)
+	n.mu.Unlock()
+
+	n.sent <- struct{}{}
+
+	return nil
+}
+
+func (n *notifierStub) Wait(t *testing.T) []notify.Event {
+	select {
+	case <-n.sent:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no notification has been sent")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.messages[len(n.messages)-1]
+}
+
+func (n *notifierStub) Messages() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.messages)
+}
+
+type mirrorsStub map[string]time.Time
+
+func (m mirrorsStub) LastSyncTimes(ctx context.Context) (map[string]time.Time, error) {
+	return m, nil
+}
+
+func (m mirrorsStub) FailingMirrors(ctx context.Context) (map[string]time.Time, error) {
+	return nil, nil
+}
+
+// unreliableNotifierStub fails to deliver the first failures messages.
+type unreliableNotifierStub struct {
+	*notifierStub
+	failures int32
+}
+
+func (n *unreliableNotifierStub) Notify(ctx context.Context, events []notify.Event) error {
+	if atomic.AddInt32(&n.failures, -1) >= 0 {
+		return errors.New("connection refused")
+	}
+
+	return n.notifierStub.Notify(ctx, events)
+}
+
+func TestMonitor_SyncFinished(t *testing.T) {
+	n := newNotifierStub()
+
+	m := notify.NewMonitor(100 * time.Millisecond)
+	m.Subscribe(notify.Subscription{Name: "test", Notifier: n})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	m.SyncFinished(ctx, "a/b", nil)
+	m.SyncFinished(ctx, "a/b", errors.New("fatal: repository not found"))
+
+	events := n.Wait(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.EventSyncFailed, events[0].Type)
+	assert.Equal(t, "a/b", events[0].Repository)
+	assert.Equal(t, "fatal: repository not found", events[0].Error)
+	assert.Equal(t, 1, events[0].Count)
+	failingSince := events[0].Since
+
+	// Following events are sent in a digest
+	m.SyncFinished(ctx, "a/b", errors.New("fatal: unable to access"))
+	m.SyncFinished(ctx, "a/b", errors.New("fatal: unable to access"))
+	m.SyncFinished(ctx, "a/b", nil)
+
+	events = n.Wait(t)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, notify.EventSyncFailed, events[0].Type)
+	assert.Equal(t, 2, events[0].Count)
+	assert.Equal(t, failingSince, events[0].Since)
+	assert.Equal(t, "fatal: unable to access", events[0].Error)
+
+	assert.Equal(t, notify.EventRecovered, events[1].Type)
+	assert.Equal(t, "a/b", events[1].Repository)
+	assert.Equal(t, failingSince, events[1].Since)
+
+	// Successful syncs of healthy mirrors are not reported
+	m.SyncFinished(ctx, "a/b", nil)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 2, n.Messages())
+}
+
+func TestMonitor_SyncFinished_NotifyFailed(t *testing.T) {
+	n := &unreliableNotifierStub{notifierStub: newNotifierStub(), failures: 1}
+
+	m := notify.NewMonitor(100 * time.Millisecond)
+	m.Subscribe(notify.Subscription{Name: "test", Notifier: n})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	m.SyncFinished(ctx, "a/b", errors.New("fatal: repository not found"))
+
+	// Events that failed to be sent are retried with the next digest
+	events := n.Wait(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.EventSyncFailed, events[0].Type)
+	assert.Equal(t, "a/b", events[0].Repository)
+	assert.Equal(t, 1, events[0].Count)
+}
+
+func TestMonitor_RestoreFailing(t *testing.T) {
+	n := newNotifierStub()
+
+	failingSince := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	m := notify.NewMonitor(0)
+	m.Subscribe(notify.Subscription{Name: "test", Notifier: n})
+	m.RestoreFailing(map[string]time.Time{"a/b": failingSince})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	m.SyncFinished(ctx, "a/c", nil)
+	m.SyncFinished(ctx, "a/b", nil)
+
+	events := n.Wait(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.EventRecovered, events[0].Type)
+	assert.Equal(t, "a/b", events[0].Repository)
+	assert.Equal(t, failingSince, events[0].Since)
+}
+
+func TestMonitor_SyncFinished_Cancelled(t *testing.T) {
+	n := newNotifierStub()
+
+	m := notify.NewMonitor(0)
+	m.Subscribe(notify.Subscription{Name: "test", Notifier: n})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	syncCtx, cancelSync := context.WithCancel(ctx)
+	cancelSync()
+	m.SyncFinished(syncCtx, "a/b", context.Canceled)
+	m.TrackFinished(ctx, "a/b", errors.New("403 Forbidden"))
+
+	events := n.Wait(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.EventTrackFailed, events[0].Type)
+	assert.Equal(t, "403 Forbidden", events[0].Error)
+}
+
+func TestMonitor_Subscriptions(t *testing.T) {
+	all, acme, failures := newNotifierStub(), newNotifierStub(), newNotifierStub()
+
+	m := notify.NewMonitor(0)
+	m.Subscribe(notify.Subscription{Name: "all", Notifier: all})
+	m.Subscribe(notify.Subscription{Name: "acme", Notifier: acme, Repos: []string{"acme/*"}})
+	m.Subscribe(notify.Subscription{Name: "failures", Notifier: failures, Events: []string{notify.EventSyncFailed}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	m.TrackFinished(ctx, "other/repo", errors.New("404 Not Found"))
+	assert.Equal(t, "other/repo", all.Wait(t)[0].Repository)
+
+	m.SyncFinished(ctx, "acme/api", errors.New("timeout"))
+	assert.Equal(t, "acme/api", all.Wait(t)[0].Repository)
+	assert.Equal(t, "acme/api", acme.Wait(t)[0].Repository)
+	assert.Equal(t, "acme/api", failures.Wait(t)[0].Repository)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, all.Messages())
+	assert.Equal(t, 1, acme.Messages())
+	assert.Equal(t, 1, failures.Messages())
+}
+
+func TestMonitor_EnableStaleCheck(t *testing.T) {
+	n := newNotifierStub()
+
+	lastSyncAt := time.Now().Add(-48 * time.Hour)
+
+	m := notify.NewMonitor(0)
+	m.Subscribe(notify.Subscription{Name: "test", Notifier: n})
+	m.EnableStaleCheck(mirrorsStub{
+		"a/b": lastSyncAt,
+		"a/c": time.Now(),
+		"a/d": time.Time{},
+	}, 24*time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	events := n.Wait(t)
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.EventStale, events[0].Type)
+	assert.Equal(t, "a/b", events[0].Repository)
+	assert.True(t, lastSyncAt.Equal(events[0].Since))
+}
+
+func TestMonitor_Nil(t *testing.T) {
+	var m *notify.Monitor
+	assert.NotPanics(t, func() {
+		m.SyncFinished(context.Background(), "a/b", errors.New("failed"))
+		m.TrackFinished(context.Background(), "a/b", errors.New("failed"))
+	})
+}
+
+func TestEvent_String(t *testing.T) {
+	since := time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local)
+
+	examples := map[string]struct {
+		Event    notify.Event
+		Expected string
+	}{
+		"sync failed": {
+			notify.Event{Type: notify.EventSyncFailed, Repository: "a/b", Since: since, Error: "timeout", Count: 3},
+			"a/b failed to sync 3 times, failing since " + since.Format("2006-01-02 15:04 MST") + ": timeout",
+		},
+		"recovered": {
+			notify.Event{Type: notify.EventRecovered, Repository: "a/b", Since: since, Count: 1},
+			"a/b has been synced successfully after failing since " + since.Format("2006-01-02 15:04 MST"),
+		},
+		"stale": {
+			notify.Event{Type: notify.EventStale, Repository: "a/b", Since: since, Count: 1},
+			"a/b has not been synced since " + since.Format("2006-01-02 15:04 MST"),
+		},
+		"track failed": {
+			notify.Event{Type: notify.EventTrackFailed, Repository: "a/b", Error: "403 Forbidden", Count: 1},
+			"failed to set up webhook for a/b: 403 Forbidden",
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, example.Expected, example.Event.String())
+		})
+	}
+}
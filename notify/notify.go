@@ -0,0 +1,109 @@
+// Package notify alerts administrators about mirrors that need attention, such as ones that fail to sync
+// or have not been synced for too long. Events are delivered by notifiers, i.e. email or chat webhooks,
+// according to subscriptions. Repeated events are grouped into digests to avoid flooding subscribers.
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Event types.
+const (
+	// EventSyncFailed is sent each time a mirror fails to sync.
+	EventSyncFailed = "sync_failed"
+	// EventRecovered is sent when a mirror that has been failing is synced successfully.
+	EventRecovered = "recovered"
+	// EventStale is sent once a mirror has not been synced successfully for longer than a threshold.
+	EventStale = "stale"
+	// EventTrackFailed is sent if a webhook for a mirror could not be set up.
+	EventTrackFailed = "track_failed"
+)
+
+// Events lists all supported event types.
+var Events = []string{EventSyncFailed, EventRecovered, EventStale, EventTrackFailed}
+
+// timeFormat is the format of times in notification messages.
+const timeFormat = "2006-01-02 15:04 MST"
+
+// Event describes something that happened to a mirror.
+type Event struct {
+	Type       string `json:"type"`
+	Repository string `json:"repository"`
+	// Time is when the event has occurred, the latest one for a repeated event.
+	Time time.Time `json:"time"`
+	// Since is when the condition has started, i.e. the first failure of a failing mirror or the latest
+	// successful sync of a stale one.
+	Since time.Time `json:"since"`
+	// Error is the latest error for failure events.
+	Error string `json:"error,omitempty"`
+	// Count is the number of times event has occurred since it was reported last time.
+	Count int `json:"count"`
+}
+
+// String returns a human-readable description of event.
+func (e Event) String() string {
+	var s string
+	switch e.Type {
+	case EventSyncFailed:
+		s = fmt.Sprintf("%s failed to sync", e.Repository)
+		if e.Count > 1 {
+			s += fmt.Sprintf(" %d times", e.Count)
+		}
+		s += fmt.Sprintf(", failing since %s", e.Since.Local().Format(timeFormat))
+	case EventRecovered:
+		s = fmt.Sprintf("%s has been synced successfully after failing since %s", e.Repository, e.Since.Local().Format(timeFormat))
+	case EventStale:
+		s = fmt.Sprintf("%s has not been synced since %s", e.Repository, e.Since.Local().Format(timeFormat))
+	case EventTrackFailed:
+		s = fmt.Sprintf("failed to set up webhook for %s", e.Repository)
+		if e.Count > 1 {
+			s += fmt.Sprintf(" (%d attempts)", e.Count)
+		}
+	default:
+		s = fmt.Sprintf("%s: %s", e.Repository, e.Type)
+	}
+
+	if e.Error != "" {
+		s += ": " + e.Error
+	}
+
+	return s
+}
+
+// Notifier delivers events to subscribers. A single call may carry several events if they have been
+// grouped into a digest.
+type Notifier interface {
+	Notify(ctx context.Context, events []Event) error
+}
+
+// Subject returns a one-line summary of events to be used as email subject or message title.
+func Subject(events []Event) string {
+	if len(events) == 1 {
+		return events[0].String()
+	}
+
+	repos := make(map[string]struct{}, len(events))
+	for _, e := range events {
+		repos[e.Repository] = struct{}{}
+	}
+
+	if len(repos) == 1 {
+		return fmt.Sprintf("%d events for %s", len(events), events[0].Repository)
+	}
+
+	return fmt.Sprintf("%d events for %d mirrors", len(events), len(repos))
+}
+
+// Text returns a plain text description of events, one per line.
+func Text(events []Event) string {
+	lines := make([]string, 0, len(events))
+	for _, e := range events {
+		lines = append(lines, e.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Webhook sends events as JSON to an HTTP endpoint:
+//
+//   {"events": [{"type": "sync_failed", "repository": "acme/api", "time": "...", "since": "...", "error": "...", "count": 1}]}
+type Webhook struct {
+	client *http.Client
+	url    string
+}
+
+// NewWebhook returns an instance of Webhook that posts events to url. If client is nil, a client with
+// a 10 seconds timeout is used.
+func NewWebhook(client *http.Client, url string) *Webhook {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Webhook{
+		client: client,
+		url:    url,
+	}
+}
+
+// Notify posts events to webhook URL.
+func (n *Webhook) Notify(ctx context.Context, events []Event) error {
+	return postJSON(ctx, n.client, n.url, struct {
+		Events []Event `json:"events"`
+	}{events})
+}
+
+// Slack sends events as a message to a Slack or Mattermost incoming webhook.
+type Slack struct {
+	client *http.Client
+	url    string
+}
+
+// NewSlack returns an instance of Slack that posts messages to incoming webhook url. If client is nil,
+// a client with a 10 seconds timeout is used.
+func NewSlack(client *http.Client, url string) *Slack {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Slack{
+		client: client,
+		url:    url,
+	}
+}
+
+// Notify posts a message describing events to incoming webhook.
+func (n *Slack) Notify(ctx context.Context, events []Event) error {
+	text := Subject(events)
+	if len(events) > 1 {
+		text += "\n" + Text(events)
+	}
+
+	return postJSON(ctx, n.client, n.url, struct {
+		Username string `json:"username"`
+		Text     string `json:"text"`
+	}{"doppelganger", text})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ctxhttp.Do(ctx, client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestWebhook_Notify(t *testing.T) {
+	var received struct {
+		Events []notify.Event `json:"events"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&received))
+	}))
+	defer srv.Close()
+
+	since := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	event := notify.Event{Type: notify.EventSyncFailed, Repository: "acme/api", Time: since.Add(time.Hour), Since: since, Error: "timeout", Count: 2}
+
+	require.NoError(t, notify.NewWebhook(nil, srv.URL).Notify(context.Background(), []notify.Event{event}))
+	assert.Equal(t, []notify.Event{event}, received.Events)
+}
+
+func TestWebhook_Notify_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	assert.Error(t, notify.NewWebhook(nil, srv.URL).Notify(context.Background(), []notify.Event{{Type: notify.EventStale, Repository: "a/b", Count: 1}}))
+}
+
+func TestSlack_Notify(t *testing.T) {
+	var received struct {
+		Username string `json:"username"`
+		Text     string `json:"text"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&received))
+	}))
+	defer srv.Close()
+
+	n := notify.NewSlack(nil, srv.URL)
+
+	require.NoError(t, n.Notify(context.Background(), []notify.Event{
+		{Type: notify.EventTrackFailed, Repository: "acme/api", Error: "403 Forbidden", Count: 1},
+	}))
+	assert.Equal(t, "doppelganger", received.Username)
+	assert.Equal(t, "failed to set up webhook for acme/api: 403 Forbidden", received.Text)
+
+	require.NoError(t, n.Notify(context.Background(), []notify.Event{
+		{Type: notify.EventTrackFailed, Repository: "acme/api", Error: "403 Forbidden", Count: 1},
+		{Type: notify.EventTrackFailed, Repository: "acme/web", Error: "404 Not Found", Count: 1},
+	}))
+	assert.Equal(t, "2 events for 2 mirrors\n"+
+		"failed to set up webhook for acme/api: 403 Forbidden\n"+
+		"failed to set up webhook for acme/web: 404 Not Found", received.Text)
+}
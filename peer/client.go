@@ -0,0 +1,122 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Mirror is a repository mirror as it is listed by primary instance API.
+type Mirror struct {
+	FullName     string  `json:"full_name"`
+	CloneURL     string  `json:"clone_url"`
+	SourceURL    string  `json:"git_url"`
+	LatestCommit *Commit `json:"latest_commit"`
+}
+
+// Commit is the latest commit from mirror master branch.
+type Commit struct {
+	SHA     string    `json:"sha"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+}
+
+// LatestSHA returns the SHA of the latest commit from master or an empty string if there are no commits.
+func (m Mirror) LatestSHA() string {
+	if m.LatestCommit == nil {
+		return ""
+	}
+
+	return m.LatestCommit.SHA
+}
+
+// Notification is sent by primary instance to subscribers each time a mirror is created or updated.
+type Notification struct {
+	Repository string `json:"repository"`
+}
+
+// Subscription is a request sent by secondary instance to subscribe to change notifications.
+type Subscription struct {
+	CallbackURL string `json:"callback_url"`
+}
+
+// Client is a type that talks to the API of primary Doppelganger instance.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewClient returns an instance of Client for primary instance running at baseURL.
+func NewClient(httpClient *http.Client, baseURL string) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		httpClient: httpClient,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Mirrors returns the list of mirrors maintained by primary instance.
+func (c *Client) Mirrors(ctx context.Context) ([]Mirror, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/mirrors", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", req.URL.Redacted(), resp.Status)
+	}
+
+	var mirrors []Mirror
+	if err := json.NewDecoder(resp.Body).Decode(&mirrors); err != nil {
+		return nil, fmt.Errorf("failed to parse mirrors list: %s", err)
+	}
+
+	return mirrors, nil
+}
+
+// Subscribe asks primary instance to send change notifications to callbackURL.
+func (c *Client) Subscribe(ctx context.Context, callbackURL string) error {
+	return c.post(ctx, c.baseURL+"/api/peers/subscribe", Subscription{CallbackURL: callbackURL})
+}
+
+func (c *Client) post(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s returned %s", req.URL.Redacted(), resp.Status)
+	}
+
+	return nil
+}
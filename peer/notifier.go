@@ -0,0 +1,168 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/hooks"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// MaxSubscribers is the maximum number of secondary instances subscribed to change notifications at a time.
+const MaxSubscribers = 100
+
+// ErrTooManySubscribers is returned by Subscribe if there are MaxSubscribers active subscriptions already.
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// Notifier keeps track of secondary instances subscribed to change notifications and sends them a Notification
+// each time a mirror is changed. Subscriptions expire unless renewed within ttl. Notifications are signed with
+// a secret shared with secondary instances in the same way as outgoing webhook deliveries, see hooks.Sign.
+type Notifier struct {
+	httpClient *http.Client
+	ttl        time.Duration
+	secret     string
+
+	mu          sync.Mutex
+	subscribers map[string]time.Time
+}
+
+// NewNotifier creates and initializes an instance of Notifier.
+func NewNotifier(httpClient *http.Client, ttl time.Duration) *Notifier {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Notifier{
+		httpClient:  httpClient,
+		ttl:         ttl,
+		subscribers: make(map[string]time.Time),
+	}
+}
+
+// SetSecret sets the secret notifications are signed with. Secondary instances reject unsigned notifications.
+func (n *Notifier) SetSecret(secret string) {
+	n.secret = secret
+}
+
+// Subscribe adds or renews a subscription for callbackURL. ErrTooManySubscribers is returned if there is
+// no room for a new subscription.
+func (n *Notifier) Subscribe(callbackURL string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.subscribers[callbackURL]; !ok {
+		n.removeExpired()
+		if len(n.subscribers) >= MaxSubscribers {
+			return ErrTooManySubscribers
+		}
+
+		slog.Info("peer subscribed to change notifications", "peer", callbackURL)
+	}
+	n.subscribers[callbackURL] = time.Now().Add(n.ttl)
+
+	return nil
+}
+
+// Subscribers returns the list of callback URLs with active subscriptions.
+func (n *Notifier) Subscribers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.removeExpired()
+
+	active := make([]string, 0, len(n.subscribers))
+	for callbackURL := range n.subscribers {
+		active = append(active, callbackURL)
+	}
+
+	return active
+}
+
+// removeExpired removes subscriptions that have not been renewed in time, n.mu is expected to be held by caller.
+func (n *Notifier) removeExpired() {
+	for callbackURL, expiresAt := range n.subscribers {
+		if time.Now().After(expiresAt) {
+			slog.Info("peer subscription expired", "peer", callbackURL)
+			delete(n.subscribers, callbackURL)
+		}
+	}
+}
+
+// Notify sends a change notification for repository to all subscribers in background.
+func (n *Notifier) Notify(repoName string) {
+	body, err := json.Marshal(Notification{Repository: repoName})
+	if err != nil {
+		slog.Warn("failed to encode notification", "repo", repoName, "error", err)
+		return
+	}
+
+	for _, callbackURL := range n.Subscribers() {
+		go func(callbackURL string) {
+			req, err := http.NewRequest("POST", callbackURL, bytes.NewReader(body))
+			if err != nil {
+				slog.Warn("failed to notify peer", "peer", callbackURL, "repo", repoName, "error", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if n.secret != "" {
+				req.Header.Set(hooks.SignatureHeader, hooks.Sign(n.secret, body))
+			}
+
+			resp, err := ctxhttp.Do(context.Background(), n.httpClient, req)
+			if err != nil {
+				slog.Warn("failed to notify peer", "peer", callbackURL, "repo", repoName, "error", err)
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode >= 300 {
+				slog.Warn("peer rejected notification", "peer", callbackURL, "repo", repoName, "status", resp.StatusCode)
+			}
+		}(callbackURL)
+	}
+}
+
+// NotifyingMirrors is a type that implements git.MirrorService and notifies subscribed peers each time
+// a mirror is created or updated.
+type NotifyingMirrors struct {
+	git.MirrorService
+
+	notifier *Notifier
+}
+
+// NewNotifyingMirrors wraps mirrors to send change notifications using notifier.
+func NewNotifyingMirrors(mirrors git.MirrorService, notifier *Notifier) *NotifyingMirrors {
+	return &NotifyingMirrors{
+		MirrorService: mirrors,
+		notifier:      notifier,
+	}
+}
+
+// Create creates a mirror and notifies peers.
+func (service *NotifyingMirrors) Create(ctx context.Context, name git.RepositoryName, url string) error {
+	if err := service.MirrorService.Create(ctx, name, url); err != nil {
+		return err
+	}
+
+	service.notifier.Notify(name.String())
+
+	return nil
+}
+
+// Update updates a mirror and notifies peers.
+func (service *NotifyingMirrors) Update(ctx context.Context, name git.RepositoryName) error {
+	if err := service.MirrorService.Update(ctx, name); err != nil {
+		return err
+	}
+
+	service.notifier.Notify(name.String())
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package peer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/andrewslotin/doppelganger/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	notifications := make(chan peer.Notification, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.True(t, hooks.Verify("s3cr3t", body, r.Header.Get(hooks.SignatureHeader)), "notification should be signed")
+
+		var n peer.Notification
+		require.NoError(t, json.Unmarshal(body, &n))
+		notifications <- n
+	}))
+	defer srv.Close()
+
+	notifier := peer.NewNotifier(srv.Client(), time.Minute)
+	notifier.SetSecret("s3cr3t")
+	require.NoError(t, notifier.Subscribe(srv.URL+"/api/peers/notify"))
+	notifier.Notify("user1/repo1")
+
+	select {
+	case n := <-notifications:
+		assert.Equal(t, "user1/repo1", n.Repository)
+	case <-time.After(time.Second):
+		t.Fatal("notification was not sent")
+	}
+}
+
+func TestNotifier_SubscriptionExpires(t *testing.T) {
+	notifier := peer.NewNotifier(nil, -time.Second)
+	require.NoError(t, notifier.Subscribe("http://secondary/api/peers/notify"))
+
+	assert.Empty(t, notifier.Subscribers())
+}
+
+func TestNotifier_MaxSubscribers(t *testing.T) {
+	notifier := peer.NewNotifier(nil, time.Minute)
+	for i := 0; i < peer.MaxSubscribers; i++ {
+		require.NoError(t, notifier.Subscribe(fmt.Sprintf("http://secondary%d/api/peers/notify", i)))
+	}
+
+	assert.Equal(t, peer.ErrTooManySubscribers, notifier.Subscribe("http://secondary/api/peers/notify"))
+	assert.NoError(t, notifier.Subscribe("http://secondary0/api/peers/notify"), "existing subscriptions should be renewed")
+	assert.Len(t, notifier.Subscribers(), peer.MaxSubscribers)
+}
@@ -0,0 +1,159 @@
+package peer
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/logging"
+	"golang.org/x/net/context"
+)
+
+// LocalMirrors is the type that wraps mirror operations used by Replicator.
+type LocalMirrors interface {
+	git.MirrorService
+
+	SetSourceURL(ctx context.Context, name git.RepositoryName, sourceURL string) error
+	UpdateFromSource(ctx context.Context, name git.RepositoryName) error
+}
+
+// Replicator keeps mirrors of a secondary Doppelganger instance in sync with primary one. It periodically
+// fetches the list of mirrors from primary, creates missing ones and updates those which master branch differs.
+// In between Replicator reacts to change notifications sent by primary. If primary instance is unreachable,
+// Replicator falls back to updating mirrors directly from their original repositories.
+type Replicator struct {
+	primary     *Client
+	mirrors     LocalMirrors
+	queue       *git.SyncQueue
+	callbackURL string
+	interval    time.Duration
+}
+
+// NewReplicator creates and initializes an instance of Replicator. Sync jobs are scheduled using queue, and
+// primary is asked to send change notifications to callbackURL.
+func NewReplicator(primary *Client, mirrors LocalMirrors, queue *git.SyncQueue, callbackURL string, interval time.Duration) *Replicator {
+	return &Replicator{
+		primary:     primary,
+		mirrors:     mirrors,
+		queue:       queue,
+		callbackURL: callbackURL,
+		interval:    interval,
+	}
+}
+
+// Run synchronizes mirrors with primary every r.interval until ctx is cancelled.
+func (r *Replicator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.Sync(logging.With(ctx, "job_id", logging.NewID()))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync renews change notifications subscription and schedules creation and update of mirrors that differ
+// from primary ones.
+func (r *Replicator) Sync(ctx context.Context) error {
+	if r.callbackURL != "" {
+		if err := r.primary.Subscribe(ctx, r.callbackURL); err != nil {
+			slog.WarnContext(ctx, "failed to subscribe to change notifications from primary", "error", err)
+		}
+	}
+
+	local, err := r.mirrors.All(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to list local mirrors", "error", err)
+		return err
+	}
+
+	remote, err := r.primary.Mirrors(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "primary is unreachable, updating mirrors from their sources", "mirrors", len(local), "error", err)
+		for _, repo := range local {
+			name, err := git.ParseRepositoryName(repo.FullName)
+			if err != nil {
+				continue
+			}
+
+			r.queue.Enqueue(ctx, repo.FullName, r.updateFromSource(name))
+		}
+
+		return nil
+	}
+
+	localSHAs := make(map[string]string, len(local))
+	for _, repo := range local {
+		if repo.LatestMasterCommit != nil {
+			localSHAs[repo.FullName] = repo.LatestMasterCommit.SHA
+		} else {
+			localSHAs[repo.FullName] = ""
+		}
+	}
+
+	for _, m := range remote {
+		name, err := git.ParseRepositoryName(m.FullName)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping mirror with invalid name", "repo", m.FullName, "error", err)
+			continue
+		}
+
+		sha, ok := localSHAs[m.FullName]
+		switch {
+		case !ok:
+			r.queue.Enqueue(ctx, m.FullName, r.create(name, m))
+		case sha != m.LatestSHA():
+			r.queue.Enqueue(ctx, m.FullName, r.update(name))
+		}
+	}
+
+	return nil
+}
+
+// Notify handles change notification from primary scheduling an update of repository. If there is no local
+// mirror yet, a full synchronization is scheduled instead.
+func (r *Replicator) Notify(ctx context.Context, repoName git.RepositoryName) {
+	if _, err := r.mirrors.Get(ctx, repoName); err != nil {
+		r.queue.Enqueue(ctx, "*", func(ctx context.Context) error { return r.Sync(ctx) })
+		return
+	}
+
+	r.queue.Enqueue(ctx, repoName.String(), r.update(repoName))
+}
+
+func (r *Replicator) create(name git.RepositoryName, m Mirror) git.SyncFunc {
+	return func(ctx context.Context) error {
+		if err := r.mirrors.Create(ctx, name, m.CloneURL); err != nil {
+			return err
+		}
+
+		if m.SourceURL == "" {
+			return nil
+		}
+
+		return r.mirrors.SetSourceURL(ctx, name, m.SourceURL)
+	}
+}
+
+func (r *Replicator) update(repoName git.RepositoryName) git.SyncFunc {
+	return func(ctx context.Context) error {
+		err := r.mirrors.Update(ctx, repoName)
+		if err == nil {
+			return nil
+		}
+
+		slog.WarnContext(ctx, "failed to update mirror from primary, updating from source", "repo", repoName, "error", err)
+		return r.mirrors.UpdateFromSource(ctx, repoName)
+	}
+}
+
+func (r *Replicator) updateFromSource(repoName git.RepositoryName) git.SyncFunc {
+	return func(ctx context.Context) error {
+		return r.mirrors.UpdateFromSource(ctx, repoName)
+	}
+}
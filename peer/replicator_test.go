@@ -0,0 +1,136 @@
+package peer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type localMirrorsMock struct {
+	mock.Mock
+}
+
+func (m *localMirrorsMock) All(ctx context.Context) ([]*git.Repository, error) {
+	args := m.Called()
+	repos, _ := args.Get(0).([]*git.Repository)
+	return repos, args.Error(1)
+}
+
+func (m *localMirrorsMock) Get(ctx context.Context, name git.RepositoryName) (*git.Repository, error) {
+	args := m.Called(name.String())
+	repo, _ := args.Get(0).(*git.Repository)
+	return repo, args.Error(1)
+}
+
+func (m *localMirrorsMock) Create(ctx context.Context, name git.RepositoryName, url string) error {
+	return m.Called(name.String(), url).Error(0)
+}
+
+func (m *localMirrorsMock) Update(ctx context.Context, name git.RepositoryName) error {
+	return m.Called(name.String()).Error(0)
+}
+
+func (m *localMirrorsMock) SetSourceURL(ctx context.Context, name git.RepositoryName, sourceURL string) error {
+	return m.Called(name.String(), sourceURL).Error(0)
+}
+
+func (m *localMirrorsMock) UpdateFromSource(ctx context.Context, name git.RepositoryName) error {
+	return m.Called(name.String()).Error(0)
+}
+
+func TestReplicator_Sync(t *testing.T) {
+	subscriptions := make(chan string, 1)
+
+	mux := http.NewServeMux()
+	primary := httptest.NewServer(mux)
+	defer primary.Close()
+
+	mux.HandleFunc("/api/peers/subscribe", func(w http.ResponseWriter, r *http.Request) {
+		var s peer.Subscription
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&s))
+		subscriptions <- s.CallbackURL
+	})
+	mux.HandleFunc("/api/mirrors", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[
+			{"full_name": "user1/new", "clone_url": "%[1]s/user1/new.git", "git_url": "git@github.com:user1/new.git"},
+			{"full_name": "user1/changed", "clone_url": "%[1]s/user1/changed.git", "latest_commit": {"sha": "def456"}},
+			{"full_name": "user1/same", "clone_url": "%[1]s/user1/same.git", "latest_commit": {"sha": "abc123"}}
+		]`, primary.URL)
+	})
+
+	mirrors := &localMirrorsMock{}
+	mirrors.On("All").Return([]*git.Repository{
+		{FullName: "user1/changed", LatestMasterCommit: &git.Commit{SHA: "abc123"}},
+		{FullName: "user1/same", LatestMasterCommit: &git.Commit{SHA: "abc123"}},
+	}, nil)
+	mirrors.On("Create", "user1/new", primary.URL+"/user1/new.git").Return(nil)
+	mirrors.On("SetSourceURL", "user1/new", "git@github.com:user1/new.git").Return(nil)
+	mirrors.On("Update", "user1/changed").Return(nil)
+
+	queue := git.NewSyncQueue(10)
+	replicator := peer.NewReplicator(peer.NewClient(primary.Client(), primary.URL), mirrors, queue, "http://secondary/api/peers/notify", time.Minute)
+
+	require.NoError(t, replicator.Sync(context.Background()))
+	assert.Equal(t, "http://secondary/api/peers/notify", <-subscriptions)
+	assert.Equal(t, 2, queue.Len())
+
+	runQueue(queue)
+	mirrors.AssertExpectations(t)
+}
+
+func TestReplicator_Sync_PrimaryUnreachable(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+
+	mirrors := &localMirrorsMock{}
+	mirrors.On("All").Return([]*git.Repository{{FullName: "user1/repo1"}}, nil)
+	mirrors.On("UpdateFromSource", "user1/repo1").Return(nil)
+
+	queue := git.NewSyncQueue(10)
+	replicator := peer.NewReplicator(peer.NewClient(primary.Client(), primary.URL), mirrors, queue, "", time.Minute)
+
+	require.NoError(t, replicator.Sync(context.Background()))
+	runQueue(queue)
+
+	mirrors.AssertExpectations(t)
+}
+
+func TestReplicator_Notify_UpdateFailsOver(t *testing.T) {
+	mirrors := &localMirrorsMock{}
+	mirrors.On("Get", "user1/repo1").Return(&git.Repository{FullName: "user1/repo1"}, nil)
+	mirrors.On("Update", "user1/repo1").Return(assert.AnError)
+	mirrors.On("UpdateFromSource", "user1/repo1").Return(nil)
+
+	queue := git.NewSyncQueue(10)
+	replicator := peer.NewReplicator(peer.NewClient(nil, "http://primary"), mirrors, queue, "", time.Minute)
+
+	replicator.Notify(context.Background(), "user1/repo1")
+	runQueue(queue)
+
+	mirrors.AssertExpectations(t)
+}
+
+// runQueue processes all jobs currently waiting in the queue.
+func runQueue(queue *git.SyncQueue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go queue.Run(ctx, 1)
+	for queue.Len() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Let the last picked up job finish
+	time.Sleep(50 * time.Millisecond)
+}
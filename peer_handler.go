@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/auth"
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/hooks"
+	"github.com/andrewslotin/doppelganger/peer"
+)
+
+// maxNotificationSize limits the size of change notification body read by secondary instance.
+const maxNotificationSize = 64 << 10
+
+// MirrorsAPIHandler is a type that implements http.Handler interface and is used to list mirrors in JSON format
+// at "/api/mirrors". Secondary Doppelganger instances use this endpoint to discover mirrors of the primary one.
+// The list can be filtered, sorted and paginated using the same parameters as repository list pages. Unless
+// page parameter is set, all matching mirrors are returned.
+type MirrorsAPIHandler struct {
+	mirroredRepos git.RepositoryService
+	publicURL     string
+}
+
+// NewMirrorsAPIHandler creates and initializes a new handler. Clone URLs point to publicURL, or to the host the request
+// has been sent to if it's empty.
+func NewMirrorsAPIHandler(mirroredRepos git.RepositoryService, publicURL string) *MirrorsAPIHandler {
+	return &MirrorsAPIHandler{
+		mirroredRepos: mirroredRepos,
+		publicURL:     publicURL,
+	}
+}
+
+func (handler *MirrorsAPIHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	startTime := time.Now()
+
+	repos, err := handler.mirroredRepos.All(req.Context())
+	if err != nil {
+		slog.WarnContext(req.Context(), "failed to get mirrors", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	query := repositoryQueryFromRequest(req, 0)
+	repos, total := query.Apply(accessFromRequest(req).Visible(repos))
+
+	mirrors := make([]peer.Mirror, 0, len(repos))
+	for _, repo := range repos {
+		m := peer.Mirror{
+			FullName:  repo.FullName,
+			CloneURL:  cloneURL(baseURL(handler.publicURL, req), repo.FullName).String(),
+			SourceURL: repo.GitURL,
+		}
+
+		if c := repo.LatestMasterCommit; c != nil {
+			m.LatestCommit = &peer.Commit{
+				SHA:     c.SHA,
+				Message: c.Message,
+				Author:  c.Author,
+				Date:    c.Date,
+			}
+		}
+
+		mirrors = append(mirrors, m)
+	}
+
+	writePaginationHeaders(w, req, query, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mirrors)
+
+	slog.DebugContext(req.Context(), "listed mirrors", "mirrors", len(mirrors), "duration", time.Since(startTime))
+}
+
+// PeerHandler is a type that implements http.Handler interface and is used to handle requests from other
+// Doppelganger instances. Secondary instances subscribe to change notifications sending a peer.Subscription
+// to "/api/peers/subscribe", while primary notifies them by sending a peer.Notification to "/api/peers/notify".
+// Either notifier or replicator is nil depending on the role of this instance.
+//
+// Notifications are accepted without user authentication, but need to be signed with the secret shared between
+// instances, see peer.Notifier. Unsigned notifications are rejected, so that nobody else can schedule syncs.
+type PeerHandler struct {
+	notifier   *peer.Notifier
+	replicator *peer.Replicator
+	secret     string
+}
+
+// NewPeerHandler creates and initializes a new handler.
+func NewPeerHandler(notifier *peer.Notifier, replicator *peer.Replicator, secret string) *PeerHandler {
+	return &PeerHandler{
+		notifier:   notifier,
+		replicator: replicator,
+		secret:     secret,
+	}
+}
+
+func (handler *PeerHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/subscribe") && handler.notifier != nil:
+		// Notifications are sent for all mirrors, so subscribers need access to all of them
+		if accessFromRequest(req).Role("") < auth.RoleOperator {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		var subscription peer.Subscription
+		if err := json.NewDecoder(req.Body).Decode(&subscription); err != nil {
+			http.Error(w, "Malformed subscription request", http.StatusBadRequest)
+			return
+		}
+
+		if u, err := url.Parse(subscription.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "Invalid callback URL", http.StatusBadRequest)
+			return
+		}
+
+		if err := handler.notifier.Subscribe(subscription.CallbackURL); err != nil {
+			slog.WarnContext(req.Context(), "rejected peer subscription", "peer", subscription.CallbackURL, "error", err)
+			http.Error(w, "Too many subscribers", http.StatusServiceUnavailable)
+			return
+		}
+
+		if handler.secret == "" {
+			slog.WarnContext(req.Context(), "peer subscribed, but DOPPELGANGER_PEER_SECRET is not set, notifications will be rejected", "peer", subscription.CallbackURL)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasSuffix(req.URL.Path, "/notify") && handler.replicator != nil:
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxNotificationSize))
+		if err != nil {
+			http.Error(w, "Malformed notification", http.StatusBadRequest)
+			return
+		}
+
+		if handler.secret == "" || !hooks.Verify(handler.secret, body, req.Header.Get(hooks.SignatureHeader)) {
+			slog.WarnContext(req.Context(), "rejected change notification with invalid signature")
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var notification peer.Notification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			http.Error(w, "Malformed notification", http.StatusBadRequest)
+			return
+		}
+
+		name, err := git.ParseRepositoryName(notification.Repository)
+		if err != nil {
+			http.Error(w, "Invalid repository name", http.StatusBadRequest)
+			return
+		}
+
+		slog.InfoContext(req.Context(), "received change notification", "repo", name)
+		handler.replicator.Notify(req.Context(), name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func cloneURL(base *url.URL, repoName string) *url.URL {
+	base.Path += "/" + repoName + ".git"
+
+	return base
+}
@@ -2,8 +2,7 @@ package main
 
 import (
 	"fmt"
-	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -11,8 +10,8 @@ import (
 )
 
 var (
-	repoTemplate      = template.Must(template.ParseFiles("templates/layout.html.template", "templates/repo/show.html.template"))
-	newMirrorTemplate = template.Must(template.ParseFiles("templates/layout.html.template", "templates/repo/mirror.html.template"))
+	repoTemplate      = parsePageTemplate("repo/show.html.template")
+	newMirrorTemplate = parsePageTemplate("repo/mirror.html.template")
 )
 
 // RepoHandler is a type that implements http.Handler interface and is used by ReposHandler to handle single repository
@@ -33,15 +32,22 @@ func (handler *RepoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request)
 	startTime := time.Now()
 	ctx := req.Context()
 
-	repoName, ok := handler.fetchRepoFromRequest(req)
+	name, ok := handler.fetchRepoFromRequest(req)
 	if !ok {
 		WriteNotFoundPage(w, "No such repository", "")
 		return
 	}
+	repoName := name.String()
+
+	access := accessFromRequest(req)
+	if !access.CanView(repoName) {
+		WriteForbidden(w, req, fmt.Sprintf("You are not allowed to view %s", repoName))
+		return
+	}
 
 	switch req.Method {
 	case "GET":
-		switch repo, err := handler.repositories.Get(ctx, repoName); err {
+		switch repo, err := handler.repositories.Get(ctx, name); err {
 		case git.ErrorNotFound: // GitHub repository not found
 			WriteNotFoundPage(w, fmt.Sprintf("No such repository %q", repoName), req.Referer())
 		case git.ErrorNotMirrored: // Mirror repository not found, offer to create a new one
@@ -49,22 +55,22 @@ func (handler *RepoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request)
 				FullName: repoName,
 			}
 
-			if err := handler.NewMirror(w, repo); err != nil {
-				log.Printf("failed to render repo/mirror %s (%s)", repo.FullName, err)
+			if err := handler.NewMirror(w, repo, access); err != nil {
+				slog.WarnContext(ctx, "failed to render page", "template", "repo/mirror", "repo", repo.FullName, "error", err)
 				WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
 			} else {
-				log.Printf("rendered repo/mirror %s [%s]", repo.FullName, time.Since(startTime))
+				slog.DebugContext(ctx, "rendered page", "template", "repo/mirror", "repo", repo.FullName, "duration", time.Since(startTime))
 			}
 		case nil: // Repository found
-			if err := handler.Show(w, repo); err != nil {
-				log.Printf("failed to render repo/show %s with latest commit from %q (%s)", repo.FullName, repo.Master, err)
+			if err := handler.Show(w, repo, access); err != nil {
+				slog.WarnContext(ctx, "failed to render page", "template", "repo/show", "repo", repo.FullName, "ref", repo.Master, "error", err)
 				WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
 			} else {
-				log.Printf("rendered repo/show %s with latest commit from %q [%s]", repo.FullName, repo.Master, time.Since(startTime))
+				slog.DebugContext(ctx, "rendered page", "template", "repo/show", "repo", repo.FullName, "ref", repo.Master, "duration", time.Since(startTime))
 			}
 		default: // Failed to fetch repository
-			log.Printf("failed to fetch %s (%s)", repoName, err)
-			WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+			slog.WarnContext(ctx, "failed to fetch repository", "repo", repoName, "error", err)
+			WriteServiceErrorPage(w, err, req.Referer())
 		}
 	case "POST":
 		WriteErrorPage(w, UserError{Message: "Not implemented", BackURL: req.Referer()}, http.StatusNotImplemented)
@@ -73,21 +79,23 @@ func (handler *RepoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+// repoPage is a repository along with permissions of the user viewing it.
+type repoPage struct {
+	*git.Repository
+	User *userAccess
+}
+
 // Show renders a repository page using templates/repo/show.html.template
-func (handler *RepoHandler) Show(w http.ResponseWriter, repo *git.Repository) error {
-	return repoTemplate.Execute(w, repo)
+func (handler *RepoHandler) Show(w http.ResponseWriter, repo *git.Repository, access *userAccess) error {
+	return repoTemplate.Execute(w, repoPage{Repository: repo, User: access})
 }
 
 // NewMirror renders a new repository mirror page using templates/repo/mirror.html.template
-func (handler *RepoHandler) NewMirror(w http.ResponseWriter, repo *git.Repository) error {
-	return newMirrorTemplate.Execute(w, repo)
+func (handler *RepoHandler) NewMirror(w http.ResponseWriter, repo *git.Repository, access *userAccess) error {
+	return newMirrorTemplate.Execute(w, repoPage{Repository: repo, User: access})
 }
 
-func (handler *RepoHandler) fetchRepoFromRequest(req *http.Request) (string, bool) {
-	owner, repo := req.URL.Query().Get(":owner"), req.URL.Query().Get(":repo")
-	if owner == "" || repo == "" {
-		return "", false
-	}
-
-	return owner + "/" + repo, true
+func (handler *RepoHandler) fetchRepoFromRequest(req *http.Request) (git.RepositoryName, bool) {
+	name, err := git.ParseRepositoryName(req.URL.Query().Get(":owner") + "/" + req.URL.Query().Get(":repo"))
+	return name, err == nil
 }
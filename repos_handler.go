@@ -1,20 +1,27 @@
 package main
 
 import (
-	"html/template"
-	"log"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/andrewslotin/doppelganger/git"
+	"golang.org/x/net/context"
 )
 
 var (
-	reposTemplate = template.Must(template.ParseFiles("templates/layout.html.template", "templates/repos/index.html.template"))
+	reposTemplate = parsePageTemplate("repos/index.html.template")
 )
 
+// minRefreshInterval is the minimum age of a cached list that can be refreshed on demand, so that repeated
+// requests do not exhaust GitHub API rate limit.
+const minRefreshInterval = time.Minute
+
 // ReposHandler is a type that implements http.Handler interface and is used to render repository lists.
-// Doppelganger uses ReposHandler to display both GitHub and local repositories.
+// Doppelganger uses ReposHandler to display both GitHub and local repositories. If repository service caches
+// the list, POST requests by operators make ReposHandler refresh it.
 type ReposHandler struct {
 	repositories git.RepositoryService
 	mirrors      bool
@@ -32,21 +39,156 @@ func (handler *ReposHandler) ServeHTTP(w http.ResponseWriter, req *http.Request)
 	startTime := time.Now()
 	ctx := req.Context()
 
-	repos, err := handler.repositories.All(ctx)
+	if req.Method == "POST" {
+		handler.Refresh(w, req)
+		return
+	}
+
+	access := accessFromRequest(req)
+	query := repositoryQueryFromRequest(req, 1)
+
+	result, err := handler.query(ctx, access, query)
 	if err != nil {
-		log.Printf("failed to get repos (%s) %v", err, req)
-		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		slog.WarnContext(ctx, "failed to get repositories", "error", err)
+		WriteServiceErrorPage(w, err, req.Referer())
+		return
+	}
+	page, total := result.Repositories, result.Total
+
+	if acceptsJSON(req) {
+		handler.writeJSON(w, req, query, page, total)
 		return
 	}
 
 	values := struct {
 		Repositories []*git.Repository
 		Mirrors      bool
-	}{repos, handler.mirrors}
+		Cache        git.CacheService
+		List         repositoryListPage
+		User         *userAccess
+	}{
+		Repositories: page,
+		Mirrors:      handler.mirrors,
+		User:         access,
+		List: repositoryListPage{
+			RepositoryQuery: query,
+			Path:            req.URL.Path,
+			Total:           total,
+			Owners:          result.Owners,
+		},
+	}
+	if cache, ok := handler.repositories.(git.CacheService); ok {
+		values.Cache = cache
+	}
+
 	if err := reposTemplate.Execute(w, values); err != nil {
-		log.Printf("failed to render repos/index with %d entries (%s)", len(repos), err)
+		slog.WarnContext(ctx, "failed to render page", "template", "repos/index", "repos", len(page), "error", err)
 		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
 	} else {
-		log.Printf("rendered repos/index with %d entries [%s]", len(repos), time.Since(startTime))
+		slog.DebugContext(ctx, "rendered page", "template", "repos/index", "repos", len(page), "duration", time.Since(startTime))
 	}
 }
+
+// query returns the page of repositories requested by user. Services that paginate on their side are only used if
+// user is allowed to view all repositories, otherwise the full list is needed to filter out hidden ones.
+func (handler *ReposHandler) query(ctx context.Context, access *userAccess, query git.RepositoryQuery) (git.RepositoryPage, error) {
+	if service, ok := handler.repositories.(git.QueryService); ok && access.VisibleAll() {
+		return service.Query(ctx, query)
+	}
+
+	repos, err := handler.repositories.All(ctx)
+	if err != nil {
+		return git.RepositoryPage{}, err
+	}
+
+	repos = access.Visible(repos)
+	page, total := query.Apply(repos)
+
+	return git.RepositoryPage{Repositories: page, Total: total, Owners: git.Owners(repos)}, nil
+}
+
+func (handler *ReposHandler) writeJSON(w http.ResponseWriter, req *http.Request, query git.RepositoryQuery, repos []*git.Repository, total int) {
+	type commitJSON struct {
+		SHA     string    `json:"sha"`
+		Message string    `json:"message"`
+		Author  string    `json:"author"`
+		Date    time.Time `json:"date"`
+	}
+
+	type repositoryJSON struct {
+		FullName     string      `json:"full_name"`
+		Description  string      `json:"description,omitempty"`
+		Master       string      `json:"master"`
+		HTMLURL      string      `json:"html_url,omitempty"`
+		LatestCommit *commitJSON `json:"latest_commit,omitempty"`
+		LastPushAt   *time.Time  `json:"last_push_at,omitempty"`
+		LastSyncAt   *time.Time  `json:"last_sync_at,omitempty"`
+	}
+
+	result := make([]repositoryJSON, 0, len(repos))
+	for _, repo := range repos {
+		r := repositoryJSON{
+			FullName:    repo.FullName,
+			Description: repo.Description,
+			Master:      repo.Master,
+			HTMLURL:     repo.HTMLURL,
+		}
+
+		if c := repo.LatestMasterCommit; c != nil {
+			r.LatestCommit = &commitJSON{SHA: c.SHA, Message: c.Message, Author: c.Author, Date: c.Date}
+		}
+
+		if !repo.LastPushAt.IsZero() {
+			r.LastPushAt = &repo.LastPushAt
+		}
+
+		if !repo.LastSyncAt.IsZero() {
+			r.LastSyncAt = &repo.LastSyncAt
+		}
+
+		result = append(result, r)
+	}
+
+	writePaginationHeaders(w, req, query, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Refresh reloads repositories list if repository service caches it and redirects back to the list. Lists that
+// have been fetched less than minRefreshInterval ago are not reloaded.
+func (handler *ReposHandler) Refresh(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	cache, ok := handler.repositories.(git.CacheService)
+	if !ok {
+		WriteErrorPage(w, UserError{Message: "Repositories list is not cached", BackURL: req.Referer()}, http.StatusNotImplemented)
+		return
+	}
+
+	if !accessFromRequest(req).CanRefresh() {
+		WriteForbidden(w, req, "You are not allowed to refresh the list of repositories")
+		return
+	}
+
+	if !cache.Stale() && time.Since(cache.UpdatedAt()) < minRefreshInterval {
+		http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	if err := cache.Refresh(ctx); err != nil {
+		var rateLimitErr *git.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			WriteServiceErrorPage(w, err, req.URL.Path)
+			return
+		}
+
+		WriteErrorPage(w, UserError{
+			Message:       "Failed to refresh the list of repositories, GitHub is unavailable",
+			BackURL:       req.URL.Path,
+			OriginalError: err,
+		}, http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+}
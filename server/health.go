@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultCheckTimeout is the maximum time a single readiness check is allowed to run.
+const DefaultCheckTimeout = 10 * time.Second
+
+// CheckFunc is a function that verifies the availability of a dependency. It returns an error if the
+// dependency is not operational.
+type CheckFunc func(ctx context.Context) error
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthStatus is the response body of health and readiness endpoints.
+type HealthStatus struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks,omitempty"`
+}
+
+// HealthHandler is a type that implements http.Handler interface and reports whether the server
+// is alive, i.e. is able to handle requests. It always responds with 200 OK.
+type HealthHandler struct{}
+
+func (HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	writeHealthStatus(w, HealthStatus{Status: "ok"}, http.StatusOK)
+}
+
+type namedCheck struct {
+	name string
+	fn   CheckFunc
+}
+
+// ReadinessHandler is a type that implements http.Handler interface and reports whether the server is ready to
+// serve requests running registered checks concurrently. If any of checks fails, the handler responds with
+// 503 Service Unavailable. Results of each check are reported in response body.
+type ReadinessHandler struct {
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+// NewReadinessHandler returns an instance of ReadinessHandler without any checks.
+func NewReadinessHandler() *ReadinessHandler {
+	return &ReadinessHandler{
+		Timeout: DefaultCheckTimeout,
+	}
+}
+
+// AddCheck registers a readiness check with given name.
+func (handler *ReadinessHandler) AddCheck(name string, fn CheckFunc) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+
+	handler.checks = append(handler.checks, namedCheck{name, fn})
+}
+
+// Check runs all registered checks and returns their results.
+func (handler *ReadinessHandler) Check(ctx context.Context) HealthStatus {
+	handler.mu.Lock()
+	checks := append([]namedCheck(nil), handler.checks...)
+	handler.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, handler.Timeout)
+	defer cancel()
+
+	results := make([]*CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, fn CheckFunc) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, fn)
+		}(i, check.fn)
+	}
+	wg.Wait()
+
+	status := HealthStatus{
+		Status: "ok",
+		Checks: make(map[string]*CheckResult, len(checks)),
+	}
+	for i, check := range checks {
+		status.Checks[check.name] = results[i]
+		if results[i].Error != "" {
+			status.Status = "fail"
+		}
+	}
+
+	return status
+}
+
+func (handler *ReadinessHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status := handler.Check(req.Context())
+
+	code := http.StatusOK
+	if status.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeHealthStatus(w, status, code)
+}
+
+// CachedCheck wraps fn to reuse its result for ttl. This is useful for checks that are expensive or
+// consume a limited resource, such as GitHub API rate limit.
+func CachedCheck(ttl time.Duration, fn CheckFunc) CheckFunc {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		lastErr   error
+	)
+
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return lastErr
+		}
+
+		lastErr = fn(ctx)
+		if ctx.Err() == nil {
+			checkedAt = time.Now()
+		}
+
+		return lastErr
+	}
+}
+
+func runCheck(ctx context.Context, fn CheckFunc) *CheckResult {
+	startTime := time.Now()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := &CheckResult{
+		Status:   "ok",
+		Duration: time.Since(startTime).String(),
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func writeHealthStatus(w http.ResponseWriter, status HealthStatus, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestReadinessHandler_OK(t *testing.T) {
+	handler := server.NewReadinessHandler()
+	handler.AddCheck("git", func(ctx context.Context) error { return nil })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var status server.HealthStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, "ok", status.Status)
+	if assert.Contains(t, status.Checks, "git") {
+		assert.Equal(t, "ok", status.Checks["git"].Status)
+	}
+}
+
+func TestReadinessHandler_Fail(t *testing.T) {
+	handler := server.NewReadinessHandler()
+	handler.Timeout = 50 * time.Millisecond
+	handler.AddCheck("git", func(ctx context.Context) error { return nil })
+	handler.AddCheck("mirror_dir", func(ctx context.Context) error { return errors.New("read-only file system") })
+	handler.AddCheck("github", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var status server.HealthStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, "fail", status.Status)
+	assert.Equal(t, "ok", status.Checks["git"].Status)
+	assert.Equal(t, "read-only file system", status.Checks["mirror_dir"].Error)
+	assert.Equal(t, context.DeadlineExceeded.Error(), status.Checks["github"].Error)
+}
+
+func TestCachedCheck(t *testing.T) {
+	var calls int
+	check := server.CachedCheck(time.Minute, func(ctx context.Context) error {
+		calls++
+		return errors.New("failed")
+	})
+
+	assert.Error(t, check(context.Background()))
+	assert.Error(t, check(context.Background()))
+	assert.Equal(t, 1, calls)
+}
+
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	server.HealthHandler{}.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, rec.Body.String())
+}
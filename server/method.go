@@ -0,0 +1,16 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// MethodNotAllowed is a type that implements http.Handler interface and responds with 405 Method Not Allowed
+// listing methods supported by the endpoint in Allow header. It's meant to be registered for GET requests to
+// endpoints that change state, so that they are not handled by a catch-all route instead.
+type MethodNotAllowed []string
+
+func (allowed MethodNotAllowed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,18 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrewslotin/doppelganger/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	server.MethodNotAllowed{"POST", "DELETE"}.ServeHTTP(rec, httptest.NewRequest("GET", "/mirror", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "POST, DELETE", rec.Header().Get("Allow"))
+}
@@ -1,12 +1,15 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"sync"
+
+	"golang.org/x/net/context"
 )
 
 // ErrNotStarted is an error returned by (*Server).Shutdown() if the server was not started yet.
@@ -16,13 +19,43 @@ var ErrNotStarted = errors.New("server not running")
 type Server struct {
 	Addr string
 
-	ln net.Listener
+	tlsConfig    *tls.Config
+	srv          *http.Server
+	shuttingDown chan struct{}
+	shutdownOnce sync.Once
+
 	mu sync.Mutex
 }
 
 // New returns an unstarted *Server instance that serves connections on provided host:port.
 func New(host string, port int) *Server {
-	return &Server{Addr: fmt.Sprintf("%s:%d", host, port)}
+	return &Server{
+		Addr:         fmt.Sprintf("%s:%d", host, port),
+		shuttingDown: make(chan struct{}),
+	}
+}
+
+// EnableTLS makes server accept HTTPS connections instead of plain HTTP ones. It must be called before Run.
+func (srv *Server) EnableTLS(opts TLSOptions) error {
+	cfg, err := opts.Config()
+	if err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.tlsConfig = cfg
+
+	return nil
+}
+
+// TLS returns true if server accepts HTTPS connections.
+func (srv *Server) TLS() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.tlsConfig != nil
 }
 
 // Run starts the server and spawns a goroutine that accepts incoming connections and handles them using http.Handler.
@@ -34,31 +67,42 @@ func (srv *Server) Run(h http.Handler) error {
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %s", srv.Addr, err)
 	}
-	srv.ln = ln
+	srv.Addr = ln.Addr().String()
+
+	if srv.tlsConfig != nil {
+		ln = tls.NewListener(ln, srv.tlsConfig)
+	}
+	srv.srv = &http.Server{Handler: h}
 
 	go func(srv *http.Server, ln net.Listener) {
-		if err := srv.Serve(ln); err != nil {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
-	}(&http.Server{Handler: h}, srv.ln)
+	}(srv.srv, ln)
 
 	return nil
 }
 
-// Shutdown terminates running server.
-func (srv *Server) Shutdown() error {
-	if srv.ln == nil {
-		return ErrNotStarted
-	}
+// ShuttingDown returns a channel that is closed once Shutdown has been called. Handlers that keep connection open
+// for an indefinite time, such as event streams, are expected to return once it's closed, so that they don't delay
+// the shutdown.
+func (srv *Server) ShuttingDown() <-chan struct{} {
+	return srv.shuttingDown
+}
 
+// Shutdown stops accepting new connections and waits for active requests to complete. If ctx is done before that,
+// Shutdown returns its error leaving remaining requests running.
+func (srv *Server) Shutdown(ctx context.Context) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	if err := srv.ln.Close(); err != nil {
-		return err
+	if srv.srv == nil {
+		return ErrNotStarted
 	}
+	srv.shutdownOnce.Do(func() { close(srv.shuttingDown) })
 
-	srv.ln = nil
+	err := srv.srv.Shutdown(ctx)
+	srv.srv = nil
 
-	return nil
+	return err
 }
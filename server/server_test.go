@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+
+	srv := server.New("127.0.0.1", 0)
+	require.NoError(t, srv.Run(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})))
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr + "/")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		responses <- response{string(body), err}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// In-flight request is completed before Shutdown returns
+	require.NoError(t, srv.Shutdown(ctx))
+	select {
+	case resp := <-responses:
+		require.NoError(t, resp.err)
+		assert.Equal(t, "done", resp.body)
+	default:
+		t.Error("Shutdown returned before request has been handled")
+	}
+
+	_, err := http.Get("http://" + srv.Addr + "/")
+	assert.Error(t, err)
+
+	assert.Equal(t, server.ErrNotStarted, srv.Shutdown(ctx))
+}
+
+func TestServer_Shutdown_Timeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+
+	srv := server.New("127.0.0.1", 0)
+	require.NoError(t, srv.Run(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})))
+
+	go http.Get("http://" + srv.Addr + "/")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, srv.Shutdown(ctx))
+}
+
+func TestServer_ShuttingDown(t *testing.T) {
+	started := make(chan struct{})
+
+	srv := server.New("127.0.0.1", 0)
+	require.NoError(t, srv.Run(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+
+		select {
+		case <-srv.ShuttingDown():
+			w.Write([]byte("shutting down"))
+		case <-time.After(5 * time.Second):
+		}
+	})))
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr + "/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-started
+
+	select {
+	case <-srv.ShuttingDown():
+		t.Fatal("ShuttingDown() is closed before Shutdown has been called")
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.Equal(t, "shutting down", <-responses)
+}
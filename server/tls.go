@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertificateCheckInterval is the minimum time between checks whether certificate files have changed.
+const DefaultCertificateCheckInterval = 10 * time.Second
+
+// ErrNoClientCAs is an error returned by TLSOptions.Config() if client CA file contains no certificates.
+var ErrNoClientCAs = errors.New("no certificates found")
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts TLS version string, i.e. "1.2", into a constant understood by crypto/tls.
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q", s)
+	}
+
+	return v, nil
+}
+
+// TLSOptions configures HTTPS.
+type TLSOptions struct {
+	// CertFile and KeyFile are PEM-encoded certificate chain and private key. Both files are reloaded
+	// once they change on disk.
+	CertFile, KeyFile string
+	// MinVersion is the minimum accepted TLS version, tls.VersionTLS12 if not set.
+	MinVersion uint16
+	// ClientCAFile is an optional PEM file with CA certificates used to verify client certificates.
+	ClientCAFile string
+	// ClientAuth is the policy for client certificates if ClientCAFile is set. By default clients are
+	// required to present a valid certificate.
+	ClientAuth tls.ClientAuthType
+}
+
+// Config returns TLS configuration that serves certificates using a CertificateReloader.
+func (opts TLSOptions) Config() (*tls.Config, error) {
+	certs, err := NewCertificateReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     opts.MinVersion,
+	}
+
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	if opts.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %s", err)
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("failed to read client CA file %s: %s", opts.ClientCAFile, ErrNoClientCAs)
+		}
+
+		cfg.ClientAuth = opts.ClientAuth
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return cfg, nil
+}
+
+// CertificateReloader is a type that serves TLS certificate from files and reloads it once any of them changes,
+// so that renewed certificates are picked up without restart. If reload fails, the previous certificate is served.
+type CertificateReloader struct {
+	// CheckInterval is the minimum time between checks whether files have been modified.
+	CheckInterval time.Duration
+
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertificateReloader loads certificate and private key from provided files and returns an instance
+// of CertificateReloader serving them.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		CheckInterval: DefaultCertificateCheckInterval,
+		certFile:      certFile,
+		keyFile:       keyFile,
+	}
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate returns current certificate. It is intended to be used as tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < r.CheckInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		slog.Warn("failed to check TLS certificate for changes, serving the current one", "error", err)
+		return r.cert, nil
+	}
+
+	if modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	if err := r.load(modTime); err != nil {
+		slog.Warn("failed to reload TLS certificate, serving the current one", "error", err)
+		return r.cert, nil
+	}
+	slog.Info("reloaded TLS certificate", "path", r.certFile)
+
+	return r.cert, nil
+}
+
+func (r *CertificateReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %s", err)
+	}
+
+	r.cert, r.modTime, r.checkedAt = &cert, modTime, time.Now()
+
+	return nil
+}
+
+func (r *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
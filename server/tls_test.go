@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := server.ParseTLSVersion("1.3")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+
+	_, err = server.ParseTLSVersion("3.0")
+	assert.Error(t, err)
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "doppelganger-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, writeTestCertificate(certFile, keyFile, 1, nil))
+
+	r, err := server.NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+	r.CheckInterval = 0
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1), cert.Leaf.SerialNumber)
+
+	// Renewed certificate is picked up
+	require.NoError(t, writeTestCertificate(certFile, keyFile, 2, nil))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(2), cert.Leaf.SerialNumber)
+
+	// Broken certificate is ignored
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(2), cert.Leaf.SerialNumber)
+
+	_, err = server.NewCertificateReloader(certFile, keyFile)
+	assert.Error(t, err)
+}
+
+func TestServer_EnableTLS(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "doppelganger-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, writeTestCertificate(certFile, keyFile, 1, nil))
+
+	// Client certificate signed by self-signed CA
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, writeTestCertificate(caFile, caKeyFile, 10, nil))
+	ca, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	require.NoError(t, err)
+
+	clientCertFile, clientKeyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, writeTestCertificate(clientCertFile, clientKeyFile, 11, &ca))
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	require.NoError(t, err)
+
+	srv := server.New("127.0.0.1", 0)
+	require.NoError(t, srv.EnableTLS(server.TLSOptions{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+	}))
+	assert.True(t, srv.TLS())
+
+	require.NoError(t, srv.Run(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "tls=%t", req.TLS != nil)
+	})))
+	defer srv.Shutdown(context.Background())
+
+	get := func(tlsConfig *tls.Config) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get("https://" + srv.Addr + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	body, err := get(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}})
+	require.NoError(t, err)
+	assert.Equal(t, "tls=true", body)
+
+	_, err = get(&tls.Config{InsecureSkipVerify: true})
+	assert.Error(t, err, "client certificate is required")
+
+	_, err = get(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}, MaxVersion: tls.VersionTLS11})
+	assert.Error(t, err, "TLS 1.1 is not accepted by default")
+}
+
+func TestServer_EnableTLS_InvalidOptions(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "doppelganger-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, writeTestCertificate(certFile, keyFile, 1, nil))
+
+	srv := server.New("127.0.0.1", 0)
+	assert.Error(t, srv.EnableTLS(server.TLSOptions{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.pem")}))
+	assert.Error(t, srv.EnableTLS(server.TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}))
+	assert.False(t, srv.TLS())
+}
+
+// writeTestCertificate generates a certificate for 127.0.0.1 signed by parent or a self-signed CA one if parent is nil.
+func writeTestCertificate(certFile, keyFile string, serial int64, parent *tls.Certificate) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "doppelganger"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		if signer, err = x509.ParseCertificate(parent.Certificate[0]); err != nil {
+			return err
+		}
+		signerKey = parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
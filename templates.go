@@ -0,0 +1,104 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/andrewslotin/doppelganger/git"
+)
+
+// layoutTemplate is the name of template that page templates are rendered within.
+const layoutTemplate = "layout.html.template"
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// githubRateLimit is used to display the status of GitHub API rate limit in page footer. It is set
+// in main() once GitHub client is initialized.
+var githubRateLimit git.RateLimitService
+
+var templateFuncs = template.FuncMap{
+	"githubRateLimit": func() *git.RateLimit {
+		if githubRateLimit == nil {
+			return nil
+		}
+
+		rl := githubRateLimit.RateLimit()
+		if !rl.Known() {
+			return nil
+		}
+
+		return &rl
+	},
+}
+
+// pageTemplates lists all templates created with parsePageTemplate.
+var pageTemplates []*pageTemplate
+
+// pageTemplate is a page template rendered within the layout. By default templates embedded into the binary are used.
+// If -templates-dir is set, templates are read from there on each render, so that changes are visible without restart.
+type pageTemplate struct {
+	name     string
+	embedded *template.Template
+}
+
+// parsePageTemplate parses an embedded page template with given name, i.e. "repo/show.html.template",
+// together with the layout and functions available to all templates.
+func parsePageTemplate(name string) *pageTemplate {
+	t := &pageTemplate{name: name}
+	t.embedded = template.Must(t.parse(templateFiles("")))
+	pageTemplates = append(pageTemplates, t)
+
+	return t
+}
+
+// Execute renders the page template with provided data.
+func (t *pageTemplate) Execute(w io.Writer, data interface{}) error {
+	tmpl := t.embedded
+	if args.templatesDir != "" {
+		var err error
+		if tmpl, err = t.parse(templateFiles(args.templatesDir)); err != nil {
+			return err
+		}
+	}
+
+	return tmpl.Execute(w, data)
+}
+
+func (t *pageTemplate) parse(fsys fs.FS) (*template.Template, error) {
+	return template.New(layoutTemplate).Funcs(templateFuncs).ParseFS(fsys, layoutTemplate, t.name)
+}
+
+// templateFiles returns the file system to read templates from. Templates missing in dir, i.e. when
+// only the layout is overridden, are taken from the embedded ones.
+func templateFiles(dir string) fs.FS {
+	embedded, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	if dir == "" {
+		return embedded
+	}
+
+	return overlayFS{os.DirFS(dir), embedded}
+}
+
+// overlayFS is a file system that looks up files in upper and falls back to lower if they're missing there.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+// Open implements fs.FS.
+func (fsys overlayFS) Open(name string) (fs.File, error) {
+	fd, err := fsys.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fsys.lower.Open(name)
+	}
+
+	return fd, err
+}
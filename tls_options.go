@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/andrewslotin/doppelganger/server"
+)
+
+// tlsOptions returns HTTPS server options set with -tls-* flags.
+func tlsOptions() (server.TLSOptions, error) {
+	if args.tlsCert == "" || args.tlsKey == "" {
+		return server.TLSOptions{}, errors.New("both -tls-cert and -tls-key are required to enable HTTPS")
+	}
+
+	minVersion, err := server.ParseTLSVersion(args.tlsMinVersion)
+	if err != nil {
+		return server.TLSOptions{}, err
+	}
+
+	opts := server.TLSOptions{
+		CertFile:     args.tlsCert,
+		KeyFile:      args.tlsKey,
+		MinVersion:   minVersion,
+		ClientCAFile: args.tlsClientCA,
+	}
+
+	switch args.tlsClientAuth {
+	case "require":
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	case "verify-if-given":
+		opts.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return server.TLSOptions{}, fmt.Errorf("unsupported -tls-client-auth value %q", args.tlsClientAuth)
+	}
+
+	return opts, nil
+}
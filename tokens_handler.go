@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/auth"
+)
+
+var (
+	tokensTemplate = parsePageTemplate("tokens/index.html.template")
+)
+
+// TokensHandler is a type that implements http.Handler interface and is used to manage API tokens at "/tokens".
+// Users can create and revoke their own personal tokens, admins can also see all tokens and create service
+// tokens. Tokens themselves can't be used to manage tokens.
+//
+// Tokens are managed in the browser: a signed in user opens /tokens, fills in the form to create a token and
+// copies its value, which is shown only once, or clicks "Revoke" next to a token in the list. Both forms are
+// POSTed with the CSRF token of user session, so tokens can't be created or revoked by scripts.
+type TokensHandler struct {
+	tokens *auth.TokenStore
+}
+
+// NewTokensHandler creates and initializes a new handler.
+func NewTokensHandler(tokens *auth.TokenStore) *TokensHandler {
+	return &TokensHandler{
+		tokens: tokens,
+	}
+}
+
+func (handler *TokensHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	access := accessFromRequest(req)
+	if access.Identity == nil || access.Identity.Scopes != nil {
+		WriteForbidden(w, req, "API tokens can only be managed by signed in users")
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		handler.List(w, req, access, nil, "")
+	case "POST":
+		switch action := req.FormValue("action"); action {
+		case "create":
+			handler.Create(w, req, access)
+		case "revoke":
+			handler.Revoke(w, req, access)
+		default:
+			WriteErrorPage(w, UserError{Message: "Unsupported action " + action, BackURL: req.Referer()}, http.StatusBadRequest)
+		}
+	default:
+		WriteErrorPage(w, UserError{Message: req.Method + " requests are not supported", BackURL: req.Referer()}, http.StatusMethodNotAllowed)
+	}
+}
+
+// List renders tokens available to user. A newly created token value is shown once if provided.
+func (handler *TokensHandler) List(w http.ResponseWriter, req *http.Request, access *userAccess, created *auth.Token, value string) {
+	owner := access.Identity.Username
+	if access.IsAdmin() {
+		owner = ""
+	}
+	tokens := handler.tokens.List(owner)
+
+	if acceptsJSON(req) {
+		type tokenJSON struct {
+			ID         string       `json:"id"`
+			Name       string       `json:"name"`
+			Owner      string       `json:"owner"`
+			Service    bool         `json:"service"`
+			Scopes     []auth.Scope `json:"scopes"`
+			Repos      string       `json:"repos,omitempty"`
+			CreatedAt  time.Time    `json:"created_at"`
+			LastUsedAt *time.Time   `json:"last_used_at"`
+			Token      string       `json:"token,omitempty"`
+		}
+
+		result := make([]tokenJSON, 0, len(tokens))
+		for i, t := range tokens {
+			tj := tokenJSON{ID: t.ID, Name: t.Name, Owner: t.Owner, Service: t.Service, Scopes: t.Scopes, Repos: t.Repos, CreatedAt: t.CreatedAt}
+			if !t.LastUsedAt.IsZero() {
+				tj.LastUsedAt = &tokens[i].LastUsedAt
+			}
+
+			if created != nil && created.ID == t.ID {
+				tj.Token = value
+			}
+
+			result = append(result, tj)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+
+		return
+	}
+
+	values := struct {
+		Tokens  []auth.Token
+		Scopes  []auth.Scope
+		Created *auth.Token
+		Value   string
+		User    *userAccess
+	}{
+		Tokens:  tokens,
+		Scopes:  auth.Scopes,
+		Created: created,
+		Value:   value,
+		User:    access,
+	}
+
+	if err := tokensTemplate.Execute(w, values); err != nil {
+		slog.WarnContext(req.Context(), "failed to render page", "template", "tokens/index", "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+	}
+}
+
+// Create issues a new token using "name", "scope", "repos" and "service" form values.
+func (handler *TokensHandler) Create(w http.ResponseWriter, req *http.Request, access *userAccess) {
+	if err := req.ParseForm(); err != nil {
+		WriteErrorPage(w, UserError{Message: "Malformed request", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+		return
+	}
+
+	t := auth.Token{
+		Name:          req.PostForm.Get("name"),
+		Owner:         access.Identity.Username,
+		OwnerProvider: access.Identity.UserProvider(),
+		Repos:         strings.TrimSpace(req.PostForm.Get("repos")),
+		Service:       req.PostForm.Get("service") != "",
+	}
+
+	for _, s := range req.PostForm["scope"] {
+		scope, err := auth.ParseScope(s)
+		if err != nil {
+			WriteErrorPage(w, UserError{Message: err.Error(), BackURL: req.Referer()}, http.StatusBadRequest)
+			return
+		}
+
+		t.Scopes = append(t.Scopes, scope)
+	}
+
+	if t.Service && !access.IsAdmin() {
+		WriteForbidden(w, req, "Only admins can create service tokens")
+		return
+	}
+
+	created, value, err := handler.tokens.Create(t)
+	switch err {
+	case nil:
+	case auth.ErrInvalidToken:
+		WriteErrorPage(w, UserError{Message: "Token name, scopes or repository pattern are not valid", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+		return
+	default:
+		slog.WarnContext(req.Context(), "failed to create API token", "user", access.Identity.String(), "name", t.Name, "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "created API token", "user", access.Identity.String(), "token", created.ID, "name", created.Name)
+	w.Header().Set("Cache-Control", "no-store")
+	handler.List(w, req, access, created, value)
+}
+
+// Revoke deletes a token specified by "id" form value. Users can revoke their own tokens, admins can revoke any token.
+func (handler *TokensHandler) Revoke(w http.ResponseWriter, req *http.Request, access *userAccess) {
+	id := req.FormValue("id")
+
+	t, err := handler.tokens.Get(id)
+	if err == nil && t.Owner != access.Identity.Username && !access.IsAdmin() {
+		err = auth.ErrTokenNotFound
+	}
+
+	if err == nil {
+		err = handler.tokens.Revoke(id)
+	}
+
+	switch err {
+	case nil:
+	case auth.ErrTokenNotFound:
+		WriteNotFoundPage(w, "No such token", "/tokens")
+		return
+	default:
+		slog.WarnContext(req.Context(), "failed to revoke API token", "token", id, "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "revoked API token", "user", access.Identity.String(), "token", t.ID, "name", t.Name)
+	http.Redirect(w, req, "/tokens", http.StatusSeeOther)
+}
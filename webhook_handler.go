@@ -1,62 +1,133 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/logging"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 )
 
+var webhookEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "doppelganger_webhook_events_total",
+	Help: "Number of GitHub webhook events received by event type and outcome.",
+}, []string{"event", "outcome"})
+
+func init() {
+	prometheus.MustRegister(webhookEventsTotal)
+}
+
 // WebhookHandler is a type that implements http.Handler interface and is used by HTTP server to handle GitHub webhooks sent to "/apihook".
-// Currently "ping" and "push" events are supported.
+// Currently "ping" and "push" events are supported. If secret is set, payloads are required to be signed with it
+// and requests without valid X-Hub-Signature-256 (or legacy X-Hub-Signature) header are rejected.
 //
 // For more details on webhooks see https://developer.github.com/webhooks/.
 type WebhookHandler struct {
 	mirroredRepos git.MirrorService
+	secret        []byte
 }
 
-// NewWebhookHandler creates and initializes an instance of WebhookHandler.
-func NewWebhookHandler(mirroredRepos git.MirrorService) *WebhookHandler {
+// NewWebhookHandler creates and initializes an instance of WebhookHandler. An empty secret disables signature check.
+func NewWebhookHandler(mirroredRepos git.MirrorService, secret string) *WebhookHandler {
 	return &WebhookHandler{
 		mirroredRepos: mirroredRepos,
+		secret:        []byte(secret),
 	}
 }
 
 func (handler *WebhookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	startTime := time.Now()
-	ctx := req.Context()
+	ctx := logging.With(req.Context(), "github_event", req.Header.Get("X-Github-Event"))
 
 	defer req.Body.Close()
 
+	if len(handler.secret) > 0 {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		if !handler.VerifySignature(req.Header, body) {
+			webhookEventsTotal.WithLabelValues(req.Header.Get("X-Github-Event"), "unauthorized").Inc()
+			slog.WarnContext(ctx, "rejected webhook with invalid signature", "remote_addr", req.RemoteAddr)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
 	switch event := req.Header.Get("X-Github-Event"); event {
 	case "ping":
+		webhookEventsTotal.WithLabelValues(event, "ok").Inc()
 		fmt.Fprint(w, "PONG")
 	case "push":
 		switch repo, err := handler.UpdateRepo(ctx, req); err {
 		case nil:
-			log.Printf("updated %s [%s]", repo.FullName, time.Since(startTime))
+			webhookEventsTotal.WithLabelValues(event, "ok").Inc()
+			slog.InfoContext(ctx, "updated mirror", "repo", repo.FullName, "duration", time.Since(startTime))
 			fmt.Fprint(w, "OK")
 		case git.ErrorNotFound, git.ErrorNotMirrored:
+			webhookEventsTotal.WithLabelValues(event, "not_found").Inc()
 			http.Error(w, "Not found", http.StatusNotFound)
+		case git.ErrorInvalidRepositoryName:
+			webhookEventsTotal.WithLabelValues(event, "invalid").Inc()
+			http.Error(w, "Invalid repository name", http.StatusBadRequest)
 		default:
+			webhookEventsTotal.WithLabelValues(event, "error").Inc()
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 	default:
+		webhookEventsTotal.WithLabelValues("unsupported", "rejected").Inc()
 		http.Error(w, fmt.Sprintf("Unsupported event %q", event), http.StatusBadRequest)
 	}
 }
 
+// VerifySignature checks that the payload was signed with the webhook secret.
+func (handler *WebhookHandler) VerifySignature(header http.Header, body []byte) bool {
+	var (
+		hashFn    func() hash.Hash
+		signature string
+	)
+
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		hashFn, signature = sha256.New, strings.TrimPrefix(sig, "sha256=")
+	} else if sig := header.Get("X-Hub-Signature"); sig != "" {
+		hashFn, signature = sha1.New, strings.TrimPrefix(sig, "sha1=")
+	} else {
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(hashFn, handler.secret)
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
 // UpdateRepo handles "push" event and updates existing GitHub repository mirrors synchronizing it with remote.
 func (handler *WebhookHandler) UpdateRepo(ctx context.Context, req *http.Request) (repo *git.Repository, err error) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("failed to read request body (%s)", err)
+		slog.WarnContext(ctx, "failed to read request body", "error", err)
 		return nil, err
 	}
 
@@ -68,21 +139,27 @@ func (handler *WebhookHandler) UpdateRepo(ctx context.Context, req *http.Request
 	}
 
 	if err := json.Unmarshal(body, &updateEvent); err != nil {
-		log.Printf("failed to parse push event payload %s", string(body))
+		slog.WarnContext(ctx, "failed to parse push event payload", "error", err)
+		return nil, err
+	}
+
+	name, err := git.ParseRepositoryName(updateEvent.Repository.FullName)
+	if err != nil {
+		slog.WarnContext(ctx, "received push event with invalid repository name", "repo", updateEvent.Repository.FullName)
 		return nil, err
 	}
 
-	repo, err = handler.mirroredRepos.Get(ctx, updateEvent.Repository.FullName)
+	repo, err = handler.mirroredRepos.Get(ctx, name)
 	if err != nil {
-		log.Printf("failed to find mirrored copy of %s (%s)", updateEvent.Repository.FullName, err)
+		slog.InfoContext(ctx, "failed to find mirrored copy", "repo", updateEvent.Repository.FullName, "error", err)
 		return nil, err
 	}
 
 	updatedBranch := strings.TrimPrefix(updateEvent.Ref, "refs/heads/")
 	if repo.Master != updatedBranch {
-		log.Printf("skip push event to %s (mirrored ref %s, received %s)", repo.FullName, repo.Master, updatedBranch)
+		slog.InfoContext(ctx, "skipping push event to a branch that is not mirrored", "repo", repo.FullName, "mirrored_ref", repo.Master, "ref", updatedBranch)
 		return repo, nil
 	}
 
-	return repo, handler.mirroredRepos.Update(ctx, repo.FullName)
+	return repo, handler.mirroredRepos.Update(ctx, name)
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andrewslotin/doppelganger/git"
+	"github.com/andrewslotin/doppelganger/hooks"
+)
+
+var (
+	webhooksTemplate = parsePageTemplate("webhooks/index.html.template")
+	webhookTemplate  = parsePageTemplate("webhooks/show.html.template")
+)
+
+// WebhooksHandler is a type that implements http.Handler interface and is used to manage outgoing webhooks at
+// "/webhooks" and to browse their deliveries at "/webhooks/:id". Users who can sync a mirror can manage its
+// webhooks, global webhooks called for every mirror are only available to admins.
+//
+//   // Call CI after each update of acme/widgets, the secret is generated unless provided
+//   curl -H 'Accept: application/json' -d action=create -d repo=acme/widgets -d url=https://ci.example.com/hook http://doppelganger/webhooks
+//   // Send a delivery again
+//   curl -d action=redeliver -d id=0a1b2c3d4e5f http://doppelganger/webhooks
+type WebhooksHandler struct {
+	webhooks *hooks.Service
+}
+
+// NewWebhooksHandler creates and initializes a new handler.
+func NewWebhooksHandler(webhooks *hooks.Service) *WebhooksHandler {
+	return &WebhooksHandler{
+		webhooks: webhooks,
+	}
+}
+
+func (handler *WebhooksHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	access := accessFromRequest(req)
+
+	if id := req.URL.Query().Get(":id"); id != "" {
+		handler.Show(w, req, access, id)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		handler.List(w, req, access, nil)
+	case "POST":
+		switch action := req.FormValue("action"); action {
+		case "create":
+			handler.Create(w, req, access)
+		case "delete":
+			handler.Delete(w, req, access)
+		case "redeliver":
+			handler.Redeliver(w, req, access)
+		default:
+			WriteErrorPage(w, UserError{Message: "Unsupported action " + action, BackURL: req.Referer()}, http.StatusBadRequest)
+		}
+	default:
+		WriteErrorPage(w, UserError{Message: req.Method + " requests are not supported", BackURL: req.Referer()}, http.StatusMethodNotAllowed)
+	}
+}
+
+// webhookView is a webhook rendered along with the status of its latest delivery.
+type webhookView struct {
+	hooks.Hook
+	LastDelivery *hooks.Delivery
+}
+
+// List renders webhooks user can manage, optionally limited to the mirror provided in "repo" query parameter.
+// The secret of a newly created webhook is shown once if provided.
+func (handler *WebhooksHandler) List(w http.ResponseWriter, req *http.Request, access *userAccess, created *hooks.Hook) {
+	repo := strings.TrimSpace(req.URL.Query().Get("repo"))
+
+	var webhooks []webhookView
+	for _, h := range handler.webhooks.All() {
+		if !canManageWebhook(access, h.Repository) || (repo != "" && h.Repository != repo) {
+			continue
+		}
+
+		v := webhookView{Hook: h}
+		if deliveries := handler.webhooks.Deliveries(h.ID); len(deliveries) > 0 {
+			v.LastDelivery = &deliveries[0]
+		}
+
+		webhooks = append(webhooks, v)
+	}
+
+	if acceptsJSON(req) {
+		type webhookJSON struct {
+			ID             string     `json:"id"`
+			Repository     string     `json:"repository,omitempty"`
+			URL            string     `json:"url"`
+			CreatedBy      string     `json:"created_by,omitempty"`
+			CreatedAt      time.Time  `json:"created_at"`
+			LastDeliveryAt *time.Time `json:"last_delivery_at"`
+			LastStatus     string     `json:"last_status,omitempty"`
+			Secret         string     `json:"secret,omitempty"`
+		}
+
+		result := make([]webhookJSON, 0, len(webhooks))
+		for _, v := range webhooks {
+			wj := webhookJSON{ID: v.ID, Repository: v.Repository, URL: v.URL, CreatedBy: v.CreatedBy, CreatedAt: v.CreatedAt}
+			if d := v.LastDelivery; d != nil {
+				wj.LastDeliveryAt, wj.LastStatus = &d.CreatedAt, d.Status
+			}
+
+			if created != nil && created.ID == v.ID {
+				wj.Secret = created.Secret
+			}
+
+			result = append(result, wj)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+
+		return
+	}
+
+	values := struct {
+		Webhooks   []webhookView
+		Repository string
+		Created    *hooks.Hook
+		User       *userAccess
+	}{
+		Webhooks:   webhooks,
+		Repository: repo,
+		Created:    created,
+		User:       access,
+	}
+
+	if err := webhooksTemplate.Execute(w, values); err != nil {
+		slog.WarnContext(req.Context(), "failed to render page", "template", "webhooks/index", "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+	}
+}
+
+// Show renders the delivery log of webhook with given ID.
+func (handler *WebhooksHandler) Show(w http.ResponseWriter, req *http.Request, access *userAccess, id string) {
+	h, err := handler.webhooks.Get(id)
+	if err != nil || !canManageWebhook(access, h.Repository) {
+		WriteNotFoundPage(w, "No such webhook", "/webhooks")
+		return
+	}
+
+	deliveries := handler.webhooks.Deliveries(h.ID)
+
+	if acceptsJSON(req) {
+		if deliveries == nil {
+			deliveries = []hooks.Delivery{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+
+		return
+	}
+
+	values := struct {
+		Webhook    hooks.Hook
+		Deliveries []hooks.Delivery
+		User       *userAccess
+	}{
+		Webhook:    h,
+		Deliveries: deliveries,
+		User:       access,
+	}
+
+	if err := webhookTemplate.Execute(w, values); err != nil {
+		slog.WarnContext(req.Context(), "failed to render page", "template", "webhooks/show", "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+	}
+}
+
+// Create registers a webhook using "repo", "url" and "secret" form values. Webhooks without repository are called
+// for all mirrors. A random secret is generated if none has been provided.
+func (handler *WebhooksHandler) Create(w http.ResponseWriter, req *http.Request, access *userAccess) {
+	if err := req.ParseForm(); err != nil {
+		WriteErrorPage(w, UserError{Message: "Malformed request", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+		return
+	}
+
+	h := hooks.Hook{
+		URL:    req.PostForm.Get("url"),
+		Secret: req.PostForm.Get("secret"),
+	}
+
+	if repo := strings.TrimSpace(req.PostForm.Get("repo")); repo != "" {
+		name, err := git.ParseRepositoryName(repo)
+		if err != nil {
+			WriteErrorPage(w, UserError{Message: "Invalid repository name " + repo, BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+			return
+		}
+		h.Repository = name.String()
+	}
+
+	if !canManageWebhook(access, h.Repository) {
+		if h.Repository == "" {
+			WriteForbidden(w, req, "Only admins can manage webhooks called for all mirrors")
+		} else {
+			WriteForbidden(w, req, "You are not allowed to manage webhooks of "+h.Repository)
+		}
+
+		return
+	}
+
+	if access.Identity != nil {
+		h.CreatedBy = access.Identity.Username
+	}
+
+	if h.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+			return
+		}
+		h.Secret = secret
+	}
+
+	created, err := handler.webhooks.Add(req.Context(), h)
+	switch err {
+	case nil:
+	case hooks.ErrInvalidHook:
+		WriteErrorPage(w, UserError{Message: "Webhook URL must be an absolute http(s) URL", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+		return
+	case hooks.ErrNotMirrored:
+		WriteErrorPage(w, UserError{Message: h.Repository + " is not mirrored", BackURL: req.Referer(), OriginalError: err}, http.StatusBadRequest)
+		return
+	default:
+		slog.WarnContext(req.Context(), "failed to create webhook", "repo", h.Repository, "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "created webhook", "webhook", created.ID, "repo", created.Repository)
+	w.Header().Set("Cache-Control", "no-store")
+	handler.List(w, req, access, created)
+}
+
+// Delete removes a webhook specified by "id" form value along with its deliveries.
+func (handler *WebhooksHandler) Delete(w http.ResponseWriter, req *http.Request, access *userAccess) {
+	id := req.FormValue("id")
+
+	h, err := handler.webhooks.Get(id)
+	if err == nil && !canManageWebhook(access, h.Repository) {
+		err = hooks.ErrHookNotFound
+	}
+
+	if err == nil {
+		err = handler.webhooks.Remove(req.Context(), id)
+	}
+
+	switch err {
+	case nil:
+	case hooks.ErrHookNotFound:
+		WriteNotFoundPage(w, "No such webhook", "/webhooks")
+		return
+	default:
+		slog.WarnContext(req.Context(), "failed to delete webhook", "webhook", id, "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "deleted webhook", "webhook", h.ID, "repo", h.Repository)
+	http.Redirect(w, req, "/webhooks", http.StatusSeeOther)
+}
+
+// Redeliver sends the payload of a delivery specified by "id" form value again.
+func (handler *WebhooksHandler) Redeliver(w http.ResponseWriter, req *http.Request, access *userAccess) {
+	id := req.FormValue("id")
+
+	d, err := handler.webhooks.Delivery(id)
+	if err == nil {
+		var h hooks.Hook
+		if h, err = handler.webhooks.Get(d.HookID); err == nil && !canManageWebhook(access, h.Repository) {
+			err = hooks.ErrDeliveryNotFound
+		}
+	}
+
+	var redelivery *hooks.Delivery
+	if err == nil {
+		redelivery, err = handler.webhooks.Redeliver(req.Context(), id)
+	}
+
+	switch err {
+	case nil:
+	case hooks.ErrDeliveryNotFound, hooks.ErrHookNotFound:
+		WriteNotFoundPage(w, "No such delivery", "/webhooks")
+		return
+	default:
+		slog.WarnContext(req.Context(), "failed to redeliver webhook", "delivery", id, "error", err)
+		WriteErrorPage(w, UserError{Message: "Internal server error", BackURL: req.Referer(), OriginalError: err}, http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "queued webhook redelivery", "webhook", d.HookID, "delivery", redelivery.ID, "redelivery_of", id)
+	http.Redirect(w, req, "/webhooks/"+d.HookID, http.StatusSeeOther)
+}
+
+// canManageWebhook returns true if user is allowed to manage webhooks of repository repoName. Global webhooks
+// with an empty repoName can only be managed by admins.
+func canManageWebhook(access *userAccess, repoName string) bool {
+	if repoName == "" {
+		return access.IsAdmin()
+	}
+
+	return access.Can("manage-webhooks", repoName)
+}
+
+// newWebhookSecret returns a random secret to sign webhook deliveries with.
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}